@@ -0,0 +1,185 @@
+// Command share keeps the snippets shared from the tour and the playground.
+//
+// Usage:
+//
+//	share [flags]                          serve /share and /p/<id>
+//	share takedown -reason text <id>...    remove snippets
+//	share import [flags] <playground.db>   import the snippets of the old playground
+//	share prune                            delete expired snippets
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/upper/db/v4/adapter/ql"
+
+	"github.com/upper/upper.io/share/server"
+	"github.com/upper/upper.io/share/store"
+)
+
+var (
+	flagAddr          = flag.String("addr", ":8090", "listen address")
+	flagDB            = flag.String("db", "/data/share.db", "database: the path of a SQLite database or a postgres:// URL")
+	flagPlayURL       = flag.String("play-url", "https://demo.upper.io", "URL of the playground the snippet pages use")
+	flagMaxExpiry     = flag.Duration("max-expiry", 0, "longest lifetime a snippet may ask for, zero for no limit")
+	flagPruneInterval = flag.Duration("prune-interval", time.Hour, "how often expired snippets are deleted")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: share [flags] [takedown|import|prune] [args]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	st, err := store.Open(*flagDB)
+	if err != nil {
+		log.Fatalf("open %s: %v", *flagDB, err)
+	}
+	defer st.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch cmd := flag.Arg(0); cmd {
+	case "":
+		err = serve(ctx, st)
+	case "takedown":
+		err = takedown(ctx, st, flag.Args()[1:])
+	case "import":
+		err = importLegacy(ctx, st, flag.Args()[1:])
+	case "prune":
+		var n int
+		if n, err = st.Prune(ctx); err == nil {
+			log.Printf("deleted %d expired snippets", n)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func serve(ctx context.Context, st *store.Store) error {
+	srv := &http.Server{
+		Addr: *flagAddr,
+		Handler: server.New(st, server.Config{
+			PlayURL:   *flagPlayURL,
+			MaxExpiry: *flagMaxExpiry,
+		}),
+	}
+
+	go func() {
+		ticker := time.NewTicker(*flagPruneInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if n, err := st.Prune(ctx); err != nil {
+				log.Printf("prune: %v", err)
+			} else if n > 0 {
+				log.Printf("deleted %d expired snippets", n)
+			}
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("listening on %s", *flagAddr)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func takedown(ctx context.Context, st *store.Store, args []string) error {
+	fs := flag.NewFlagSet("takedown", flag.ExitOnError)
+	reason := fs.String("reason", "", "why the snippets are removed, required")
+	fs.Parse(args)
+
+	if *reason == "" || fs.NArg() == 0 {
+		return fmt.Errorf("usage: share takedown -reason text <id>...")
+	}
+	for _, id := range fs.Args() {
+		if err := st.Takedown(ctx, id, *reason); err != nil {
+			return fmt.Errorf("%s: %w", id, err)
+		}
+		log.Printf("removed %s", id)
+	}
+	return nil
+}
+
+func importLegacy(ctx context.Context, st *store.Store, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	collection := fs.String("collection", "snippets", "table of snippets in the playground database")
+	idColumn := fs.String("id-column", "hash", "column with the ID of the snippets")
+	bodyColumn := fs.String("body-column", "body", "column with the code of the snippets")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: share import [flags] <playground.db>")
+	}
+
+	// QL opens its files for writing, with a log next to them, and the
+	// database of the playground is mounted read-only: a copy is read.
+	dir, err := os.MkdirTemp("", "share-import")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "playground.db")
+	if err := copyFile(name, fs.Arg(0)); err != nil {
+		return err
+	}
+
+	src, err := ql.Open(ql.ConnectionURL{Database: name})
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	n, err := st.Import(ctx, src, store.Legacy{
+		Collection: *collection,
+		IDColumn:   *idColumn,
+		BodyColumn: *bodyColumn,
+	})
+	log.Printf("imported %d snippets", n)
+	return err
+}
+
+func copyFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,175 @@
+// Package server implements the HTTP interface of the share service, which is
+// compatible with the /share and /p/ endpoints of the Go playground.
+package server
+
+import (
+	"errors"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/upper/upper.io/share/store"
+)
+
+// maxSnippetSize is the maximum size of a snippet, the same as the maximum
+// size of a program for the compile service.
+const maxSnippetSize = 64 << 10
+
+// Config configures a server.
+type Config struct {
+	// PlayURL is the URL of the playground whose scripts render snippets.
+	PlayURL string
+	// MaxExpiry is the longest lifetime a snippet may ask for, zero means
+	// there is no limit. Snippets that do not ask for one never expire.
+	MaxExpiry time.Duration
+}
+
+// Server handles share requests.
+type Server struct {
+	store *store.Store
+	conf  Config
+	mux   *http.ServeMux
+}
+
+// New creates a server that keeps snippets in st.
+func New(st *store.Store, conf Config) *Server {
+	s := &Server{
+		store: st,
+		conf:  conf,
+		mux:   http.NewServeMux(),
+	}
+	s.mux.HandleFunc("/share", s.handleShare)
+	s.mux.HandleFunc("/p/", s.handleSnippet)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// handleShare stores the request body and replies with the ID of the snippet.
+// The optional expires query parameter, like 24h, sets how long the snippet
+// is kept.
+func (s *Server) handleShare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var ttl time.Duration
+	if v := r.URL.Query().Get("expires"); v != "" {
+		var err error
+		if ttl, err = time.ParseDuration(v); err != nil || ttl <= 0 {
+			http.Error(w, "invalid expires parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	if s.conf.MaxExpiry > 0 && ttl > s.conf.MaxExpiry {
+		ttl = s.conf.MaxExpiry
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSnippetSize+1))
+	if err != nil {
+		http.Error(w, "could not read snippet", http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxSnippetSize {
+		http.Error(w, "snippet is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	id, err := s.store.Put(r.Context(), string(body), ttl)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, id)
+}
+
+// handleSnippet serves /p/<id>, a page with the snippet in the playground
+// editor, and /p/<id>.go, the code itself.
+func (s *Server) handleSnippet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/p/")
+	raw := strings.HasSuffix(id, ".go")
+	id = strings.TrimSuffix(id, ".go")
+	if id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	snippet, err := s.store.Get(r.Context(), id)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	if raw {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Disposition", `inline; filename="prog.go"`)
+		io.WriteString(w, snippet.Body)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = snippetPage.Execute(w, map[string]interface{}{
+		"ID":      snippet.ID,
+		"Body":    snippet.Body,
+		"PlayURL": s.conf.PlayURL,
+	})
+	if err != nil {
+		log.Printf("snippet %s: %v", snippet.ID, err)
+	}
+}
+
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, "snippet not found", http.StatusNotFound)
+	case errors.Is(err, store.ErrRemoved):
+		http.Error(w, "snippet removed", http.StatusGone)
+	case r.Context().Err() != nil:
+		// The client is gone.
+	default:
+		log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}
+}
+
+var snippetPage = template.Must(template.New("snippet").Parse(`<!DOCTYPE html>
+<html>
+  <head>
+    <meta charset="utf-8" />
+    <meta content="width=device-width, initial-scale=1.0" name="viewport" />
+    <title>upper/db playground - {{ .ID }}</title>
+
+    <script src="//ajax.googleapis.com/ajax/libs/jquery/1.8.2/jquery.min.js"></script>
+
+    <link href="{{ .PlayURL }}/static/example.css" rel="stylesheet" type="text/css" />
+    <link href="{{ .PlayURL }}/static/codemirror.css" rel="stylesheet" type="text/css" />
+
+    <script src="{{ .PlayURL }}/static/playground-full.js"></script>
+    <script src="{{ .PlayURL }}/static/snippets.js"></script>
+
+    <script>
+    goPlaygroundOptions({
+      'shareRedirect': '{{ .PlayURL }}/p/',
+      'shareURL': '{{ .PlayURL }}/share',
+      'compileURL': '{{ .PlayURL }}/compile',
+      'fmtURL': '{{ .PlayURL }}/fmt'
+    });
+    </script>
+  </head>
+  <body>
+    <textarea class="go-playground-snippet" data-expanded="1">{{ .Body }}</textarea>
+  </body>
+</html>
+`))
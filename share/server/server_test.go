@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/upper/upper.io/share/store"
+)
+
+func newServer(t *testing.T, conf Config) (*httptest.Server, *store.Store) {
+	t.Helper()
+	st, err := store.Open(filepath.Join(t.TempDir(), "share.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	srv := httptest.NewServer(New(st, conf))
+	t.Cleanup(srv.Close)
+	return srv, st
+}
+
+func do(t *testing.T, method, url, body string) (int, http.Header, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, resp.Header, string(data)
+}
+
+func TestShare(t *testing.T) {
+	srv, st := newServer(t, Config{PlayURL: "https://play.upper.io", MaxExpiry: time.Hour})
+
+	status, _, id := do(t, "POST", srv.URL+"/share", "package main\n")
+	if status != http.StatusOK || id == "" {
+		t.Fatalf("share: status %d, %q", status, id)
+	}
+	if status, _, again := do(t, "POST", srv.URL+"/share", "package main\n"); status != http.StatusOK || again != id {
+		t.Errorf("share of the same body: status %d, %q, want %q", status, again, id)
+	}
+
+	// Lifetimes longer than MaxExpiry are cut to it.
+	status, _, short := do(t, "POST", srv.URL+"/share?expires=720h", "package short\n")
+	if status != http.StatusOK {
+		t.Fatalf("share with expires: status %d", status)
+	}
+	snippet, err := st.Get(context.Background(), short)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snippet.ExpiresAt == nil || snippet.ExpiresAt.After(time.Now().Add(time.Hour)) {
+		t.Errorf("expiry %v, want within an hour", snippet.ExpiresAt)
+	}
+
+	tests := []struct {
+		method, path, body string
+		status             int
+	}{
+		{"GET", "/share", "", http.StatusMethodNotAllowed},
+		{"POST", "/share?expires=forever", "package main\n", http.StatusBadRequest},
+		{"POST", "/share?expires=-1h", "package main\n", http.StatusBadRequest},
+		{"POST", "/share", strings.Repeat("x", maxSnippetSize), http.StatusOK},
+		{"POST", "/share", strings.Repeat("x", maxSnippetSize+1), http.StatusRequestEntityTooLarge},
+		{"POST", "/p/" + id, "", http.StatusMethodNotAllowed},
+		{"GET", "/p/", "", http.StatusNotFound},
+		{"GET", "/p/" + id + "/x", "", http.StatusNotFound},
+		{"GET", "/p/nonexistent", "", http.StatusNotFound},
+		{"HEAD", "/p/" + id, "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if status, _, body := do(t, tt.method, srv.URL+tt.path, tt.body); status != tt.status {
+			t.Errorf("%s %s: status %d, want %d\n%s", tt.method, tt.path, status, tt.status, body)
+		}
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	srv, st := newServer(t, Config{PlayURL: "https://play.upper.io"})
+	ctx := context.Background()
+
+	code := "package main\n\n// <b>\nfunc main() {}\n"
+	id, err := st.Put(ctx, code, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, header, body := do(t, "GET", srv.URL+"/p/"+id+".go", "")
+	if status != http.StatusOK || body != code {
+		t.Errorf("raw snippet: status %d, %q", status, body)
+	}
+	if got := header.Get("Content-Disposition"); got != `inline; filename="prog.go"` {
+		t.Errorf("Content-Disposition %q", got)
+	}
+
+	status, header, body = do(t, "GET", srv.URL+"/p/"+id, "")
+	if status != http.StatusOK || !strings.HasPrefix(header.Get("Content-Type"), "text/html") {
+		t.Fatalf("snippet page: status %d, %s", status, header.Get("Content-Type"))
+	}
+	for _, want := range []string{
+		"// &lt;b&gt;",
+		"<title>upper/db playground - " + id + "</title>",
+		`'shareURL': 'https:\/\/play.upper.io/share'`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("snippet page without %q:\n%s", want, body)
+		}
+	}
+
+	if err := st.Takedown(ctx, id, "abuse"); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/p/" + id, "/p/" + id + ".go"} {
+		if status, _, _ := do(t, "GET", srv.URL+path, ""); status != http.StatusGone {
+			t.Errorf("%s of a removed snippet: status %d, want %d", path, status, http.StatusGone)
+		}
+	}
+	if status, _, _ := do(t, "POST", srv.URL+"/share", code); status != http.StatusGone {
+		t.Errorf("share of a removed snippet: status %d, want %d", status, http.StatusGone)
+	}
+}
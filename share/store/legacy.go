@@ -0,0 +1,119 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/upper/db/v4"
+)
+
+// Legacy describes where the old playground keeps its snippets.
+type Legacy struct {
+	// Collection is the name of the table of snippets.
+	Collection string
+	// IDColumn is the column with the ID snippets are linked with.
+	IDColumn string
+	// BodyColumn is the column with the code of the snippets.
+	BodyColumn string
+}
+
+// Import copies the snippets of src, a session on the database of the old
+// playground, into the store. Their old IDs are kept as aliases, so old links
+// keep working. Snippets imported before are skipped. It returns the number of
+// old IDs added.
+//
+// The columns of l are looked up in the schema of the table first, so that a
+// table with other columns fails with the ones it has, not with a snippet
+// without an ID.
+func (s *Store) Import(ctx context.Context, src db.Session, l Legacy) (int, error) {
+	if err := l.check(ctx, src); err != nil {
+		return 0, err
+	}
+
+	res := src.WithContext(ctx).Collection(l.Collection).Find()
+	defer res.Close()
+
+	aliases := s.sess.WithContext(ctx).Collection(aliasesTable)
+
+	imported := 0
+	var row map[string]interface{}
+	for res.Next(&row) {
+		legacyID, body := toString(row[l.IDColumn]), toString(row[l.BodyColumn])
+		if legacyID == "" {
+			return imported, fmt.Errorf("snippet without %s column", l.IDColumn)
+		}
+
+		id, err := s.Put(ctx, body, 0)
+		if errors.Is(err, ErrRemoved) {
+			continue
+		}
+		if err != nil {
+			return imported, fmt.Errorf("snippet %s: %w", legacyID, err)
+		}
+		if id == legacyID {
+			continue
+		}
+
+		exists, err := aliases.Find(db.Cond{"alias": legacyID}).Exists()
+		if err != nil {
+			return imported, err
+		}
+		if exists {
+			continue
+		}
+		if _, err := aliases.Insert(alias{Alias: legacyID, SnippetID: id}); err != nil {
+			return imported, fmt.Errorf("snippet %s: %w", legacyID, err)
+		}
+		imported++
+	}
+	if err := res.Err(); err != nil {
+		return imported, err
+	}
+	return imported, nil
+}
+
+// check makes sure that the table of snippets has the columns of l.
+func (l Legacy) check(ctx context.Context, src db.Session) error {
+	// The columns of a query are known even if it has no rows.
+	rows, err := src.SQL().Select().From(l.Collection).Limit(1).QueryContext(ctx)
+	if err != nil {
+		return fmt.Errorf("table %s: %w", l.Collection, err)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return fmt.Errorf("table %s: %w", l.Collection, err)
+	}
+	// Some drivers, like the one of QL, go on with a query after its rows
+	// are closed: it is read to the end.
+	for rows.Next() {
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("table %s: %w", l.Collection, err)
+	}
+
+	has := make(map[string]bool, len(columns))
+	for _, c := range columns {
+		has[c] = true
+	}
+	for _, c := range []string{l.IDColumn, l.BodyColumn} {
+		if !has[c] {
+			return fmt.Errorf("table %s has no column %q, only %s", l.Collection, c, strings.Join(columns, ", "))
+		}
+	}
+	return nil
+}
+
+func toString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return fmt.Sprint(v)
+}
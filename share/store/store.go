@@ -0,0 +1,268 @@
+// Package store keeps shared snippets in a SQLite or PostgreSQL database
+// through upper/db.
+//
+// Snippets are content addressed: the ID of a snippet is derived from the
+// SHA-256 of its body, so sharing the same code twice gives the same link.
+// Snippets imported from the old playground keep their IDs as aliases.
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/upper/db/v4"
+	"github.com/upper/db/v4/adapter/postgresql"
+	"github.com/upper/db/v4/adapter/sqlite"
+)
+
+// idLength is the length of snippet IDs, which grow when two bodies share a
+// prefix of their hashes.
+const idLength = 10
+
+var (
+	// ErrNotFound is returned for snippets that do not exist or have expired.
+	ErrNotFound = errors.New("snippet not found")
+	// ErrRemoved is returned for snippets that were taken down.
+	ErrRemoved = errors.New("snippet removed")
+)
+
+// Snippet is a shared program.
+type Snippet struct {
+	ID   string `db:"id"`
+	Body string `db:"body"`
+	// Hash is the SHA-256 of the body, the ID is a prefix of it.
+	Hash      string     `db:"hash"`
+	CreatedAt time.Time  `db:"created_at"`
+	ExpiresAt *time.Time `db:"expires_at"`
+	// RemovedAt is set when the snippet is taken down. The body is erased,
+	// but the row is kept so the same code cannot be shared again.
+	RemovedAt     *time.Time `db:"removed_at"`
+	RemovedReason string     `db:"removed_reason"`
+}
+
+// alias maps the ID a snippet had in the old playground to its current ID.
+type alias struct {
+	Alias     string `db:"alias"`
+	SnippetID string `db:"snippet_id"`
+}
+
+const (
+	snippetsTable = "snippets"
+	aliasesTable  = "snippet_aliases"
+)
+
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS snippets (
+		id VARCHAR(64) PRIMARY KEY,
+		body TEXT NOT NULL,
+		hash VARCHAR(64) NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NULL,
+		removed_at TIMESTAMP NULL,
+		removed_reason TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE INDEX IF NOT EXISTS snippets_expires_at ON snippets (expires_at)`,
+	`CREATE TABLE IF NOT EXISTS snippet_aliases (
+		alias VARCHAR(64) PRIMARY KEY,
+		snippet_id VARCHAR(64) NOT NULL REFERENCES snippets (id) ON DELETE CASCADE
+	)`,
+}
+
+// Store is a database of snippets.
+type Store struct {
+	sess db.Session
+	now  func() time.Time
+}
+
+// Open connects to the database at dsn and creates the tables if needed.
+// PostgreSQL databases are given as postgres:// URLs, anything else is the
+// path of a SQLite database, optionally with a sqlite:// prefix.
+func Open(dsn string) (*Store, error) {
+	sess, err := openSession(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	s := New(sess)
+	if err := s.migrate(); err != nil {
+		sess.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func openSession(dsn string) (db.Session, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		settings, err := postgresql.ParseURL(dsn)
+		if err != nil {
+			return nil, err
+		}
+		return postgresql.Open(settings)
+	}
+	return sqlite.Open(sqlite.ConnectionURL{
+		Database: strings.TrimPrefix(dsn, "sqlite://"),
+		Options:  map[string]string{"_foreign_keys": "1"},
+	})
+}
+
+// New returns a store on an open session whose tables exist already.
+func New(sess db.Session) *Store {
+	return &Store{sess: sess, now: time.Now}
+}
+
+func (s *Store) migrate() error {
+	for _, stmt := range schema {
+		if _, err := s.sess.SQL().Exec(stmt); err != nil {
+			return fmt.Errorf("create tables: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.sess.Close()
+}
+
+// Put stores a snippet and returns its ID. A positive ttl makes the snippet
+// expire after that time. Sharing a body that is already stored returns the
+// existing ID, and keeps the snippet for as long as the longest of the
+// requested lifetimes.
+func (s *Store) Put(ctx context.Context, body string, ttl time.Duration) (string, error) {
+	now := s.now().UTC()
+	var expiresAt *time.Time
+	if ttl > 0 {
+		t := now.Add(ttl)
+		expiresAt = &t
+	}
+
+	sum := sha256.Sum256([]byte(body))
+	hash := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	var id string
+	err := s.sess.WithContext(ctx).Tx(func(tx db.Session) error {
+		snippets := tx.Collection(snippetsTable)
+		for n := idLength; n <= len(hash); n++ {
+			id = hash[:n]
+
+			var existing Snippet
+			err := snippets.Find(db.Cond{"id": id}).One(&existing)
+			if errors.Is(err, db.ErrNoMoreRows) {
+				_, err = snippets.Insert(Snippet{
+					ID:        id,
+					Body:      body,
+					Hash:      hash,
+					CreatedAt: now,
+					ExpiresAt: expiresAt,
+				})
+				return err
+			}
+			if err != nil {
+				return err
+			}
+
+			if existing.Hash != hash {
+				continue
+			}
+			if existing.RemovedAt != nil {
+				return ErrRemoved
+			}
+			if !outlives(existing.ExpiresAt, expiresAt, now) {
+				return nil
+			}
+			return snippets.Find(db.Cond{"id": id}).Update(map[string]interface{}{
+				"expires_at": expiresAt,
+			})
+		}
+		return fmt.Errorf("no free ID for snippet %s", hash)
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// outlives reports whether a snippet expiring at b lives longer than one
+// expiring at a, at time now. Nil means no expiry.
+func outlives(a, b *time.Time, now time.Time) bool {
+	switch {
+	case a == nil:
+		return false
+	case b == nil:
+		return true
+	case a.Before(now):
+		return true
+	}
+	return b.After(*a)
+}
+
+// Get returns the snippet with the given ID, which may be an alias.
+func (s *Store) Get(ctx context.Context, id string) (*Snippet, error) {
+	sess := s.sess.WithContext(ctx)
+
+	var snippet Snippet
+	err := sess.Collection(snippetsTable).Find(db.Cond{"id": id}).One(&snippet)
+	if errors.Is(err, db.ErrNoMoreRows) {
+		var a alias
+		err = sess.Collection(aliasesTable).Find(db.Cond{"alias": id}).One(&a)
+		if err == nil {
+			err = sess.Collection(snippetsTable).Find(db.Cond{"id": a.SnippetID}).One(&snippet)
+		}
+	}
+	if errors.Is(err, db.ErrNoMoreRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if snippet.RemovedAt != nil {
+		return nil, ErrRemoved
+	}
+	if snippet.ExpiresAt != nil && !snippet.ExpiresAt.After(s.now()) {
+		return nil, ErrNotFound
+	}
+	return &snippet, nil
+}
+
+// Takedown removes a snippet, given its ID or an alias. Its body is erased,
+// and sharing the same body again fails with ErrRemoved.
+func (s *Store) Takedown(ctx context.Context, id string, reason string) error {
+	if reason == "" {
+		return errors.New("a reason is required")
+	}
+	snippet, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	now := s.now().UTC()
+	return s.sess.WithContext(ctx).Collection(snippetsTable).Find(db.Cond{"id": snippet.ID}).Update(map[string]interface{}{
+		"body":           "",
+		"removed_at":     now,
+		"removed_reason": reason,
+	})
+}
+
+// Prune deletes the snippets that have expired, returning how many there
+// were.
+func (s *Store) Prune(ctx context.Context) (int, error) {
+	sess := s.sess.WithContext(ctx)
+	cond := db.Cond{"expires_at <=": s.now().UTC(), "removed_at": nil}
+
+	n, err := sess.Collection(snippetsTable).Find(cond).Count()
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	if err := sess.Collection(snippetsTable).Find(cond).Delete(); err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
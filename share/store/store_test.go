@@ -0,0 +1,294 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/upper/db/v4"
+	"github.com/upper/db/v4/adapter/ql"
+	"github.com/upper/db/v4/adapter/sqlite"
+)
+
+// newStore opens a store on a new SQLite database whose clock is at *now.
+func newStore(t *testing.T, now *time.Time) *Store {
+	t.Helper()
+	s, err := Open(filepath.Join(t.TempDir(), "share.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	s.now = func() time.Time { return *now }
+	return s
+}
+
+func hashOf(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func TestPut(t *testing.T) {
+	now := time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC)
+	s := newStore(t, &now)
+	ctx := context.Background()
+
+	body := "package main\n"
+	id, err := s.Put(ctx, body, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := hashOf(body)[:idLength]; id != want {
+		t.Errorf("ID %q, want %q", id, want)
+	}
+	again, err := s.Put(ctx, body, time.Hour)
+	if err != nil || again != id {
+		t.Errorf("Put of the same body = %q, %v, want %q", again, err, id)
+	}
+	snippet, err := s.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snippet.Body != body || snippet.ExpiresAt != nil {
+		t.Errorf("Get = %+v, want the body with no expiry", snippet)
+	}
+	if _, err := s.Get(ctx, "nonexistent"); err != ErrNotFound {
+		t.Errorf("Get of an unknown ID: %v, want ErrNotFound", err)
+	}
+}
+
+func TestPutCollision(t *testing.T) {
+	now := time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC)
+	s := newStore(t, &now)
+	ctx := context.Background()
+
+	// Other bodies hold the IDs the body would get, as if their hashes
+	// shared a prefix with its hash.
+	body := "package main\n"
+	hash := hashOf(body)
+	snippets := s.sess.Collection(snippetsTable)
+	for _, n := range []int{idLength, idLength + 1} {
+		if _, err := snippets.Insert(Snippet{
+			ID:        hash[:n],
+			Body:      "other",
+			Hash:      hash[:n] + "other",
+			CreatedAt: now,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := hash[:idLength+2]
+	for i := 0; i < 2; i++ {
+		id, err := s.Put(ctx, body, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != want {
+			t.Errorf("Put %d: ID %q, want %q", i, id, want)
+		}
+	}
+	for _, id := range []string{hash[:idLength], want} {
+		snippet, err := s.Get(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (snippet.Body == body) != (id == want) {
+			t.Errorf("Get(%q) = %q", id, snippet.Body)
+		}
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	start := time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		ttls   []time.Duration // of each Put, one hour apart
+		alive  time.Duration   // how long after the first Put it is found
+		pruned int
+	}{
+		{"one", []time.Duration{2 * time.Hour}, 2 * time.Hour, 1},
+		{"longer", []time.Duration{2 * time.Hour, 3 * time.Hour}, 4 * time.Hour, 1},
+		{"shorter", []time.Duration{3 * time.Hour, time.Hour}, 3 * time.Hour, 1},
+		{"no expiry", []time.Duration{time.Hour, 0}, 0, 0},
+		{"no expiry first", []time.Duration{0, time.Hour}, 0, 0},
+		{"after it expired", []time.Duration{time.Hour, time.Hour}, 2 * time.Hour, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := start
+			s := newStore(t, &now)
+			var id string
+			for i, ttl := range tt.ttls {
+				now = start.Add(time.Duration(i) * time.Hour)
+				var err error
+				if id, err = s.Put(ctx, "package main\n", ttl); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if tt.alive == 0 {
+				now = start.Add(100 * 24 * time.Hour)
+				if _, err := s.Get(ctx, id); err != nil {
+					t.Errorf("Get of a snippet that does not expire: %v", err)
+				}
+			} else {
+				now = start.Add(tt.alive - time.Second)
+				if _, err := s.Get(ctx, id); err != nil {
+					t.Errorf("Get before the expiry: %v", err)
+				}
+				now = start.Add(tt.alive)
+				if _, err := s.Get(ctx, id); err != ErrNotFound {
+					t.Errorf("Get after the expiry: %v, want ErrNotFound", err)
+				}
+			}
+
+			n, err := s.Prune(ctx)
+			if err != nil || n != tt.pruned {
+				t.Errorf("Prune = %d, %v, want %d", n, err, tt.pruned)
+			}
+		})
+	}
+}
+
+func TestTakedown(t *testing.T) {
+	now := time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC)
+	s := newStore(t, &now)
+	ctx := context.Background()
+
+	id, err := s.Put(ctx, "package main\n", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Takedown(ctx, id, ""); err == nil {
+		t.Error("Takedown without a reason succeeded")
+	}
+	if err := s.Takedown(ctx, id, "abuse"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, id); err != ErrRemoved {
+		t.Errorf("Get of a removed snippet: %v, want ErrRemoved", err)
+	}
+	if _, err := s.Put(ctx, "package main\n", 0); err != ErrRemoved {
+		t.Errorf("Put of a removed body: %v, want ErrRemoved", err)
+	}
+	if err := s.Takedown(ctx, "nonexistent", "abuse"); err != ErrNotFound {
+		t.Errorf("Takedown of an unknown ID: %v, want ErrNotFound", err)
+	}
+
+	// Removed snippets are kept when they expire.
+	now = now.Add(2 * time.Hour)
+	if n, err := s.Prune(ctx); err != nil || n != 0 {
+		t.Errorf("Prune = %d, %v, want 0", n, err)
+	}
+	var snippet Snippet
+	if err := s.sess.Collection(snippetsTable).Find(db.Cond{"id": id}).One(&snippet); err != nil {
+		t.Fatal(err)
+	}
+	if snippet.Body != "" || snippet.RemovedReason != "abuse" {
+		t.Errorf("removed snippet %+v, want no body and the reason", snippet)
+	}
+}
+
+func TestImport(t *testing.T) {
+	now := time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC)
+	s := newStore(t, &now)
+	ctx := context.Background()
+
+	src, err := sqlite.Open(sqlite.ConnectionURL{Database: filepath.Join(t.TempDir(), "legacy.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	if _, err := src.SQL().Exec(`CREATE TABLE snippets (key TEXT PRIMARY KEY, code BLOB)`); err != nil {
+		t.Fatal(err)
+	}
+	removed, err := s.Put(ctx, "removed", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Takedown(ctx, removed, "abuse"); err != nil {
+		t.Fatal(err)
+	}
+	same := hashOf("same")[:idLength]
+	for key, code := range map[string]string{
+		"a1":   "package a\n",
+		"a2":   "package a\n",
+		"b1":   "package b\n",
+		"gone": "removed",
+		same:   "same",
+	} {
+		if _, err := src.Collection("snippets").Insert(map[string]interface{}{"key": key, "code": []byte(code)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l := Legacy{Collection: "snippets", IDColumn: "key", BodyColumn: "code"}
+	n, err := s.Import(ctx, src, l)
+	if err != nil || n != 3 {
+		t.Fatalf("Import = %d, %v, want 3", n, err)
+	}
+	for key, body := range map[string]string{"a1": "package a\n", "a2": "package a\n", "b1": "package b\n", same: "same"} {
+		snippet, err := s.Get(ctx, key)
+		if err != nil || snippet.Body != body {
+			t.Errorf("Get(%q) = %+v, %v, want body %q", key, snippet, err, body)
+		}
+	}
+	if _, err := s.Get(ctx, "gone"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a removed legacy snippet: %v, want ErrNotFound", err)
+	}
+
+	// Importing again adds nothing.
+	if n, err := s.Import(ctx, src, l); err != nil || n != 0 {
+		t.Errorf("second Import = %d, %v, want 0", n, err)
+	}
+}
+
+func TestImportQL(t *testing.T) {
+	now := time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC)
+	s := newStore(t, &now)
+	ctx := context.Background()
+
+	// A database of the old playground, which keeps its snippets in QL.
+	src, err := ql.Open(ql.ConnectionURL{Database: filepath.Join(t.TempDir(), "playground.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	if err := src.Tx(func(tx db.Session) error {
+		_, err := tx.SQL().Exec(`CREATE TABLE snippets (hash string, body blob, created_at time)`)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for i, code := range []string{"package a\n", "package b\n"} {
+		row := map[string]interface{}{"hash": fmt.Sprintf("h%d", i), "body": []byte(code), "created_at": now}
+		if _, err := src.Collection("snippets").Insert(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := s.Import(ctx, src, Legacy{Collection: "snippets", IDColumn: "hash", BodyColumn: "body"})
+	if err != nil || n != 2 {
+		t.Fatalf("Import = %d, %v, want 2", n, err)
+	}
+	if snippet, err := s.Get(ctx, "h1"); err != nil || snippet.Body != "package b\n" {
+		t.Errorf("Get(h1) = %+v, %v, want package b", snippet, err)
+	}
+
+	// Columns the table does not have are named, with the ones it has.
+	_, err = s.Import(ctx, src, Legacy{Collection: "snippets", IDColumn: "key", BodyColumn: "body"})
+	if err == nil || !strings.Contains(err.Error(), `no column "key", only hash, body, created_at`) {
+		t.Errorf("Import with a missing column: %v", err)
+	}
+	if _, err := s.Import(ctx, src, Legacy{Collection: "pastes", IDColumn: "hash", BodyColumn: "body"}); err == nil {
+		t.Error("Import of a missing table succeeded")
+	}
+}
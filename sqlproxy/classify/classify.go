@@ -0,0 +1,425 @@
+// Package classify sorts SQL statements into the categories the read-only
+// proxy cares about. It does not parse SQL; it looks at the leading keywords
+// of each statement and at the functions it calls, which is enough for the
+// dialects of PostgreSQL and CockroachDB and errs on the side of blocking what
+// it does not recognize.
+package classify
+
+// Class is the category of a statement.
+type Class int
+
+// Statement classes.
+const (
+	// Unknown statements are not recognized.
+	Unknown Class = iota
+	// Read statements only read data: SELECT, SHOW, EXPLAIN, ...
+	Read
+	// Write statements modify data: INSERT, UPDATE, DELETE, ...
+	Write
+	// DDL statements modify the schema or the permissions.
+	DDL
+	// Transaction statements control transactions: BEGIN, COMMIT, ...
+	Transaction
+	// Session statements change the state of the session: SET, PREPARE, ...
+	Session
+	// Privileged statements would lift the limits the proxy enforces, such as
+	// changing statement_timeout or switching roles.
+	Privileged
+)
+
+var classNames = map[Class]string{
+	Unknown:     "unknown",
+	Read:        "read",
+	Write:       "write",
+	DDL:         "DDL",
+	Transaction: "transaction",
+	Session:     "session",
+	Privileged:  "privileged",
+}
+
+func (c Class) String() string {
+	return classNames[c]
+}
+
+// Statement is a classified SQL statement.
+type Statement struct {
+	Class Class
+	// Command is the leading keyword of the statement, such as SELECT.
+	Command string
+	// Text is the text of the statement.
+	Text string
+}
+
+var commands = map[token]Class{
+	"SELECT": Read,
+	"SHOW":   Read,
+	"TABLE":  Read,
+	"VALUES": Read,
+	"FETCH":  Read,
+	"MOVE":   Read,
+
+	"INSERT":   Write,
+	"UPDATE":   Write,
+	"DELETE":   Write,
+	"UPSERT":   Write,
+	"MERGE":    Write,
+	"TRUNCATE": Write,
+	"CALL":     Write,
+	"DO":       Write,
+	"IMPORT":   Write,
+	"LOCK":     Write,
+
+	"CREATE":    DDL,
+	"ALTER":     DDL,
+	"DROP":      DDL,
+	"GRANT":     DDL,
+	"REVOKE":    DDL,
+	"COMMENT":   DDL,
+	"REINDEX":   DDL,
+	"VACUUM":    DDL,
+	"ANALYZE":   DDL,
+	"CLUSTER":   DDL,
+	"REFRESH":   DDL,
+	"SECURITY":  DDL,
+	"BACKUP":    DDL,
+	"RESTORE":   DDL,
+	"EXPORT":    DDL,
+	"CONFIGURE": DDL,
+
+	"BEGIN":     Transaction,
+	"START":     Transaction,
+	"COMMIT":    Transaction,
+	"END":       Transaction,
+	"ROLLBACK":  Transaction,
+	"ABORT":     Transaction,
+	"SAVEPOINT": Transaction,
+	"RELEASE":   Transaction,
+
+	"SET":        Session,
+	"RESET":      Session,
+	"DISCARD":    Session,
+	"DEALLOCATE": Session,
+	"EXECUTE":    Session,
+	"CLOSE":      Session,
+	"LISTEN":     Session,
+	"UNLISTEN":   Session,
+}
+
+// protectedSettings are the settings the proxy relies on. Changing them is
+// privileged.
+var protectedSettings = map[token]bool{
+	"STATEMENT_TIMEOUT":             true,
+	"DEFAULT_TRANSACTION_READ_ONLY": true,
+	"TRANSACTION_READ_ONLY":         true,
+	"ROLE":                          true,
+	"ALL":                           true, // RESET ALL
+	"CLUSTER":                       true, // CockroachDB's SET CLUSTER SETTING
+}
+
+// privilegedFunctions are the functions that would lift the limits of the
+// proxy, act on other sessions or on the server, or run a query the proxy
+// never sees. Statements that call them are privileged, whatever their
+// command.
+var privilegedFunctions = map[string]bool{
+	// Like SET, as in set_config('statement_timeout', '0', false).
+	"SET_CONFIG": true,
+
+	"PG_CANCEL_BACKEND":    true,
+	"PG_TERMINATE_BACKEND": true,
+	"PG_RELOAD_CONF":       true,
+	"PG_ROTATE_LOGFILE":    true,
+
+	"PG_ADVISORY_LOCK":                 true,
+	"PG_ADVISORY_LOCK_SHARED":          true,
+	"PG_ADVISORY_XACT_LOCK":            true,
+	"PG_ADVISORY_XACT_LOCK_SHARED":     true,
+	"PG_TRY_ADVISORY_LOCK":             true,
+	"PG_TRY_ADVISORY_LOCK_SHARED":      true,
+	"PG_TRY_ADVISORY_XACT_LOCK":        true,
+	"PG_TRY_ADVISORY_XACT_LOCK_SHARED": true,
+	"PG_ADVISORY_UNLOCK":               true,
+	"PG_ADVISORY_UNLOCK_SHARED":        true,
+	"PG_ADVISORY_UNLOCK_ALL":           true,
+
+	"LO_IMPORT":     true,
+	"LO_EXPORT":     true,
+	"LO_CREAT":      true,
+	"LO_CREATE":     true,
+	"LO_FROM_BYTEA": true,
+	"LO_PUT":        true,
+	"LO_UNLINK":     true,
+	"LO_OPEN":       true,
+	"LOWRITE":       true,
+	"LO_TRUNCATE":   true,
+
+	"PG_READ_FILE":        true,
+	"PG_READ_BINARY_FILE": true,
+	"PG_LS_DIR":           true,
+	"PG_STAT_FILE":        true,
+
+	"PG_NOTIFY":                  true,
+	"PG_LOGICAL_EMIT_MESSAGE":    true,
+	"PG_CREATE_RESTORE_POINT":    true,
+	"PG_SWITCH_WAL":              true,
+	"PG_DROP_REPLICATION_SLOT":   true,
+	"PG_REPLICATION_ORIGIN_DROP": true,
+
+	"DBLINK":            true,
+	"DBLINK_EXEC":       true,
+	"DBLINK_CONNECT":    true,
+	"DBLINK_CONNECT_U":  true,
+	"DBLINK_SEND_QUERY": true,
+	"DBLINK_OPEN":       true,
+	"DBLINK_GET_RESULT": true,
+
+	// They run the query they are given.
+	"QUERY_TO_XML":               true,
+	"QUERY_TO_XMLSCHEMA":         true,
+	"QUERY_TO_XML_AND_XMLSCHEMA": true,
+}
+
+// writeFunctions are the functions that modify data.
+var writeFunctions = map[string]bool{
+	"NEXTVAL": true,
+	"SETVAL":  true,
+}
+
+// Classify splits sql into statements and classifies each of them.
+func Classify(sql string) []Statement {
+	stmts, texts := lex(sql)
+
+	res := make([]Statement, len(stmts))
+	for i, tokens := range stmts {
+		res[i] = Statement{
+			Class: classifyCalls(tokens, classify(tokens)),
+			Text:  texts[i],
+		}
+		if tokens = skipParens(tokens); len(tokens) > 0 {
+			res[i].Command = string(tokens[0])
+		}
+	}
+	return res
+}
+
+func classify(tokens []token) Class {
+	tokens = skipParens(tokens)
+	if len(tokens) == 0 {
+		return Unknown
+	}
+
+	class, ok := commands[tokens[0]]
+	if !ok {
+		switch tokens[0] {
+		case "WITH":
+			return classifyWith(tokens[1:])
+		case "EXPLAIN":
+			return classifyExplain(tokens[1:])
+		case "PREPARE":
+			return classifyPrepare(tokens[1:])
+		case "DECLARE":
+			return classifyDeclare(tokens[1:])
+		case "COPY":
+			return classifyCopy(tokens[1:])
+		}
+		return Unknown
+	}
+
+	switch class {
+	case Read:
+		if tokens[0] == "SELECT" && (contains(tokens, "INTO") || lockingSelect(tokens)) {
+			// SELECT ... INTO creates a table, SELECT ... FOR UPDATE takes row
+			// locks.
+			return Write
+		}
+	case Transaction:
+		if readWrite(tokens) {
+			return Privileged
+		}
+	case Session:
+		if tokens[0] == "SET" || tokens[0] == "RESET" {
+			return classifySet(tokens[1:])
+		}
+	}
+	return class
+}
+
+// classifyCalls raises the class of a statement that calls the functions of
+// privilegedFunctions or writeFunctions, anywhere in its text.
+func classifyCalls(tokens []token, class Class) Class {
+	for i := 0; i+1 < len(tokens); i++ {
+		if tokens[i+1] != "(" {
+			continue
+		}
+		name := tokens[i].name()
+		switch {
+		case name == "" || privilegedFunctions[name]:
+			return Privileged
+		case i >= 2 && tokens[i-1] == "." && tokens[i-2].name() == "CRDB_INTERNAL":
+			// The functions of CockroachDB's internal schema.
+			return Privileged
+		case writeFunctions[name] && (class == Read || class == Session || class == Transaction):
+			class = Write
+		}
+	}
+	return class
+}
+
+// classifyWith classifies a statement with common table expressions, which
+// may contain data-modifying statements.
+func classifyWith(tokens []token) Class {
+	for _, t := range tokens {
+		if class, ok := commands[t]; ok && class == Write {
+			return Write
+		}
+	}
+	if contains(tokens, "INTO") || lockingSelect(tokens) {
+		return Write
+	}
+	return Read
+}
+
+// classifyExplain classifies an EXPLAIN statement. Only EXPLAIN ANALYZE runs
+// the explained statement.
+func classifyExplain(tokens []token) Class {
+	analyze := false
+options:
+	for len(tokens) > 0 {
+		switch tokens[0] {
+		case "ANALYZE", "ANALYSE":
+			analyze = true
+			tokens = tokens[1:]
+		case "VERBOSE":
+			tokens = tokens[1:]
+		case "(":
+			end := closingParen(tokens)
+			if contains(tokens[:end], "ANALYZE") || contains(tokens[:end], "ANALYSE") {
+				analyze = true
+			}
+			tokens = tokens[end:]
+		default:
+			break options
+		}
+	}
+	if !analyze {
+		return Read
+	}
+	return classify(tokens)
+}
+
+// classifyPrepare classifies PREPARE name [(types)] AS statement by the
+// statement it prepares.
+func classifyPrepare(tokens []token) Class {
+	if len(tokens) > 0 && tokens[0] == "TRANSACTION" {
+		return Transaction
+	}
+	for i, t := range tokens {
+		if t == "AS" {
+			return classify(tokens[i+1:])
+		}
+	}
+	return Unknown
+}
+
+// classifyDeclare classifies DECLARE name ... FOR statement by the statement
+// of the cursor.
+func classifyDeclare(tokens []token) Class {
+	for i, t := range tokens {
+		if t == "FOR" {
+			return classify(tokens[i+1:])
+		}
+	}
+	return Unknown
+}
+
+// classifyCopy tells COPY ... TO, which reads, from COPY ... FROM.
+func classifyCopy(tokens []token) Class {
+	if contains(tokens, "FROM") || contains(tokens, "PROGRAM") {
+		return Write
+	}
+	return Read
+}
+
+// classifySet classifies SET and RESET by the setting they change.
+func classifySet(tokens []token) Class {
+	if len(tokens) > 0 && (tokens[0] == "SESSION" || tokens[0] == "LOCAL") {
+		if len(tokens) > 1 && tokens[1] == "AUTHORIZATION" {
+			return Privileged
+		}
+		if len(tokens) > 1 && tokens[1] == "CHARACTERISTICS" {
+			if readWrite(tokens) {
+				return Privileged
+			}
+			return Session
+		}
+		tokens = tokens[1:]
+	}
+	if len(tokens) == 0 {
+		return Unknown
+	}
+	if tokens[0] == "TRANSACTION" && readWrite(tokens) {
+		return Privileged
+	}
+	if name := token(tokens[0].name()); name == "" || protectedSettings[name] {
+		return Privileged
+	}
+	return Session
+}
+
+// readWrite reports whether tokens ask for a READ WRITE transaction.
+func readWrite(tokens []token) bool {
+	for i := 0; i+1 < len(tokens); i++ {
+		if tokens[i] == "READ" && tokens[i+1] == "WRITE" {
+			return true
+		}
+	}
+	return false
+}
+
+func lockingSelect(tokens []token) bool {
+	for i := 0; i+1 < len(tokens); i++ {
+		if tokens[i] != "FOR" {
+			continue
+		}
+		switch tokens[i+1] {
+		case "UPDATE", "SHARE", "NO", "KEY":
+			return true
+		}
+	}
+	return false
+}
+
+// skipParens drops the parentheses that may enclose a whole statement, as in
+// (SELECT 1).
+func skipParens(tokens []token) []token {
+	for len(tokens) > 0 && tokens[0] == "(" {
+		tokens = tokens[1:]
+	}
+	return tokens
+}
+
+// closingParen returns the index right after the parenthesis that closes the
+// one at tokens[0].
+func closingParen(tokens []token) int {
+	depth := 0
+	for i, t := range tokens {
+		switch t {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(tokens)
+}
+
+func contains(tokens []token, t token) bool {
+	for _, tok := range tokens {
+		if tok == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package classify
+
+import "testing"
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		sql   string
+		class Class
+	}{
+		// Reads.
+		{"SELECT 1", Read},
+		{"select * from books where title = 'INSERT; DELETE'", Read},
+		{"(SELECT 1) UNION (SELECT 2)", Read},
+		{"SHOW search_path", Read},
+		{"TABLE books", Read},
+		{"VALUES (1), (2)", Read},
+		{"WITH t AS (SELECT 1) SELECT * FROM t", Read},
+		{"EXPLAIN SELECT 1", Read},
+		{"EXPLAIN DELETE FROM books", Read},
+		{"COPY books TO STDOUT", Read},
+		{"DECLARE c CURSOR FOR SELECT 1", Read},
+		{"PREPARE q AS SELECT $1", Read},
+		{"SELECT $tag$DROP TABLE books$tag$", Read},
+		{"SELECT 1 -- ; DROP TABLE books", Read},
+		{"SELECT /* ; DROP TABLE books */ 1", Read},
+		{`SELECT "into", "from" FROM books`, Read},
+		{"SELECT current_setting('statement_timeout')", Read},
+		{"SELECT count(*), lower(title) FROM books", Read},
+		{"SELECT * FROM crdb_internal.tables", Read},
+
+		// Writes.
+		{"INSERT INTO books VALUES (1)", Write},
+		{"update books set title = 'x'", Write},
+		{"DELETE FROM books", Write},
+		{"UPSERT INTO books VALUES (1)", Write},
+		{"TRUNCATE books", Write},
+		{"SELECT * INTO copy FROM books", Write},
+		{"SELECT * FROM books FOR UPDATE", Write},
+		{"SELECT * FROM books FOR NO KEY UPDATE", Write},
+		{"WITH d AS (DELETE FROM books RETURNING *) SELECT * FROM d", Write},
+		{"EXPLAIN ANALYZE DELETE FROM books", Write},
+		{"EXPLAIN (ANALYZE, BUFFERS) UPDATE books SET id = 1", Write},
+		{"COPY books FROM STDIN", Write},
+		{"PREPARE q AS INSERT INTO books VALUES ($1)", Write},
+		{"SELECT nextval('books_id_seq')", Write},
+		{"SELECT setval('books_id_seq', 1)", Write},
+
+		// DDL.
+		{"CREATE TABLE t (id int)", DDL},
+		{"DROP TABLE books", DDL},
+		{"GRANT ALL ON books TO public", DDL},
+		{"ALTER ROLE demo SET statement_timeout = 0", DDL},
+
+		// Transactions and sessions.
+		{"BEGIN", Transaction},
+		{"START TRANSACTION READ ONLY", Transaction},
+		{"COMMIT", Transaction},
+		{"SET search_path = public", Session},
+		{"SET SESSION application_name = 'tour'", Session},
+		{"RESET search_path", Session},
+		{"DEALLOCATE q", Session},
+
+		// What would lift the limits of the proxy.
+		{"BEGIN READ WRITE", Privileged},
+		{"START TRANSACTION READ WRITE", Privileged},
+		{"SET TRANSACTION READ WRITE", Privileged},
+		{"SET SESSION CHARACTERISTICS AS TRANSACTION READ WRITE", Privileged},
+		{"SET statement_timeout = 0", Privileged},
+		{"SET LOCAL statement_timeout = 0", Privileged},
+		{`SET "statement_timeout" = 0`, Privileged},
+		{`SET U&"\0073tatement_timeout" = 0`, Privileged},
+		{"SET default_transaction_read_only = off", Privileged},
+		{"SET ROLE postgres", Privileged},
+		{"SET SESSION AUTHORIZATION postgres", Privileged},
+		{"RESET ALL", Privileged},
+		{"SET CLUSTER SETTING sql.defaults.statement_timeout = '0'", Privileged},
+
+		// Functions with side effects, in any statement.
+		{"SELECT set_config('statement_timeout', '0', false)", Privileged},
+		{"select SET_CONFIG('statement_timeout','0',false)", Privileged},
+		{"SELECT pg_catalog.set_config('statement_timeout', '0', false)", Privileged},
+		{`SELECT "set_config"('statement_timeout', '0', false)`, Privileged},
+		{`SELECT "pg_catalog"."set_config"('statement_timeout', '0', false)`, Privileged},
+		{`SELECT U&"\0073et_config"('statement_timeout', '0', false)`, Privileged},
+		{"SELECT * FROM set_config('statement_timeout', '0', false)", Privileged},
+		{"WITH t AS (SELECT set_config('statement_timeout', '0', false)) SELECT * FROM t", Privileged},
+		{"VALUES (set_config('statement_timeout', '0', false))", Privileged},
+		{"EXPLAIN ANALYZE SELECT set_config('statement_timeout', '0', false)", Privileged},
+		{"COPY (SELECT set_config('statement_timeout', '0', false)) TO STDOUT", Privileged},
+		{"PREPARE q AS SELECT set_config($1, $2, false)", Privileged},
+		{"DECLARE c CURSOR FOR SELECT set_config('statement_timeout', '0', false)", Privileged},
+		{"SELECT pg_terminate_backend(pid) FROM pg_stat_activity", Privileged},
+		{"SELECT pg_cancel_backend(123)", Privileged},
+		{"SELECT pg_advisory_lock(1)", Privileged},
+		{"SELECT pg_try_advisory_xact_lock(1)", Privileged},
+		{"SELECT lo_import('/etc/passwd')", Privileged},
+		{"SELECT pg_read_file('/etc/passwd')", Privileged},
+		{"SELECT * FROM dblink('host=db', 'DELETE FROM books') AS t(x int)", Privileged},
+		{"SELECT dblink_exec('DELETE FROM books')", Privileged},
+		{"SELECT query_to_xml('SELECT set_config(''statement_timeout'', ''0'', false)', true, false, '')", Privileged},
+		{"SELECT crdb_internal.force_panic('x')", Privileged},
+
+		// Not recognized.
+		{"", Unknown},
+		{"FOO BAR", Unknown},
+		{`"select" 1`, Unknown},
+	}
+	for _, tt := range tests {
+		stmts := Classify(tt.sql)
+		got := Unknown
+		if len(stmts) > 0 {
+			got = stmts[0].Class
+		}
+		if got != tt.class {
+			t.Errorf("Classify(%q) = %v, want %v", tt.sql, got, tt.class)
+		}
+	}
+}
+
+func TestClassifySplit(t *testing.T) {
+	tests := []struct {
+		sql      string
+		commands []string
+		classes  []Class
+	}{
+		{"SELECT 1; SELECT 2", []string{"SELECT", "SELECT"}, []Class{Read, Read}},
+		{"SELECT 1; DELETE FROM books;", []string{"SELECT", "DELETE"}, []Class{Read, Write}},
+		{"SELECT ';'; SET statement_timeout = 0", []string{"SELECT", "SET"}, []Class{Read, Privileged}},
+		{`SELECT "a;b"; BEGIN`, []string{"SELECT", "BEGIN"}, []Class{Read, Transaction}},
+		{"SELECT $$;$$; COMMIT", []string{"SELECT", "COMMIT"}, []Class{Read, Transaction}},
+		{" ; ; ", nil, nil},
+	}
+	for _, tt := range tests {
+		stmts := Classify(tt.sql)
+		if len(stmts) != len(tt.commands) {
+			t.Errorf("Classify(%q): %d statements, want %d", tt.sql, len(stmts), len(tt.commands))
+			continue
+		}
+		for i, stmt := range stmts {
+			if stmt.Command != tt.commands[i] || stmt.Class != tt.classes[i] {
+				t.Errorf("Classify(%q)[%d] = %s %v, want %s %v", tt.sql, i, stmt.Command, stmt.Class, tt.commands[i], tt.classes[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package classify
+
+import (
+	"strings"
+	"unicode"
+)
+
+// token is a keyword or identifier (upper-cased), or a single punctuation
+// character. Literals and comments are dropped. Quoted identifiers keep their
+// leading quote, so that they are not mistaken for keywords: "into" is the
+// token "INTO.
+type token string
+
+// unknownIdent is a quoted identifier whose name the lexer cannot tell, like
+// one with Unicode escapes: U&"\0073et_config".
+const unknownIdent token = `"`
+
+// name returns the name of an identifier, quoted or not, upper-cased. It
+// returns the empty string for unknownIdent.
+func (t token) name() string {
+	return strings.TrimPrefix(string(t), `"`)
+}
+
+// lex splits sql into statements and each statement into tokens. It knows
+// just enough SQL to skip comments, string literals, quoted identifiers and
+// dollar-quoted strings, so that semicolons inside them are not mistaken for
+// statement separators.
+func lex(sql string) (stmts [][]token, texts []string) {
+	var (
+		cur   []token
+		start int
+	)
+	flush := func(end int) {
+		if len(cur) > 0 {
+			stmts = append(stmts, cur)
+			texts = append(texts, strings.TrimSpace(sql[start:end]))
+		}
+		cur = nil
+		start = end + 1
+	}
+
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == ';':
+			flush(i)
+			i++
+		case c == '-' && i+1 < len(sql) && sql[i+1] == '-':
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(sql) && sql[i+1] == '*':
+			i = skipBlockComment(sql, i)
+		case c == '\'':
+			i = skipQuoted(sql, i, '\'')
+		case c == '"':
+			j := skipQuoted(sql, i, '"')
+			if n := len(cur); n >= 2 && cur[n-2] == "U" && cur[n-1] == "&" && sql[i-1] == '&' {
+				// The U& prefix is part of the identifier.
+				cur = append(cur[:n-2], unknownIdent)
+			} else {
+				ident := strings.ReplaceAll(strings.TrimSuffix(sql[i+1:j], `"`), `""`, `"`)
+				cur = append(cur, token(`"`+strings.ToUpper(ident)))
+			}
+			i = j
+		case c == '$':
+			i = skipDollarQuoted(sql, i)
+		case isIdentStart(c):
+			j := i
+			for j < len(sql) && isIdentPart(sql[j]) {
+				j++
+			}
+			// E'...', B'...', X'...' and U&'...' prefixes are part of a
+			// literal.
+			if j < len(sql) && sql[j] == '\'' {
+				i = j
+				continue
+			}
+			cur = append(cur, token(strings.ToUpper(sql[i:j])))
+			i = j
+		case unicode.IsSpace(rune(c)):
+			i++
+		default:
+			cur = append(cur, token(string(c)))
+			i++
+		}
+	}
+	flush(len(sql))
+
+	return stmts, texts
+}
+
+func skipBlockComment(sql string, i int) int {
+	depth := 0
+	for i < len(sql) {
+		switch {
+		case strings.HasPrefix(sql[i:], "/*"):
+			depth++
+			i += 2
+		case strings.HasPrefix(sql[i:], "*/"):
+			depth--
+			i += 2
+			if depth == 0 {
+				return i
+			}
+		default:
+			i++
+		}
+	}
+	return i
+}
+
+// skipQuoted skips a literal enclosed in q, where a doubled q stands for
+// itself. Backslash escapes are skipped too: they only matter for escape
+// strings, but are harmless elsewhere for the purpose of finding the end.
+func skipQuoted(sql string, i int, q byte) int {
+	for i++; i < len(sql); i++ {
+		switch sql[i] {
+		case '\\':
+			if q == '\'' {
+				i++
+			}
+		case q:
+			if i+1 < len(sql) && sql[i+1] == q {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return i
+}
+
+func skipDollarQuoted(sql string, i int) int {
+	j := i + 1
+	for j < len(sql) && isIdentPart(sql[j]) && sql[j] != '$' {
+		j++
+	}
+	if j >= len(sql) || sql[j] != '$' {
+		// A positional parameter, such as $1.
+		return j
+	}
+	tag := sql[i : j+1]
+	end := strings.Index(sql[j+1:], tag)
+	if end < 0 {
+		return len(sql)
+	}
+	return j + 1 + end + len(tag)
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9' || c == '$'
+}
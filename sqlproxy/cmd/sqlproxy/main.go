@@ -0,0 +1,108 @@
+// Command sqlproxy is a read-only PostgreSQL protocol proxy that sits between
+// the sandbox and a demo database (PostgreSQL or CockroachDB).
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/upper/upper.io/sqlproxy/proxy"
+)
+
+var (
+	flagListen           = flag.String("listen", ":5432", "listen address")
+	flagUpstream         = flag.String("upstream", "demo.upper.io:5432", "address of the database")
+	flagUpstreamSSLMode  = flag.String("upstream-sslmode", "disable", "TLS mode for the database connection: disable, require or verify-full")
+	flagUpstreamCA       = flag.String("upstream-ca", "", "CA certificate used to verify the database with verify-full")
+	flagTLSCert          = flag.String("tls-cert", "", "certificate offered to clients, a self-signed one is generated if empty")
+	flagTLSKey           = flag.String("tls-key", "", "key of -tls-cert")
+	flagMaxConns         = flag.Int("max-conns", 32, "maximum number of concurrent connections")
+	flagStatementTimeout = flag.Duration("statement-timeout", 5*time.Second, "statement timeout")
+	flagMaxRows          = flag.Int("max-rows", 1000, "maximum number of rows returned for a statement")
+	flagExplainListen    = flag.String("explain-listen", "", "listen address of the plans endpoint, explaining statements is disabled if empty")
+	flagExplainAnalyze   = flag.Bool("explain-analyze", false, "use EXPLAIN ANALYZE, which runs statements twice; only for disposable databases")
+)
+
+func main() {
+	flag.Parse()
+
+	upstreamTLS, err := upstreamTLSConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	clientTLS, err := clientTLSConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	p := proxy.New(proxy.Config{
+		Upstream:         *flagUpstream,
+		UpstreamTLS:      upstreamTLS,
+		TLS:              clientTLS,
+		MaxConns:         *flagMaxConns,
+		StatementTimeout: *flagStatementTimeout,
+		MaxRows:          *flagMaxRows,
+		Explain:          *flagExplainListen != "",
+		ExplainAnalyze:   *flagExplainAnalyze,
+	})
+
+	if *flagExplainListen != "" {
+		go func() {
+			log.Printf("serving plans on %s", *flagExplainListen)
+			log.Fatal(http.ListenAndServe(*flagExplainListen, p.PlansHandler()))
+		}()
+	}
+
+	l, err := net.Listen("tcp", *flagListen)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("listening on %s, relaying to %s", *flagListen, *flagUpstream)
+	log.Fatal(p.Serve(l))
+}
+
+func upstreamTLSConfig() (*tls.Config, error) {
+	host, _, err := net.SplitHostPort(*flagUpstream)
+	if err != nil {
+		return nil, err
+	}
+
+	switch *flagUpstreamSSLMode {
+	case "disable":
+		return nil, nil
+	case "require":
+		// Like libpq's sslmode=require: encrypt, but do not verify.
+		return &tls.Config{InsecureSkipVerify: true}, nil
+	case "verify-full":
+		pem, err := os.ReadFile(*flagUpstreamCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", *flagUpstreamCA)
+		}
+		return &tls.Config{RootCAs: pool, ServerName: host}, nil
+	}
+	return nil, fmt.Errorf("unknown sslmode %q", *flagUpstreamSSLMode)
+}
+
+func clientTLSConfig() (*tls.Config, error) {
+	if *flagTLSCert == "" {
+		return proxy.SelfSignedTLS()
+	}
+	cert, err := tls.LoadX509KeyPair(*flagTLSCert, *flagTLSKey)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
@@ -0,0 +1,280 @@
+// Package explain turns the output of EXPLAIN into a tree that looks the same
+// for PostgreSQL and CockroachDB.
+package explain
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Databases.
+const (
+	PostgreSQL  = "postgresql"
+	CockroachDB = "cockroachdb"
+)
+
+// Plan is the plan of a statement executed by a program.
+type Plan struct {
+	Database  string
+	Statement string
+	// Arguments are the parameters of the statement in text format.
+	Arguments []string `json:",omitempty"`
+	// Analyze is set if the statement was run with EXPLAIN ANALYZE, in which
+	// case the nodes have actual row counts.
+	Analyze bool
+	Root    *Node `json:",omitempty"`
+	// Details are the properties of the whole plan, such as the planning
+	// time.
+	Details map[string]string `json:",omitempty"`
+	Error   string            `json:",omitempty"`
+	Time    time.Time
+}
+
+// Node is an operation of a plan.
+type Node struct {
+	// Operation is the name the database gives to the operation, such as
+	// "Seq Scan" or "scan".
+	Operation     string
+	Table         string   `json:",omitempty"`
+	Index         string   `json:",omitempty"`
+	EstimatedRows *float64 `json:",omitempty"`
+	ActualRows    *float64 `json:",omitempty"`
+	// Cost is the estimated total cost, only reported by PostgreSQL.
+	Cost *float64 `json:",omitempty"`
+	// Details are the other properties of the operation, as reported by the
+	// database.
+	Details  map[string]string `json:",omitempty"`
+	Children []*Node           `json:",omitempty"`
+}
+
+// Statement returns the statement that explains query on the given database.
+func Statement(database string, analyze bool, query string) string {
+	switch {
+	case database == PostgreSQL && analyze:
+		return "EXPLAIN (ANALYZE, FORMAT JSON) " + query
+	case database == PostgreSQL:
+		return "EXPLAIN (FORMAT JSON) " + query
+	case analyze:
+		return "EXPLAIN ANALYZE " + query
+	}
+	return "EXPLAIN " + query
+}
+
+// Parse fills in the tree of p from the rows returned by the statement from
+// Statement. Each row is the text of its first column.
+func (p *Plan) Parse(rows []string) error {
+	var err error
+	switch p.Database {
+	case PostgreSQL:
+		p.Root, p.Details, err = parsePostgres(strings.Join(rows, "\n"))
+	case CockroachDB:
+		p.Root, p.Details, err = parseCockroach(rows)
+	default:
+		err = fmt.Errorf("unknown database %q", p.Database)
+	}
+	return err
+}
+
+// parsePostgres parses the output of EXPLAIN (FORMAT JSON).
+func parsePostgres(data string) (*Node, map[string]string, error) {
+	var out []map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &out); err != nil {
+		return nil, nil, fmt.Errorf("invalid plan: %w", err)
+	}
+	if len(out) == 0 {
+		return nil, nil, fmt.Errorf("empty plan")
+	}
+
+	plan, ok := out[0]["Plan"].(map[string]interface{})
+	if !ok {
+		return nil, nil, fmt.Errorf("plan without nodes")
+	}
+	details := make(map[string]string)
+	for k, v := range out[0] {
+		if k != "Plan" {
+			details[k] = formatValue(v)
+		}
+	}
+	return postgresNode(plan), details, nil
+}
+
+func postgresNode(m map[string]interface{}) *Node {
+	n := &Node{Details: make(map[string]string)}
+	for k, v := range m {
+		switch k {
+		case "Node Type":
+			n.Operation, _ = v.(string)
+		case "Relation Name":
+			n.Table, _ = v.(string)
+		case "Index Name":
+			n.Index, _ = v.(string)
+		case "Plan Rows":
+			n.EstimatedRows = number(v)
+		case "Actual Rows":
+			n.ActualRows = number(v)
+		case "Total Cost":
+			n.Cost = number(v)
+		case "Plans":
+			children, _ := v.([]interface{})
+			for _, c := range children {
+				if c, ok := c.(map[string]interface{}); ok {
+					n.Children = append(n.Children, postgresNode(c))
+				}
+			}
+		default:
+			n.Details[k] = formatValue(v)
+		}
+	}
+	return n
+}
+
+// parseCockroach parses the text tree returned by CockroachDB's EXPLAIN:
+//
+//	distribution: local
+//	vectorized: true
+//
+//	• sort
+//	│ order: +title
+//	│
+//	└── • scan
+//	      estimated row count: 15 (100% of the table; stats collected 2 days ago)
+//	      table: books@primary
+//	      spans: FULL SCAN
+//
+// Nodes are marked with a bullet, and the column of the bullet gives their
+// depth. The lines below a node are its properties; the lines before the
+// first node are properties of the whole plan.
+func parseCockroach(rows []string) (*Node, map[string]string, error) {
+	type level struct {
+		col  int
+		node *Node
+	}
+	var (
+		root    *Node
+		stack   []level
+		current *Node
+		details = make(map[string]string)
+	)
+
+	for _, row := range rows {
+		for _, line := range strings.Split(row, "\n") {
+			if i := strings.Index(line, "•"); i >= 0 {
+				col := utf8.RuneCountInString(line[:i])
+				n := &Node{
+					Operation: strings.TrimSpace(line[i+len("•"):]),
+					Details:   make(map[string]string),
+				}
+				for len(stack) > 0 && stack[len(stack)-1].col >= col {
+					stack = stack[:len(stack)-1]
+				}
+				if len(stack) == 0 {
+					if root != nil {
+						return nil, nil, fmt.Errorf("plan with more than one root")
+					}
+					root = n
+				} else {
+					parent := stack[len(stack)-1].node
+					parent.Children = append(parent.Children, n)
+				}
+				stack = append(stack, level{col: col, node: n})
+				current = n
+				continue
+			}
+
+			key, value, ok := cockroachProperty(line)
+			if !ok {
+				continue
+			}
+			if current == nil {
+				details[key] = value
+				continue
+			}
+			switch key {
+			case "table":
+				current.Table = value
+				if i := strings.IndexByte(value, '@'); i >= 0 {
+					current.Table, current.Index = value[:i], value[i+1:]
+				}
+			case "estimated row count":
+				current.EstimatedRows = leadingNumber(value)
+			case "actual row count":
+				current.ActualRows = leadingNumber(value)
+			default:
+				current.Details[key] = value
+			}
+		}
+	}
+
+	if root == nil {
+		return nil, nil, fmt.Errorf("plan without nodes")
+	}
+	return root, details, nil
+}
+
+// cockroachProperty parses a "key: value" line, ignoring the characters that
+// draw the tree.
+func cockroachProperty(line string) (key, value string, ok bool) {
+	line = strings.TrimLeft(line, " │├└─")
+	i := strings.Index(line, ": ")
+	if i < 0 {
+		if line = strings.TrimSpace(line); line != "" {
+			// Properties without a value, like "missing stats".
+			return line, "", true
+		}
+		return "", "", false
+	}
+	return strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+2:]), true
+}
+
+// leadingNumber parses the number at the beginning of s, as in
+// "1,000 (100% of the table)".
+func leadingNumber(s string) *float64 {
+	if i := strings.IndexByte(s, ' '); i >= 0 {
+		s = s[:i]
+	}
+	f, err := strconv.ParseFloat(strings.ReplaceAll(s, ",", ""), 64)
+	if err != nil {
+		return nil
+	}
+	return &f
+}
+
+func number(v interface{}) *float64 {
+	if f, ok := v.(float64); ok {
+		return &f
+	}
+	return nil
+}
+
+// formatValue formats a value of a JSON plan as a string.
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []interface{}:
+		s := make([]string, len(v))
+		for i := range v {
+			s[i] = formatValue(v[i])
+		}
+		return strings.Join(s, ", ")
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		s := make([]string, len(keys))
+		for i, k := range keys {
+			s[i] = k + ": " + formatValue(v[k])
+		}
+		return strings.Join(s, ", ")
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,170 @@
+package explain
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func f(v float64) *float64 { return &v }
+
+func TestStatement(t *testing.T) {
+	tests := []struct {
+		database string
+		analyze  bool
+		want     string
+	}{
+		{PostgreSQL, false, "EXPLAIN (FORMAT JSON) SELECT 1"},
+		{PostgreSQL, true, "EXPLAIN (ANALYZE, FORMAT JSON) SELECT 1"},
+		{CockroachDB, false, "EXPLAIN SELECT 1"},
+		{CockroachDB, true, "EXPLAIN ANALYZE SELECT 1"},
+	}
+	for _, tt := range tests {
+		if got := Statement(tt.database, tt.analyze, "SELECT 1"); got != tt.want {
+			t.Errorf("Statement(%s, %v) = %q, want %q", tt.database, tt.analyze, got, tt.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		database string
+		rows     []string
+		root     *Node
+		details  map[string]string
+		err      string
+	}{
+		{
+			name:     "postgresql",
+			database: PostgreSQL,
+			rows: []string{`[
+  {
+    "Plan": {
+      "Node Type": "Sort",
+      "Startup Cost": 1.5,
+      "Total Cost": 1.54,
+      "Plan Rows": 15,
+      "Actual Rows": 15,
+      "Sort Key": ["title"],
+      "Plans": [
+        {
+          "Node Type": "Index Scan",
+          "Parent Relationship": "Outer",
+          "Relation Name": "books",
+          "Index Name": "books_pkey",
+          "Total Cost": 1.2,
+          "Plan Rows": 15,
+          "Index Cond": "(id > 1)"
+        }
+      ]
+    },
+    "Planning Time": 0.061,
+    "Triggers": []
+  }
+]`},
+			root: &Node{
+				Operation:     "Sort",
+				EstimatedRows: f(15),
+				ActualRows:    f(15),
+				Cost:          f(1.54),
+				Details:       map[string]string{"Startup Cost": "1.5", "Sort Key": "title"},
+				Children: []*Node{{
+					Operation:     "Index Scan",
+					Table:         "books",
+					Index:         "books_pkey",
+					EstimatedRows: f(15),
+					Cost:          f(1.2),
+					Details:       map[string]string{"Parent Relationship": "Outer", "Index Cond": "(id > 1)"},
+				}},
+			},
+			details: map[string]string{"Planning Time": "0.061", "Triggers": ""},
+		},
+		{
+			name:     "cockroachdb",
+			database: CockroachDB,
+			rows: strings.Split(`distribution: local
+vectorized: true
+
+• hash join
+│ equality: (author_id) = (id)
+│ estimated row count: 1,000
+│
+├── • scan
+│     missing stats
+│     table: books@primary
+│     spans: FULL SCAN
+│
+└── • filter
+    │ filter: name = 'Poe'
+    │
+    └── • scan
+          estimated row count: 3 (100% of the table; stats collected 2 days ago)
+          actual row count: 2
+          table: authors@authors_name_idx`, "\n"),
+			root: &Node{
+				Operation:     "hash join",
+				EstimatedRows: f(1000),
+				Details:       map[string]string{"equality": "(author_id) = (id)"},
+				Children: []*Node{
+					{
+						Operation: "scan",
+						Table:     "books",
+						Index:     "primary",
+						Details:   map[string]string{"missing stats": "", "spans": "FULL SCAN"},
+					},
+					{
+						Operation: "filter",
+						Details:   map[string]string{"filter": "name = 'Poe'"},
+						Children: []*Node{{
+							Operation:     "scan",
+							Table:         "authors",
+							Index:         "authors_name_idx",
+							EstimatedRows: f(3),
+							ActualRows:    f(2),
+							Details:       map[string]string{},
+						}},
+					},
+				},
+			},
+			details: map[string]string{"distribution": "local", "vectorized": "true"},
+		},
+		{
+			// Rows may hold several lines each.
+			name:     "cockroachdb in one row",
+			database: CockroachDB,
+			rows:     []string{"• scan\n  table: books"},
+			root:     &Node{Operation: "scan", Table: "books", Details: map[string]string{}},
+			details:  map[string]string{},
+		},
+		{name: "two roots", database: CockroachDB, rows: []string{"• scan", "• scan"}, err: "more than one root"},
+		{name: "no nodes", database: CockroachDB, rows: []string{"distribution: local"}, err: "without nodes"},
+		{name: "invalid json", database: PostgreSQL, rows: []string{"[{"}, err: "invalid plan"},
+		{name: "empty json", database: PostgreSQL, rows: []string{"[]"}, err: "empty plan"},
+		{name: "no plan", database: PostgreSQL, rows: []string{`[{"Planning Time": 1}]`}, err: "without nodes"},
+		{name: "unknown database", database: "mysql", rows: []string{"x"}, err: "unknown database"},
+	}
+	for _, tt := range tests {
+		p := &Plan{Database: tt.database}
+		err := p.Parse(tt.rows)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want one with %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(p.Root, tt.root) {
+			got, _ := json.MarshalIndent(p.Root, "", "  ")
+			want, _ := json.MarshalIndent(tt.root, "", "  ")
+			t.Errorf("%s: tree\n%s\nwant\n%s", tt.name, got, want)
+		}
+		if !reflect.DeepEqual(p.Details, tt.details) {
+			t.Errorf("%s: details %q, want %q", tt.name, p.Details, tt.details)
+		}
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/upper/upper.io/sqlproxy
+
+go 1.17
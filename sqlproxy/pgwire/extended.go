@@ -0,0 +1,204 @@
+package pgwire
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errMalformed = errors.New("pgwire: malformed message")
+
+// ParseStatement returns the statement name, the query text and the raw
+// parameter type section of a Parse message.
+func ParseStatement(m *Message) (name, query string, paramTypes []byte) {
+	name, rest := cstring(m.Body)
+	query, rest = cstring(rest)
+	return name, query, rest
+}
+
+// NewParse returns a Parse message. paramTypes is the raw parameter type
+// section, as returned by ParseStatement.
+func NewParse(name, query string, paramTypes []byte) *Message {
+	body := append([]byte(name), 0)
+	body = append(body, query...)
+	body = append(body, 0)
+	if len(paramTypes) == 0 {
+		paramTypes = []byte{0, 0}
+	}
+	body = append(body, paramTypes...)
+	return &Message{Type: Parse, Body: body}
+}
+
+// BindMessage holds the fields of a Bind message.
+type BindMessage struct {
+	Portal    string
+	Statement string
+	// ParamFormats and Params are the format codes and values of the
+	// parameters. A nil value is NULL.
+	ParamFormats  []int16
+	Params        [][]byte
+	ResultFormats []int16
+}
+
+// ParseBind parses a Bind message.
+func ParseBind(m *Message) (*BindMessage, error) {
+	b := &BindMessage{}
+	rest := m.Body
+	b.Portal, rest = cstring(rest)
+	b.Statement, rest = cstring(rest)
+
+	var err error
+	if b.ParamFormats, rest, err = readInt16s(rest); err != nil {
+		return nil, err
+	}
+
+	if len(rest) < 2 {
+		return nil, errMalformed
+	}
+	n := int(binary.BigEndian.Uint16(rest))
+	rest = rest[2:]
+	b.Params = make([][]byte, n)
+	for i := range b.Params {
+		if len(rest) < 4 {
+			return nil, errMalformed
+		}
+		size := int32(binary.BigEndian.Uint32(rest))
+		rest = rest[4:]
+		if size < 0 {
+			continue
+		}
+		if len(rest) < int(size) {
+			return nil, errMalformed
+		}
+		b.Params[i], rest = rest[:size], rest[size:]
+	}
+
+	if b.ResultFormats, _, err = readInt16s(rest); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Message returns b as a Bind message.
+func (b *BindMessage) Message() *Message {
+	body := append([]byte(b.Portal), 0)
+	body = append(body, b.Statement...)
+	body = append(body, 0)
+	body = appendInt16s(body, b.ParamFormats)
+	body = appendUint16(body, uint16(len(b.Params)))
+	for _, p := range b.Params {
+		if p == nil {
+			body = appendUint32(body, 0xffffffff)
+			continue
+		}
+		body = appendUint32(body, uint32(len(p)))
+		body = append(body, p...)
+	}
+	body = appendInt16s(body, b.ResultFormats)
+	return &Message{Type: Bind, Body: body}
+}
+
+// ParamFormat returns the format code of the i-th parameter: 0 for text and 1
+// for binary.
+func (b *BindMessage) ParamFormat(i int) int16 {
+	switch len(b.ParamFormats) {
+	case 0:
+		return 0
+	case 1:
+		return b.ParamFormats[0]
+	}
+	if i < len(b.ParamFormats) {
+		return b.ParamFormats[i]
+	}
+	return 0
+}
+
+// NewExecute returns an Execute message for a portal, without a row limit.
+func NewExecute(portal string) *Message {
+	body := append([]byte(portal), 0)
+	body = appendUint32(body, 0)
+	return &Message{Type: Execute, Body: body}
+}
+
+// NewClose returns a Close message for a prepared statement ('S') or a
+// portal ('P').
+func NewClose(kind byte, name string) *Message {
+	body := append([]byte{kind}, name...)
+	body = append(body, 0)
+	return &Message{Type: Close, Body: body}
+}
+
+// NewSync returns a Sync message.
+func NewSync() *Message {
+	return &Message{Type: Sync}
+}
+
+// NewQuery returns a Query message.
+func NewQuery(sql string) *Message {
+	return &Message{Type: Query, Body: append([]byte(sql), 0)}
+}
+
+// DataRowValues returns the column values of a DataRow message. A nil value
+// is NULL.
+func DataRowValues(m *Message) ([][]byte, error) {
+	rest := m.Body
+	if len(rest) < 2 {
+		return nil, errMalformed
+	}
+	values := make([][]byte, binary.BigEndian.Uint16(rest))
+	rest = rest[2:]
+	for i := range values {
+		if len(rest) < 4 {
+			return nil, errMalformed
+		}
+		size := int32(binary.BigEndian.Uint32(rest))
+		rest = rest[4:]
+		if size < 0 {
+			continue
+		}
+		if len(rest) < int(size) {
+			return nil, errMalformed
+		}
+		values[i], rest = rest[:size], rest[size:]
+	}
+	return values, nil
+}
+
+// ParameterStatusValue returns the name and the value of a ParameterStatus
+// message.
+func ParameterStatusValue(m *Message) (name, value string) {
+	name, rest := cstring(m.Body)
+	value, _ = cstring(rest)
+	return name, value
+}
+
+func readInt16s(b []byte) ([]int16, []byte, error) {
+	if len(b) < 2 {
+		return nil, nil, errMalformed
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	b = b[2:]
+	if len(b) < 2*n {
+		return nil, nil, errMalformed
+	}
+	v := make([]int16, n)
+	for i := range v {
+		v[i] = int16(binary.BigEndian.Uint16(b[2*i:]))
+	}
+	return v, b[2*n:], nil
+}
+
+func appendInt16s(b []byte, v []int16) []byte {
+	b = appendUint16(b, uint16(len(v)))
+	for _, n := range v {
+		b = appendUint16(b, uint16(n))
+	}
+	return b
+}
+
+func appendUint16(b []byte, n uint16) []byte {
+	return append(b, byte(n>>8), byte(n))
+}
+
+func appendUint32(b []byte, n uint32) []byte {
+	return append(b, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
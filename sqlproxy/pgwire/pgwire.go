@@ -0,0 +1,249 @@
+// Package pgwire reads and writes messages of the PostgreSQL frontend/backend
+// protocol (version 3), which CockroachDB speaks as well.
+package pgwire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Special codes sent instead of a protocol version in startup packets.
+const (
+	ProtocolVersion   = 196608
+	CancelRequestCode = 80877102
+	SSLRequestCode    = 80877103
+	GSSENCRequestCode = 80877104
+)
+
+const (
+	maxStartupLength   = 10000
+	defaultMaxBodySize = 1 << 24
+)
+
+// Frontend message types.
+const (
+	Query     = 'Q'
+	Parse     = 'P'
+	Bind      = 'B'
+	Execute   = 'E'
+	Describe  = 'D'
+	Close     = 'C'
+	Sync      = 'S'
+	Flush     = 'H'
+	Terminate = 'X'
+	Password  = 'p'
+
+	FunctionCall = 'F'
+	CopyData     = 'd'
+	CopyDone     = 'c'
+	CopyFail     = 'f'
+)
+
+// Backend message types.
+const (
+	Authentication  = 'R'
+	BackendKeyData  = 'K'
+	CommandComplete = 'C'
+	DataRow         = 'D'
+	EmptyQuery      = 'I'
+	ErrorResponse   = 'E'
+	NoticeResponse  = 'N'
+	ParameterStatus = 'S'
+	PortalSuspended = 's'
+	ReadyForQuery   = 'Z'
+)
+
+// Authentication request codes that expect a reply from the frontend.
+const (
+	AuthCleartextPassword = 3
+	AuthMD5Password       = 5
+	AuthSASL              = 10
+	AuthSASLContinue      = 11
+)
+
+// ErrMessageTooLarge is returned when a message exceeds the maximum size.
+var ErrMessageTooLarge = errors.New("pgwire: message too large")
+
+// Message is a regular protocol message.
+type Message struct {
+	Type byte
+	Body []byte
+}
+
+// Bytes returns the message in wire format.
+func (m *Message) Bytes() []byte {
+	buf := make([]byte, 5+len(m.Body))
+	buf[0] = m.Type
+	binary.BigEndian.PutUint32(buf[1:5], uint32(4+len(m.Body)))
+	copy(buf[5:], m.Body)
+	return buf
+}
+
+// ReadMessage reads a message whose body is at most maxBody bytes long. A
+// maxBody of zero means a default of 16MiB.
+func ReadMessage(r io.Reader, maxBody int) (*Message, error) {
+	if maxBody <= 0 {
+		maxBody = defaultMaxBodySize
+	}
+
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	n := int(binary.BigEndian.Uint32(header[1:5])) - 4
+	if n < 0 {
+		return nil, fmt.Errorf("pgwire: invalid message length %d", n+4)
+	}
+	if n > maxBody {
+		return nil, ErrMessageTooLarge
+	}
+
+	m := &Message{Type: header[0], Body: make([]byte, n)}
+	if _, err := io.ReadFull(r, m.Body); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Startup is the first packet a frontend sends. Code is either the protocol
+// version or one of the special request codes.
+type Startup struct {
+	Code uint32
+	Body []byte
+}
+
+// Bytes returns the startup packet in wire format.
+func (s *Startup) Bytes() []byte {
+	buf := make([]byte, 8+len(s.Body))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(8+len(s.Body)))
+	binary.BigEndian.PutUint32(buf[4:8], s.Code)
+	copy(buf[8:], s.Body)
+	return buf
+}
+
+// Params returns the parameters of a startup message.
+func (s *Startup) Params() map[string]string {
+	params := make(map[string]string)
+	fields := bytes.Split(s.Body, []byte{0})
+	for i := 0; i+1 < len(fields); i += 2 {
+		if len(fields[i]) == 0 {
+			break
+		}
+		params[string(fields[i])] = string(fields[i+1])
+	}
+	return params
+}
+
+// ReadStartup reads a startup packet.
+func ReadStartup(r io.Reader) (*Startup, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	n := int(binary.BigEndian.Uint32(header[0:4]))
+	if n < 8 || n > maxStartupLength {
+		return nil, fmt.Errorf("pgwire: invalid startup packet length %d", n)
+	}
+
+	s := &Startup{
+		Code: binary.BigEndian.Uint32(header[4:8]),
+		Body: make([]byte, n-8),
+	}
+	if _, err := io.ReadFull(r, s.Body); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SSLRequest returns an SSLRequest packet.
+func SSLRequest() []byte {
+	return (&Startup{Code: SSLRequestCode}).Bytes()
+}
+
+// AuthCode returns the request code of an Authentication message.
+func AuthCode(m *Message) int {
+	if m.Type != Authentication || len(m.Body) < 4 {
+		return -1
+	}
+	return int(binary.BigEndian.Uint32(m.Body[0:4]))
+}
+
+// QueryString returns the query text of a Query message.
+func QueryString(m *Message) string {
+	s, _ := cstring(m.Body)
+	return s
+}
+
+// ParseQuery returns the query text of a Parse message.
+func ParseQuery(m *Message) string {
+	_, rest := cstring(m.Body)
+	s, _ := cstring(rest)
+	return s
+}
+
+// CommandTag returns the tag of a CommandComplete message.
+func CommandTag(m *Message) string {
+	s, _ := cstring(m.Body)
+	return s
+}
+
+// NewCommandComplete returns a CommandComplete message with the given tag.
+func NewCommandComplete(tag string) *Message {
+	return &Message{Type: CommandComplete, Body: append([]byte(tag), 0)}
+}
+
+// NewReadyForQuery returns a ReadyForQuery message with the given transaction
+// status.
+func NewReadyForQuery(status byte) *Message {
+	return &Message{Type: ReadyForQuery, Body: []byte{status}}
+}
+
+// Notice holds the fields of an ErrorResponse or a NoticeResponse.
+type Notice struct {
+	Severity string
+	Code     string
+	Message  string
+	Detail   string
+	Hint     string
+}
+
+// ErrorMessage returns n as an ErrorResponse message.
+func (n *Notice) ErrorMessage() *Message {
+	return n.message(ErrorResponse)
+}
+
+// NoticeMessage returns n as a NoticeResponse message.
+func (n *Notice) NoticeMessage() *Message {
+	return n.message(NoticeResponse)
+}
+
+func (n *Notice) message(typ byte) *Message {
+	var body []byte
+	field := func(code byte, value string) {
+		if value == "" {
+			return
+		}
+		body = append(body, code)
+		body = append(body, value...)
+		body = append(body, 0)
+	}
+	field('S', n.Severity)
+	field('V', n.Severity)
+	field('C', n.Code)
+	field('M', n.Message)
+	field('D', n.Detail)
+	field('H', n.Hint)
+	body = append(body, 0)
+	return &Message{Type: typ, Body: body}
+}
+
+func cstring(b []byte) (string, []byte) {
+	i := bytes.IndexByte(b, 0)
+	if i < 0 {
+		return string(b), nil
+	}
+	return string(b[:i]), b[i+1:]
+}
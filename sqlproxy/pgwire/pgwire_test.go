@@ -0,0 +1,172 @@
+package pgwire
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestReadMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+		max  int
+		msg  *Message
+		err  error
+	}{
+		{"query", NewQuery("SELECT 1").Bytes(), 0, &Message{Type: Query, Body: []byte("SELECT 1\x00")}, nil},
+		{"no body", NewSync().Bytes(), 0, &Message{Type: Sync, Body: []byte{}}, nil},
+		{"at the limit", NewQuery("SELECT 1").Bytes(), 9, &Message{Type: Query, Body: []byte("SELECT 1\x00")}, nil},
+		{"too large", NewQuery("SELECT 1").Bytes(), 8, nil, ErrMessageTooLarge},
+		{"cut in the header", []byte{'Q', 0, 0}, 0, nil, io.ErrUnexpectedEOF},
+		{"cut in the body", NewQuery("SELECT 1").Bytes()[:8], 0, nil, io.ErrUnexpectedEOF},
+		{"nothing", nil, 0, nil, io.EOF},
+	}
+	for _, tt := range tests {
+		msg, err := ReadMessage(bytes.NewReader(tt.in), tt.max)
+		if err != tt.err || !reflect.DeepEqual(msg, tt.msg) {
+			t.Errorf("%s: ReadMessage = %+v, %v, want %+v, %v", tt.name, msg, err, tt.msg, tt.err)
+		}
+	}
+
+	if _, err := ReadMessage(bytes.NewReader([]byte{'Q', 0, 0, 0, 3}), 0); err == nil {
+		t.Error("ReadMessage of a length below 4 succeeded")
+	}
+}
+
+func TestReadStartup(t *testing.T) {
+	body := []byte("user\x00upper\x00database\x00booktown\x00application_name\x00sqlproxy-explain:42\x00\x00")
+	s, err := ReadStartup(bytes.NewReader((&Startup{Code: ProtocolVersion, Body: body}).Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"user": "upper", "database": "booktown", "application_name": "sqlproxy-explain:42"}
+	if s.Code != ProtocolVersion || !reflect.DeepEqual(s.Params(), want) {
+		t.Errorf("ReadStartup = %d, %v, want %v", s.Code, s.Params(), want)
+	}
+
+	s, err = ReadStartup(bytes.NewReader(SSLRequest()))
+	if err != nil || s.Code != SSLRequestCode || len(s.Body) != 0 {
+		t.Errorf("ReadStartup of an SSLRequest = %+v, %v", s, err)
+	}
+
+	for _, in := range [][]byte{
+		{0, 0, 0, 7, 0, 0, 0, 0},
+		{0, 0, 0x27, 0x11, 0, 3, 0, 0},
+		{0, 0, 0, 12, 0, 3, 0, 0},
+	} {
+		if _, err := ReadStartup(bytes.NewReader(in)); err == nil {
+			t.Errorf("ReadStartup(%x) succeeded", in)
+		}
+	}
+}
+
+func TestAccessors(t *testing.T) {
+	parse := NewParse("s1", "SELECT $1", []byte{0, 1, 0, 0, 0, 23})
+	name, query, types := ParseStatement(parse)
+	if name != "s1" || query != "SELECT $1" || !bytes.Equal(types, []byte{0, 1, 0, 0, 0, 23}) {
+		t.Errorf("ParseStatement = %q, %q, %x", name, query, types)
+	}
+	if got := ParseQuery(parse); got != "SELECT $1" {
+		t.Errorf("ParseQuery = %q", got)
+	}
+	if _, _, types := ParseStatement(NewParse("", "SELECT 1", nil)); !bytes.Equal(types, []byte{0, 0}) {
+		t.Errorf("parameter types of a Parse without them: %x", types)
+	}
+
+	if got := QueryString(NewQuery("SELECT 1")); got != "SELECT 1" {
+		t.Errorf("QueryString = %q", got)
+	}
+	if got := CommandTag(NewCommandComplete("SELECT 3")); got != "SELECT 3" {
+		t.Errorf("CommandTag = %q", got)
+	}
+	status := &Message{Type: ParameterStatus, Body: []byte("server_version\x0013.4\x00")}
+	if name, value := ParameterStatusValue(status); name != "server_version" || value != "13.4" {
+		t.Errorf("ParameterStatusValue = %q, %q", name, value)
+	}
+
+	for _, tt := range []struct {
+		m    *Message
+		code int
+	}{
+		{&Message{Type: Authentication, Body: []byte{0, 0, 0, AuthMD5Password, 1, 2, 3, 4}}, AuthMD5Password},
+		{&Message{Type: Authentication, Body: []byte{0, 0, 0}}, -1},
+		{NewReadyForQuery('I'), -1},
+	} {
+		if got := AuthCode(tt.m); got != tt.code {
+			t.Errorf("AuthCode(%+v) = %d, want %d", tt.m, got, tt.code)
+		}
+	}
+
+	n := &Notice{Severity: "ERROR", Code: "25006", Message: "read-only"}
+	want := "SERROR\x00VERROR\x00C25006\x00Mread-only\x00\x00"
+	if m := n.ErrorMessage(); m.Type != ErrorResponse || string(m.Body) != want {
+		t.Errorf("ErrorMessage = %c %q, want %q", m.Type, m.Body, want)
+	}
+	if m := n.NoticeMessage(); m.Type != NoticeResponse || string(m.Body) != want {
+		t.Errorf("NoticeMessage = %c %q, want %q", m.Type, m.Body, want)
+	}
+}
+
+func TestBind(t *testing.T) {
+	tests := []*BindMessage{
+		{Portal: "", Statement: "s1", ParamFormats: []int16{}, Params: [][]byte{}, ResultFormats: []int16{}},
+		{
+			Portal:        "p",
+			Statement:     "s1",
+			ParamFormats:  []int16{0, 1},
+			Params:        [][]byte{[]byte("42"), nil, {}},
+			ResultFormats: []int16{1},
+		},
+	}
+	for _, b := range tests {
+		got, err := ParseBind(b.Message())
+		if err != nil {
+			t.Errorf("ParseBind(%+v): %v", b, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, b) {
+			t.Errorf("ParseBind = %+v, want %+v", got, b)
+		}
+	}
+
+	full := tests[1].Message().Body
+	for i := 0; i < len(full); i++ {
+		if _, err := ParseBind(&Message{Type: Bind, Body: full[:i]}); err == nil {
+			t.Errorf("ParseBind of %d of %d bytes succeeded", i, len(full))
+		}
+	}
+
+	for _, tt := range []struct {
+		formats []int16
+		format  []int16 // of parameters 0, 1 and 2
+	}{
+		{nil, []int16{0, 0, 0}},
+		{[]int16{1}, []int16{1, 1, 1}},
+		{[]int16{1, 0}, []int16{1, 0, 0}},
+	} {
+		b := &BindMessage{ParamFormats: tt.formats}
+		for i, want := range tt.format {
+			if got := b.ParamFormat(i); got != want {
+				t.Errorf("ParamFormat(%d) with formats %v = %d, want %d", i, tt.formats, got, want)
+			}
+		}
+	}
+}
+
+func TestDataRowValues(t *testing.T) {
+	body := []byte{0, 3, 0, 0, 0, 2, '4', '2', 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0}
+	values, err := DataRowValues(&Message{Type: DataRow, Body: body})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]byte{[]byte("42"), nil, {}}; !reflect.DeepEqual(values, want) {
+		t.Errorf("DataRowValues = %q, want %q", values, want)
+	}
+	for i := 0; i < len(body); i++ {
+		if _, err := DataRowValues(&Message{Type: DataRow, Body: body[:i]}); err == nil {
+			t.Errorf("DataRowValues of %d of %d bytes succeeded", i, len(body))
+		}
+	}
+}
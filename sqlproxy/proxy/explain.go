@@ -0,0 +1,231 @@
+package proxy
+
+import (
+	"time"
+
+	"github.com/upper/upper.io/sqlproxy/classify"
+	"github.com/upper/upper.io/sqlproxy/explain"
+	"github.com/upper/upper.io/sqlproxy/pgwire"
+)
+
+// explainStatementName is the prepared statement the proxy uses to explain
+// statements of the extended query protocol.
+const explainStatementName = "sqlproxy_explain"
+
+// maxPreparedStatements is the maximum number of prepared statements tracked
+// for a session.
+const maxPreparedStatements = 1000
+
+// preparedStatement is a statement prepared by the client that can be
+// explained.
+type preparedStatement struct {
+	query      string
+	paramTypes []byte
+}
+
+// explainCandidate is a statement to explain once the batch that ran it is
+// done.
+type explainCandidate struct {
+	query      string
+	paramTypes []byte
+	// bind is the Bind message of the client for statements of the extended
+	// query protocol.
+	bind *pgwire.BindMessage
+	args []string
+}
+
+// explainJob collects the response to an explain batch.
+type explainJob struct {
+	plan explain.Plan
+	rows []string
+	err  string
+}
+
+// queryCandidates returns the statements of a simple query to explain.
+func queryCandidates(sql string) []explainCandidate {
+	var candidates []explainCandidate
+	for _, stmt := range classify.Classify(sql) {
+		if explainable(stmt) {
+			candidates = append(candidates, explainCandidate{query: stmt.Text})
+		}
+	}
+	return candidates
+}
+
+func explainable(stmt classify.Statement) bool {
+	return stmt.Class == classify.Read && (stmt.Command == "SELECT" || stmt.Command == "WITH")
+}
+
+// prepare remembers a statement of a Parse message if it can be explained.
+func (s *session) prepare(m *pgwire.Message) {
+	name, query, paramTypes := pgwire.ParseStatement(m)
+	stmts := classify.Classify(query)
+	if len(stmts) != 1 || !explainable(stmts[0]) {
+		delete(s.statements, name)
+		return
+	}
+	if _, ok := s.statements[name]; !ok && len(s.statements) >= maxPreparedStatements {
+		return
+	}
+	s.statements[name] = preparedStatement{query: query, paramTypes: paramTypes}
+}
+
+// bindCandidate returns the statement to explain for a Bind message.
+func (s *session) bindCandidate(m *pgwire.Message) (explainCandidate, bool) {
+	b, err := pgwire.ParseBind(m)
+	if err != nil {
+		return explainCandidate{}, false
+	}
+	stmt, ok := s.statements[b.Statement]
+	if !ok {
+		return explainCandidate{}, false
+	}
+
+	args := make([]string, len(b.Params))
+	for i, p := range b.Params {
+		switch {
+		case p == nil:
+			args[i] = "NULL"
+		case b.ParamFormat(i) != 0:
+			args[i] = "(binary)"
+		default:
+			args[i] = string(p)
+		}
+	}
+
+	return explainCandidate{
+		query:      stmt.query,
+		paramTypes: stmt.paramTypes,
+		bind:       b,
+		args:       args,
+	}, true
+}
+
+// explainNow returns the statements of a finished batch to explain now.
+// Statements are only explained when the database is idle and outside of a
+// transaction, so that a failed EXPLAIN cannot affect the client.
+//
+// It must be called with s.mu held.
+func (s *session) explainNow(item pendingItem) []explainCandidate {
+	if len(item.explain) == 0 || len(s.pending) > 0 || s.txStatus != 'I' || s.unsynced {
+		return nil
+	}
+	if s.p.plans.full(s.runID) {
+		return nil
+	}
+	s.explaining += len(item.explain)
+	return item.explain
+}
+
+// sendExplains sends an explain batch to the database for each candidate.
+func (s *session) sendExplains(candidates []explainCandidate) error {
+	for i, c := range candidates {
+		job := &explainJob{
+			plan: explain.Plan{
+				Database:  s.database,
+				Statement: c.query,
+				Arguments: c.args,
+				Analyze:   s.p.cfg.ExplainAnalyze,
+				Time:      time.Now(),
+			},
+		}
+
+		stmt := explain.Statement(s.database, s.p.cfg.ExplainAnalyze, c.query)
+		batch := []*pgwire.Message{pgwire.NewQuery(stmt)}
+		if c.bind != nil {
+			bind := &pgwire.BindMessage{
+				Statement:    explainStatementName,
+				ParamFormats: c.bind.ParamFormats,
+				Params:       c.bind.Params,
+			}
+			batch = []*pgwire.Message{
+				pgwire.NewParse(explainStatementName, stmt, c.paramTypes),
+				bind.Message(),
+				pgwire.NewExecute(""),
+				pgwire.NewClose('S', explainStatementName),
+				pgwire.NewSync(),
+			}
+		}
+
+		if err := s.forward(batch, pendingItem{upstream: true, job: job}); err != nil {
+			s.mu.Lock()
+			s.explaining -= len(candidates) - i
+			s.cond.Broadcast()
+			s.mu.Unlock()
+			return err
+		}
+	}
+	return nil
+}
+
+// collect handles a message of the response to the explain batch at the
+// head of the pending queue.
+//
+// It must be called with s.mu held.
+func (s *session) collect(m *pgwire.Message) error {
+	job := s.pending[0].job
+
+	switch m.Type {
+	case pgwire.DataRow:
+		values, err := pgwire.DataRowValues(m)
+		if err == nil && len(values) > 0 && values[0] != nil {
+			job.rows = append(job.rows, string(values[0]))
+		}
+
+	case pgwire.ErrorResponse:
+		if job.err == "" {
+			job.err = errorText(m)
+		}
+
+	case pgwire.ReadyForQuery:
+		s.txStatus = m.Body[0]
+		s.pending = s.pending[1:]
+		s.explaining--
+		s.cond.Broadcast()
+
+		plan := job.plan
+		if job.err != "" {
+			plan.Error = job.err
+		} else if err := plan.Parse(job.rows); err != nil {
+			plan.Error = err.Error()
+		}
+		s.p.plans.add(s.runID, plan)
+
+		// Deliver the answers that were waiting for the explain batch. The
+		// client may be gone already, so there is nothing to flush otherwise.
+		if len(s.pending) == 0 || s.pending[0].upstream {
+			return nil
+		}
+		for len(s.pending) > 0 && !s.pending[0].upstream {
+			s.writeAnswer(s.pending[0])
+			s.pending = s.pending[1:]
+		}
+		return s.clientWriter.Flush()
+	}
+	return nil
+}
+
+// waitExplains waits for the explain batches sent to the database to finish,
+// for up to a second longer than the statement timeout.
+func (s *session) waitExplains() {
+	timeout := 5 * time.Second
+	if d := s.p.cfg.StatementTimeout; d > 0 {
+		timeout = d + time.Second
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	timedOut := false
+	t := time.AfterFunc(timeout, func() {
+		s.mu.Lock()
+		timedOut = true
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	})
+	defer t.Stop()
+
+	for s.explaining > 0 && !s.backendDone && !timedOut {
+		s.cond.Wait()
+	}
+}
@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/upper/upper.io/sqlproxy/explain"
+)
+
+// ExplainAppPrefix marks the sessions whose statements are explained. A
+// session with application_name set to ExplainAppPrefix followed by a run ID
+// stores the plans of its SELECT statements under that ID, until they are
+// fetched from the plans endpoint.
+const ExplainAppPrefix = "sqlproxy-explain:"
+
+const (
+	// maxPlansPerRun is the maximum number of plans kept for a run.
+	maxPlansPerRun = 100
+	// planTTL is how long the plans of a run are kept after its last session
+	// ends.
+	planTTL = time.Minute
+	// maxPlansWait is the longest a request for plans waits for the sessions
+	// of a run to end.
+	maxPlansWait      = 10 * time.Second
+	plansPollInterval = 50 * time.Millisecond
+)
+
+type planStore struct {
+	mu   sync.Mutex
+	runs map[string]*runPlans
+}
+
+type runPlans struct {
+	plans    []explain.Plan
+	sessions int
+	updated  time.Time
+}
+
+func newPlanStore() *planStore {
+	return &planStore{runs: make(map[string]*runPlans)}
+}
+
+// open registers a session of a run.
+func (ps *planStore) open(run string) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	ps.prune()
+	r, ok := ps.runs[run]
+	if !ok {
+		r = &runPlans{}
+		ps.runs[run] = r
+	}
+	r.sessions++
+	r.updated = time.Now()
+}
+
+// close unregisters a session of a run.
+func (ps *planStore) close(run string) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if r, ok := ps.runs[run]; ok {
+		r.sessions--
+		r.updated = time.Now()
+	}
+}
+
+// add stores a plan. It reports false if the run has too many plans.
+func (ps *planStore) add(run string, plan explain.Plan) bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	r, ok := ps.runs[run]
+	if !ok || len(r.plans) >= maxPlansPerRun {
+		return false
+	}
+	r.plans = append(r.plans, plan)
+	r.updated = time.Now()
+	return true
+}
+
+// full reports whether no more plans can be stored for a run.
+func (ps *planStore) full(run string) bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	r, ok := ps.runs[run]
+	return !ok || len(r.plans) >= maxPlansPerRun
+}
+
+// take returns and forgets the plans of a run. It waits up to wait for the
+// sessions of the run to end, so that the plans of their last statements are
+// included.
+func (ps *planStore) take(run string, wait time.Duration) []explain.Plan {
+	deadline := time.Now().Add(wait)
+	for {
+		ps.mu.Lock()
+		r, ok := ps.runs[run]
+		if !ok || r.sessions <= 0 || time.Now().After(deadline) {
+			delete(ps.runs, run)
+			ps.mu.Unlock()
+			if !ok {
+				return nil
+			}
+			return r.plans
+		}
+		ps.mu.Unlock()
+		time.Sleep(plansPollInterval)
+	}
+}
+
+// prune must be called with ps.mu held.
+func (ps *planStore) prune() {
+	for run, r := range ps.runs {
+		if r.sessions <= 0 && time.Since(r.updated) > planTTL {
+			delete(ps.runs, run)
+		}
+	}
+}
+
+// PlansHandler returns the HTTP handler the compile service fetches plans
+// from:
+//
+//	GET /plans/<run>?wait=2s
+//
+// It replies with the plans of the run as a JSON array, and forgets them.
+func (p *Proxy) PlansHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		run := strings.TrimPrefix(r.URL.Path, "/plans/")
+		if r.Method != http.MethodGet || run == "" || run == r.URL.Path {
+			http.NotFound(w, r)
+			return
+		}
+
+		var wait time.Duration
+		if s := r.URL.Query().Get("wait"); s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				http.Error(w, "invalid wait", http.StatusBadRequest)
+				return
+			}
+			wait = d
+		}
+		if wait > maxPlansWait {
+			wait = maxPlansWait
+		}
+
+		plans := p.plans.take(run, wait)
+		if plans == nil {
+			plans = []explain.Plan{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(plans); err != nil {
+			log.Printf("plans: %v", err)
+		}
+	})
+}
@@ -0,0 +1,261 @@
+// Package proxy implements a read-only PostgreSQL protocol proxy.
+//
+// The proxy relays the traffic between sandboxed programs and a demo
+// database. Every statement is classified before it reaches the database:
+// anything that would modify data or the schema is answered by the proxy with
+// an error that explains the sandbox is read-only. Sessions run with a
+// statement timeout, results are cut after a number of rows and the number of
+// concurrent connections is capped.
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/upper/upper.io/sqlproxy/pgwire"
+)
+
+const (
+	startupTimeout = 10 * time.Second
+	dialTimeout    = 5 * time.Second
+)
+
+// Config holds the settings of a Proxy.
+type Config struct {
+	// Upstream is the address of the database.
+	Upstream string
+
+	// UpstreamTLS is used to connect to the database. If nil, the connection
+	// is not encrypted.
+	UpstreamTLS *tls.Config
+
+	// TLS is offered to clients. If nil, clients are asked to connect without
+	// encryption.
+	TLS *tls.Config
+
+	// MaxConns is the maximum number of concurrent client connections.
+	MaxConns int
+
+	// StatementTimeout is the statement_timeout of every session.
+	StatementTimeout time.Duration
+
+	// MaxRows is the maximum number of rows returned for a statement.
+	MaxRows int
+
+	// Explain enables the plans of SELECT statements for the sessions that
+	// ask for them with ExplainAppPrefix.
+	Explain bool
+
+	// ExplainAnalyze runs the statements with EXPLAIN ANALYZE, which executes
+	// them a second time. Only use it in front of disposable databases.
+	ExplainAnalyze bool
+}
+
+// Proxy is a read-only PostgreSQL protocol proxy.
+type Proxy struct {
+	cfg   Config
+	conns chan struct{}
+	plans *planStore
+}
+
+// New creates a proxy with the given configuration.
+func New(cfg Config) *Proxy {
+	p := &Proxy{cfg: cfg, plans: newPlanStore()}
+	if cfg.MaxConns > 0 {
+		p.conns = make(chan struct{}, cfg.MaxConns)
+	}
+	return p
+}
+
+// Serve accepts client connections on l.
+func (p *Proxy) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go p.handle(conn)
+	}
+}
+
+func (p *Proxy) handle(conn net.Conn) {
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(startupTimeout))
+
+	conn, startup, err := p.negotiate(conn)
+	if err != nil {
+		if err != io.EOF {
+			log.Printf("proxy: %s: %v", conn.RemoteAddr(), err)
+		}
+		return
+	}
+
+	switch startup.Code {
+	case pgwire.CancelRequestCode:
+		p.forwardCancel(startup)
+		return
+	case pgwire.ProtocolVersion:
+	default:
+		writeFatal(conn, &pgwire.Notice{
+			Code:    "08P01", // protocol_violation
+			Message: fmt.Sprintf("unsupported frontend protocol %d", startup.Code),
+		})
+		return
+	}
+
+	if !p.acquire() {
+		writeFatal(conn, &pgwire.Notice{
+			Code:    "53300", // too_many_connections
+			Message: fmt.Sprintf("too many connections to the read-only sandbox (the limit is %d)", p.cfg.MaxConns),
+			Hint:    "Close the sessions you no longer need, or wait a moment and try again.",
+		})
+		return
+	}
+	defer p.release()
+
+	upstream, err := p.dial()
+	if err != nil {
+		log.Printf("proxy: %v", err)
+		writeFatal(conn, &pgwire.Notice{
+			Code:    "08006", // connection_failure
+			Message: "could not connect to the demo database",
+		})
+		return
+	}
+	defer upstream.Close()
+
+	s := newSession(p, conn, upstream)
+	if app := startup.Params()["application_name"]; p.cfg.Explain && strings.HasPrefix(app, ExplainAppPrefix) {
+		s.runID = strings.TrimPrefix(app, ExplainAppPrefix)
+		p.plans.open(s.runID)
+		defer p.plans.close(s.runID)
+	}
+	if err := s.start(startup); err != nil {
+		log.Printf("proxy: %s: startup: %v", conn.RemoteAddr(), err)
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	if err := s.run(); err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+		log.Printf("proxy: %s: %v", conn.RemoteAddr(), err)
+	}
+}
+
+// negotiate reads the startup packet of a client, answering encryption
+// requests on the way. The returned connection is encrypted if the client
+// asked for it and the proxy has TLS configured.
+func (p *Proxy) negotiate(conn net.Conn) (net.Conn, *pgwire.Startup, error) {
+	for {
+		startup, err := pgwire.ReadStartup(conn)
+		if err != nil {
+			return conn, nil, err
+		}
+
+		switch startup.Code {
+		case pgwire.SSLRequestCode:
+			if p.cfg.TLS == nil {
+				if _, err := conn.Write([]byte{'N'}); err != nil {
+					return conn, nil, err
+				}
+				continue
+			}
+			if _, err := conn.Write([]byte{'S'}); err != nil {
+				return conn, nil, err
+			}
+			tlsConn := tls.Server(conn, p.cfg.TLS)
+			if err := tlsConn.Handshake(); err != nil {
+				return conn, nil, err
+			}
+			conn = tlsConn
+		case pgwire.GSSENCRequestCode:
+			if _, err := conn.Write([]byte{'N'}); err != nil {
+				return conn, nil, err
+			}
+		default:
+			return conn, startup, nil
+		}
+	}
+}
+
+// dial connects to the database.
+func (p *Proxy) dial() (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", p.cfg.Upstream)
+	if err != nil {
+		return nil, err
+	}
+	if p.cfg.UpstreamTLS == nil {
+		return conn, nil
+	}
+
+	_ = conn.SetDeadline(time.Now().Add(dialTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	if _, err := conn.Write(pgwire.SSLRequest()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	var resp [1]byte
+	if _, err := io.ReadFull(conn, resp[:]); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp[0] != 'S' {
+		conn.Close()
+		return nil, fmt.Errorf("%s does not support TLS", p.cfg.Upstream)
+	}
+
+	tlsConn := tls.Client(conn, p.cfg.UpstreamTLS)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// forwardCancel passes a cancel request on to the database. The key in the
+// request was issued by the database, so there is nothing to translate.
+func (p *Proxy) forwardCancel(startup *pgwire.Startup) {
+	upstream, err := p.dial()
+	if err != nil {
+		log.Printf("proxy: cancel: %v", err)
+		return
+	}
+	defer upstream.Close()
+
+	_, _ = upstream.Write(startup.Bytes())
+}
+
+func (p *Proxy) acquire() bool {
+	if p.conns == nil {
+		return true
+	}
+	select {
+	case p.conns <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *Proxy) release() {
+	if p.conns != nil {
+		<-p.conns
+	}
+}
+
+func writeFatal(w io.Writer, n *pgwire.Notice) {
+	n.Severity = "FATAL"
+	_, _ = w.Write(n.ErrorMessage().Bytes())
+}
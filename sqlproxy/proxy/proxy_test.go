@@ -0,0 +1,503 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/upper/upper.io/sqlproxy/explain"
+	"github.com/upper/upper.io/sqlproxy/pgwire"
+)
+
+// backend is a database that answers SELECT n with n rows, EXPLAIN with a
+// plan and anything else with an empty result. It records the statements it
+// gets.
+type backend struct {
+	addr string
+
+	mu       sync.Mutex
+	received []string
+}
+
+func newBackend(t *testing.T) *backend {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	b := &backend{addr: l.Addr().String()}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *backend) record(s string) {
+	b.mu.Lock()
+	b.received = append(b.received, s)
+	b.mu.Unlock()
+}
+
+// take returns and forgets the statements received.
+func (b *backend) take() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	received := b.received
+	b.received = nil
+	return received
+}
+
+func (b *backend) serve(conn net.Conn) {
+	defer conn.Close()
+	if _, err := pgwire.ReadStartup(conn); err != nil {
+		return
+	}
+	write := func(typ byte, body []byte) {
+		conn.Write((&pgwire.Message{Type: typ, Body: body}).Bytes())
+	}
+	ready := func() { write(pgwire.ReadyForQuery, []byte{'I'}) }
+	respond := func(sql string) {
+		rows, tag := 0, "SET"
+		switch {
+		case strings.HasPrefix(sql, "EXPLAIN"):
+			plan := []byte(`[{"Plan": {"Node Type": "Result", "Plan Rows": 1}}]`)
+			write(pgwire.DataRow, append([]byte{0, 1, 0, 0, 0, byte(len(plan))}, plan...))
+			tag = "EXPLAIN"
+		case strings.HasPrefix(sql, "SELECT "):
+			rows, _ = strconv.Atoi(strings.TrimPrefix(sql, "SELECT "))
+			if rows == 0 {
+				rows = 1
+			}
+			tag = "SELECT " + strconv.Itoa(rows)
+		}
+		for i := 0; i < rows; i++ {
+			write(pgwire.DataRow, []byte{0, 1, 0, 0, 0, 1, 'x'})
+		}
+		write(pgwire.CommandComplete, append([]byte(tag), 0))
+	}
+
+	write(pgwire.Authentication, []byte{0, 0, 0, 0})
+	write(pgwire.ParameterStatus, []byte("server_version\x0013.4\x00"))
+	ready()
+
+	statements := make(map[string]string)
+	portals := make(map[string]string)
+	for {
+		m, err := pgwire.ReadMessage(conn, 0)
+		if err != nil {
+			return
+		}
+		switch m.Type {
+		case pgwire.Query:
+			sql := pgwire.QueryString(m)
+			b.record(sql)
+			respond(sql)
+			ready()
+		case pgwire.Parse:
+			name, query, _ := pgwire.ParseStatement(m)
+			b.record("parse " + query)
+			statements[name] = query
+			write('1', nil)
+		case pgwire.Bind:
+			bind, err := pgwire.ParseBind(m)
+			if err != nil {
+				return
+			}
+			portals[bind.Portal] = statements[bind.Statement]
+			write('2', nil)
+		case pgwire.Execute:
+			respond(portals[cstring(m.Body)])
+		case pgwire.Close:
+			write('3', nil)
+		case pgwire.Sync:
+			b.record("sync")
+			ready()
+		case pgwire.Terminate:
+			return
+		default:
+			b.record("message " + string(m.Type))
+			ready()
+		}
+	}
+}
+
+// cstring returns the string at the start of b.
+func cstring(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		return string(b[:i])
+	}
+	return string(b)
+}
+
+// newProxy serves a proxy in front of a new backend.
+func newProxy(t *testing.T, cfg Config) (*Proxy, string, *backend) {
+	t.Helper()
+	w := log.Writer()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(w) })
+
+	b := newBackend(t)
+	cfg.Upstream = b.addr
+	p := New(cfg)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go p.Serve(l)
+	return p, l.Addr().String(), b
+}
+
+// connect opens a session with the given application_name and returns the
+// connection once the session is ready.
+func connect(t *testing.T, addr, app string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	// The proxy has no TLS to offer.
+	conn.Write(pgwire.SSLRequest())
+	var resp [1]byte
+	if _, err := io.ReadFull(conn, resp[:]); err != nil || resp[0] != 'N' {
+		t.Fatalf("SSLRequest: %q, %v", resp[0], err)
+	}
+
+	body := []byte("user\x00upper\x00application_name\x00" + app + "\x00\x00")
+	conn.Write((&pgwire.Startup{Code: pgwire.ProtocolVersion, Body: body}).Bytes())
+	receive(t, conn)
+	return conn
+}
+
+// receive reads messages up to a ReadyForQuery or an error and returns their
+// types, followed by the tag of CommandComplete messages and the code of
+// errors.
+func receive(t *testing.T, conn net.Conn) []string {
+	t.Helper()
+	var got []string
+	for {
+		m, err := pgwire.ReadMessage(conn, 0)
+		if err != nil {
+			t.Fatalf("after %q: %v", got, err)
+		}
+		s := string(m.Type)
+		switch m.Type {
+		case pgwire.CommandComplete:
+			s += " " + pgwire.CommandTag(m)
+		case pgwire.ErrorResponse, pgwire.NoticeResponse:
+			for _, field := range strings.Split(string(m.Body), "\x00") {
+				if strings.HasPrefix(field, "C") {
+					s += " " + field[1:]
+				}
+			}
+		}
+		got = append(got, s)
+		if m.Type == pgwire.ReadyForQuery || (m.Type == pgwire.ErrorResponse && strings.Contains(string(m.Body), "FATAL")) {
+			return got
+		}
+	}
+}
+
+func send(conn net.Conn, msgs ...*pgwire.Message) {
+	var buf []byte
+	for _, m := range msgs {
+		buf = append(buf, m.Bytes()...)
+	}
+	conn.Write(buf)
+}
+
+func bind(statement string, params ...string) *pgwire.Message {
+	b := &pgwire.BindMessage{Statement: statement}
+	for _, p := range params {
+		b.Params = append(b.Params, []byte(p))
+	}
+	return b.Message()
+}
+
+func TestProxy(t *testing.T) {
+	_, addr, b := newProxy(t, Config{MaxConns: 1, MaxRows: 3, StatementTimeout: time.Second})
+	conn := connect(t, addr, "test")
+
+	if got, want := b.take(), []string{"SET default_transaction_read_only = on", "SET statement_timeout = '1000ms'"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("session set up with %q, want %q", got, want)
+	}
+
+	tests := []struct {
+		name     string
+		msgs     []*pgwire.Message
+		want     []string
+		received []string
+	}{
+		{
+			name:     "read",
+			msgs:     []*pgwire.Message{pgwire.NewQuery("SELECT 2")},
+			want:     []string{"D", "D", "C SELECT 2", "Z"},
+			received: []string{"SELECT 2"},
+		},
+		{
+			name:     "truncated",
+			msgs:     []*pgwire.Message{pgwire.NewQuery("SELECT 5")},
+			want:     []string{"D", "D", "D", "N 01000", "C SELECT 3", "Z"},
+			received: []string{"SELECT 5"},
+		},
+		{
+			name: "write",
+			msgs: []*pgwire.Message{pgwire.NewQuery("DELETE FROM books")},
+			want: []string{"E 25006", "Z"},
+		},
+		{
+			name: "setting",
+			msgs: []*pgwire.Message{pgwire.NewQuery("SET default_transaction_read_only = off")},
+			want: []string{"E 42501", "Z"},
+		},
+		{
+			name: "extended",
+			msgs: []*pgwire.Message{
+				pgwire.NewParse("", "SELECT 1", nil), bind(""), pgwire.NewExecute(""),
+				pgwire.NewSync(),
+			},
+			want:     []string{"1", "2", "D", "C SELECT 1", "Z"},
+			received: []string{"parse SELECT 1", "sync"},
+		},
+		{
+			// What came before the blocked statement runs; what comes after
+			// it is skipped up to the Sync, with a single ReadyForQuery.
+			name: "extended write",
+			msgs: []*pgwire.Message{
+				pgwire.NewParse("", "SELECT 1", nil), bind(""), pgwire.NewExecute(""),
+				pgwire.NewParse("", "DROP TABLE books", nil), bind(""), pgwire.NewExecute(""),
+				pgwire.NewParse("", "SELECT 2", nil), bind(""), pgwire.NewExecute(""),
+				pgwire.NewSync(),
+			},
+			want:     []string{"1", "2", "D", "C SELECT 1", "E 25006", "Z"},
+			received: []string{"parse SELECT 1", "sync"},
+		},
+	}
+	for _, tt := range tests {
+		send(conn, tt.msgs...)
+		if got := receive(t, conn); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+		if got := b.take(); !reflect.DeepEqual(got, tt.received) {
+			t.Errorf("%s: database got %q, want %q", tt.name, got, tt.received)
+		}
+	}
+
+	// There is room for one connection only.
+	other, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	other.SetDeadline(time.Now().Add(10 * time.Second))
+	other.Write((&pgwire.Startup{Code: pgwire.ProtocolVersion, Body: []byte("user\x00upper\x00\x00")}).Bytes())
+	if got := receive(t, other); !reflect.DeepEqual(got, []string{"E 53300"}) {
+		t.Errorf("connection over the limit: got %q", got)
+	}
+}
+
+func TestUnsupportedMessages(t *testing.T) {
+	_, addr, b := newProxy(t, Config{})
+	conn := connect(t, addr, "test")
+	b.take()
+
+	// A FunctionCall with the OID of set_config, 2078, and its arguments.
+	var body []byte
+	body = append(body, 0, 0, 0x08, 0x1e, 0, 0, 0, 3)
+	for _, arg := range []string{"default_transaction_read_only", "off", "f"} {
+		body = append(body, 0, 0, 0, byte(len(arg)))
+		body = append(body, arg...)
+	}
+	body = append(body, 0, 0)
+	call := &pgwire.Message{Type: pgwire.FunctionCall, Body: body}
+
+	tests := []struct {
+		name     string
+		msgs     []*pgwire.Message
+		want     []string
+		received []string
+	}{
+		{
+			name: "function call",
+			msgs: []*pgwire.Message{call},
+			want: []string{"E 42501", "Z"},
+		},
+		{
+			name:     "sync after a function call",
+			msgs:     []*pgwire.Message{pgwire.NewSync()},
+			want:     []string{"Z"},
+			received: []string{"sync"},
+		},
+		{
+			name: "copy data",
+			msgs: []*pgwire.Message{{Type: pgwire.CopyData, Body: []byte("1\tx\n")}},
+			want: []string{"E 08P01", "Z"},
+		},
+		{
+			// In a batch, what came before runs and the rest is skipped up to
+			// the Sync.
+			name: "function call in a batch",
+			msgs: []*pgwire.Message{
+				pgwire.NewParse("", "SELECT 1", nil), bind(""), pgwire.NewExecute(""),
+				call,
+				pgwire.NewParse("", "SELECT 2", nil), bind(""), pgwire.NewExecute(""),
+				{Type: pgwire.CopyDone},
+				pgwire.NewSync(),
+			},
+			want:     []string{"1", "2", "D", "C SELECT 1", "E 42501", "Z"},
+			received: []string{"parse SELECT 1", "sync"},
+		},
+	}
+	for _, tt := range tests {
+		send(conn, tt.msgs...)
+		if got := receive(t, conn); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+		if got := b.take(); !reflect.DeepEqual(got, tt.received) {
+			t.Errorf("%s: database got %q, want %q", tt.name, got, tt.received)
+		}
+	}
+}
+
+func TestExplain(t *testing.T) {
+	p, addr, b := newProxy(t, Config{Explain: true})
+	conn := connect(t, addr, ExplainAppPrefix+"run1")
+	b.take()
+
+	send(conn, pgwire.NewQuery("SELECT 2; SHOW search_path"))
+	receive(t, conn)
+	send(conn,
+		pgwire.NewParse("s", "SELECT $1", nil), bind("s", "7"), pgwire.NewExecute(""),
+		pgwire.NewSync(),
+	)
+	receive(t, conn)
+	// Only reads are explained.
+	send(conn, pgwire.NewQuery("DELETE FROM books"))
+	receive(t, conn)
+	send(conn, &pgwire.Message{Type: pgwire.Terminate})
+
+	srv := httptest.NewServer(p.PlansHandler())
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/plans/run1?wait=5s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var plans []explain.Plan
+	if err := json.NewDecoder(resp.Body).Decode(&plans); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, plan := range plans {
+		s := plan.Statement + " " + strings.Join(plan.Arguments, ",")
+		if plan.Error != "" || plan.Root == nil || plan.Root.Operation != "Result" {
+			s += " without a plan: " + plan.Error
+		}
+		got = append(got, s)
+	}
+	if want := []string{"SELECT 2 ", "SELECT $1 7"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("plans %q, want %q", got, want)
+	}
+	wantReceived := []string{
+		"SELECT 2; SHOW search_path",
+		"EXPLAIN (FORMAT JSON) SELECT 2",
+		"parse SELECT $1",
+		"sync",
+		"parse EXPLAIN (FORMAT JSON) SELECT $1",
+		"sync",
+	}
+	if got := b.take(); !reflect.DeepEqual(got, wantReceived) {
+		t.Errorf("database got %q, want %q", got, wantReceived)
+	}
+
+	// Plans are handed out once.
+	resp, err = http.Get(srv.URL + "/plans/run1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if strings.TrimSpace(string(body)) != "[]" {
+		t.Errorf("plans fetched again: %s", body)
+	}
+}
+
+func TestPlanStore(t *testing.T) {
+	ps := newPlanStore()
+	if ps.add("run", explain.Plan{}) {
+		t.Error("plan of a run without sessions added")
+	}
+
+	ps.open("run")
+	ps.open("run")
+	for i := 0; i < maxPlansPerRun; i++ {
+		if !ps.add("run", explain.Plan{Statement: strconv.Itoa(i)}) {
+			t.Fatalf("plan %d not added", i)
+		}
+	}
+	if !ps.full("run") || ps.add("run", explain.Plan{}) {
+		t.Error("more than maxPlansPerRun plans")
+	}
+
+	// take waits for the sessions of the run to end, up to a point.
+	start := time.Now()
+	if plans := ps.take("run", 100*time.Millisecond); len(plans) != maxPlansPerRun {
+		t.Errorf("took %d plans, want %d", len(plans), maxPlansPerRun)
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Errorf("take returned after %v with sessions open", d)
+	}
+
+	ps.open("run")
+	ps.add("run", explain.Plan{Statement: "last"})
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		ps.close("run")
+	}()
+	start = time.Now()
+	plans := ps.take("run", 5*time.Second)
+	if len(plans) != 1 || plans[0].Statement != "last" {
+		t.Errorf("took %+v, want the last plan", plans)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("take returned after %v, not when the session ended", d)
+	}
+	if plans := ps.take("run", 0); plans != nil {
+		t.Errorf("plans taken twice: %+v", plans)
+	}
+}
+
+func TestTruncateTag(t *testing.T) {
+	tests := []struct{ tag, want string }{
+		{"SELECT 1000", "SELECT 3"},
+		{"FETCH 10", "FETCH 3"},
+		{"SHOW", "SHOW"},
+		{"SET x", "SET x"},
+	}
+	for _, tt := range tests {
+		if got := truncateTag(tt.tag, 3); got != tt.want {
+			t.Errorf("truncateTag(%q) = %q, want %q", tt.tag, got, tt.want)
+		}
+	}
+}
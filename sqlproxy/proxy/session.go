@@ -0,0 +1,580 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/upper/upper.io/sqlproxy/classify"
+	"github.com/upper/upper.io/sqlproxy/explain"
+	"github.com/upper/upper.io/sqlproxy/pgwire"
+)
+
+// maxClientMessage is the maximum size of a message sent by a client.
+const maxClientMessage = 1 << 20
+
+// session relays the traffic of one client connection.
+//
+// Messages from the client are checked before they are forwarded. When a
+// statement is blocked, the proxy answers on behalf of the database, and its
+// answer has to be delivered in order with the responses the database is
+// still sending for earlier messages. To keep that order, every batch sent to
+// the database is recorded as a pending item, and answers from the proxy are
+// queued behind the pending batches; they are written once the database
+// finishes the batches in front of them with a ReadyForQuery.
+type session struct {
+	p *Proxy
+
+	client   net.Conn
+	upstream net.Conn
+
+	upstreamReader *bufio.Reader
+
+	// database is the kind of database behind the proxy, as in the explain
+	// package.
+	database string
+	// runID is set if the plans of the session are stored for a run.
+	runID string
+	// statements are the prepared statements of the client, kept to explain
+	// them when they are bound. Only used by relayFrontend.
+	statements map[string]preparedStatement
+
+	// writeMu serializes writes to the database.
+	writeMu sync.Mutex
+
+	// The following fields are guarded by mu.
+	mu           sync.Mutex
+	clientWriter *bufio.Writer
+	pending      []pendingItem
+	txStatus     byte
+	// unsynced is set while the database may answer messages of the client
+	// that are not covered by a pending item.
+	unsynced bool
+	// explaining is the number of explain batches sent to the database and
+	// not answered yet.
+	explaining  int
+	backendDone bool
+	cond        *sync.Cond
+
+	closeOnce sync.Once
+}
+
+// pendingItem is either a batch forwarded to the database or an answer from
+// the proxy.
+type pendingItem struct {
+	// upstream is set if the item is a batch waiting for a ReadyForQuery from
+	// the database.
+	upstream bool
+	// swallowReady discards the ReadyForQuery that ends the batch, for
+	// batches the proxy had to close on its own.
+	swallowReady bool
+
+	// messages is the answer from the proxy.
+	messages []*pgwire.Message
+	// ready appends a ReadyForQuery to the answer.
+	ready bool
+
+	// explain holds the statements of the batch to explain once it is done.
+	explain []explainCandidate
+	// job is set if the item is an explain batch sent by the proxy, whose
+	// response is not passed on to the client.
+	job *explainJob
+}
+
+func newSession(p *Proxy, client, upstream net.Conn) *session {
+	s := &session{
+		p:              p,
+		client:         client,
+		upstream:       upstream,
+		upstreamReader: bufio.NewReader(upstream),
+		database:       explain.PostgreSQL,
+		statements:     make(map[string]preparedStatement),
+		clientWriter:   bufio.NewWriter(client),
+		txStatus:       'I',
+	}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// start relays the startup message and the authentication exchange, then
+// applies the settings of the sandbox to the session.
+func (s *session) start(startup *pgwire.Startup) error {
+	if _, err := s.upstream.Write(startup.Bytes()); err != nil {
+		return err
+	}
+
+	for {
+		m, err := pgwire.ReadMessage(s.upstreamReader, 0)
+		if err != nil {
+			return err
+		}
+
+		if m.Type == pgwire.ReadyForQuery {
+			if err := s.configure(); err != nil {
+				s.writeClient(s.p.configError())
+				return err
+			}
+			s.txStatus = m.Body[0]
+			return s.writeClient(m)
+		}
+
+		if err := s.writeClient(m); err != nil {
+			return err
+		}
+
+		switch m.Type {
+		case pgwire.ParameterStatus:
+			if name, _ := pgwire.ParameterStatusValue(m); name == "crdb_version" {
+				s.database = explain.CockroachDB
+			}
+		case pgwire.ErrorResponse:
+			return fmt.Errorf("rejected by the database")
+		case pgwire.Authentication:
+			switch pgwire.AuthCode(m) {
+			case pgwire.AuthCleartextPassword, pgwire.AuthMD5Password, pgwire.AuthSASL, pgwire.AuthSASLContinue:
+				reply, err := pgwire.ReadMessage(s.client, maxClientMessage)
+				if err != nil {
+					return err
+				}
+				if _, err := s.upstream.Write(reply.Bytes()); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// configure sets the limits of the sandbox on the session.
+func (s *session) configure() error {
+	settings := []string{"SET default_transaction_read_only = on"}
+	if d := s.p.cfg.StatementTimeout; d > 0 {
+		settings = append(settings, fmt.Sprintf("SET statement_timeout = '%dms'", d.Milliseconds()))
+	}
+
+	for _, setting := range settings {
+		q := &pgwire.Message{Type: pgwire.Query, Body: append([]byte(setting), 0)}
+		if _, err := s.upstream.Write(q.Bytes()); err != nil {
+			return err
+		}
+
+		var failed error
+		for {
+			m, err := pgwire.ReadMessage(s.upstreamReader, 0)
+			if err != nil {
+				return err
+			}
+			if m.Type == pgwire.ErrorResponse {
+				failed = fmt.Errorf("%s: %s", setting, errorText(m))
+			}
+			if m.Type == pgwire.ReadyForQuery {
+				break
+			}
+		}
+		if failed != nil {
+			return failed
+		}
+	}
+	return nil
+}
+
+// run relays messages in both directions until either side closes the
+// connection. When the client leaves first, the explain batches still running
+// are given some time to finish.
+func (s *session) run() error {
+	backendErr := make(chan error, 1)
+	frontendErr := make(chan error, 1)
+	go func() {
+		err := s.relayBackend()
+		s.mu.Lock()
+		s.backendDone = true
+		s.cond.Broadcast()
+		s.mu.Unlock()
+		backendErr <- err
+	}()
+	go func() {
+		frontendErr <- s.relayFrontend()
+	}()
+
+	var err error
+	select {
+	case err = <-frontendErr:
+		s.waitExplains()
+		s.close()
+		<-backendErr
+	case err = <-backendErr:
+		s.close()
+		<-frontendErr
+	}
+	return err
+}
+
+func (s *session) close() {
+	s.closeOnce.Do(func() {
+		s.client.Close()
+		s.upstream.Close()
+	})
+}
+
+// relayFrontend reads messages from the client and either forwards them to
+// the database or answers them. Only the messages of the simple and extended
+// query protocols are forwarded; anything else is answered with an error.
+func (s *session) relayFrontend() error {
+	var (
+		batch []*pgwire.Message
+		// skipping is set after a statement is blocked in the extended query
+		// protocol. Like the database would, the proxy then ignores messages
+		// until the next Sync.
+		skipping bool
+		// candidates are the statements of the batch to explain.
+		candidates []explainCandidate
+	)
+
+	for {
+		m, err := pgwire.ReadMessage(s.client, maxClientMessage)
+		if err != nil {
+			return err
+		}
+
+		switch m.Type {
+		case pgwire.Query:
+			if n := s.p.check(pgwire.QueryString(m)); n != nil {
+				s.answer(pendingItem{messages: []*pgwire.Message{n.ErrorMessage()}, ready: true})
+				continue
+			}
+			item := pendingItem{upstream: true}
+			if s.runID != "" {
+				item.explain = queryCandidates(pgwire.QueryString(m))
+			}
+			if err := s.forward([]*pgwire.Message{m}, item); err != nil {
+				return err
+			}
+
+		case pgwire.Sync:
+			if skipping {
+				skipping = false
+				s.answer(pendingItem{ready: true})
+				continue
+			}
+			if err := s.forward(append(batch, m), pendingItem{upstream: true, explain: candidates}); err != nil {
+				return err
+			}
+			batch, candidates = nil, nil
+
+		case pgwire.Flush:
+			if skipping {
+				continue
+			}
+			if err := s.forward(append(batch, m), pendingItem{}); err != nil {
+				return err
+			}
+			batch = nil
+
+		case pgwire.Terminate:
+			s.writeMu.Lock()
+			_, err := s.upstream.Write(m.Bytes())
+			s.writeMu.Unlock()
+			return err
+
+		case pgwire.Parse:
+			if skipping {
+				continue
+			}
+			n := s.p.check(pgwire.ParseQuery(m))
+			if n == nil {
+				if s.runID != "" {
+					s.prepare(m)
+				}
+				batch = append(batch, m)
+				continue
+			}
+			// Close what was already sent in this batch and answer for the
+			// blocked statement.
+			if len(batch) > 0 {
+				syncMsg := &pgwire.Message{Type: pgwire.Sync}
+				if err := s.forward(append(batch, syncMsg), pendingItem{upstream: true, swallowReady: true}); err != nil {
+					return err
+				}
+				batch = nil
+			}
+			s.answer(pendingItem{messages: []*pgwire.Message{n.ErrorMessage()}})
+			skipping = true
+			candidates = nil
+
+		case pgwire.Bind:
+			if skipping {
+				continue
+			}
+			if s.runID != "" {
+				if c, ok := s.bindCandidate(m); ok {
+					candidates = append(candidates, c)
+				}
+			}
+			batch = append(batch, m)
+
+		case pgwire.Describe, pgwire.Execute, pgwire.Close:
+			if skipping {
+				continue
+			}
+			batch = append(batch, m)
+
+		default:
+			// Anything else, like a FunctionCall, would reach the database
+			// without being classified.
+			if skipping {
+				continue
+			}
+			n := s.p.unsupported(m)
+			if len(batch) == 0 {
+				s.answer(pendingItem{messages: []*pgwire.Message{n.ErrorMessage()}, ready: true})
+				continue
+			}
+			// In the middle of a batch, it is refused like a blocked
+			// statement.
+			syncMsg := &pgwire.Message{Type: pgwire.Sync}
+			if err := s.forward(append(batch, syncMsg), pendingItem{upstream: true, swallowReady: true}); err != nil {
+				return err
+			}
+			batch = nil
+			s.answer(pendingItem{messages: []*pgwire.Message{n.ErrorMessage()}})
+			skipping = true
+			candidates = nil
+		}
+	}
+}
+
+// forward sends a batch of messages to the database. If item waits for the
+// database, it is queued before sending.
+func (s *session) forward(batch []*pgwire.Message, item pendingItem) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.mu.Lock()
+	if item.upstream {
+		s.pending = append(s.pending, item)
+	}
+	if item.job == nil {
+		s.unsynced = !item.upstream
+	}
+	s.mu.Unlock()
+
+	var buf []byte
+	for _, m := range batch {
+		buf = append(buf, m.Bytes()...)
+	}
+	_, err := s.upstream.Write(buf)
+	return err
+}
+
+// answer sends an answer from the proxy to the client, after the responses
+// to the batches that are still pending.
+func (s *session) answer(item pendingItem) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.pending) > 0 {
+		s.pending = append(s.pending, item)
+		return
+	}
+	s.writeAnswer(item)
+	s.clientWriter.Flush()
+}
+
+// writeAnswer must be called with s.mu held.
+func (s *session) writeAnswer(item pendingItem) {
+	for _, m := range item.messages {
+		s.clientWriter.Write(m.Bytes())
+	}
+	if item.ready {
+		s.clientWriter.Write(pgwire.NewReadyForQuery(s.txStatus).Bytes())
+	}
+}
+
+// relayBackend reads messages from the database and passes them on to the
+// client, cutting results that exceed the row limit.
+func (s *session) relayBackend() error {
+	var (
+		rows      int
+		truncated bool
+	)
+
+	for {
+		m, err := pgwire.ReadMessage(s.upstreamReader, 0)
+		if err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+
+		if len(s.pending) > 0 && s.pending[0].job != nil {
+			werr := s.collect(m)
+			s.mu.Unlock()
+			if werr != nil {
+				return werr
+			}
+			continue
+		}
+
+		var explains []explainCandidate
+		ready := false
+		switch m.Type {
+		case pgwire.DataRow:
+			rows++
+			if limit := s.p.cfg.MaxRows; limit > 0 && rows > limit {
+				truncated = true
+				m = nil
+			}
+
+		case pgwire.CommandComplete:
+			if truncated {
+				s.clientWriter.Write(s.p.truncatedNotice().Bytes())
+				m = pgwire.NewCommandComplete(truncateTag(pgwire.CommandTag(m), s.p.cfg.MaxRows))
+			}
+			rows, truncated = 0, false
+
+		case pgwire.ErrorResponse, pgwire.EmptyQuery, pgwire.PortalSuspended:
+			rows, truncated = 0, false
+
+		case pgwire.ReadyForQuery:
+			rows, truncated = 0, false
+			s.txStatus = m.Body[0]
+			ready = true
+
+			if len(s.pending) > 0 {
+				item := s.pending[0]
+				if item.swallowReady {
+					m = nil
+				}
+				s.pending = s.pending[1:]
+				explains = s.explainNow(item)
+			}
+		}
+
+		if m != nil {
+			s.clientWriter.Write(m.Bytes())
+		}
+
+		if ready {
+			// Deliver the answers that were waiting for this batch.
+			for len(s.pending) > 0 && !s.pending[0].upstream {
+				s.writeAnswer(s.pending[0])
+				s.pending = s.pending[1:]
+			}
+		}
+
+		var werr error
+		if s.upstreamReader.Buffered() == 0 {
+			werr = s.clientWriter.Flush()
+		}
+		s.mu.Unlock()
+
+		if werr != nil {
+			return werr
+		}
+		if err := s.sendExplains(explains); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *session) writeClient(m *pgwire.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.clientWriter.Write(m.Bytes())
+	return s.clientWriter.Flush()
+}
+
+// check classifies the statements in sql and returns the error to answer
+// with if any of them is not allowed.
+func (p *Proxy) check(sql string) *pgwire.Notice {
+	for _, stmt := range classify.Classify(sql) {
+		switch stmt.Class {
+		case classify.Read, classify.Transaction, classify.Session:
+			continue
+		case classify.Write, classify.DDL:
+			return &pgwire.Notice{
+				Severity: "ERROR",
+				Code:     "25006", // read_only_sql_transaction
+				Message:  fmt.Sprintf("cannot execute %s: this is a read-only sandbox", stmt.Command),
+				Detail:   "The demo databases are shared by everyone taking the tour, so they can only be read.",
+				Hint:     "Use SELECT to query the data, or run this example against your own database to modify it.",
+			}
+		case classify.Privileged:
+			return &pgwire.Notice{
+				Severity: "ERROR",
+				Code:     "42501", // insufficient_privilege
+				Message:  fmt.Sprintf("cannot execute %s: the read-only sandbox does not allow changing this setting", stmt.Command),
+			}
+		default:
+			return &pgwire.Notice{
+				Severity: "ERROR",
+				Code:     "42501", // insufficient_privilege
+				Message:  fmt.Sprintf("cannot execute %s: this statement is not supported by the read-only sandbox", stmt.Command),
+				Hint:     "Only queries that read data are allowed.",
+			}
+		}
+	}
+	return nil
+}
+
+// unsupported returns the error to answer a message the proxy does not relay
+// with.
+func (p *Proxy) unsupported(m *pgwire.Message) *pgwire.Notice {
+	if m.Type == pgwire.FunctionCall {
+		return &pgwire.Notice{
+			Severity: "ERROR",
+			Code:     "42501", // insufficient_privilege
+			Message:  "cannot execute a function call: this is not supported by the read-only sandbox",
+			Hint:     "Call the function from a SELECT statement instead.",
+		}
+	}
+	return &pgwire.Notice{
+		Severity: "ERROR",
+		Code:     "08P01", // protocol_violation
+		Message:  fmt.Sprintf("unexpected message type %q", m.Type),
+	}
+}
+
+func (p *Proxy) truncatedNotice() *pgwire.Message {
+	n := &pgwire.Notice{
+		Severity: "NOTICE",
+		Code:     "01000", // warning
+		Message:  fmt.Sprintf("result truncated to %d rows by the read-only sandbox", p.cfg.MaxRows),
+		Hint:     "Use LIMIT to fetch fewer rows.",
+	}
+	return n.NoticeMessage()
+}
+
+func (p *Proxy) configError() *pgwire.Message {
+	n := &pgwire.Notice{
+		Severity: "FATAL",
+		Code:     "08006", // connection_failure
+		Message:  "could not set up a read-only session on the demo database",
+	}
+	return n.ErrorMessage()
+}
+
+// truncateTag replaces the row count of a command tag, as in "SELECT 1000".
+func truncateTag(tag string, rows int) string {
+	i := strings.LastIndexByte(tag, ' ')
+	if i < 0 {
+		return tag
+	}
+	if _, err := strconv.Atoi(tag[i+1:]); err != nil {
+		return tag
+	}
+	return tag[:i+1] + strconv.Itoa(rows)
+}
+
+// errorText returns the message of an ErrorResponse.
+func errorText(m *pgwire.Message) string {
+	for _, field := range strings.Split(string(m.Body), "\x00") {
+		if strings.HasPrefix(field, "M") {
+			return field[1:]
+		}
+	}
+	return "unknown error"
+}
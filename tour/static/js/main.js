@@ -0,0 +1,61 @@
+// A program with several files is written in the editor as a txtar archive,
+// where each file starts with a "-- name --" line, and sent to the playground
+// as is. The tabs above the editor list those files and jump to them.
+(function($) {
+  var markerRe = /^-- (.+) --$/;
+
+  // files returns the files of the archive in the editor, with the line each
+  // one starts at. The text before the first marker is prog.go, like in the
+  // playground, so that the positions of errors name the files shown here.
+  function files(cm) {
+    var list = [];
+    cm.eachLine(function(line) {
+      var m = markerRe.exec(line.text);
+      if (m) {
+        list.push({name: m[1].trim(), line: cm.getLineNumber(line)});
+      }
+    });
+    if (list.length > 0 && list[0].line > 0) {
+      list.unshift({name: 'prog.go', line: 0});
+    }
+    return list;
+  }
+
+  function renderTabs(cm, $tabs) {
+    var list = files(cm);
+    var cursor = cm.getCursor().line;
+
+    $tabs.empty().toggle(list.length > 1);
+    $.each(list, function(i, file) {
+      var next = i + 1 < list.length ? list[i + 1].line : cm.lineCount();
+      var $tab = $('<li>').append($('<a href="#">').text(file.name));
+      if (cursor >= file.line && cursor < next) {
+        $tab.addClass('active');
+      }
+      $tab.on('click', function(e) {
+        e.preventDefault();
+        var line = file.line > 0 || markerRe.test(cm.getLine(0)) ? file.line + 1 : 0;
+        cm.setCursor({line: line, ch: 0});
+        cm.scrollIntoView({line: file.line, ch: 0}, 0);
+        cm.focus();
+      });
+      $tabs.append($tab);
+    });
+  }
+
+  $(window).on('load', function() {
+    $('.code-snippet .CodeMirror').each(function() {
+      var cm = this.CodeMirror;
+      if (!cm) {
+        return;
+      }
+      var $tabs = $('<ul class="file-tabs">').insertBefore($(this).closest('.code-snippet'));
+      var update = function() {
+        renderTabs(cm, $tabs);
+      };
+      cm.on('changes', update);
+      cm.on('cursorActivity', update);
+      update();
+    });
+  });
+})(jQuery);
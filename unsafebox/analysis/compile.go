@@ -0,0 +1,188 @@
+package analysis
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/upper/upper.io/unsafebox/txtar"
+)
+
+// compileErrorRe matches the first line of an error printed by go build, like
+// prog.go:12:6: undefined: sqlbuilder. The column is optional.
+var compileErrorRe = regexp.MustCompile(`^([^\s:]+\.go):(\d+)(?::(\d+))?: (.*)$`)
+
+// CompileErrors parses the errors printed by go build, after the paths of the
+// work directory were removed from them. Lines that continue an error, which
+// are indented, are added to its message; other lines without a position are
+// returned without one.
+func CompileErrors(out string) []Diagnostic {
+	var diags []Diagnostic
+	for _, line := range strings.Split(out, "\n") {
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "# ") {
+			continue
+		}
+		if strings.HasPrefix(line, "\t") && len(diags) > 0 {
+			diags[len(diags)-1].Message += "\n" + line
+			continue
+		}
+		d := Diagnostic{
+			Severity: SeverityError,
+			Category: "compile",
+			Message:  line,
+		}
+		if m := compileErrorRe.FindStringSubmatch(line); m != nil {
+			d.File = m[1]
+			d.Line, _ = strconv.Atoi(m[2])
+			d.Column, _ = strconv.Atoi(m[3])
+			d.Message = m[4]
+		}
+		diags = append(diags, d)
+	}
+	return diags
+}
+
+// Annotate sets the SourceLine of the diagnostics found in a program to the
+// line of body, the source it was submitted as, and adds hints to the ones
+// that are common mistakes. Programs run as they were submitted, with nothing
+// injected into or wrapped around them, so only the files of an archive after
+// the first one are at other lines of body than their own.
+func Annotate(diags []Diagnostic, body string) {
+	start := txtar.StartLines([]byte(body))
+	for i := range diags {
+		d := &diags[i]
+		if n, ok := start[d.File]; ok && d.Line > 0 {
+			d.SourceLine = n + d.Line - 1
+		}
+		if d.Hint == "" {
+			d.Hint = hint(d.Message)
+		}
+	}
+}
+
+// FormatHints formats the hints of diagnostics, to be shown after the errors
+// they are about.
+func FormatHints(diags []Diagnostic) string {
+	var b strings.Builder
+	for _, d := range diags {
+		if d.Hint == "" {
+			continue
+		}
+		if b.Len() == 0 {
+			b.WriteString("\n")
+		}
+		if d.Line > 0 {
+			fmt.Fprintf(&b, "%s:%d: hint: %s\n", d.File, d.Line, d.Hint)
+		} else {
+			fmt.Fprintf(&b, "hint: %s\n", d.Hint)
+		}
+	}
+	return b.String()
+}
+
+// dbAdapters are the adapters of upper/db v4.
+var dbAdapters = map[string]bool{
+	"cockroachdb": true,
+	"mongo":       true,
+	"mssql":       true,
+	"mysql":       true,
+	"postgresql":  true,
+	"ql":          true,
+	"sqlite":      true,
+}
+
+const (
+	hintSQLBuilder = "upper/db v4 has no sqlbuilder package: sqlbuilder.Database and sqlbuilder.Tx are db.Session, " +
+		"and the builder types, like sqlbuilder.Selector, are db.Selector of github.com/upper/db/v4"
+	hintTx = "in upper/db v4, Tx only takes the function, which gets a db.Session: " +
+		"sess.Tx(func(tx db.Session) error { ... }); use sess.WithContext(ctx).Tx(...) to pass a context"
+)
+
+var (
+	// sess.Select undefined (type db.Session has no field or method Select)
+	sqlMethodRe = regexp.MustCompile(`^(.+)\.(\w+) undefined \(type db\.Session has no field or method \w+`)
+	// too many arguments in call to sess.Tx
+	txArgsRe = regexp.MustCompile(`^too many arguments in call to .+\.Tx\b`)
+	// cannot use func literal (type func(sqlbuilder.Tx) error) as type func(db.Session) error in argument to sess.Tx
+	txFuncRe = regexp.MustCompile(`^cannot use .* as (type )?func\(db\.Session\) error\b`)
+	// cannot find package "upper.io/db.v4/postgresql" in any of:
+	// no required module provides package upper.io/db.v4/postgresql; to add it:
+	missingPackageRe = regexp.MustCompile(`(?:cannot find package "|no required module provides package |package )([\w.\-/]+)`)
+)
+
+// sqlMethods are the methods of the sessions of upper/db v3 that moved to
+// the SQL method in v4.
+var sqlMethods = map[string]bool{
+	"DeleteFrom":      true,
+	"Exec":            true,
+	"ExecContext":     true,
+	"InsertInto":      true,
+	"Iterator":        true,
+	"IteratorContext": true,
+	"NewIterator":     true,
+	"Prepare":         true,
+	"PrepareContext":  true,
+	"Query":           true,
+	"QueryContext":    true,
+	"QueryRow":        true,
+	"QueryRowContext": true,
+	"Select":          true,
+	"SelectFrom":      true,
+	"Update":          true,
+}
+
+// hint returns advice for errors learners run into when porting examples of
+// upper/db v3 to v4.
+func hint(msg string) string {
+	switch {
+	case msg == "undefined: sqlbuilder":
+		return hintSQLBuilder
+	case msg == "undefined: db.Database":
+		return "db.Database is db.Session in upper/db v4"
+	case txArgsRe.MatchString(msg), txFuncRe.MatchString(msg):
+		return hintTx
+	}
+	if m := sqlMethodRe.FindStringSubmatch(msg); m != nil && sqlMethods[m[2]] {
+		return fmt.Sprintf("upper/db v4 moved the SQL builder to the SQL method of sessions: use %s.SQL().%s", m[1], m[2])
+	}
+	if m := missingPackageRe.FindStringSubmatch(msg); m != nil {
+		return importHint(m[1])
+	}
+	return ""
+}
+
+// importHint returns the import path of upper/db v4 that replaces a package
+// that could not be found.
+func importHint(importPath string) string {
+	var rest string
+	switch {
+	case importPath == "upper.io/db.v4" || importPath == "github.com/upper/db":
+		return "upper/db v4 is imported as github.com/upper/db/v4"
+	case strings.HasPrefix(importPath, "upper.io/db.v4/"):
+		rest = strings.TrimPrefix(importPath, "upper.io/db.v4/")
+	case strings.HasPrefix(importPath, "github.com/upper/db/v4/"):
+		rest = strings.TrimPrefix(importPath, "github.com/upper/db/v4/")
+	case strings.HasPrefix(importPath, "github.com/upper/db/"):
+		rest = strings.TrimPrefix(importPath, "github.com/upper/db/")
+	case strings.HasPrefix(importPath, "upper.io/db.v3/"), strings.HasPrefix(importPath, "upper.io/db.v2/"):
+		rest = importPath[len("upper.io/db.v3/"):]
+		if dbAdapters[rest] {
+			return fmt.Sprintf("with upper/db v4, the adapter is imported as github.com/upper/db/v4/adapter/%s", rest)
+		}
+		return ""
+	default:
+		return ""
+	}
+	rest = strings.TrimPrefix(rest, "adapter/")
+	switch {
+	case importPath == "github.com/upper/db/v4/adapter/"+rest:
+		// The right path, the package is missing from the sandbox.
+		return ""
+	case dbAdapters[rest]:
+		return fmt.Sprintf("the adapters of upper/db v4 are imported as github.com/upper/db/v4/adapter/%s", rest)
+	case rest == "lib/sqlbuilder":
+		return hintSQLBuilder
+	}
+	return ""
+}
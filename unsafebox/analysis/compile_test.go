@@ -0,0 +1,169 @@
+package analysis
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompileErrors(t *testing.T) {
+	out := `# command-line-arguments
+prog.go:8:2: undefined: sqlbuilder
+models.go:12: too many arguments in call to sess.Tx
+	have (nil, func(sqlbuilder.Tx) error)
+	want (func(db.Session) error)
+
+go: updates to go.mod needed; to update it:
+	go mod tidy
+`
+	want := []Diagnostic{
+		{File: "prog.go", Line: 8, Column: 2, Severity: SeverityError, Category: "compile", Message: "undefined: sqlbuilder"},
+		{File: "models.go", Line: 12, Severity: SeverityError, Category: "compile",
+			Message: "too many arguments in call to sess.Tx\n\thave (nil, func(sqlbuilder.Tx) error)\n\twant (func(db.Session) error)"},
+		{Severity: SeverityError, Category: "compile", Message: "go: updates to go.mod needed; to update it:\n\tgo mod tidy"},
+	}
+	if got := CompileErrors(out); !reflect.DeepEqual(got, want) {
+		t.Errorf("CompileErrors =\n%+v\nwant\n%+v", got, want)
+	}
+	if got := CompileErrors(""); got != nil {
+		t.Errorf("CompileErrors of no output = %+v", got)
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	body := `package main
+
+func main() {
+	run()
+}
+-- models.go --
+package main
+
+func run() {
+	var tx sqlbuilder.Tx
+}
+-- db/db.go --
+package db
+
+var Session db.Database
+`
+	diags := CompileErrors(`# example.com/prog/db
+db/db.go:3:13: undefined: db.Database
+# command-line-arguments
+prog.go:4:2: undefined: run
+models.go:4:9: undefined: sqlbuilder
+go.mod:3: unknown directive: toolchain
+`)
+	diags = append(diags, Diagnostic{File: "prog.go", Line: 1, Message: "undefined: sqlbuilder", Hint: "kept"})
+	Annotate(diags, body)
+
+	want := []struct {
+		sourceLine int
+		hint       string
+	}{
+		{15, "db.Database is db.Session in upper/db v4"},
+		{4, ""},
+		{10, hintSQLBuilder},
+		// go.mod is not a file of the program.
+		{0, ""},
+		{1, "kept"},
+	}
+	if len(diags) != len(want) {
+		t.Fatalf("%d diagnostics, want %d: %+v", len(diags), len(want), diags)
+	}
+	for i, w := range want {
+		if d := diags[i]; d.SourceLine != w.sourceLine || d.Hint != w.hint {
+			t.Errorf("%s:%d: SourceLine %d, hint %q, want %d, %q", d.File, d.Line, d.SourceLine, d.Hint, w.sourceLine, w.hint)
+		}
+	}
+
+	// A program in a single file is at its own lines.
+	diags = CompileErrors("prog.go:3:2: undefined: x\n")
+	Annotate(diags, "package main\n\nfunc main() {\n\tx()\n}\n")
+	if diags[0].SourceLine != 3 {
+		t.Errorf("SourceLine = %d, want 3", diags[0].SourceLine)
+	}
+}
+
+func TestHint(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want string
+	}{
+		{"undefined: sqlbuilder", hintSQLBuilder},
+		{"undefined: db.Database", "db.Database is db.Session in upper/db v4"},
+		{"undefined: db.Session", ""},
+
+		{"sess.Select undefined (type db.Session has no field or method Select)",
+			"upper/db v4 moved the SQL builder to the SQL method of sessions: use sess.SQL().Select"},
+		{"s.sess.QueryRowContext undefined (type db.Session has no field or method QueryRowContext)",
+			"upper/db v4 moved the SQL builder to the SQL method of sessions: use s.sess.SQL().QueryRowContext"},
+		{"sess.Find undefined (type db.Session has no field or method Find)", ""},
+		{"x.Select undefined (type T has no field or method Select)", ""},
+
+		{"too many arguments in call to sess.Tx\n\thave (nil, func(sqlbuilder.Tx) error)\n\twant (func(db.Session) error)", hintTx},
+		{"too many arguments in call to sess.TxContext", ""},
+		{"cannot use func literal (type func(sqlbuilder.Tx) error) as type func(db.Session) error in argument to sess.Tx", hintTx},
+		{"cannot use func(tx sqlbuilder.Tx) error {…} (value of type func(tx sqlbuilder.Tx) error) as func(db.Session) error value in argument to sess.Tx", hintTx},
+
+		{`cannot find package "upper.io/db.v4/postgresql" in any of:`,
+			"the adapters of upper/db v4 are imported as github.com/upper/db/v4/adapter/postgresql"},
+		{"no required module provides package upper.io/db.v3/mysql; to add it:",
+			"with upper/db v4, the adapter is imported as github.com/upper/db/v4/adapter/mysql"},
+		{"package github.com/upper/db is not in GOROOT", "upper/db v4 is imported as github.com/upper/db/v4"},
+		{"no required module provides package golang.org/x/text; to add it:", ""},
+
+		{"missing return", ""},
+	}
+	for _, tt := range tests {
+		if got := hint(tt.msg); got != tt.want {
+			t.Errorf("hint(%q) = %q, want %q", tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestImportHint(t *testing.T) {
+	tests := []struct {
+		importPath string
+		want       string
+	}{
+		{"upper.io/db.v4", "upper/db v4 is imported as github.com/upper/db/v4"},
+		{"github.com/upper/db", "upper/db v4 is imported as github.com/upper/db/v4"},
+		{"upper.io/db.v4/postgresql", "the adapters of upper/db v4 are imported as github.com/upper/db/v4/adapter/postgresql"},
+		{"upper.io/db.v4/adapter/sqlite", "the adapters of upper/db v4 are imported as github.com/upper/db/v4/adapter/sqlite"},
+		{"github.com/upper/db/v4/mongo", "the adapters of upper/db v4 are imported as github.com/upper/db/v4/adapter/mongo"},
+		{"github.com/upper/db/cockroachdb", "the adapters of upper/db v4 are imported as github.com/upper/db/v4/adapter/cockroachdb"},
+		{"upper.io/db.v3/ql", "with upper/db v4, the adapter is imported as github.com/upper/db/v4/adapter/ql"},
+		{"upper.io/db.v2/mssql", "with upper/db v4, the adapter is imported as github.com/upper/db/v4/adapter/mssql"},
+		{"github.com/upper/db/v4/lib/sqlbuilder", hintSQLBuilder},
+		{"upper.io/db.v4/lib/sqlbuilder", hintSQLBuilder},
+
+		// The right path: the package is missing from the sandbox.
+		{"github.com/upper/db/v4/adapter/postgresql", ""},
+
+		// Nothing to suggest.
+		{"upper.io/db.v3/lib/sqlbuilder", ""},
+		{"upper.io/db.v4/internal/sqladapter", ""},
+		{"upper.io/db.v5", ""},
+		{"github.com/lib/pq", ""},
+	}
+	for _, tt := range tests {
+		if got := importHint(tt.importPath); got != tt.want {
+			t.Errorf("importHint(%s) = %q, want %q", tt.importPath, got, tt.want)
+		}
+	}
+}
+
+func TestFormatHints(t *testing.T) {
+	diags := []Diagnostic{
+		{File: "prog.go", Line: 8, Message: "undefined: sqlbuilder", Hint: "use db.Session"},
+		{File: "prog.go", Line: 9, Message: "missing return"},
+		{Message: "go: no go.mod", Hint: "add a go.mod"},
+	}
+	want := "\nprog.go:8: hint: use db.Session\nhint: add a go.mod\n"
+	if got := FormatHints(diags); got != want {
+		t.Errorf("FormatHints = %q, want %q", got, want)
+	}
+	if got := FormatHints(diags[1:2]); got != "" {
+		t.Errorf("FormatHints without hints = %q", got)
+	}
+}
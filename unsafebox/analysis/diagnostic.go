@@ -0,0 +1,84 @@
+// Package analysis formats and checks programs without running them.
+package analysis
+
+import (
+	"go/scanner"
+	"go/token"
+	"sort"
+)
+
+// Severities of a diagnostic.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Diagnostic is a problem found in a program. Lines and columns start at 1;
+// columns are byte offsets, like in compiler errors.
+type Diagnostic struct {
+	File     string
+	Line     int
+	Column   int
+	Severity string
+	// Category is the check that found the problem, such as "typecheck" or
+	// "printf".
+	Category string
+	Message  string
+	// SourceLine is the line of the submitted source the problem is at. It
+	// differs from Line in the files after the first one of an archive.
+	SourceLine int `json:",omitempty"`
+	// Hint suggests a fix for common mistakes, like using the API of
+	// upper/db v3 with v4.
+	Hint string `json:",omitempty"`
+}
+
+// diagnostics collects diagnostics for the files of a file set.
+type diagnostics struct {
+	fset  *token.FileSet
+	items []Diagnostic
+}
+
+func (d *diagnostics) add(pos token.Pos, severity, category, msg string) {
+	d.addPosition(d.fset.Position(pos), severity, category, msg)
+}
+
+func (d *diagnostics) addPosition(p token.Position, severity, category, msg string) {
+	d.items = append(d.items, Diagnostic{
+		File:     p.Filename,
+		Line:     p.Line,
+		Column:   p.Column,
+		Severity: severity,
+		Category: category,
+		Message:  msg,
+	})
+}
+
+// addParseErrors adds the errors returned by the parser.
+func (d *diagnostics) addParseErrors(err error) {
+	if list, ok := err.(scanner.ErrorList); ok {
+		for _, e := range list {
+			d.addPosition(e.Pos, SeverityError, "syntax", e.Msg)
+		}
+		return
+	}
+	d.items = append(d.items, Diagnostic{
+		Severity: SeverityError,
+		Category: "syntax",
+		Message:  err.Error(),
+	})
+}
+
+// sorted returns the diagnostics in the order of their positions.
+func (d *diagnostics) sorted() []Diagnostic {
+	sort.SliceStable(d.items, func(i, j int) bool {
+		a, b := d.items[i], d.items[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return d.items
+}
@@ -0,0 +1,284 @@
+package analysis
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/upper/upper.io/unsafebox/txtar"
+)
+
+// Format formats a program like gofmt. With fixImports, it also adds the
+// imports the program is missing and removes the ones it does not use, like
+// goimports. Packages of upper/db are imported from the major version the
+// program already uses.
+func Format(filename string, src []byte, fixImports bool) ([]byte, error) {
+	if fixImports {
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		src = fixFileImports(fset, f, src, nil, nil)
+	}
+	return format.Source(src)
+}
+
+// FormatFiles formats the .go files of a program with several files, like
+// Format. When fixing imports, the names declared by the other files of a
+// package are not mistaken for missing imports, and the packages of the
+// program itself can be imported too. Other files are returned as they are.
+func FormatFiles(files []txtar.File, fixImports bool) ([]txtar.File, error) {
+	out := make([]txtar.File, len(files))
+	copy(out, files)
+
+	p := &program{}
+	if fixImports {
+		var errs []error
+		if p, errs = parseProgram(token.NewFileSet(), files, parser.ParseComments); len(errs) > 0 {
+			return nil, errs[0]
+		}
+	}
+
+	for i, file := range out {
+		if !strings.HasSuffix(file.Name, ".go") {
+			continue
+		}
+		src := file.Data
+		if f, ok := p.files[file.Name]; ok {
+			src = fixFileImports(p.fset, f, src, p.declared(file.Name), p.localImports(file.Name))
+		}
+		formatted, err := format.Source(src)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file.Name, err)
+		}
+		out[i].Data = formatted
+	}
+	return out, nil
+}
+
+// importSpec is an import of a program.
+type importSpec struct {
+	name string // the name the package is referred to by
+	path string
+	text string // the spec as written in the source, with its comment
+	std  bool   // whether the package belongs to the standard library
+}
+
+// fixFileImports returns src with its import declarations rewritten to
+// import what the file uses. declared holds the names declared by the other
+// files of the package, and local maps the names of the packages of the
+// program to their import paths. If nothing has to change, src is returned as
+// it is.
+func fixFileImports(fset *token.FileSet, f *ast.File, src []byte, declared map[string]bool, local map[string]string) []byte {
+	offset := func(pos token.Pos) int {
+		return fset.Position(pos).Offset
+	}
+
+	// The packages of a program in module mode have paths that look like
+	// they belong to the standard library.
+	isStd := func(p string) bool {
+		for _, localPath := range local {
+			if localPath == p {
+				return false
+			}
+		}
+		return isStdlib(p)
+	}
+
+	refs := packageRefs(f)
+	for name := range declared {
+		delete(refs, name)
+	}
+
+	var (
+		kept    []importSpec
+		paths   []string
+		changed bool
+	)
+	imported := make(map[string]bool)
+	for _, spec := range f.Imports {
+		p, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			return src
+		}
+		paths = append(paths, p)
+
+		name, known := importName(p)
+		for localName, localPath := range local {
+			if localPath == p {
+				name, known = localName, true
+			}
+		}
+		if spec.Name != nil {
+			name, known = spec.Name.Name, true
+		}
+		if known && name != "_" && name != "." && refs[name] == nil {
+			changed = true
+			continue
+		}
+
+		end := spec.End()
+		if spec.Comment != nil {
+			end = spec.Comment.End()
+		}
+		kept = append(kept, importSpec{name: name, path: p, text: string(src[offset(spec.Pos()):offset(end)]), std: isStd(p)})
+		imported[name] = true
+	}
+
+	version := upperVersion(paths)
+	for name, sels := range refs {
+		if imported[name] {
+			continue
+		}
+		p, ok := local[name]
+		if !ok {
+			p, ok = upperPackages[version][name]
+		}
+		if !ok {
+			p, ok = stdlib.lookup(name, sels)
+		}
+		if !ok {
+			continue
+		}
+		kept = append(kept, importSpec{name: name, path: p, text: strconv.Quote(p), std: isStd(p)})
+		changed = true
+	}
+
+	if !changed {
+		return src
+	}
+
+	var start, end int
+	for _, decl := range f.Decls {
+		decl, ok := decl.(*ast.GenDecl)
+		if !ok || decl.Tok != token.IMPORT {
+			continue
+		}
+		if end == 0 {
+			start = offset(decl.Pos())
+		}
+		end = offset(decl.End())
+	}
+	if end == 0 {
+		// No imports yet, they go after the package clause.
+		start, end = offset(f.Name.End()), offset(f.Name.End())
+	}
+
+	var buf bytes.Buffer
+	buf.Write(src[:start])
+	if end == start {
+		buf.WriteString("\n\n")
+	}
+	buf.WriteString(importDecl(kept))
+	buf.Write(src[end:])
+	return buf.Bytes()
+}
+
+// importDecl returns an import declaration with the standard library
+// packages in one group and the rest in another.
+func importDecl(specs []importSpec) string {
+	sort.SliceStable(specs, func(i, j int) bool {
+		return specs[i].path < specs[j].path
+	})
+
+	var std, other []string
+	for _, spec := range specs {
+		if spec.std {
+			std = append(std, spec.text)
+		} else {
+			other = append(other, spec.text)
+		}
+	}
+
+	switch len(specs) {
+	case 0:
+		return ""
+	case 1:
+		return "import " + specs[0].text
+	}
+
+	var b strings.Builder
+	b.WriteString("import (\n")
+	for _, s := range std {
+		b.WriteString("\t" + s + "\n")
+	}
+	if len(std) > 0 && len(other) > 0 {
+		b.WriteString("\n")
+	}
+	for _, s := range other {
+		b.WriteString("\t" + s + "\n")
+	}
+	b.WriteString(")")
+	return b.String()
+}
+
+// packageRefs returns the names used as package names in f, with the
+// selectors used on each of them.
+func packageRefs(f *ast.File) map[string][]string {
+	unresolved := make(map[*ast.Ident]bool)
+	for _, id := range f.Unresolved {
+		unresolved[id] = true
+	}
+
+	refs := make(map[string][]string)
+	ast.Inspect(f, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		if id, ok := sel.X.(*ast.Ident); ok && unresolved[id] {
+			refs[id.Name] = append(refs[id.Name], sel.Sel.Name)
+		}
+		return true
+	})
+	return refs
+}
+
+var versionSuffix = regexp.MustCompile(`^v[0-9]+$`)
+
+// importName returns the name of the package with the given import path, and
+// whether it is certain. The name is guessed from the path if the package
+// cannot be found.
+func importName(importPath string) (string, bool) {
+	for _, pkgs := range upperPackages {
+		for name, p := range pkgs {
+			if p == importPath {
+				return name, true
+			}
+		}
+	}
+
+	if pkg, err := build.Default.Import(importPath, "", 0); err == nil && pkg.Name != "" {
+		return pkg.Name, true
+	}
+
+	name := path.Base(importPath)
+	if versionSuffix.MatchString(name) {
+		name = path.Base(path.Dir(importPath))
+	}
+	if i := strings.Index(name, ".v"); i > 0 {
+		name = name[:i]
+	}
+	name = strings.TrimPrefix(name, "go-")
+	return strings.ReplaceAll(name, "-", "_"), false
+}
+
+// isStdlib reports whether an import path belongs to the standard library,
+// whose paths have no dot in their first element.
+func isStdlib(importPath string) bool {
+	first := importPath
+	if i := strings.IndexByte(importPath, '/'); i >= 0 {
+		first = importPath[:i]
+	}
+	return !strings.Contains(first, ".")
+}
@@ -0,0 +1,179 @@
+package analysis
+
+import (
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// upperPackages maps the names of the packages of each major version of
+// upper/db to their import paths.
+var upperPackages = map[string]map[string]string{
+	"v4": {
+		"db":          "github.com/upper/db/v4",
+		"cockroachdb": "github.com/upper/db/v4/adapter/cockroachdb",
+		"mongo":       "github.com/upper/db/v4/adapter/mongo",
+		"mssql":       "github.com/upper/db/v4/adapter/mssql",
+		"mysql":       "github.com/upper/db/v4/adapter/mysql",
+		"postgresql":  "github.com/upper/db/v4/adapter/postgresql",
+		"ql":          "github.com/upper/db/v4/adapter/ql",
+		"sqlite":      "github.com/upper/db/v4/adapter/sqlite",
+	},
+	"v3": {
+		"db":         "upper.io/db.v3",
+		"mongo":      "upper.io/db.v3/mongo",
+		"mssql":      "upper.io/db.v3/mssql",
+		"mysql":      "upper.io/db.v3/mysql",
+		"postgresql": "upper.io/db.v3/postgresql",
+		"ql":         "upper.io/db.v3/ql",
+		"sqlite":     "upper.io/db.v3/sqlite",
+		"sqlbuilder": "upper.io/db.v3/lib/sqlbuilder",
+	},
+	"v2": {
+		"db":         "upper.io/db.v2",
+		"mongo":      "upper.io/db.v2/mongo",
+		"mssql":      "upper.io/db.v2/mssql",
+		"mysql":      "upper.io/db.v2/mysql",
+		"postgresql": "upper.io/db.v2/postgresql",
+		"ql":         "upper.io/db.v2/ql",
+		"sqlite":     "upper.io/db.v2/sqlite",
+		"sqlbuilder": "upper.io/db.v2/lib/sqlbuilder",
+	},
+}
+
+// upperVersion returns the major version of upper/db a program uses, judging
+// by its imports. Programs that do not import it yet get the latest.
+func upperVersion(paths []string) string {
+	for _, path := range paths {
+		switch {
+		case path == "upper.io/db.v3" || strings.HasPrefix(path, "upper.io/db.v3/"):
+			return "v3"
+		case path == "upper.io/db.v2" || strings.HasPrefix(path, "upper.io/db.v2/"):
+			return "v2"
+		}
+	}
+	return "v4"
+}
+
+// stdlib indexes the packages of the standard library by name.
+var stdlib = &packageIndex{}
+
+type packageIndex struct {
+	once   sync.Once
+	byName map[string][]string
+
+	mu      sync.Mutex
+	exports map[string]map[string]bool
+}
+
+func (x *packageIndex) load() {
+	x.byName = make(map[string][]string)
+	x.exports = make(map[string]map[string]bool)
+
+	src := filepath.Join(build.Default.GOROOT, "src")
+	_ = filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil || rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		switch base := filepath.Base(rel); {
+		case rel == "cmd", base == "internal", base == "vendor", base == "testdata":
+			return filepath.SkipDir
+		}
+		name := filepath.Base(rel)
+		x.byName[name] = append(x.byName[name], rel)
+		return nil
+	})
+	for _, paths := range x.byName {
+		sort.Slice(paths, func(i, j int) bool {
+			if len(paths[i]) != len(paths[j]) {
+				return len(paths[i]) < len(paths[j])
+			}
+			return paths[i] < paths[j]
+		})
+	}
+}
+
+// lookup returns the import path of the standard library package called
+// name that exports all the given names, preferring the shortest path.
+func (x *packageIndex) lookup(name string, uses []string) (string, bool) {
+	x.once.Do(x.load)
+
+	for _, path := range x.byName[name] {
+		exports := x.packageExports(filepath.Join(build.Default.GOROOT, "src", path))
+		ok := len(exports) > 0
+		for _, u := range uses {
+			if !exports[u] {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// packageExports returns the exported top-level names of the package in dir.
+func (x *packageIndex) packageExports(dir string) map[string]bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if exports, ok := x.exports[dir]; ok {
+		return exports
+	}
+
+	exports := make(map[string]bool)
+	notTest := func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}
+	pkgs, _ := parser.ParseDir(token.NewFileSet(), dir, notTest, parser.SkipObjectResolution)
+	for _, pkg := range pkgs {
+		for _, f := range pkg.Files {
+			for _, decl := range f.Decls {
+				for _, name := range declNames(decl) {
+					if ast.IsExported(name) {
+						exports[name] = true
+					}
+				}
+			}
+		}
+	}
+	x.exports[dir] = exports
+	return exports
+}
+
+// declNames returns the names declared at the top level by decl, ignoring
+// methods.
+func declNames(decl ast.Decl) []string {
+	var names []string
+	switch decl := decl.(type) {
+	case *ast.FuncDecl:
+		if decl.Recv == nil {
+			names = append(names, decl.Name.Name)
+		}
+	case *ast.GenDecl:
+		for _, spec := range decl.Specs {
+			switch spec := spec.(type) {
+			case *ast.TypeSpec:
+				names = append(names, spec.Name.Name)
+			case *ast.ValueSpec:
+				for _, n := range spec.Names {
+					names = append(names, n.Name)
+				}
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,171 @@
+package analysis
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Actions of a policy rule.
+const (
+	// ActionDeny rejects the program.
+	ActionDeny = "deny"
+	// ActionWarn lets the program run, but reports the problem.
+	ActionWarn = "warn"
+)
+
+// Rule matches imports, symbols, directives or files of a program.
+type Rule struct {
+	// Match is the pattern of the rule. Import paths ending in /... match
+	// the package and everything below it; anything else is matched like
+	// path.Match.
+	Match  string
+	Action string
+	Reason string
+}
+
+// Policy decides which programs the compile service runs, before building
+// them. Policies are read from JSON files like this one:
+//
+//	{
+//	  "Imports": [
+//	    {"Match": "os/exec", "Action": "deny", "Reason": "programs may not start processes"}
+//	  ],
+//	  "Symbols": [
+//	    {"Match": "os.StartProcess", "Action": "deny", "Reason": "programs may not start processes"}
+//	  ],
+//	  "Directives": [
+//	    {"Match": "go:linkname", "Action": "deny", "Reason": "may reach unexported runtime functions"}
+//	  ],
+//	  "Files": [
+//	    {"Match": "*.s", "Action": "deny", "Reason": "assembly may make system calls"}
+//	  ],
+//	  "Allow": ["github.com/upper/db/v4/..."]
+//	}
+//
+// Packages outside of the standard library may only be imported if they are
+// listed in Allow, or are dependencies of a package listed there.
+type Policy struct {
+	Imports    []Rule
+	Symbols    []Rule
+	Directives []Rule
+	Files      []Rule
+	Allow      []string
+
+	once    sync.Once
+	allowed map[string]bool // the packages in Allow and their dependencies
+}
+
+// LoadPolicy reads a policy from a JSON file.
+func LoadPolicy(filename string) (*Policy, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	p := &Policy{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	for _, rules := range [][]Rule{p.Imports, p.Symbols, p.Directives, p.Files} {
+		for _, r := range rules {
+			if r.Action != ActionDeny && r.Action != ActionWarn {
+				return nil, fmt.Errorf("%s: rule %q: unknown action %q", filename, r.Match, r.Action)
+			}
+			if _, err := path.Match(r.Match, ""); err != nil {
+				return nil, fmt.Errorf("%s: rule %q: %w", filename, r.Match, err)
+			}
+		}
+	}
+	return p, nil
+}
+
+// matchRule returns the first rule that matches s.
+func matchRule(rules []Rule, s string) (Rule, bool) {
+	for _, r := range rules {
+		if matchPattern(r.Match, s) {
+			return r, true
+		}
+	}
+	return Rule{}, false
+}
+
+func matchPattern(pattern, s string) bool {
+	if prefix := strings.TrimSuffix(pattern, "/..."); prefix != pattern {
+		return s == prefix || strings.HasPrefix(s, prefix+"/")
+	}
+	ok, _ := path.Match(pattern, s)
+	return ok
+}
+
+// isAllowed reports whether a package outside of the standard library may be
+// imported.
+func (p *Policy) isAllowed(importPath string) bool {
+	for _, pattern := range p.Allow {
+		if matchPattern(pattern, importPath) {
+			return true
+		}
+	}
+	p.once.Do(p.loadAllowed)
+	return p.allowed[importPath]
+}
+
+// loadAllowed finds the dependencies of the allowed packages installed in
+// GOPATH.
+func (p *Policy) loadAllowed() {
+	p.allowed = make(map[string]bool)
+
+	var visit func(importPath string)
+	visit = func(importPath string) {
+		if p.allowed[importPath] || importPath == "C" || isStdlib(importPath) {
+			return
+		}
+		pkg, err := build.Default.Import(importPath, "", 0)
+		if err != nil {
+			return
+		}
+		p.allowed[importPath] = true
+		for _, imp := range pkg.Imports {
+			visit(imp)
+		}
+	}
+
+	for _, pattern := range p.Allow {
+		for _, importPath := range expandPattern(pattern) {
+			visit(importPath)
+		}
+	}
+}
+
+// expandPattern returns the packages in GOPATH matched by an import path
+// pattern ending in /..., or the path itself for other patterns.
+func expandPattern(pattern string) []string {
+	root := strings.TrimSuffix(pattern, "/...")
+	if root == pattern {
+		return []string{pattern}
+	}
+
+	var paths []string
+	for _, src := range build.Default.SrcDirs() {
+		dir := filepath.Join(src, filepath.FromSlash(root))
+		_ = filepath.Walk(dir, func(name string, fi os.FileInfo, err error) error {
+			if err != nil || !fi.IsDir() {
+				return nil
+			}
+			base := fi.Name()
+			if name != dir && (base == "testdata" || base == "vendor" || strings.HasPrefix(base, ".") || strings.HasPrefix(base, "_")) {
+				return filepath.SkipDir
+			}
+			rel, err := filepath.Rel(src, name)
+			if err == nil {
+				paths = append(paths, filepath.ToSlash(rel))
+			}
+			return nil
+		})
+	}
+	return paths
+}
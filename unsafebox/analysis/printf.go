@@ -0,0 +1,176 @@
+package analysis
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/types"
+	"strings"
+	"unicode/utf8"
+)
+
+// printfFuncs maps the functions that take a format to the index of their
+// format argument.
+var printfFuncs = map[string]int{
+	"fmt.Errorf":           0,
+	"fmt.Fprintf":          1,
+	"fmt.Printf":           0,
+	"fmt.Sprintf":          0,
+	"log.Fatalf":           0,
+	"log.Panicf":           0,
+	"log.Printf":           0,
+	"(*log.Logger).Fatalf": 0,
+	"(*log.Logger).Panicf": 0,
+	"(*log.Logger).Printf": 0,
+}
+
+// printFuncs are the functions that do not take a format.
+var printFuncs = map[string]bool{
+	"fmt.Fprint":            true,
+	"fmt.Fprintln":          true,
+	"fmt.Print":             true,
+	"fmt.Println":           true,
+	"fmt.Sprint":            true,
+	"fmt.Sprintln":          true,
+	"log.Fatal":             true,
+	"log.Fatalln":           true,
+	"log.Panic":             true,
+	"log.Panicln":           true,
+	"log.Print":             true,
+	"log.Println":           true,
+	"(*log.Logger).Fatal":   true,
+	"(*log.Logger).Fatalln": true,
+	"(*log.Logger).Panic":   true,
+	"(*log.Logger).Panicln": true,
+	"(*log.Logger).Print":   true,
+	"(*log.Logger).Println": true,
+}
+
+// checkPrintf reports calls to printf-like functions whose number of
+// arguments does not match their format, and calls to print-like functions
+// that look like they meant to use a format.
+func checkPrintf(d *diagnostics, f *ast.File, info *types.Info) {
+	ast.Inspect(f, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || call.Ellipsis.IsValid() {
+			return true
+		}
+		name := calleeName(call, info)
+		if name == "" {
+			return true
+		}
+
+		if idx, ok := printfFuncs[name]; ok && idx < len(call.Args) {
+			format, ok := stringConstant(call.Args[idx], info)
+			if !ok {
+				return true
+			}
+			want := countVerbs(format)
+			got := len(call.Args) - idx - 1
+			if want >= 0 && want != got {
+				d.add(call.Pos(), SeverityWarning, "printf",
+					fmt.Sprintf("%s call needs %d %s but has %d %s", name, want, plural(want, "arg"), got, plural(got, "arg")))
+			}
+			return true
+		}
+
+		if printFuncs[name] {
+			for _, arg := range call.Args {
+				s, ok := stringConstant(arg, info)
+				if !ok {
+					continue
+				}
+				if verb := firstVerb(s); verb != "" {
+					d.add(arg.Pos(), SeverityWarning, "printf",
+						fmt.Sprintf("%s call has possible formatting directive %s", name, verb))
+					break
+				}
+			}
+		}
+		return true
+	})
+}
+
+// calleeName returns the full name of the function called by call, as in
+// fmt.Printf or (*log.Logger).Printf.
+func calleeName(call *ast.CallExpr, info *types.Info) string {
+	var id *ast.Ident
+	switch fun := call.Fun.(type) {
+	case *ast.Ident:
+		id = fun
+	case *ast.SelectorExpr:
+		id = fun.Sel
+	default:
+		return ""
+	}
+	fn, ok := info.Uses[id].(*types.Func)
+	if !ok {
+		return ""
+	}
+	return fn.FullName()
+}
+
+func stringConstant(e ast.Expr, info *types.Info) (string, bool) {
+	tv, ok := info.Types[e]
+	if !ok || tv.Value == nil || tv.Value.Kind() != constant.String {
+		return "", false
+	}
+	return constant.StringVal(tv.Value), true
+}
+
+// countVerbs returns the number of arguments a format reads, or -1 if it
+// uses explicit argument indexes.
+func countVerbs(format string) int {
+	n := 0
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		i++
+		if i < len(format) && format[i] == '%' {
+			continue
+		}
+		for i < len(format) && strings.IndexByte("+-# 0", format[i]) >= 0 {
+			i++
+		}
+		if i < len(format) && format[i] == '[' {
+			return -1
+		}
+		// Width and precision, either of which may be read from an
+		// argument.
+		for i < len(format) && (format[i] >= '0' && format[i] <= '9' || format[i] == '.' || format[i] == '*') {
+			if format[i] == '*' {
+				n++
+			}
+			i++
+		}
+		if i < len(format) {
+			_, size := utf8.DecodeRuneInString(format[i:])
+			i += size - 1
+			n++
+		}
+	}
+	return n
+}
+
+// firstVerb returns the first thing in s that looks like a formatting
+// directive, such as %d.
+func firstVerb(s string) string {
+	for i := 0; i+1 < len(s); i++ {
+		if s[i] != '%' {
+			continue
+		}
+		if strings.IndexByte("vTtbcdoOqxXUeEfFgGsp", s[i+1]) >= 0 {
+			return s[i : i+2]
+		}
+		i++
+	}
+	return ""
+}
+
+func plural(n int, s string) string {
+	if n == 1 {
+		return s
+	}
+	return s + "s"
+}
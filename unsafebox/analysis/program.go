@@ -0,0 +1,131 @@
+package analysis
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/upper/upper.io/unsafebox/txtar"
+)
+
+// program is a parsed program with several files. Each directory of the
+// program holds a package, the one at the top is the main package.
+type program struct {
+	fset *token.FileSet
+	// files holds the .go files of the program by name.
+	files map[string]*ast.File
+	// dirs holds the names of the .go files in each directory, in order.
+	dirs map[string][]string
+	// module is the module path declared in go.mod, if there is one.
+	module string
+}
+
+// parseProgram parses the .go files of a program into fset, returning the
+// parse errors of each file. Files that could be parsed are kept even if they
+// have errors.
+func parseProgram(fset *token.FileSet, files []txtar.File, mode parser.Mode) (*program, []error) {
+	p := &program{
+		fset:  fset,
+		files: make(map[string]*ast.File),
+		dirs:  make(map[string][]string),
+	}
+
+	var errs []error
+	for _, file := range files {
+		if file.Name == "go.mod" {
+			p.module = txtar.ModulePath(file.Data)
+			continue
+		}
+		if !isGoFile(file.Name) {
+			continue
+		}
+		f, err := parser.ParseFile(p.fset, file.Name, file.Data, mode)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if f != nil {
+			p.files[file.Name] = f
+			dir := path.Dir(file.Name)
+			p.dirs[dir] = append(p.dirs[dir], file.Name)
+		}
+	}
+	for _, names := range p.dirs {
+		sort.Strings(names)
+	}
+	return p, errs
+}
+
+// declared returns the top-level names declared by the files of the package
+// of the given file, other than the file itself.
+func (p *program) declared(name string) map[string]bool {
+	names := make(map[string]bool)
+	for _, other := range p.dirs[path.Dir(name)] {
+		if other == name {
+			continue
+		}
+		for _, decl := range p.files[other].Decls {
+			for _, n := range declNames(decl) {
+				names[n] = true
+			}
+		}
+	}
+	return names
+}
+
+// localImports returns the packages of the program that the given file may
+// import, by package name.
+func (p *program) localImports(name string) map[string]string {
+	from := path.Dir(name)
+	pkgs := make(map[string]string)
+	for dir, names := range p.dirs {
+		if dir == from || dir == "." {
+			continue
+		}
+		importPath, ok := p.importPath(from, dir)
+		if !ok {
+			continue
+		}
+		pkgs[p.files[names[0]].Name.Name] = importPath
+	}
+	return pkgs
+}
+
+// importPath returns the path a file in the directory from imports the
+// package in dir with.
+func (p *program) importPath(from, dir string) (string, bool) {
+	if p.module != "" {
+		return p.module + "/" + dir, true
+	}
+	// In GOPATH mode, packages of the program are imported with paths
+	// relative to the importing package.
+	if from == "." {
+		return "./" + dir, true
+	}
+	if strings.HasPrefix(dir, from+"/") {
+		return "./" + strings.TrimPrefix(dir, from+"/"), true
+	}
+	return "", false
+}
+
+// importDir returns the directory of the package a file in the directory
+// from imports with importPath, if it is a package of the program.
+func (p *program) importDir(from, importPath string) (string, bool) {
+	var dir string
+	switch {
+	case p.module != "" && strings.HasPrefix(importPath, p.module+"/"):
+		dir = strings.TrimPrefix(importPath, p.module+"/")
+	case strings.HasPrefix(importPath, "./") || strings.HasPrefix(importPath, "../"):
+		dir = path.Join(from, importPath)
+	default:
+		return "", false
+	}
+	_, ok := p.dirs[dir]
+	return dir, ok
+}
+
+func isGoFile(name string) bool {
+	return strings.HasSuffix(name, ".go") && !strings.HasSuffix(name, "_test.go")
+}
@@ -0,0 +1,170 @@
+package analysis
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/upper/upper.io/unsafebox/txtar"
+)
+
+// Screen checks a program against the policy before it is built. Rules that
+// deny something are reported as errors, and rules that warn as warnings;
+// either way in the "policy" category. Programs with errors should not be
+// run.
+func (p *Policy) Screen(files []txtar.File) []Diagnostic {
+	fset := token.NewFileSet()
+	d := &diagnostics{fset: fset}
+
+	for _, file := range files {
+		if r, ok := matchRule(p.Files, path.Base(file.Name)); ok {
+			d.addPosition(token.Position{Filename: file.Name}, severity(r), "policy",
+				ruleMessage(r, "file "+file.Name))
+		}
+	}
+
+	// Syntax errors are left for the compiler to report; whatever could be
+	// parsed is screened.
+	prog, _ := parseProgram(fset, files, parser.ParseComments)
+	for name, f := range prog.files {
+		p.screenImports(d, prog, name, f)
+		p.screenSymbols(d, f)
+		p.screenDirectives(d, f)
+	}
+	return d.sorted()
+}
+
+// Rejected reports whether the policy denies a program, given the diagnostics
+// returned by Screen.
+func Rejected(diags []Diagnostic) bool {
+	for _, diag := range diags {
+		if diag.Category == "policy" && diag.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Policy) screenImports(d *diagnostics, prog *program, name string, f *ast.File) {
+	for _, spec := range f.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		if _, ok := prog.importDir(path.Dir(name), importPath); ok {
+			// The packages of the program are screened with it.
+			continue
+		}
+
+		if r, ok := matchRule(p.Imports, importPath); ok {
+			d.add(spec.Path.Pos(), severity(r), "policy",
+				ruleMessage(r, "import of "+spec.Path.Value))
+			continue
+		}
+		if spec.Name != nil && spec.Name.Name == "." && p.hasSymbolRules(importPath) {
+			d.add(spec.Path.Pos(), SeverityError, "policy",
+				fmt.Sprintf("dot import of %s is not allowed: some of its identifiers are restricted", spec.Path.Value))
+			continue
+		}
+		if !isStdlib(importPath) && !p.isAllowed(importPath) {
+			d.add(spec.Path.Pos(), SeverityError, "policy",
+				fmt.Sprintf("import of %s is not allowed: only upper/db and its dependencies may be imported", spec.Path.Value))
+		}
+	}
+}
+
+// hasSymbolRules reports whether any symbol rule may match an identifier of
+// the package with the given import path.
+func (p *Policy) hasSymbolRules(importPath string) bool {
+	for _, r := range p.Symbols {
+		if i := strings.LastIndexByte(r.Match, '.'); i >= 0 && matchPattern(r.Match[:i], importPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// screenSymbols reports the uses of restricted identifiers of imported
+// packages, like os.StartProcess.
+func (p *Policy) screenSymbols(d *diagnostics, f *ast.File) {
+	if len(p.Symbols) == 0 {
+		return
+	}
+
+	// Identifiers that name an import in this file are unresolved by the
+	// parser, the rest were declared by the program.
+	unresolved := make(map[*ast.Ident]bool)
+	for _, id := range f.Unresolved {
+		unresolved[id] = true
+	}
+	imports := make(map[string]string)
+	for _, spec := range f.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		name, _ := importName(importPath)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		imports[name] = importPath
+	}
+
+	ast.Inspect(f, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		id, ok := sel.X.(*ast.Ident)
+		if !ok || !unresolved[id] {
+			return true
+		}
+		importPath, ok := imports[id.Name]
+		if !ok {
+			return true
+		}
+		symbol := importPath + "." + sel.Sel.Name
+		if r, ok := matchRule(p.Symbols, symbol); ok {
+			d.add(sel.Pos(), severity(r), "policy",
+				ruleMessage(r, "use of "+symbol))
+		}
+		return true
+	})
+}
+
+// screenDirectives reports compiler directives like //go:linkname, and the
+// //export directive of cgo.
+func (p *Policy) screenDirectives(d *diagnostics, f *ast.File) {
+	for _, group := range f.Comments {
+		for _, c := range group.List {
+			text := strings.TrimPrefix(c.Text, "//")
+			if text == c.Text || (!strings.HasPrefix(text, "go:") && !strings.HasPrefix(text, "export ")) {
+				continue
+			}
+			directive := strings.Fields(text)[0]
+			if r, ok := matchRule(p.Directives, directive); ok {
+				d.add(c.Pos(), severity(r), "policy",
+					ruleMessage(r, "//"+directive+" directive"))
+			}
+		}
+	}
+}
+
+// ruleMessage describes what a rule matched.
+func ruleMessage(r Rule, what string) string {
+	if r.Action == ActionDeny {
+		return fmt.Sprintf("%s is not allowed: %s", what, r.Reason)
+	}
+	return fmt.Sprintf("%s: %s", what, r.Reason)
+}
+
+func severity(r Rule) string {
+	if r.Action == ActionDeny {
+		return SeverityError
+	}
+	return SeverityWarning
+}
@@ -0,0 +1,307 @@
+package analysis
+
+import (
+	"fmt"
+	"go/build"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/upper/upper.io/unsafebox/txtar"
+)
+
+// gopath makes a GOPATH with the given files, by name under src, the one of
+// go/build while the test runs. Like in the image of the sandbox, the
+// packages are found outside of any module.
+func gopath(t *testing.T, files map[string]string) {
+	t.Helper()
+	t.Setenv("GO111MODULE", "off")
+	dir := t.TempDir()
+	for name, data := range files {
+		name = filepath.Join(dir, "src", filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	saved := build.Default
+	build.Default.GOPATH = dir
+	t.Cleanup(func() { build.Default = saved })
+}
+
+// upperdb is a GOPATH with upper/db v4, which depends on github.com/x/dep,
+// and an unrelated package, github.com/x/other.
+var upperdb = map[string]string{
+	"github.com/upper/db/v4/db.go":                            "package db\n\nimport _ \"github.com/x/dep\"\n",
+	"github.com/upper/db/v4/adapter/postgresql/postgresql.go": "package postgresql\n\nimport _ \"github.com/x/pq\"\n",
+	"github.com/upper/db/v4/internal/testdata/x/x.go":         "package x\n\nimport _ \"github.com/x/testonly\"\n",
+	"github.com/upper/db/v4/vendor/github.com/v/v.go":         "package v\n",
+	"github.com/upper/db/v4/_examples/main.go":                "package main\n\nimport _ \"github.com/x/example\"\n",
+	"github.com/x/dep/dep.go":                                 "package dep\n\nimport \"fmt\"\n\nvar _ = fmt.Sprint\n",
+	"github.com/x/pq/pq.go":                                   "package pq\n",
+	"github.com/x/testonly/testonly.go":                       "package testonly\n",
+	"github.com/x/example/example.go":                         "package example\n",
+	"github.com/x/other/other.go":                             "package other\n",
+}
+
+func loadPolicy(t *testing.T) *Policy {
+	t.Helper()
+	p, err := LoadPolicy("../policy.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestScreen(t *testing.T) {
+	gopath(t, upperdb)
+
+	tests := []struct {
+		name     string
+		files    []txtar.File
+		want     []string // file:line:col: severity: message
+		rejected bool
+	}{
+		{
+			name:  "ok",
+			files: prog("package main\n\nimport (\n\t\"fmt\"\n\t\"os\"\n)\n\nfunc main() { fmt.Fprintln(os.Stdout, 1) }\n"),
+		},
+		{
+			name:     "os/exec",
+			files:    prog("package main\n\nimport \"os/exec\"\n\nfunc main() { exec.Command(\"ls\").Run() }\n"),
+			want:     []string{`prog.go:3:8: error: import of "os/exec" is not allowed: programs may not start processes`},
+			rejected: true,
+		},
+		{
+			name:     "syscall",
+			files:    prog("package main\n\nimport (\n\t\"fmt\"\n\t\"syscall\"\n)\n\nfunc main() { fmt.Println(syscall.Getpid()) }\n"),
+			want:     []string{`prog.go:5:2: error: import of "syscall" is not allowed: programs may not make system calls directly`},
+			rejected: true,
+		},
+		{
+			name:     "golang.org/x/sys",
+			files:    prog("package main\n\nimport \"golang.org/x/sys/unix\"\n\nfunc main() { unix.Getpid() }\n"),
+			want:     []string{`prog.go:3:8: error: import of "golang.org/x/sys/unix" is not allowed: programs may not make system calls directly`},
+			rejected: true,
+		},
+		{
+			name:     "unsafe",
+			files:    prog("package main\n\nimport \"unsafe\"\n\nfunc main() { println(unsafe.Sizeof(0)) }\n"),
+			want:     []string{`prog.go:3:8: error: import of "unsafe" is not allowed: programs may not bypass the type system`},
+			rejected: true,
+		},
+		{
+			name:     "plugin",
+			files:    prog("package main\n\nimport \"plugin\"\n\nfunc main() { plugin.Open(\"x.so\") }\n"),
+			want:     []string{`prog.go:3:8: error: import of "plugin" is not allowed: programs may not load code at run time`},
+			rejected: true,
+		},
+		{
+			// The #cgo directives of the preamble only take effect with the
+			// import of C, which is what is reported.
+			name:     "cgo",
+			files:    prog("package main\n\n// #cgo LDFLAGS: -lcrypt\n// #include <stdlib.h>\nimport \"C\"\n\nfunc main() {}\n"),
+			want:     []string{`prog.go:5:8: error: import of "C" is not allowed: cgo is not supported`},
+			rejected: true,
+		},
+		{
+			name:  "cgo directives without cgo",
+			files: prog("package main\n\n// #cgo LDFLAGS: -lcrypt\nimport \"fmt\"\n\nfunc main() { fmt.Println() }\n"),
+		},
+		{
+			name:  "linkname",
+			files: prog("package main\n\nimport _ \"unsafe\"\n\n//go:linkname now runtime.nanotime\nfunc now() int64\n\nfunc main() {}\n"),
+			want: []string{
+				`prog.go:3:10: error: import of "unsafe" is not allowed: programs may not bypass the type system`,
+				`prog.go:5:1: error: //go:linkname directive is not allowed: programs may not reach unexported functions of other packages`,
+			},
+			rejected: true,
+		},
+		{
+			name:  "export and cgo_import_dynamic",
+			files: prog("package main\n\n//go:cgo_import_dynamic libc_getpid getpid \"libc.so.6\"\n\n//export Hello\nfunc Hello() {}\n\nfunc main() {}\n"),
+			want: []string{
+				`prog.go:3:1: error: //go:cgo_import_dynamic directive is not allowed: cgo is not supported`,
+				`prog.go:5:1: error: //export directive is not allowed: cgo is not supported`,
+			},
+			rejected: true,
+		},
+		{
+			// Other directives, and comments that only look like them, are
+			// fine.
+			name:  "other directives",
+			files: prog("package main\n\n//go:noinline\nfunc f() {}\n\n// go:linkname is not a directive\nfunc main() { f() }\n"),
+		},
+		{
+			name: "assembly",
+			files: []txtar.File{
+				{Name: "prog.go", Data: []byte("package main\n\nfunc getpid() int\n\nfunc main() { println(getpid()) }\n")},
+				{Name: "getpid_amd64.s", Data: []byte("TEXT ·getpid(SB),0,$0\n")},
+				{Name: "sys/sys.S", Data: []byte("\n")},
+			},
+			want: []string{
+				`getpid_amd64.s:0:0: error: file getpid_amd64.s is not allowed: assembly may make system calls`,
+				`sys/sys.S:0:0: error: file sys/sys.S is not allowed: assembly may make system calls`,
+			},
+			rejected: true,
+		},
+		{
+			name: "c files",
+			files: []txtar.File{
+				{Name: "prog.go", Data: []byte("package main\n\nfunc main() {}\n")},
+				{Name: "hello.c", Data: []byte("int x;\n")},
+			},
+			want:     []string{`hello.c:0:0: error: file hello.c is not allowed: cgo is not supported`},
+			rejected: true,
+		},
+		{
+			name:  "warning",
+			files: prog("package main\n\nimport \"runtime/debug\"\n\nfunc main() { debug.SetGCPercent(-1) }\n"),
+			want:  []string{`prog.go:3:8: warning: import of "runtime/debug": changing the settings of the runtime does not lift the limits of the sandbox`},
+		},
+		{
+			name:     "symbol",
+			files:    prog("package main\n\nimport sys \"os\"\n\nfunc main() {\n\tsys.StartProcess(\"/bin/sh\", nil, nil)\n}\n"),
+			want:     []string{`prog.go:6:2: error: use of os.StartProcess is not allowed: programs may not start processes`},
+			rejected: true,
+		},
+		{
+			// Identifiers of the program are not the ones of the package.
+			name:  "symbol of a local variable",
+			files: prog("package main\n\ntype proc struct{}\n\nfunc (proc) StartProcess() {}\n\nfunc main() {\n\tvar os proc\n\tos.StartProcess()\n}\n"),
+		},
+		{
+			name:     "dot import",
+			files:    prog("package main\n\nimport . \"os\"\n\nfunc main() { StartProcess(\"/bin/sh\", nil, nil) }\n"),
+			want:     []string{`prog.go:3:10: error: dot import of "os" is not allowed: some of its identifiers are restricted`},
+			rejected: true,
+		},
+		{
+			// upper/db, its dependencies and the packages of the program may
+			// be imported.
+			name: "allowed",
+			files: []txtar.File{
+				{Name: "prog.go", Data: []byte("package main\n\nimport (\n\t_ \"example.com/prog/models\"\n\t_ \"github.com/upper/db/v4\"\n\t_ \"github.com/upper/db/v4/adapter/postgresql\"\n\t_ \"github.com/x/dep\"\n\t_ \"github.com/x/pq\"\n\t_ \"upper.io/db.v3/lib/sqlbuilder\"\n)\n\nfunc main() {}\n")},
+				{Name: "go.mod", Data: []byte("module example.com/prog\n")},
+				{Name: "models/models.go", Data: []byte("package models\n\nimport \"os/exec\"\n\nvar _ = exec.Command\n")},
+			},
+			want:     []string{`models/models.go:3:8: error: import of "os/exec" is not allowed: programs may not start processes`},
+			rejected: true,
+		},
+		{
+			name:  "not allowed",
+			files: prog("package main\n\nimport (\n\t_ \"github.com/x/other\"\n\t_ \"github.com/x/testonly\"\n\t_ \"github.com/x/example\"\n\t_ \"github.com/upper/db\"\n)\n\nfunc main() {}\n"),
+			want: []string{
+				`prog.go:4:4: error: import of "github.com/x/other" is not allowed: only upper/db and its dependencies may be imported`,
+				`prog.go:5:4: error: import of "github.com/x/testonly" is not allowed: only upper/db and its dependencies may be imported`,
+				`prog.go:6:4: error: import of "github.com/x/example" is not allowed: only upper/db and its dependencies may be imported`,
+				`prog.go:7:4: error: import of "github.com/upper/db" is not allowed: only upper/db and its dependencies may be imported`,
+			},
+			rejected: true,
+		},
+		{
+			// What parses is screened, the rest is left to the compiler.
+			name:     "syntax error",
+			files:    prog("package main\n\nimport \"os/exec\"\n\nfunc main() {\n"),
+			want:     []string{`prog.go:3:8: error: import of "os/exec" is not allowed: programs may not start processes`},
+			rejected: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diags := loadPolicy(t).Screen(tt.files)
+			var got []string
+			for _, d := range diags {
+				if d.Category != "policy" {
+					t.Errorf("category %q", d.Category)
+				}
+				got = append(got, fmt.Sprintf("%s:%d:%d: %s: %s", d.File, d.Line, d.Column, d.Severity, d.Message))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+			if Rejected(diags) != tt.rejected {
+				t.Errorf("Rejected = %v, want %v", Rejected(diags), tt.rejected)
+			}
+		})
+	}
+}
+
+func prog(src string) []txtar.File {
+	return []txtar.File{{Name: txtar.ProgramFile, Data: []byte(src)}}
+}
+
+func TestExpandPattern(t *testing.T) {
+	gopath(t, upperdb)
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"github.com/upper/db/v4/...", []string{"github.com/upper/db/v4", "github.com/upper/db/v4/adapter", "github.com/upper/db/v4/adapter/postgresql", "github.com/upper/db/v4/internal"}},
+		{"github.com/upper/db/v4/adapter/...", []string{"github.com/upper/db/v4/adapter", "github.com/upper/db/v4/adapter/postgresql"}},
+		{"github.com/upper/db/v4", []string{"github.com/upper/db/v4"}},
+		{"github.com/missing/...", nil},
+	}
+	for _, tt := range tests {
+		got := expandPattern(tt.pattern)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("expandPattern(%s) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestIsAllowed(t *testing.T) {
+	gopath(t, upperdb)
+	p := &Policy{Allow: []string{"github.com/upper/db/v4/...", "upper.io/db.v3"}}
+
+	tests := []struct {
+		importPath string
+		want       bool
+	}{
+		{"github.com/upper/db/v4", true},
+		{"github.com/upper/db/v4/adapter/postgresql", true},
+		{"github.com/upper/db/v4/missing", true}, // matched by the pattern
+		{"upper.io/db.v3", true},
+		{"upper.io/db.v3/lib", false},
+		{"github.com/upper/db", false},
+		{"github.com/x/dep", true},
+		{"github.com/x/pq", true},
+		{"github.com/x/testonly", false},
+		{"github.com/x/example", false},
+		{"github.com/x/other", false},
+	}
+	for _, tt := range tests {
+		if got := p.isAllowed(tt.importPath); got != tt.want {
+			t.Errorf("isAllowed(%s) = %v, want %v", tt.importPath, got, tt.want)
+		}
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	tests := []struct {
+		data string
+		err  string
+	}{
+		{`{"Imports": [{"Match": "os/exec", "Action": "deny"}]}`, ""},
+		{`{"Imports": [{"Match": "os/exec", "Action": "block"}]}`, `unknown action "block"`},
+		{`{"Files": [{"Match": "[", "Action": "deny"}]}`, "syntax error in pattern"},
+		{`{"Imports": [`, "unexpected end of JSON input"},
+	}
+	for _, tt := range tests {
+		name := filepath.Join(t.TempDir(), "policy.json")
+		if err := os.WriteFile(name, []byte(tt.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := LoadPolicy(name)
+		if (err == nil) != (tt.err == "") || (err != nil && !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("LoadPolicy(%s): %v, want %q", tt.data, err, tt.err)
+		}
+	}
+}
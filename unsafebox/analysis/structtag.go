@@ -0,0 +1,190 @@
+package analysis
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/types"
+	"strconv"
+	"strings"
+)
+
+// dbTagOptions are the options upper/db understands in a db tag.
+var dbTagOptions = map[string]bool{
+	"omitempty": true,
+	"inline":    true,
+}
+
+// checkStructTags reports struct tags that reflect.StructTag.Get cannot
+// parse, and db tags that upper/db would not map as intended.
+func checkStructTags(d *diagnostics, f *ast.File, info *types.Info) {
+	ast.Inspect(f, func(n ast.Node) bool {
+		st, ok := n.(*ast.StructType)
+		if !ok {
+			return true
+		}
+
+		columns := make(map[string]string)
+		for _, field := range st.Fields.List {
+			if field.Tag == nil {
+				continue
+			}
+			tag, err := strconv.Unquote(field.Tag.Value)
+			if err != nil {
+				continue
+			}
+			pairs, err := parseStructTag(tag)
+			if err != nil {
+				d.add(field.Tag.Pos(), SeverityWarning, "structtag",
+					fmt.Sprintf("struct field tag %s not compatible with reflect.StructTag.Get: %v", field.Tag.Value, err))
+				continue
+			}
+			if value, ok := pairs["db"]; ok {
+				checkDBTag(d, field, value, columns, info)
+			}
+		}
+		return true
+	})
+}
+
+// checkDBTag checks the db tag of a field. columns maps the columns of the
+// struct seen so far to the fields they belong to.
+func checkDBTag(d *diagnostics, field *ast.Field, value string, columns map[string]string, info *types.Info) {
+	name := fieldName(field)
+	pos := field.Tag.Pos()
+	warn := func(format string, args ...interface{}) {
+		d.add(pos, SeverityWarning, "dbtag", fmt.Sprintf(format, args...))
+	}
+
+	parts := strings.Split(value, ",")
+	column, options := parts[0], parts[1:]
+	if column == "-" {
+		return
+	}
+
+	if len(field.Names) > 0 && !ast.IsExported(name) {
+		warn("db tag on unexported field %s has no effect: upper/db only maps exported fields", name)
+		return
+	}
+
+	inline := false
+	for _, opt := range options {
+		key := opt
+		if i := strings.IndexByte(opt, '='); i >= 0 {
+			key = opt[:i]
+		}
+		switch {
+		case key == "":
+			warn("empty option in db tag of field %s", name)
+		case !dbTagOptions[key]:
+			warn("unknown option %q in db tag of field %s: upper/db understands omitempty and inline", key, name)
+		case key == "inline":
+			inline = true
+		}
+	}
+
+	if inline {
+		if t := info.TypeOf(field.Type); t != nil && !isStruct(t) {
+			warn("field %s has the inline option but it is not a struct", name)
+		}
+		return
+	}
+
+	if column == "" {
+		if len(field.Names) > 0 {
+			warn("db tag of field %s has no column name", name)
+		}
+		return
+	}
+	if other, ok := columns[column]; ok {
+		warn("column %q is mapped by both %s and %s", column, other, name)
+		return
+	}
+	columns[column] = name
+}
+
+// fieldName returns the name of a field, or the type of an embedded field.
+func fieldName(field *ast.Field) string {
+	if len(field.Names) > 0 {
+		return field.Names[0].Name
+	}
+	t := field.Type
+	if star, ok := t.(*ast.StarExpr); ok {
+		t = star.X
+	}
+	switch t := t.(type) {
+	case *ast.Ident:
+		return t.Name
+	case *ast.SelectorExpr:
+		return t.Sel.Name
+	}
+	return "embedded field"
+}
+
+func isStruct(t types.Type) bool {
+	if p, ok := t.Underlying().(*types.Pointer); ok {
+		t = p.Elem()
+	}
+	_, ok := t.Underlying().(*types.Struct)
+	return ok
+}
+
+var (
+	errTagKeySyntax   = errors.New("bad syntax for struct tag key")
+	errTagPairSyntax  = errors.New("bad syntax for struct tag pair")
+	errTagValueSyntax = errors.New("bad syntax for struct tag value")
+	errTagSpace       = errors.New(`key:"value" pairs not separated by spaces`)
+)
+
+// parseStructTag parses a struct tag following the conventions of
+// reflect.StructTag, returning its key/value pairs.
+func parseStructTag(tag string) (map[string]string, error) {
+	pairs := make(map[string]string)
+	for tag != "" {
+		i := 0
+		for i < len(tag) && tag[i] == ' ' {
+			i++
+		}
+		tag = tag[i:]
+		if tag == "" {
+			break
+		}
+
+		i = 0
+		for i < len(tag) && tag[i] > ' ' && tag[i] != ':' && tag[i] != '"' && tag[i] != 0x7f {
+			i++
+		}
+		if i == 0 {
+			return nil, errTagKeySyntax
+		}
+		if i+1 >= len(tag) || tag[i] != ':' {
+			return nil, errTagPairSyntax
+		}
+		if tag[i+1] != '"' {
+			return nil, errTagValueSyntax
+		}
+		key := tag[:i]
+		tag = tag[i+1:]
+
+		i = 1
+		for i < len(tag) && tag[i] != '"' {
+			if tag[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(tag) {
+			return nil, errTagValueSyntax
+		}
+		value, err := strconv.Unquote(tag[:i+1])
+		if err != nil {
+			return nil, errTagValueSyntax
+		}
+		tag = tag[i+1:]
+		if tag != "" && tag[0] != ' ' {
+			return nil, errTagSpace
+		}
+		pairs[key] = value
+	}
+	return pairs, nil
+}
@@ -0,0 +1,168 @@
+package analysis
+
+import (
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"sort"
+	"sync"
+
+	"github.com/upper/upper.io/unsafebox/txtar"
+)
+
+// A checker type checks the packages imported by programs from source once,
+// and reuses them after that. Each checker is used by one call to Vet at a
+// time.
+type checker struct {
+	fset *token.FileSet
+	imp  types.ImporterFrom
+}
+
+const (
+	// maxCheckerBase bounds the size of the file set of a checker, which
+	// grows with every program it checks. Checkers past it are dropped.
+	maxCheckerBase = 64 << 20
+	// maxIdleCheckers is the number of checkers kept between calls.
+	maxIdleCheckers = 4
+)
+
+// checkers holds the idle checkers.
+var checkers struct {
+	mu   sync.Mutex
+	idle []*checker
+}
+
+func getChecker() *checker {
+	checkers.mu.Lock()
+	defer checkers.mu.Unlock()
+	if n := len(checkers.idle); n > 0 {
+		c := checkers.idle[n-1]
+		checkers.idle = checkers.idle[:n-1]
+		return c
+	}
+	fset := token.NewFileSet()
+	return &checker{fset: fset, imp: importer.ForCompiler(fset, "source", nil).(types.ImporterFrom)}
+}
+
+func putChecker(c *checker) {
+	if c.fset.Base() > maxCheckerBase {
+		return
+	}
+	checkers.mu.Lock()
+	defer checkers.mu.Unlock()
+	if len(checkers.idle) < maxIdleCheckers {
+		checkers.idle = append(checkers.idle, c)
+	}
+}
+
+// Vet type checks a program and runs a few of the checks of go vet on it,
+// plus a check of the db struct tags used by upper/db. The program is not
+// built nor run. Each directory of the program is checked as a package, and
+// the packages of the program may import each other.
+func Vet(files []txtar.File) []Diagnostic {
+	c := getChecker()
+	defer putChecker(c)
+
+	d := &diagnostics{fset: c.fset}
+
+	p, errs := parseProgram(c.fset, files, parser.ParseComments|parser.AllErrors)
+	if len(errs) > 0 {
+		for _, err := range errs {
+			d.addParseErrors(err)
+		}
+		return d.sorted()
+	}
+
+	info := &types.Info{
+		Types: make(map[ast.Expr]types.TypeAndValue),
+		Defs:  make(map[*ast.Ident]types.Object),
+		Uses:  make(map[*ast.Ident]types.Object),
+	}
+	imp := &programImporter{
+		imp:  c.imp,
+		p:    p,
+		d:    d,
+		info: info,
+		pkgs: make(map[string]*types.Package),
+	}
+
+	dirs := make([]string, 0, len(p.dirs))
+	for dir := range p.dirs {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		// Errors are collected by the checker, and the checks below work
+		// with whatever could be type checked.
+		_, _ = imp.check(dir)
+	}
+
+	for _, f := range p.files {
+		checkPrintf(d, f, info)
+		checkStructTags(d, f, info)
+	}
+
+	return d.sorted()
+}
+
+// programImporter type checks the packages of a program, importing the
+// packages of the program from its files and the rest from source.
+type programImporter struct {
+	imp  types.ImporterFrom
+	p    *program
+	d    *diagnostics
+	info *types.Info
+	// pkgs holds the packages already checked by directory. A nil package
+	// is being checked.
+	pkgs map[string]*types.Package
+}
+
+func (imp *programImporter) Import(path string) (*types.Package, error) {
+	return imp.ImportFrom(path, ".", 0)
+}
+
+func (imp *programImporter) ImportFrom(path, dir string, mode types.ImportMode) (*types.Package, error) {
+	if local, ok := imp.p.importDir(dir, path); ok {
+		return imp.check(local)
+	}
+	return imp.imp.ImportFrom(path, ".", mode)
+}
+
+// check type checks the package in dir.
+func (imp *programImporter) check(dir string) (*types.Package, error) {
+	if pkg, ok := imp.pkgs[dir]; ok {
+		if pkg == nil {
+			return nil, fmt.Errorf("import cycle through %s", dir)
+		}
+		return pkg, nil
+	}
+	imp.pkgs[dir] = nil
+
+	var files []*ast.File
+	for _, name := range imp.p.dirs[dir] {
+		files = append(files, imp.p.files[name])
+	}
+
+	path := "main"
+	if dir != "." {
+		path = dir
+		if imp.p.module != "" {
+			path = imp.p.module + "/" + dir
+		}
+	}
+
+	conf := types.Config{
+		Importer: imp,
+		Error: func(err error) {
+			if e, ok := err.(types.Error); ok {
+				imp.d.add(e.Pos, SeverityError, "typecheck", e.Msg)
+			}
+		},
+	}
+	pkg, _ := conf.Check(path, imp.p.fset, files, imp.info)
+	imp.pkgs[dir] = pkg
+	return pkg, nil
+}
@@ -0,0 +1,86 @@
+package analysis
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/upper/upper.io/unsafebox/txtar"
+)
+
+func TestVet(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string // categories at lines, like "printf:6"
+	}{
+		{
+			name: "ok",
+			src:  "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(1)\n}\n",
+		},
+		{
+			name: "printf",
+			src:  "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Printf(\"%d %d\\n\", 1)\n}\n",
+			want: []string{"printf:6"},
+		},
+		{
+			name: "typecheck",
+			src:  "package main\n\nfunc main() {\n\tx := 1\n}\n",
+			want: []string{"typecheck:4"},
+		},
+		{
+			name: "syntax",
+			src:  "package main\n\nfunc main() {\n",
+			want: []string{"syntax:3", "syntax:3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diags := Vet([]txtar.File{{Name: txtar.ProgramFile, Data: []byte(tt.src)}})
+			var got []string
+			for _, d := range diags {
+				got = append(got, fmt.Sprintf("%s:%d", d.Category, d.Line))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q: %+v", got, tt.want, diags)
+			}
+		})
+	}
+}
+
+func TestVetCheckers(t *testing.T) {
+	src := []txtar.File{{Name: txtar.ProgramFile, Data: []byte("package main\n\nimport \"strings\"\n\nfunc main() { _ = strings.ToUpper(\"x\") }\n")}}
+
+	// Calls do not share a checker.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if diags := Vet(src); len(diags) != 0 {
+				t.Errorf("Vet: %+v", diags)
+			}
+		}()
+	}
+	wg.Wait()
+
+	checkers.mu.Lock()
+	idle := len(checkers.idle)
+	checkers.mu.Unlock()
+	if idle == 0 || idle > maxIdleCheckers {
+		t.Errorf("%d idle checkers, want 1 to %d", idle, maxIdleCheckers)
+	}
+
+	// A checker whose file set grew too much is not kept.
+	c := getChecker()
+	c.fset.AddFile("big.go", -1, maxCheckerBase)
+	putChecker(c)
+	checkers.mu.Lock()
+	defer checkers.mu.Unlock()
+	for _, idle := range checkers.idle {
+		if idle == c {
+			t.Errorf("a checker past maxCheckerBase was kept")
+		}
+	}
+}
@@ -0,0 +1,287 @@
+// Package audit keeps a record of the programs run by the compile service,
+// so that abuse of the public sandbox can be traced back to its clients.
+//
+// The log is a directory of files with one JSON record per line, a file per
+// day. Records are only ever appended; files older than the retention period
+// are deleted as a whole.
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/upper/upper.io/unsafebox/sandbox"
+	"github.com/upper/upper.io/unsafebox/txtar"
+)
+
+// Outcomes of a run.
+const (
+	// OutcomeOK means the program ran and exited with status zero.
+	OutcomeOK = "ok"
+	// OutcomeFailed means the program ran and exited with another status.
+	OutcomeFailed = "failed"
+	// OutcomeBuildError means the program could not be built.
+	OutcomeBuildError = "build-error"
+	// OutcomeRejected means the policy did not let the program run.
+	OutcomeRejected = "rejected"
+	// OutcomeBusy means the program was turned away because the queue was
+	// full.
+	OutcomeBusy = "busy"
+	// OutcomeError means the program could not be run because of a problem
+	// of the service.
+	OutcomeError = "error"
+	// OutcomeCanceled means the run was abandoned before it finished,
+	// because the client went away or the service shut down.
+	OutcomeCanceled = "canceled"
+)
+
+const (
+	filePrefix = "audit-"
+	fileSuffix = ".jsonl"
+	dayLayout  = "2006-01-02"
+)
+
+// Record describes a run of a program.
+type Record struct {
+	Time   time.Time
+	Client string
+	// SourceHash is the hex encoded SHA-256 of the submitted program.
+	SourceHash string
+	// DB lists the upper/db modules the program imports, with the versions
+	// they resolve to in the sandbox, like upper.io/db.v3@v3.8.0.
+	DB        []string `json:",omitempty"`
+	GoVersion string   `json:",omitempty"`
+	Duration  time.Duration
+	Outcome   string
+	Status    int
+	Limits    []string `json:",omitempty"`
+	Blocked   []string `json:",omitempty"`
+}
+
+// SetResult fills in the record with the result of the run.
+func (rec *Record) SetResult(res *sandbox.Result) {
+	rec.GoVersion = res.GoVersion
+	rec.Status = res.Status
+	rec.Limits = res.Limits
+	rec.Blocked = res.Blocked
+	switch {
+	case res.Events == nil && res.Errors != "":
+		rec.Outcome = OutcomeBuildError
+	case res.Status != 0:
+		rec.Outcome = OutcomeFailed
+	default:
+		rec.Outcome = OutcomeOK
+	}
+}
+
+// Config configures a log.
+type Config struct {
+	// Retention is how long records are kept, zero keeps them forever.
+	Retention time.Duration
+	// Versions holds the versions of upper/db installed in the sandbox.
+	Versions Versions
+}
+
+// Log is an audit log. It is safe for concurrent use.
+type Log struct {
+	dir  string
+	conf Config
+
+	mu   sync.Mutex
+	f    *os.File
+	day  string
+	last time.Time // of the last prune
+}
+
+// Open opens the log in dir, creating the directory if needed, and deletes
+// the records that are past the retention period.
+func Open(dir string, conf Config) (*Log, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	l := &Log{dir: dir, conf: conf}
+	if _, err := l.Prune(time.Now()); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// NewRecord starts the record of a run of body, submitted by client, that
+// started at start.
+func (l *Log) NewRecord(client, body string, start time.Time) *Record {
+	sum := sha256.Sum256([]byte(body))
+	rec := &Record{
+		Time:       time.Now().UTC(),
+		Client:     client,
+		SourceHash: hex.EncodeToString(sum[:]),
+		Duration:   time.Since(start),
+	}
+	if files, err := txtar.Program([]byte(body)); err == nil {
+		rec.DB = l.conf.Versions.Resolve(files)
+	}
+	return rec
+}
+
+// Append writes rec to the log.
+func (l *Log) Append(rec *Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	day := rec.Time.UTC().Format(dayLayout)
+	if l.f == nil || day != l.day {
+		if l.f != nil {
+			l.f.Close()
+		}
+		name := filepath.Join(l.dir, filePrefix+day+fileSuffix)
+		l.f, err = os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			l.f = nil
+			return err
+		}
+		l.day = day
+	}
+	if _, err := l.f.Write(data); err != nil {
+		return err
+	}
+
+	if now := time.Now(); now.Sub(l.last) > time.Hour {
+		if _, err := l.prune(now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Prune deletes the files whose records are all past the retention period. It
+// returns the number of files deleted.
+func (l *Log) Prune(now time.Time) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.prune(now)
+}
+
+func (l *Log) prune(now time.Time) (int, error) {
+	l.last = now
+	if l.conf.Retention <= 0 {
+		return 0, nil
+	}
+	days, err := listDays(l.dir)
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, day := range days {
+		// A file holds the records of a whole day.
+		if now.Sub(day.AddDate(0, 0, 1)) <= l.conf.Retention {
+			continue
+		}
+		name := day.Format(dayLayout)
+		if name == l.day && l.f != nil {
+			l.f.Close()
+			l.f = nil
+		}
+		if err := os.Remove(filepath.Join(l.dir, filePrefix+name+fileSuffix)); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// Close closes the log.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+// Read calls fn with each record in the log at dir made at or after since, in
+// the order they were written.
+func Read(dir string, since time.Time, fn func(*Record) error) error {
+	days, err := listDays(dir)
+	if err != nil {
+		return err
+	}
+	for _, day := range days {
+		if day.AddDate(0, 0, 1).Before(since) {
+			continue
+		}
+		if err := readFile(filepath.Join(dir, filePrefix+day.Format(dayLayout)+fileSuffix), since, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readFile(name string, since time.Time, fn func(*Record) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		rec := &Record{}
+		if err := json.Unmarshal(scanner.Bytes(), rec); err != nil {
+			// A record cut short by a crash is followed by the next one
+			// on the same line. Both are lost.
+			continue
+		}
+		if rec.Time.Before(since) {
+			continue
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// listDays returns the days the log in dir has a file for, oldest first.
+func listDays(dir string) ([]time.Time, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var days []time.Time
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+		day, err := time.Parse(dayLayout, strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileSuffix))
+		if err != nil {
+			continue
+		}
+		days = append(days, day)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	return days, nil
+}
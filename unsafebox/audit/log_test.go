@@ -0,0 +1,145 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/upper/upper.io/unsafebox/sandbox"
+)
+
+func TestSetResult(t *testing.T) {
+	tests := []struct {
+		res     sandbox.Result
+		outcome string
+	}{
+		{sandbox.Result{Events: []sandbox.Event{}}, OutcomeOK},
+		{sandbox.Result{}, OutcomeOK},
+		{sandbox.Result{Events: []sandbox.Event{}, Status: 2}, OutcomeFailed},
+		{sandbox.Result{Errors: "prog.go:1: syntax error"}, OutcomeBuildError},
+		// Errors with output are not of the build, the program ran.
+		{sandbox.Result{Errors: "prog.go:1: vet", Events: []sandbox.Event{}}, OutcomeOK},
+	}
+	for _, tt := range tests {
+		var rec Record
+		rec.SetResult(&tt.res)
+		if rec.Outcome != tt.outcome {
+			t.Errorf("SetResult(%+v): outcome %q, want %q", tt.res, rec.Outcome, tt.outcome)
+		}
+	}
+}
+
+func TestLog(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, Config{Versions: Versions{"upper.io/db.v3": "v3.8.0"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	rec := l.NewRecord("192.0.2.1", `package main; import "upper.io/db.v3"`, time.Now())
+	sum := sha256.Sum256([]byte(`package main; import "upper.io/db.v3"`))
+	if rec.SourceHash != hex.EncodeToString(sum[:]) {
+		t.Errorf("SourceHash %q", rec.SourceHash)
+	}
+	if !reflect.DeepEqual(rec.DB, []string{"upper.io/db.v3@v3.8.0"}) {
+		t.Errorf("DB %q", rec.DB)
+	}
+
+	// Records of three days, in two files.
+	day := time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)
+	times := []time.Time{day.Add(time.Hour), day.Add(23 * time.Hour), day.Add(25 * time.Hour)}
+	for i, tm := range times {
+		rec := &Record{Time: tm, Client: "192.0.2.1", Outcome: OutcomeOK, Status: i}
+		if err := l.Append(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	sort.Strings(files)
+	want := []string{filepath.Join(dir, "audit-2021-03-04.jsonl"), filepath.Join(dir, "audit-2021-03-05.jsonl")}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("files %q, want %q", files, want)
+	}
+
+	// A record cut short by a crash is skipped, with the one after it.
+	f, err := os.OpenFile(want[1], os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"Time":"2021-03-05T02:00:00Z","Cli`)
+	f.Close()
+	if err := l.Append(&Record{Time: day.Add(27 * time.Hour), Status: 9}); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Append(&Record{Time: day.Add(28 * time.Hour), Status: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		since  time.Time
+		status []int
+	}{
+		{time.Time{}, []int{0, 1, 2, 3}},
+		{day.Add(2 * time.Hour), []int{1, 2, 3}},
+		{day.Add(24 * time.Hour), []int{2, 3}},
+		{day.Add(48 * time.Hour), nil},
+	}
+	for _, tt := range tests {
+		var status []int
+		err := Read(dir, tt.since, func(rec *Record) error {
+			status = append(status, rec.Status)
+			return nil
+		})
+		if err != nil || !reflect.DeepEqual(status, tt.status) {
+			t.Errorf("Read since %v = %v, %v, want %v", tt.since, status, err, tt.status)
+		}
+	}
+
+	stop := errors.New("stop")
+	if err := Read(dir, time.Time{}, func(*Record) error { return stop }); err != stop {
+		t.Errorf("Read: %v, want the error of fn", err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, Config{Retention: 48 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	for _, name := range []string{"audit-2021-03-01.jsonl", "audit-2021-03-02.jsonl", "audit-2021-03-03.jsonl", "audit-x.jsonl", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Files go when their last record is past the retention period.
+	tests := []struct {
+		now     time.Time
+		deleted int
+	}{
+		{time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC), 0},
+		{time.Date(2021, 3, 4, 0, 0, 1, 0, time.UTC), 1},
+		{time.Date(2021, 3, 5, 12, 0, 0, 0, time.UTC), 1},
+		{time.Date(2021, 3, 5, 12, 0, 0, 0, time.UTC), 0},
+	}
+	for _, tt := range tests {
+		if n, err := l.Prune(tt.now); err != nil || n != tt.deleted {
+			t.Errorf("Prune(%v) = %d, %v, want %d", tt.now, n, err, tt.deleted)
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	sort.Strings(files)
+	want := []string{filepath.Join(dir, "audit-2021-03-03.jsonl"), filepath.Join(dir, "audit-x.jsonl"), filepath.Join(dir, "notes.txt")}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("files %q, want %q", files, want)
+	}
+}
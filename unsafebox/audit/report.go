@@ -0,0 +1,147 @@
+package audit
+
+import (
+	"sort"
+	"time"
+)
+
+// ClientStats summarizes the runs of a client.
+type ClientStats struct {
+	Client   string
+	Runs     int
+	Failures int // runs that failed, did not build or were rejected
+	Limits   int // runs that hit a limit
+	Blocked  int // runs that tried to reach a blocked destination
+	LastSeen time.Time
+}
+
+// ProgramStats summarizes the runs of a program.
+type ProgramStats struct {
+	SourceHash string
+	Runs       int
+	Clients    int
+	// Outcomes counts the runs by outcome.
+	Outcomes map[string]int
+	// Limits counts the runs by the limits they hit, and the blocked
+	// destinations by the number of runs that tried to reach them.
+	Limits   map[string]int
+	Blocked  map[string]int
+	LastSeen time.Time
+
+	clients map[string]bool
+}
+
+func (p *ProgramStats) add(rec *Record) {
+	p.Runs++
+	p.Outcomes[rec.Outcome]++
+	for _, limit := range rec.Limits {
+		p.Limits[limit]++
+	}
+	for _, dest := range rec.Blocked {
+		p.Blocked[dest]++
+	}
+	if !p.clients[rec.Client] {
+		p.clients[rec.Client] = true
+		p.Clients++
+	}
+	if rec.Time.After(p.LastSeen) {
+		p.LastSeen = rec.Time
+	}
+}
+
+func failed(rec *Record) bool {
+	switch rec.Outcome {
+	case OutcomeFailed, OutcomeBuildError, OutcomeRejected:
+		return true
+	}
+	return false
+}
+
+// TopClients returns the n clients with the most runs.
+func TopClients(records []*Record, n int) []ClientStats {
+	byClient := make(map[string]*ClientStats)
+	for _, rec := range records {
+		c := byClient[rec.Client]
+		if c == nil {
+			c = &ClientStats{Client: rec.Client}
+			byClient[rec.Client] = c
+		}
+		c.Runs++
+		if failed(rec) {
+			c.Failures++
+		}
+		if len(rec.Limits) > 0 {
+			c.Limits++
+		}
+		if len(rec.Blocked) > 0 {
+			c.Blocked++
+		}
+		if rec.Time.After(c.LastSeen) {
+			c.LastSeen = rec.Time
+		}
+	}
+
+	stats := make([]ClientStats, 0, len(byClient))
+	for _, c := range byClient {
+		stats = append(stats, *c)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Runs != stats[j].Runs {
+			return stats[i].Runs > stats[j].Runs
+		}
+		return stats[i].Client < stats[j].Client
+	})
+	if n > 0 && len(stats) > n {
+		stats = stats[:n]
+	}
+	return stats
+}
+
+// FailingPrograms returns the programs that failed at least min times, the
+// ones that failed most often first.
+func FailingPrograms(records []*Record, min int) []ProgramStats {
+	return programs(records, failed, min)
+}
+
+// LimitPrograms returns the programs that hit a limit or tried to reach a
+// blocked destination at least min times, the most frequent first.
+func LimitPrograms(records []*Record, min int) []ProgramStats {
+	return programs(records, func(rec *Record) bool {
+		return len(rec.Limits) > 0 || len(rec.Blocked) > 0
+	}, min)
+}
+
+func programs(records []*Record, match func(*Record) bool, min int) []ProgramStats {
+	byHash := make(map[string]*ProgramStats)
+	for _, rec := range records {
+		if !match(rec) {
+			continue
+		}
+		p := byHash[rec.SourceHash]
+		if p == nil {
+			p = &ProgramStats{
+				SourceHash: rec.SourceHash,
+				Outcomes:   make(map[string]int),
+				Limits:     make(map[string]int),
+				Blocked:    make(map[string]int),
+				clients:    make(map[string]bool),
+			}
+			byHash[rec.SourceHash] = p
+		}
+		p.add(rec)
+	}
+
+	var stats []ProgramStats
+	for _, p := range byHash {
+		if p.Runs >= min {
+			stats = append(stats, *p)
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Runs != stats[j].Runs {
+			return stats[i].Runs > stats[j].Runs
+		}
+		return stats[i].SourceHash < stats[j].SourceHash
+	})
+	return stats
+}
@@ -0,0 +1,81 @@
+package audit
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func records() []*Record {
+	at := func(h int) time.Time { return time.Date(2021, 3, 4, h, 0, 0, 0, time.UTC) }
+	return []*Record{
+		{Time: at(1), Client: "a", SourceHash: "x", Outcome: OutcomeOK},
+		{Time: at(2), Client: "a", SourceHash: "x", Outcome: OutcomeFailed, Limits: []string{"memory"}},
+		{Time: at(3), Client: "b", SourceHash: "x", Outcome: OutcomeBuildError},
+		{Time: at(4), Client: "b", SourceHash: "y", Outcome: OutcomeRejected},
+		{Time: at(5), Client: "c", SourceHash: "y", Outcome: OutcomeOK, Blocked: []string{"198.51.100.1:22", "198.51.100.2:22"}},
+		{Time: at(6), Client: "a", SourceHash: "z", Outcome: OutcomeFailed, Limits: []string{"time"}, Blocked: []string{"198.51.100.1:22"}},
+		{Time: at(0), Client: "c", SourceHash: "z", Outcome: OutcomeBusy},
+		{Time: at(7), Client: "d", SourceHash: "z", Outcome: OutcomeCanceled},
+	}
+}
+
+func TestTopClients(t *testing.T) {
+	at := func(h int) time.Time { return time.Date(2021, 3, 4, h, 0, 0, 0, time.UTC) }
+	all := []ClientStats{
+		{Client: "a", Runs: 3, Failures: 2, Limits: 2, Blocked: 1, LastSeen: at(6)},
+		{Client: "b", Runs: 2, Failures: 2, LastSeen: at(4)},
+		{Client: "c", Runs: 2, Blocked: 1, LastSeen: at(5)},
+		{Client: "d", Runs: 1, LastSeen: at(7)},
+	}
+	for _, n := range []int{0, 2, 10} {
+		want := all
+		if n > 0 && n < len(all) {
+			want = all[:n]
+		}
+		if got := TopClients(records(), n); !reflect.DeepEqual(got, want) {
+			t.Errorf("TopClients(%d) = %+v, want %+v", n, got, want)
+		}
+	}
+}
+
+func TestPrograms(t *testing.T) {
+	summary := func(stats []ProgramStats) map[string][4]int {
+		m := make(map[string][4]int)
+		for _, p := range stats {
+			m[p.SourceHash] = [4]int{p.Runs, p.Clients, len(p.Limits), len(p.Blocked)}
+		}
+		return m
+	}
+	order := func(stats []ProgramStats) []string {
+		var hashes []string
+		for _, p := range stats {
+			hashes = append(hashes, p.SourceHash)
+		}
+		return hashes
+	}
+
+	failing := FailingPrograms(records(), 1)
+	if got, want := order(failing), []string{"x", "y", "z"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("FailingPrograms order %q, want %q", got, want)
+	}
+	if got, want := summary(failing), map[string][4]int{"x": {2, 2, 1, 0}, "y": {1, 1, 0, 0}, "z": {1, 1, 1, 1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("FailingPrograms = %v, want %v", got, want)
+	}
+	if got := failing[0].Outcomes; !reflect.DeepEqual(got, map[string]int{OutcomeFailed: 1, OutcomeBuildError: 1}) {
+		t.Errorf("outcomes of x %v", got)
+	}
+	if got := order(FailingPrograms(records(), 2)); !reflect.DeepEqual(got, []string{"x"}) {
+		t.Errorf("FailingPrograms with 2 failures = %q, want [x]", got)
+	}
+
+	limits := LimitPrograms(records(), 1)
+	if got, want := summary(limits), map[string][4]int{"x": {1, 1, 1, 0}, "y": {1, 1, 0, 2}, "z": {1, 1, 1, 1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LimitPrograms = %v, want %v", got, want)
+	}
+	for _, p := range limits {
+		if p.SourceHash == "z" && !reflect.DeepEqual(p.Blocked, map[string]int{"198.51.100.1:22": 1}) {
+			t.Errorf("blocked destinations of z %v", p.Blocked)
+		}
+	}
+}
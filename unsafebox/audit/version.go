@@ -0,0 +1,149 @@
+package audit
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/upper/upper.io/unsafebox/txtar"
+)
+
+// Versions maps the module paths of upper/db, like upper.io/db.v3, to the
+// version installed in the GOPATH of the sandbox.
+type Versions map[string]string
+
+// ParseVersions parses a comma separated list of module@version pairs.
+func ParseVersions(s string) (Versions, error) {
+	v := make(Versions)
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		i := strings.LastIndexByte(field, '@')
+		if i <= 0 || i == len(field)-1 {
+			return nil, fmt.Errorf("invalid module version %q", field)
+		}
+		v[field[:i]] = field[i+1:]
+	}
+	return v, nil
+}
+
+// Resolve returns the upper/db modules imported by a program, with the
+// versions they resolve to: the one required by the go.mod of the program,
+// if it has one, or the one installed in GOPATH. Modules without a known
+// version are returned without one.
+func (v Versions) Resolve(files []txtar.File) []string {
+	var gomod []byte
+	imported := make(map[string]bool)
+	fset := token.NewFileSet()
+	for _, file := range files {
+		if file.Name == "go.mod" {
+			gomod = file.Data
+			continue
+		}
+		if path.Ext(file.Name) != ".go" {
+			continue
+		}
+		f, err := parser.ParseFile(fset, file.Name, file.Data, parser.ImportsOnly)
+		if err != nil {
+			continue
+		}
+		for _, spec := range f.Imports {
+			importPath, err := strconv.Unquote(spec.Path.Value)
+			if err != nil {
+				continue
+			}
+			if mod := v.module(importPath); mod != "" {
+				imported[mod] = true
+			}
+		}
+	}
+
+	var required map[string]string
+	if gomod != nil {
+		required = requirements(gomod)
+	}
+
+	var resolved []string
+	for mod := range imported {
+		version := v[mod]
+		if gomod != nil {
+			version = required[mod]
+		}
+		if version == "" {
+			resolved = append(resolved, mod)
+			continue
+		}
+		resolved = append(resolved, mod+"@"+version)
+	}
+	sort.Strings(resolved)
+	return resolved
+}
+
+// module returns the upper/db module that provides importPath, if any.
+func (v Versions) module(importPath string) string {
+	if mod := dbModule(importPath); mod != "" {
+		return mod
+	}
+	for mod := range v {
+		if importPath == mod || strings.HasPrefix(importPath, mod+"/") {
+			return mod
+		}
+	}
+	return ""
+}
+
+// dbModule returns the module path of the version of upper/db importPath
+// belongs to, like upper.io/db.v3 or github.com/upper/db/v4.
+func dbModule(importPath string) string {
+	for _, prefix := range []string{"upper.io/db.v", "github.com/upper/db/v"} {
+		if !strings.HasPrefix(importPath, prefix) {
+			continue
+		}
+		rest := importPath[len(prefix):]
+		n := 0
+		for n < len(rest) && rest[n] >= '0' && rest[n] <= '9' {
+			n++
+		}
+		if n == 0 || (n < len(rest) && rest[n] != '/') {
+			return ""
+		}
+		return prefix + rest[:n]
+	}
+	return ""
+}
+
+// requirements returns the versions required by a go.mod file.
+func requirements(gomod []byte) map[string]string {
+	required := make(map[string]string)
+	inBlock := false
+	for _, line := range strings.Split(string(gomod), "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+			continue
+		case inBlock && fields[0] == ")":
+			inBlock = false
+			continue
+		case fields[0] == "require" && len(fields) == 2 && fields[1] == "(":
+			inBlock = true
+			continue
+		case fields[0] == "require":
+			fields = fields[1:]
+		case !inBlock:
+			continue
+		}
+		if len(fields) >= 2 {
+			required[strings.Trim(fields[0], `"`)] = fields[1]
+		}
+	}
+	return required
+}
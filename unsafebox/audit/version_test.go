@@ -0,0 +1,103 @@
+package audit
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/upper/upper.io/unsafebox/txtar"
+)
+
+func TestParseVersions(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Versions
+		ok   bool
+	}{
+		{"", Versions{}, true},
+		{
+			"upper.io/db.v3@v3.8.0, github.com/upper/db/v4@v4.5.0 ,",
+			Versions{"upper.io/db.v3": "v3.8.0", "github.com/upper/db/v4": "v4.5.0"},
+			true,
+		},
+		{"upper.io/db.v3", nil, false},
+		{"@v3.8.0", nil, false},
+		{"upper.io/db.v3@", nil, false},
+	}
+	for _, tt := range tests {
+		v, err := ParseVersions(tt.s)
+		if (err == nil) != tt.ok || !reflect.DeepEqual(v, tt.want) {
+			t.Errorf("ParseVersions(%q) = %v, %v, want %v", tt.s, v, err, tt.want)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	v := Versions{
+		"upper.io/db.v3":         "v3.8.0",
+		"github.com/upper/db/v4": "v4.5.0",
+		"github.com/lib/pq":      "v1.10.4",
+	}
+
+	tests := []struct {
+		name  string
+		files []txtar.File
+		want  []string
+	}{
+		{
+			"GOPATH",
+			[]txtar.File{{Name: "prog.go", Data: []byte(`package main
+import (
+	"fmt"
+	"upper.io/db.v3/postgresql"
+	db "github.com/upper/db/v4"
+	_ "github.com/lib/pq"
+)`)}},
+			[]string{"github.com/lib/pq@v1.10.4", "github.com/upper/db/v4@v4.5.0", "upper.io/db.v3@v3.8.0"},
+		},
+		{
+			// Versions of upper/db that are not installed are still listed.
+			"unknown version",
+			[]txtar.File{
+				{Name: "prog.go", Data: []byte(`package main; import "upper.io/db.v2"`)},
+				{Name: "b.go", Data: []byte(`package main; import "upper.io/db.v3/lib/sqlbuilder"`)},
+			},
+			[]string{"upper.io/db.v2", "upper.io/db.v3@v3.8.0"},
+		},
+		{
+			"go.mod",
+			[]txtar.File{
+				{Name: "prog.go", Data: []byte(`package main; import ("upper.io/db.v3"; "github.com/upper/db/v4")`)},
+				{Name: "go.mod", Data: []byte(`module example
+
+require github.com/upper/db/v4 v4.2.1 // indirect
+require (
+	"upper.io/db.v3" v3.7.0+incompatible
+)
+`)},
+			},
+			[]string{"github.com/upper/db/v4@v4.2.1", "upper.io/db.v3@v3.7.0+incompatible"},
+		},
+		{
+			"go.mod without the module",
+			[]txtar.File{
+				{Name: "prog.go", Data: []byte(`package main; import "upper.io/db.v3"`)},
+				{Name: "go.mod", Data: []byte("module example\n")},
+			},
+			[]string{"upper.io/db.v3"},
+		},
+		{
+			"not upper/db",
+			[]txtar.File{
+				{Name: "prog.go", Data: []byte(`package main; import ("upper.io/db.vx"; "upper.io/db.v3x"; "github.com/upper/db")`)},
+				{Name: "notes.txt", Data: []byte(`import "upper.io/db.v3"`)},
+				{Name: "broken.go", Data: []byte(`import "upper.io/db.v3"`)},
+			},
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		if got := v.Resolve(tt.files); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Resolve = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
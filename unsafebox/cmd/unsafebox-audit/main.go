@@ -0,0 +1,104 @@
+// Command unsafebox-audit reports on the audit log of the compile service.
+//
+// Usage:
+//
+//	unsafebox-audit [flags] clients    clients with the most runs
+//	unsafebox-audit [flags] failing    programs that fail again and again
+//	unsafebox-audit [flags] limits     programs that hit limits or blocked egress
+//	unsafebox-audit [flags] prune      delete records past the retention period
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/upper/upper.io/unsafebox/audit"
+)
+
+var (
+	flagDir       = flag.String("dir", "/data/audit", "directory of the audit log")
+	flagSince     = flag.Duration("since", 7*24*time.Hour, "how far back to look")
+	flagTop       = flag.Int("n", 20, "number of clients to list")
+	flagMin       = flag.Int("min", 2, "minimum number of runs for a program to be listed")
+	flagRetention = flag.Duration("retention", 90*24*time.Hour, "how long records are kept, for prune")
+)
+
+func main() {
+	log.SetFlags(0)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: unsafebox-audit [flags] clients|failing|limits|prune\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.Arg(0) == "prune" {
+		l, err := audit.Open(*flagDir, audit.Config{Retention: *flagRetention})
+		if err != nil {
+			log.Fatal(err)
+		}
+		n, err := l.Prune(time.Now())
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("deleted %d files", n)
+		return
+	}
+
+	var records []*audit.Record
+	err := audit.Read(*flagDir, time.Now().Add(-*flagSince), func(rec *audit.Record) error {
+		records = append(records, rec)
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	switch flag.Arg(0) {
+	case "clients":
+		fmt.Fprintln(w, "CLIENT\tRUNS\tFAILURES\tLIMITS\tBLOCKED\tLAST SEEN")
+		for _, c := range audit.TopClients(records, *flagTop) {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\n",
+				c.Client, c.Runs, c.Failures, c.Limits, c.Blocked, c.LastSeen.Format(time.RFC3339))
+		}
+	case "failing":
+		fmt.Fprintln(w, "SOURCE\tRUNS\tCLIENTS\tOUTCOMES\tLAST SEEN")
+		for _, p := range audit.FailingPrograms(records, *flagMin) {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\n",
+				p.SourceHash[:12], p.Runs, p.Clients, counts(p.Outcomes), p.LastSeen.Format(time.RFC3339))
+		}
+	case "limits":
+		fmt.Fprintln(w, "SOURCE\tRUNS\tCLIENTS\tLIMITS\tBLOCKED\tLAST SEEN")
+		for _, p := range audit.LimitPrograms(records, *flagMin) {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\n",
+				p.SourceHash[:12], p.Runs, p.Clients, counts(p.Limits), counts(p.Blocked), p.LastSeen.Format(time.RFC3339))
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	w.Flush()
+}
+
+// counts formats a map of counts like build-error=3,failed=1.
+func counts(m map[string]int) string {
+	if len(m) == 0 {
+		return "-"
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	s := make([]string, len(keys))
+	for i, k := range keys {
+		s[i] = fmt.Sprintf("%s=%d", k, m[k])
+	}
+	return strings.Join(s, ",")
+}
@@ -0,0 +1,210 @@
+// Command unsafebox is the compile service behind demo.upper.io. It builds
+// and runs the programs submitted by the tour and the playground inside the
+// chroot prepared by entrypoint.sh.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/upper/upper.io/unsafebox/analysis"
+	"github.com/upper/upper.io/unsafebox/audit"
+	"github.com/upper/upper.io/unsafebox/egress"
+	"github.com/upper/upper.io/unsafebox/health"
+	"github.com/upper/upper.io/unsafebox/plans"
+	"github.com/upper/upper.io/unsafebox/sandbox"
+	"github.com/upper/upper.io/unsafebox/scheduler"
+	"github.com/upper/upper.io/unsafebox/server"
+)
+
+var (
+	flagAddr           = flag.String("addr", ":8080", "listen address")
+	flagRoot           = flag.String("root", "/home/unsafebox/c", "chroot directory")
+	flagUser           = flag.String("user", "unsafebox", "user to run programs as")
+	flagWorkers        = flag.Int("workers", 2, "number of programs that may run at the same time")
+	flagMaxQueue       = flag.Int("max-queue", 32, "maximum number of queued programs")
+	flagMaxClientQueue = flag.Int("max-client-queue", 2, "maximum number of queued programs per client")
+	flagBuildTimeout   = flag.Duration("build-timeout", 30*time.Second, "maximum build time")
+	flagRunTimeout     = flag.Duration("run-timeout", 10*time.Second, "maximum run time")
+	flagAllow          = flag.String("allow", "demo.upper.io:5432,cockroachdb.demo.upper.io:26257", "comma separated list of host:port[=relay-address] pairs programs may connect to")
+	flagEgressProxy    = flag.String("egress-proxy", "127.0.0.1:9900", "address the connections of programs are redirected to")
+	flagEgressDNS      = flag.String("egress-dns", "127.0.0.1:53", "address the DNS queries of programs are sent to")
+	flagExplain        = flag.String("explain-endpoints", "", "comma separated list of the plan endpoints of the SQL proxies, explain mode is disabled if empty")
+	flagPolicy         = flag.String("policy", "", "JSON file with the policy programs are screened with before they run, screening is disabled if empty")
+	flagReadyTests     = flag.String("readiness-tests", "/home/unsafebox/_tests.no-modules", "directory with a program per version of upper/db that /readyz builds, builds are not checked if empty")
+	flagReadyTTL       = flag.Duration("readiness-ttl", 30*time.Second, "how long the result of the readiness checks is reused")
+	flagAuditDir       = flag.String("audit-dir", "", "directory of the audit log, runs are not recorded if empty")
+	flagAuditRetention = flag.Duration("audit-retention", 90*24*time.Hour, "how long audit records are kept, zero keeps them forever")
+	flagDBVersions     = flag.String("db-versions", "", "comma separated list of the module@version pairs of upper/db installed in GOPATH, for the audit log")
+	flagPoolSize       = flag.Int("pool-size", 0, "number of run environments kept ready, programs run in the chroot at -root if zero")
+	flagPoolDir        = flag.String("pool-dir", "/home/unsafebox/pool", "directory the run environments are created in")
+	flagPoolUID        = flag.Uint("pool-uid", 2000, "user ID of the first run environment, each one runs programs as the next user ID")
+	flagPoolTmpfs      = flag.String("pool-tmpfs-size", "256m", "size of the /tmp of each run environment")
+	flagPoolMounts     = flag.String("pool-mounts", "/usr/local/go,/usr/local/toolchains,/go,/dev,/lib,/lib64", "comma separated list of the directories mounted read-only in each run environment")
+	flagToolchains     = flag.String("toolchains", "/usr/local/toolchains", "directory inside the chroot with the Go toolchains programs may ask for, besides the one at /usr/local/go")
+)
+
+func main() {
+	flag.Parse()
+
+	uid, gid, err := lookupUser(*flagUser)
+	if err != nil {
+		log.Fatalf("lookup user %q: %v", *flagUser, err)
+	}
+
+	allow, err := egress.ParseAllowlist(*flagAllow)
+	if err != nil {
+		log.Fatal(err)
+	}
+	monitor := egress.NewMonitor(allow)
+
+	proxyListener, err := net.Listen("tcp", *flagEgressProxy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		log.Fatal(monitor.ServeProxy(proxyListener))
+	}()
+
+	dnsConn, err := net.ListenPacket("udp", *flagEgressDNS)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		log.Fatal(monitor.ServeDNS(dnsConn))
+	}()
+
+	toolchains, err := sandbox.FindToolchains(*flagRoot, *flagToolchains)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Go toolchains: %s (default %s)", strings.Join(toolchains.Versions(), ", "), toolchains.Default().Version)
+
+	runner := &sandbox.Chroot{
+		Root:         *flagRoot,
+		UID:          uid,
+		GID:          gid,
+		BuildTimeout: *flagBuildTimeout,
+		RunTimeout:   *flagRunTimeout,
+		Tracker:      monitor,
+		Toolchains:   toolchains,
+	}
+	var pool *sandbox.Pool
+	if *flagPoolSize > 0 {
+		pool, err = sandbox.NewPool(sandbox.PoolConfig{
+			Dir:       *flagPoolDir,
+			Size:      *flagPoolSize,
+			UID:       uint32(*flagPoolUID),
+			GID:       gid,
+			Mounts:    strings.Split(*flagPoolMounts, ","),
+			Etc:       filepath.Join(*flagRoot, "etc"),
+			Cache:     filepath.Join(*flagRoot, "tmp/.gocache"),
+			TmpfsSize: *flagPoolTmpfs,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer pool.Close()
+		runner.Pool = pool
+		log.Printf("%d run environments ready in %s", *flagPoolSize, *flagPoolDir)
+	}
+	if *flagExplain != "" {
+		client, err := plans.NewClient(*flagExplain)
+		if err != nil {
+			log.Fatal(err)
+		}
+		runner.Plans = client
+	}
+
+	sched := scheduler.New(scheduler.Config{
+		Workers:        *flagWorkers,
+		MaxQueue:       *flagMaxQueue,
+		MaxClientQueue: *flagMaxClientQueue,
+	})
+
+	var policy *analysis.Policy
+	if *flagPolicy != "" {
+		if policy, err = analysis.LoadPolicy(*flagPolicy); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	checks := health.DialChecks(allow)
+	if *flagReadyTests != "" {
+		builds, err := health.BuildChecks(runner, *flagReadyTests)
+		if err != nil {
+			log.Fatal(err)
+		}
+		checks = append(builds, checks...)
+	}
+	checker := health.NewChecker(*flagReadyTTL, *flagBuildTimeout+*flagRunTimeout, checks...)
+
+	var auditLog *audit.Log
+	if *flagAuditDir != "" {
+		versions, err := audit.ParseVersions(*flagDBVersions)
+		if err != nil {
+			log.Fatal(err)
+		}
+		auditLog, err = audit.Open(*flagAuditDir, audit.Config{
+			Retention: *flagAuditRetention,
+			Versions:  versions,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer auditLog.Close()
+	}
+
+	srv := &http.Server{
+		Addr: *flagAddr,
+		Handler: server.New(runner, sched, server.Config{
+			Policy: policy,
+			Health: checker,
+			Audit:  auditLog,
+			Pool:   pool,
+		}),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), *flagRunTimeout+*flagBuildTimeout)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("listening on %s (%d workers)", *flagAddr, *flagWorkers)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	sched.Close()
+}
+
+func lookupUser(name string) (uint32, uint32, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint32(uid), uint32(gid), nil
+}
@@ -0,0 +1,182 @@
+// Package egress restricts the network access of sandboxed programs.
+//
+// Sandboxed programs cannot reach the network directly: entrypoint.sh
+// redirects their TCP connections and DNS queries to a Monitor, which only
+// resolves and relays destinations in the allowlist. Blocked attempts are
+// logged and reported back to the run that made them.
+package egress
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// resolveTTL is how long the addresses of allowlisted hosts are cached.
+const resolveTTL = time.Minute
+
+// Allowlist is a list of host:port pairs sandboxed programs may connect to.
+// An entry may be followed by =address to relay its connections to a
+// different address, such as a proxy in front of a database.
+type Allowlist struct {
+	entries []entry
+
+	mu    sync.Mutex
+	cache map[string]resolved
+}
+
+type entry struct {
+	host string
+	port int
+	via  string
+}
+
+type resolved struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+// ParseAllowlist parses a comma separated list of host:port pairs, each
+// optionally followed by =address.
+func ParseAllowlist(s string) (*Allowlist, error) {
+	a := &Allowlist{cache: make(map[string]resolved)}
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		var via string
+		if i := strings.IndexByte(field, '='); i >= 0 {
+			field, via = field[:i], field[i+1:]
+			if _, _, err := net.SplitHostPort(via); err != nil {
+				return nil, fmt.Errorf("invalid relay address %q: %w", via, err)
+			}
+		}
+		host, port, err := net.SplitHostPort(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowlist entry %q: %w", field, err)
+		}
+		n, err := strconv.Atoi(port)
+		if err != nil || n < 1 || n > 65535 {
+			return nil, fmt.Errorf("invalid port in allowlist entry %q", field)
+		}
+		a.entries = append(a.entries, entry{host: normalizeHost(host), port: n, via: via})
+	}
+	return a, nil
+}
+
+// String returns the host:port pairs in the allowlist.
+func (a *Allowlist) String() string {
+	return strings.Join(a.Addrs(), ", ")
+}
+
+// Addrs returns the host:port pairs in the allowlist.
+func (a *Allowlist) Addrs() []string {
+	addrs := make([]string, 0, len(a.entries))
+	for _, e := range a.entries {
+		addrs = append(addrs, net.JoinHostPort(e.host, strconv.Itoa(e.port)))
+	}
+	return addrs
+}
+
+// AllowsHost reports whether host appears in the allowlist with any port.
+func (a *Allowlist) AllowsHost(host string) bool {
+	host = normalizeHost(host)
+	for _, e := range a.entries {
+		if e.host == host {
+			return true
+		}
+	}
+	return false
+}
+
+// Route returns the address a connection to addr must be relayed to, and
+// false if the connection is not allowed. The address is allowed if its port
+// matches an entry and its IP is one of the addresses of the entry's host.
+func (a *Allowlist) Route(ctx context.Context, addr *net.TCPAddr) (string, bool) {
+	for _, e := range a.entries {
+		if e.port != addr.Port {
+			continue
+		}
+		ips, err := a.lookup(ctx, e.host)
+		if err != nil {
+			continue
+		}
+		for _, ip := range ips {
+			if !ip.Equal(addr.IP) {
+				continue
+			}
+			if e.via != "" {
+				return e.via, true
+			}
+			return addr.String(), true
+		}
+	}
+	return "", false
+}
+
+// Dial connects to addr, a host:port pair, the way a sandboxed program would:
+// the host is resolved and the connection routed through the allowlist.
+func (a *Allowlist) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in %q", addr)
+	}
+	ips, err := a.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("host %q has no IPv4 addresses", host)
+	}
+	target, ok := a.Route(ctx, &net.TCPAddr{IP: ips[0], Port: n})
+	if !ok {
+		return nil, fmt.Errorf("%s is not allowed", addr)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", target)
+}
+
+// LookupHost returns the IPv4 addresses of an allowlisted host.
+func (a *Allowlist) LookupHost(ctx context.Context, host string) ([]net.IP, error) {
+	if !a.AllowsHost(host) {
+		return nil, fmt.Errorf("host %q is not allowed", host)
+	}
+	return a.lookup(ctx, normalizeHost(host))
+}
+
+func (a *Allowlist) lookup(ctx context.Context, host string) ([]net.IP, error) {
+	a.mu.Lock()
+	r, ok := a.cache[host]
+	a.mu.Unlock()
+	if ok && time.Now().Before(r.expires) {
+		return r.ips, nil
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip4", host)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	a.cache[host] = resolved{ips: ips, expires: time.Now().Add(resolveTTL)}
+	a.mu.Unlock()
+
+	return ips, nil
+}
+
+func normalizeHost(host string) string {
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
@@ -0,0 +1,142 @@
+package egress
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"log"
+	"net"
+	"strings"
+)
+
+// DNS message constants, see RFC 1035.
+const (
+	dnsHeaderLen = 12
+
+	dnsTypeA    = 1
+	dnsClassIN  = 1
+	dnsRcodeOK  = 0
+	dnsRcodeNX  = 3
+	dnsRcodeErr = 2
+
+	dnsAnswerTTL = 60
+)
+
+var errMalformedQuery = errors.New("malformed DNS query")
+
+// ServeDNS answers the DNS queries of sandboxed programs. Allowlisted hosts
+// resolve to their IPv4 addresses, every other name is answered with
+// NXDOMAIN so that programs fail right away instead of timing out.
+func (m *Monitor) ServeDNS(pc net.PacketConn) error {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		query := make([]byte, n)
+		copy(query, buf[:n])
+		go func() {
+			resp, err := m.answer(query, addr)
+			if err != nil {
+				log.Printf("egress: dns: %v", err)
+				return
+			}
+			_, _ = pc.WriteTo(resp, addr)
+		}()
+	}
+}
+
+func (m *Monitor) answer(query []byte, src net.Addr) ([]byte, error) {
+	if len(query) < dnsHeaderLen {
+		return nil, errMalformedQuery
+	}
+	if binary.BigEndian.Uint16(query[4:6]) != 1 {
+		// Only one question per query is supported, as in every resolver
+		// that matters.
+		return nil, errMalformedQuery
+	}
+	name, end, err := parseName(query, dnsHeaderLen)
+	if err != nil {
+		return nil, err
+	}
+	if len(query) < end+4 {
+		return nil, errMalformedQuery
+	}
+	qtype := binary.BigEndian.Uint16(query[end : end+2])
+	question := query[dnsHeaderLen : end+4]
+
+	if !m.allow.AllowsHost(name) {
+		m.blocked("udp", src, name)
+		return dnsResponse(query, question, dnsRcodeNX, nil), nil
+	}
+
+	// Allowlisted hosts have no IPv6 addresses as far as the sandbox is
+	// concerned, so other query types get an empty answer.
+	if qtype != dnsTypeA {
+		return dnsResponse(query, question, dnsRcodeOK, nil), nil
+	}
+
+	ips, err := m.allow.LookupHost(context.Background(), name)
+	if err != nil {
+		log.Printf("egress: dns: lookup %s: %v", name, err)
+		return dnsResponse(query, question, dnsRcodeErr, nil), nil
+	}
+	return dnsResponse(query, question, dnsRcodeOK, ips), nil
+}
+
+// dnsResponse builds the response to query with the given IPv4 addresses as
+// answers.
+func dnsResponse(query, question []byte, rcode int, ips []net.IP) []byte {
+	resp := make([]byte, dnsHeaderLen, dnsHeaderLen+len(question)+16*len(ips))
+
+	copy(resp[0:2], query[0:2]) // ID
+	// QR, same opcode and RD as the query, RA.
+	resp[2] = 0x80 | query[2]&0x79
+	resp[3] = 0x80 | byte(rcode)
+	binary.BigEndian.PutUint16(resp[4:6], 1)
+
+	resp = append(resp, question...)
+
+	var count uint16
+	for _, ip := range ips {
+		ip4 := ip.To4()
+		if ip4 == nil {
+			continue
+		}
+		var rr [12]byte
+		binary.BigEndian.PutUint16(rr[0:2], 0xc000|dnsHeaderLen) // pointer to the question name
+		binary.BigEndian.PutUint16(rr[2:4], dnsTypeA)
+		binary.BigEndian.PutUint16(rr[4:6], dnsClassIN)
+		binary.BigEndian.PutUint32(rr[6:10], dnsAnswerTTL)
+		binary.BigEndian.PutUint16(rr[10:12], 4)
+		resp = append(resp, rr[:]...)
+		resp = append(resp, ip4...)
+		count++
+	}
+	binary.BigEndian.PutUint16(resp[6:8], count)
+
+	return resp
+}
+
+// parseName reads the uncompressed name that starts at off in msg. It returns
+// the name and the offset right after it.
+func parseName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	for {
+		if off >= len(msg) {
+			return "", 0, errMalformedQuery
+		}
+		n := int(msg[off])
+		off++
+		if n == 0 {
+			break
+		}
+		if n > 63 || off+n > len(msg) {
+			return "", 0, errMalformedQuery
+		}
+		labels = append(labels, string(msg[off:off+n]))
+		off += n
+	}
+	return strings.Join(labels, "."), off, nil
+}
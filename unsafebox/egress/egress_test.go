@@ -0,0 +1,283 @@
+package egress
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newAllowlist parses an allowlist whose hosts resolve to the given addresses
+// without asking any resolver.
+func newAllowlist(t *testing.T, s string, hosts map[string][]string) *Allowlist {
+	t.Helper()
+	a, err := ParseAllowlist(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for host, addrs := range hosts {
+		var ips []net.IP
+		for _, addr := range addrs {
+			ips = append(ips, net.ParseIP(addr))
+		}
+		a.cache[host] = resolved{ips: ips, expires: time.Now().Add(time.Hour)}
+	}
+	return a
+}
+
+func TestParseAllowlist(t *testing.T) {
+	tests := []struct {
+		s     string
+		addrs []string
+		err   string
+	}{
+		{"", []string{}, ""},
+		{"demo.upper.io:5432", []string{"demo.upper.io:5432"}, ""},
+		{" Demo.Upper.IO.:5432 , ,cockroachdb.demo.upper.io:26257=upper-sqlproxy-cockroachdb:26257", []string{"demo.upper.io:5432", "cockroachdb.demo.upper.io:26257"}, ""},
+		{"[2001:db8::1]:5432", []string{"[2001:db8::1]:5432"}, ""},
+		{"demo.upper.io", nil, "invalid allowlist entry"},
+		{"demo.upper.io:0", nil, "invalid port"},
+		{"demo.upper.io:65536", nil, "invalid port"},
+		{"demo.upper.io:pg", nil, "invalid port"},
+		{"demo.upper.io:5432=upper-sqlproxy", nil, "invalid relay address"},
+	}
+	for _, tt := range tests {
+		a, err := ParseAllowlist(tt.s)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%q: error %v, want one with %q", tt.s, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.s, err)
+			continue
+		}
+		if got := a.Addrs(); !reflect.DeepEqual(got, tt.addrs) {
+			t.Errorf("%q: Addrs() = %q, want %q", tt.s, got, tt.addrs)
+		}
+	}
+}
+
+func TestRoute(t *testing.T) {
+	a := newAllowlist(t,
+		"demo.upper.io:5432=upper-sqlproxy-postgresql:5432,cockroachdb.demo.upper.io:26257,192.0.2.50:8080",
+		map[string][]string{
+			"demo.upper.io":             {"192.0.2.10", "192.0.2.11"},
+			"cockroachdb.demo.upper.io": {"192.0.2.20"},
+		})
+
+	tests := []struct {
+		addr string
+		want string // empty if not allowed
+	}{
+		{"192.0.2.10:5432", "upper-sqlproxy-postgresql:5432"},
+		{"192.0.2.11:5432", "upper-sqlproxy-postgresql:5432"},
+		{"192.0.2.20:26257", "192.0.2.20:26257"},
+		{"192.0.2.50:8080", "192.0.2.50:8080"},
+		// Allowed hosts on other ports, and ports of other hosts.
+		{"192.0.2.10:22", ""},
+		{"192.0.2.20:5432", ""},
+		{"192.0.2.10:26257", ""},
+		{"192.0.2.99:5432", ""},
+		{"127.0.0.1:5432", ""},
+	}
+	for _, tt := range tests {
+		addr, err := net.ResolveTCPAddr("tcp", tt.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := a.Route(context.Background(), addr)
+		if ok != (tt.want != "") || got != tt.want {
+			t.Errorf("Route(%s) = %q, %v, want %q", tt.addr, got, ok, tt.want)
+		}
+	}
+
+	for host, want := range map[string]bool{
+		"demo.upper.io":    true,
+		"DEMO.upper.io.":   true,
+		"upper.io":         false,
+		"xdemo.upper.io":   false,
+		"demo.upper.io.ev": false,
+	} {
+		if got := a.AllowsHost(host); got != want {
+			t.Errorf("AllowsHost(%q) = %v, want %v", host, got, want)
+		}
+	}
+	if _, err := a.LookupHost(context.Background(), "example.com"); err == nil {
+		t.Error("LookupHost of a host not allowed succeeded")
+	}
+}
+
+// query builds a DNS query for name with the given type.
+func query(id uint16, name string, qtype uint16) []byte {
+	q := make([]byte, dnsHeaderLen)
+	binary.BigEndian.PutUint16(q[0:2], id)
+	q[2] = 0x01 // RD
+	binary.BigEndian.PutUint16(q[4:6], 1)
+	for _, label := range strings.Split(name, ".") {
+		q = append(q, byte(len(label)))
+		q = append(q, label...)
+	}
+	q = append(q, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint16(q[len(q)-4:], qtype)
+	binary.BigEndian.PutUint16(q[len(q)-2:], dnsClassIN)
+	return q
+}
+
+func TestParseName(t *testing.T) {
+	tests := []struct {
+		msg  []byte
+		name string
+		end  int
+		ok   bool
+	}{
+		{[]byte("\x04demo\x05upper\x02io\x00"), "demo.upper.io", 15, true},
+		{[]byte("\x00"), "", 1, true},
+		{[]byte("\x04demo\x05upper\x02io\x00\x00\x01"), "demo.upper.io", 15, true},
+		// Cut short.
+		{[]byte(""), "", 0, false},
+		{[]byte("\x04demo"), "", 0, false},
+		{[]byte("\x04dem"), "", 0, false},
+		// Labels of more than 63 bytes, like compression pointers.
+		{[]byte("\xc0\x0c"), "", 0, false},
+		{append([]byte{64}, make([]byte, 65)...), "", 0, false},
+	}
+	for _, tt := range tests {
+		name, end, err := parseName(tt.msg, 0)
+		if (err == nil) != tt.ok || name != tt.name || end != tt.end {
+			t.Errorf("parseName(%q) = %q, %d, %v, want %q, %d, ok %v", tt.msg, name, end, err, tt.name, tt.end, tt.ok)
+		}
+	}
+}
+
+func TestAnswer(t *testing.T) {
+	m := NewMonitor(newAllowlist(t, "demo.upper.io:5432", map[string][]string{
+		"demo.upper.io": {"192.0.2.10", "2001:db8::10", "192.0.2.11"},
+	}))
+	src := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+
+	tests := []struct {
+		name  string
+		query []byte
+		rcode byte
+		ips   []string
+	}{
+		{"allowed", query(1, "demo.upper.io", dnsTypeA), dnsRcodeOK, []string{"192.0.2.10", "192.0.2.11"}},
+		{"allowed in another case", query(2, "Demo.Upper.io", dnsTypeA), dnsRcodeOK, []string{"192.0.2.10", "192.0.2.11"}},
+		{"AAAA of an allowed host", query(3, "demo.upper.io", 28), dnsRcodeOK, nil},
+		{"not allowed", query(4, "example.com", dnsTypeA), dnsRcodeNX, nil},
+		{"AAAA not allowed", query(5, "example.com", 28), dnsRcodeNX, nil},
+	}
+	for _, tt := range tests {
+		resp, err := m.answer(tt.query, src)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		qlen := len(tt.query)
+		switch {
+		case len(resp) < qlen:
+			t.Errorf("%s: response of %d bytes", tt.name, len(resp))
+			continue
+		case string(resp[0:2]) != string(tt.query[0:2]):
+			t.Errorf("%s: ID %x, want %x", tt.name, resp[0:2], tt.query[0:2])
+		case resp[2] != 0x81:
+			t.Errorf("%s: flags %#x, want QR and RD", tt.name, resp[2])
+		case resp[3]&0x0f != tt.rcode:
+			t.Errorf("%s: rcode %d, want %d", tt.name, resp[3]&0x0f, tt.rcode)
+		case string(resp[dnsHeaderLen:qlen]) != string(tt.query[dnsHeaderLen:]):
+			t.Errorf("%s: question %q, want %q", tt.name, resp[dnsHeaderLen:qlen], tt.query[dnsHeaderLen:])
+		}
+
+		count := int(binary.BigEndian.Uint16(resp[6:8]))
+		var ips []string
+		for rr := resp[qlen:]; len(rr) >= 16; rr = rr[16:] {
+			if binary.BigEndian.Uint16(rr[2:4]) != dnsTypeA || binary.BigEndian.Uint16(rr[10:12]) != 4 {
+				t.Errorf("%s: answer %x is not an A record", tt.name, rr[:16])
+			}
+			ips = append(ips, net.IP(rr[12:16]).String())
+		}
+		if count != len(tt.ips) || !reflect.DeepEqual(ips, tt.ips) {
+			t.Errorf("%s: %d answers %v, want %v", tt.name, count, ips, tt.ips)
+		}
+	}
+
+	malformed := [][]byte{
+		nil,
+		make([]byte, dnsHeaderLen),               // no questions
+		query(1, "demo.upper.io", dnsTypeA)[:20], // cut in the name
+		query(1, "demo.upper.io", dnsTypeA)[:29], // cut in the type and class
+	}
+	two := query(1, "demo.upper.io", dnsTypeA)
+	binary.BigEndian.PutUint16(two[4:6], 2)
+	malformed = append(malformed, two)
+	for _, q := range malformed {
+		if _, err := m.answer(q, src); err != errMalformedQuery {
+			t.Errorf("answer(%x): %v, want errMalformedQuery", q, err)
+		}
+	}
+}
+
+func TestTrack(t *testing.T) {
+	m := NewMonitor(newAllowlist(t, "demo.upper.io:5432", map[string][]string{"demo.upper.io": {"192.0.2.10"}}))
+
+	var reports []string
+	untrack := m.Track(42, func(dest, msg string) {
+		if !strings.Contains(msg, "connection to "+dest+" was blocked") {
+			t.Errorf("report of %s: %q", dest, msg)
+		}
+		reports = append(reports, dest)
+	})
+	m.report(42, "198.51.100.1:22")
+	m.report(42, "198.51.100.1:22")
+	m.report(7, "198.51.100.2:22")
+	m.report(42, "198.51.100.3:22")
+	if want := []string{"198.51.100.1:22", "198.51.100.3:22"}; !reflect.DeepEqual(reports, want) {
+		t.Errorf("reports %q, want %q", reports, want)
+	}
+	untrack()
+	m.report(42, "198.51.100.4:22")
+	if len(reports) != 2 {
+		t.Errorf("reported %q after tracking stopped", reports[2:])
+	}
+}
+
+// TestUntrackWaits checks that tracking stops only once the reports in
+// flight are done, so that a run never gets one after it is over. Run it with
+// the race detector.
+func TestUntrackWaits(t *testing.T) {
+	m := NewMonitor(newAllowlist(t, "demo.upper.io:5432", map[string][]string{"demo.upper.io": {"192.0.2.10"}}))
+
+	var reports []string // unguarded, like the blocked destinations of a result
+	entered := make(chan bool)
+	release := make(chan bool)
+	untrack := m.Track(42, func(dest, msg string) {
+		entered <- true
+		<-release
+		reports = append(reports, dest)
+	})
+
+	go m.report(42, "198.51.100.1:22")
+	<-entered
+
+	untracked := make(chan bool)
+	go func() {
+		untrack()
+		close(untracked)
+	}()
+	select {
+	case <-untracked:
+		t.Error("tracking stopped while a report was in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-untracked
+
+	if want := []string{"198.51.100.1:22"}; !reflect.DeepEqual(reports, want) {
+		t.Errorf("reports %q, want %q", reports, want)
+	}
+}
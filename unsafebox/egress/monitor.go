@@ -0,0 +1,94 @@
+package egress
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync"
+)
+
+// Monitor relays the network traffic of sandboxed programs to allowlisted
+// destinations and reports everything else.
+type Monitor struct {
+	allow *Allowlist
+
+	mu   sync.Mutex
+	runs map[int]*trackedRun
+}
+
+type trackedRun struct {
+	report func(dest, msg string)
+	seen   map[string]bool // guarded by Monitor.mu
+
+	// mu is held while report is called, and done is set once the run is
+	// no longer tracked: the function that stops tracking waits for the
+	// reports in flight, so that none is made after it returns.
+	mu   sync.Mutex
+	done bool
+}
+
+// NewMonitor creates a monitor that enforces the given allowlist.
+func NewMonitor(allow *Allowlist) *Monitor {
+	return &Monitor{
+		allow: allow,
+		runs:  make(map[int]*trackedRun),
+	}
+}
+
+// Track attributes the network activity of the process group pgid to a run.
+// Blocked attempts are passed to report once per destination, with a message
+// that explains them. The returned function stops tracking; report is not
+// called once it returns.
+func (m *Monitor) Track(pgid int, report func(dest, msg string)) func() {
+	run := &trackedRun{report: report, seen: make(map[string]bool)}
+	m.mu.Lock()
+	m.runs[pgid] = run
+	m.mu.Unlock()
+
+	return func() {
+		m.mu.Lock()
+		if m.runs[pgid] == run {
+			delete(m.runs, pgid)
+		}
+		m.mu.Unlock()
+
+		run.mu.Lock()
+		run.done = true
+		run.mu.Unlock()
+	}
+}
+
+// blocked logs a blocked attempt to reach dest, made from the local address
+// src, and reports it to the run that made it.
+func (m *Monitor) blocked(network string, src net.Addr, dest string) {
+	pgid, err := ownerPGID(network, src)
+	if err != nil {
+		log.Printf("egress: blocked %s to %s from unknown process: %v", network, dest, err)
+		return
+	}
+	log.Printf("egress: blocked %s to %s from process group %d", network, dest, pgid)
+	m.report(pgid, dest)
+}
+
+// report reports a blocked attempt to reach dest to the run of the process
+// group pgid, if it is tracked and has not been told about dest yet.
+func (m *Monitor) report(pgid int, dest string) {
+	m.mu.Lock()
+	run, ok := m.runs[pgid]
+	if !ok || run.seen[dest] {
+		m.mu.Unlock()
+		return
+	}
+	run.seen[dest] = true
+	m.mu.Unlock()
+
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	if run.done {
+		return
+	}
+	run.report(dest, fmt.Sprintf(
+		"\n[sandbox] connection to %s was blocked: programs in this sandbox may only connect to %s.\n",
+		dest, m.allow,
+	))
+}
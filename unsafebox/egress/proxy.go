@@ -0,0 +1,64 @@
+package egress
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"time"
+)
+
+const dialTimeout = 5 * time.Second
+
+// ServeProxy accepts the TCP connections redirected from sandboxed programs
+// and relays those whose original destination is allowed.
+func (m *Monitor) ServeProxy(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go m.relay(conn.(*net.TCPConn))
+	}
+}
+
+func (m *Monitor) relay(conn *net.TCPConn) {
+	defer conn.Close()
+
+	dest, err := originalDst(conn)
+	if err != nil {
+		log.Printf("egress: could not get original destination: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+
+	target, ok := m.allow.Route(ctx, dest)
+	if !ok {
+		m.blocked("tcp", conn.RemoteAddr(), dest.String())
+		return
+	}
+
+	var d net.Dialer
+	upstream, err := d.DialContext(ctx, "tcp", target)
+	if err != nil {
+		log.Printf("egress: dial %s: %v", target, err)
+		return
+	}
+	defer upstream.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(upstream, conn)
+		upstream.(*net.TCPConn).CloseWrite()
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(conn, upstream)
+		conn.CloseWrite()
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+}
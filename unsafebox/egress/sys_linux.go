@@ -0,0 +1,150 @@
+//go:build linux
+// +build linux
+
+package egress
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// soOriginalDst is SO_ORIGINAL_DST from linux/netfilter_ipv4.h.
+const soOriginalDst = 80
+
+// originalDst returns the destination a redirected connection was meant for.
+func originalDst(conn *net.TCPConn) (*net.TCPAddr, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		addr    *net.TCPAddr
+		sockErr error
+	)
+	err = raw.Control(func(fd uintptr) {
+		// The kernel fills in a struct sockaddr_in, which fits in the
+		// buffer of an ipv6_mreq.
+		mreq, err := syscall.GetsockoptIPv6Mreq(int(fd), syscall.IPPROTO_IP, soOriginalDst)
+		if err != nil {
+			sockErr = err
+			return
+		}
+		sa := mreq.Multiaddr
+		addr = &net.TCPAddr{
+			IP:   net.IPv4(sa[4], sa[5], sa[6], sa[7]),
+			Port: int(sa[2])<<8 | int(sa[3]),
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if sockErr != nil {
+		return nil, sockErr
+	}
+	return addr, nil
+}
+
+// ownerPGID returns the process group of the process that owns the socket
+// bound to the given local address.
+func ownerPGID(network string, addr net.Addr) (int, error) {
+	inode, err := socketInode(network, addr)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := socketOwner(inode)
+	if err != nil {
+		return 0, err
+	}
+	return processGroup(pid)
+}
+
+// socketInode looks up the inode of the socket bound to addr in
+// /proc/net/tcp or /proc/net/udp.
+func socketInode(network string, addr net.Addr) (string, error) {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return "", err
+	}
+	ip := net.ParseIP(host).To4()
+	if ip == nil {
+		return "", fmt.Errorf("not an IPv4 address: %s", host)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return "", err
+	}
+	// Addresses are written as the hex value of the address in host byte
+	// order, followed by the port.
+	local := fmt.Sprintf("%02X%02X%02X%02X:%04X", ip[3], ip[2], ip[1], ip[0], p)
+
+	f, err := os.Open(filepath.Join("/proc/net", network))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) > 9 && fields[1] == local {
+			return fields[9], nil
+		}
+	}
+	if err := s.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no socket bound to %s", addr)
+}
+
+// socketOwner returns the pid of a process that has the given socket open.
+func socketOwner(inode string) (int, error) {
+	procs, err := os.ReadDir("/proc")
+	if err != nil {
+		return 0, err
+	}
+	target := "socket:[" + inode + "]"
+	for _, proc := range procs {
+		pid, err := strconv.Atoi(proc.Name())
+		if err != nil {
+			continue
+		}
+		fdDir := filepath.Join("/proc", proc.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err == nil && link == target {
+				return pid, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("no process owns socket %s", inode)
+}
+
+// processGroup returns the process group of pid.
+func processGroup(pid int) (int, error) {
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0, err
+	}
+	// The command name is enclosed in parentheses and may contain spaces,
+	// the fields we care about come after it: state, ppid, pgrp.
+	i := strings.LastIndexByte(string(stat), ')')
+	if i < 0 {
+		return 0, fmt.Errorf("malformed stat for pid %d", pid)
+	}
+	fields := strings.Fields(string(stat[i+1:]))
+	if len(fields) < 3 {
+		return 0, fmt.Errorf("malformed stat for pid %d", pid)
+	}
+	return strconv.Atoi(fields[2])
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package egress
+
+import (
+	"errors"
+	"net"
+)
+
+var errUnsupported = errors.New("egress: not supported on this platform")
+
+func originalDst(conn *net.TCPConn) (*net.TCPAddr, error) {
+	return nil, errUnsupported
+}
+
+func ownerPGID(network string, addr net.Addr) (int, error) {
+	return 0, errUnsupported
+}
@@ -1,9 +1,10 @@
 #!/bin/bash
 
-rm -rf $WORKDIR/c
+rm -rf $WORKDIR/c $WORKDIR/pool
 
 mkdir -p $WORKDIR/c/bin
 mkdir -p $WORKDIR/c/usr/local/go
+mkdir -p $WORKDIR/c/usr/local/toolchains
 mkdir -p $WORKDIR/c/go
 mkdir -p $WORKDIR/c/lib
 mkdir -p $WORKDIR/c/lib64
@@ -11,22 +12,76 @@ mkdir -p $WORKDIR/c/tmp
 mkdir -p $WORKDIR/c/dev
 mkdir -p $WORKDIR/c/etc
 
-cp /app/playground $WORKDIR/c/bin
-
 echo "hosts: files dns" > $WORKDIR/c/etc/nsswitch.conf
 
 mount -o ro,bind /usr/local/go $WORKDIR/c/usr/local/go
+mount -o ro,bind /usr/local/toolchains $WORKDIR/c/usr/local/toolchains
 mount -o ro,bind /go $WORKDIR/c/go
 mount -o ro,bind /dev $WORKDIR/c/dev
 mount -o ro,bind /lib $WORKDIR/c/lib
 mount -o ro,bind /lib64 $WORKDIR/c/lib64
 
-touch $WORKDIR/c/etc/resolv.conf
-mount -o ro,bind /etc/resolv.conf $WORKDIR/c/etc/resolv.conf
+# Programs resolve names through the compile service, which only answers for
+# the hosts in its allowlist.
+echo "nameserver 127.0.0.1" > $WORKDIR/c/etc/resolv.conf
+echo "127.0.0.1 localhost" > $WORKDIR/c/etc/hosts
+
+# The compile service keeps POOL_SIZE run environments ready, each with its
+# own user starting at POOL_UID. With a POOL_SIZE of 0, programs run in the
+# chroot above as the unsafebox user.
+POOL_SIZE=${POOL_SIZE:-4}
+POOL_UID=${POOL_UID:-2000}
+
+SANDBOX_USERS=unsafebox
+if [ "$POOL_SIZE" -gt 0 ]; then
+  SANDBOX_USERS="$POOL_UID-$((POOL_UID + POOL_SIZE - 1))"
+fi
+
+# Every TCP connection and DNS query made by the sandbox users is redirected
+# to the compile service, everything else is rejected.
+EGRESS_PROXY_PORT=9900
+
+iptables -t nat -A OUTPUT -m owner --uid-owner $SANDBOX_USERS -p tcp -j REDIRECT --to-ports $EGRESS_PROXY_PORT
+iptables -t nat -A OUTPUT -m owner --uid-owner $SANDBOX_USERS -p udp --dport 53 -j REDIRECT --to-ports 53
+iptables -A OUTPUT -m owner --uid-owner $SANDBOX_USERS -p tcp -d 127.0.0.1 --dport $EGRESS_PROXY_PORT -j ACCEPT
+iptables -A OUTPUT -m owner --uid-owner $SANDBOX_USERS -p udp -d 127.0.0.1 --dport 53 -j ACCEPT
+iptables -A OUTPUT -m owner --uid-owner $SANDBOX_USERS -j REJECT
+ip6tables -A OUTPUT -m owner --uid-owner $SANDBOX_USERS -j REJECT
 
 chmod -R 755 $WORKDIR/c/go
 chmod -R 755 $WORKDIR/c/usr/local/go
+chmod -R 755 $WORKDIR/c/usr/local/toolchains
 
+# With a pool, this tmpfs only holds the build cache shared by the run
+# environments, which get a tmpfs of their own.
 mount -t tmpfs -o size=800m tmpfs $WORKDIR/c/tmp
 
-chroot --userspec unsafebox:unsafebox $WORKDIR/c /bin/playground
+mkdir -p $WORKDIR/c/tmp/.gocache
+
+# The shared build cache is only filled by builds of the trusted programs of
+# _tests, against every version of upper/db. The run environments of the pool
+# see it read-only, under a writable layer of their own.
+for v in v2 v3 v4; do
+  (cd $WORKDIR/_tests.no-modules/$v && \
+    GO111MODULE=off GOCACHE=$WORKDIR/c/tmp/.gocache go build -o /dev/null .) || \
+    echo "warm-up of the build cache with $v failed"
+done
+
+if [ "$POOL_SIZE" -eq 0 ]; then
+  chown -R unsafebox:unsafebox $WORKDIR/c/tmp/.gocache
+fi
+
+exec /app/unsafebox \
+  -root $WORKDIR/c \
+  -user unsafebox \
+  -pool-size "$POOL_SIZE" \
+  -pool-uid "$POOL_UID" \
+  -pool-dir $WORKDIR/pool \
+  -egress-proxy 127.0.0.1:$EGRESS_PROXY_PORT \
+  -egress-dns 127.0.0.1:53 \
+  -allow "${EGRESS_ALLOW:-demo.upper.io:5432,cockroachdb.demo.upper.io:26257}" \
+  -explain-endpoints "${EXPLAIN_ENDPOINTS:-}" \
+  -policy "${POLICY:-/app/policy.json}" \
+  -audit-dir "${AUDIT_DIR:-/data/audit}" \
+  -audit-retention "${AUDIT_RETENTION:-2160h}" \
+  -db-versions "upper.io/db.v2@${TAG_V2},upper.io/db.v3@${TAG_V3},github.com/upper/db/v4@${TAG_V4}"
@@ -0,0 +1,3 @@
+module github.com/upper/upper.io/unsafebox
+
+go 1.17
@@ -0,0 +1,135 @@
+package health
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/upper/upper.io/unsafebox/egress"
+	"github.com/upper/upper.io/unsafebox/sandbox"
+	"github.com/upper/upper.io/unsafebox/txtar"
+)
+
+// sslRequestCode is the code of the packet a PostgreSQL client sends to ask
+// for encryption, which CockroachDB understands as well.
+const sslRequestCode = 80877103
+
+// BuildChecks returns a check for each directory in dir, like the v2, v3 and
+// v4 directories of unsafebox/_tests. A check builds and runs the program in
+// its directory with runner, which fails if the version of upper/db it
+// imports is missing or broken in the sandbox.
+func BuildChecks(runner sandbox.Runner, dir string) ([]Check, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var checks []Check
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		files, err := readProgram(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			continue
+		}
+		body := string(txtar.FormatProgram(files))
+		checks = append(checks, Check{
+			Name: "build/" + e.Name(),
+			Run: func(ctx context.Context) error {
+				return build(ctx, runner, body)
+			},
+		})
+	}
+	return checks, nil
+}
+
+// readProgram reads the Go files of a directory.
+func readProgram(dir string) ([]txtar.File, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	var files []txtar.File
+	for _, name := range names {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, txtar.File{Name: filepath.Base(name), Data: data})
+	}
+	return files, nil
+}
+
+func build(ctx context.Context, runner sandbox.Runner, body string) error {
+	res, err := runner.Run(ctx, &sandbox.Request{Body: body})
+	if err != nil {
+		return err
+	}
+	if res.Errors != "" {
+		return errors.New(strings.TrimSpace(res.Errors))
+	}
+	if res.Status != 0 {
+		return fmt.Errorf("program exited with status %d", res.Status)
+	}
+	return nil
+}
+
+// DialChecks returns a check for each host:port pair in the allowlist. A check
+// connects to the database the way a sandboxed program would, and expects it
+// to answer an SSLRequest, so that a relay with nothing behind it is noticed.
+func DialChecks(allow *egress.Allowlist) []Check {
+	var checks []Check
+	for _, addr := range allow.Addrs() {
+		addr := addr
+		checks = append(checks, Check{
+			Name: "db/" + addr,
+			Run: func(ctx context.Context) error {
+				return dial(ctx, allow, addr)
+			},
+		})
+	}
+	return checks
+}
+
+func dial(ctx context.Context, allow *egress.Allowlist, addr string) error {
+	conn, err := allow.Dial(ctx, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	} else {
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	}
+
+	var req [8]byte
+	binary.BigEndian.PutUint32(req[0:4], 8)
+	binary.BigEndian.PutUint32(req[4:8], sslRequestCode)
+	if _, err := conn.Write(req[:]); err != nil {
+		return err
+	}
+	var resp [1]byte
+	if _, err := io.ReadFull(conn, resp[:]); err != nil {
+		return fmt.Errorf("no answer to SSLRequest: %w", err)
+	}
+	if resp[0] != 'S' && resp[0] != 'N' {
+		return fmt.Errorf("unexpected answer to SSLRequest: %q", resp[0])
+	}
+	return nil
+}
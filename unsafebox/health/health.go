@@ -0,0 +1,119 @@
+// Package health checks that the compile service can do its job: that the
+// programs of the tour still build against every version of upper/db, and that
+// the demo databases can be reached from the sandbox.
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Statuses of a component and of a report.
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Check is a component of the service that can be checked.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Component is the result of a check.
+type Component struct {
+	Name    string
+	Status  string
+	Latency time.Duration
+	Error   string `json:",omitempty"`
+}
+
+// Report is the result of all the checks.
+type Report struct {
+	Status     string
+	Components []Component
+	CheckedAt  time.Time
+}
+
+// OK reports whether every check passed.
+func (r *Report) OK() bool {
+	return r.Status == StatusOK
+}
+
+// Checker runs checks and keeps their results for a while, so that frequent
+// probes do not cost a build each.
+type Checker struct {
+	checks  []Check
+	ttl     time.Duration
+	timeout time.Duration
+
+	mu   sync.Mutex
+	last *Report
+}
+
+// NewChecker creates a checker that runs each check with the given timeout,
+// and reuses a report for ttl.
+func NewChecker(ttl, timeout time.Duration, checks ...Check) *Checker {
+	return &Checker{
+		checks:  checks,
+		ttl:     ttl,
+		timeout: timeout,
+	}
+}
+
+// Report returns the result of the checks. A passing report is reused for the
+// checker's ttl, a failing one is not, so that recovery is noticed at once.
+// Concurrent callers wait for the same run.
+func (c *Checker) Report(ctx context.Context) *Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.last != nil && c.last.OK() && time.Since(c.last.CheckedAt) < c.ttl {
+		return c.last
+	}
+
+	r := &Report{
+		Status:     StatusOK,
+		Components: make([]Component, len(c.checks)),
+		CheckedAt:  time.Now(),
+	}
+	var wg sync.WaitGroup
+	for i, check := range c.checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			r.Components[i] = c.run(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	for _, comp := range r.Components {
+		if comp.Status != StatusOK {
+			r.Status = StatusFail
+		}
+	}
+	if ctx.Err() == nil {
+		// A report cut short by the caller going away is not kept.
+		c.last = r
+	}
+	return r
+}
+
+func (c *Checker) run(ctx context.Context, check Check) Component {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check.Run(ctx)
+	comp := Component{
+		Name:    check.Name,
+		Status:  StatusOK,
+		Latency: time.Since(start),
+	}
+	if err != nil {
+		comp.Status = StatusFail
+		comp.Error = err.Error()
+	}
+	return comp
+}
@@ -0,0 +1,190 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/upper/upper.io/unsafebox/egress"
+	"github.com/upper/upper.io/unsafebox/sandbox"
+)
+
+func TestChecker(t *testing.T) {
+	var runs, failing int32
+	counted := Check{Name: "counted", Run: func(ctx context.Context) error {
+		atomic.AddInt32(&runs, 1)
+		if atomic.LoadInt32(&failing) != 0 {
+			return errors.New("broken")
+		}
+		return nil
+	}}
+	slow := Check{Name: "slow", Run: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+
+	c := NewChecker(time.Hour, time.Second, counted)
+	ctx := context.Background()
+	r := c.Report(ctx)
+	if !r.OK() || len(r.Components) != 1 || r.Components[0].Name != "counted" || r.Components[0].Status != StatusOK {
+		t.Fatalf("report %+v, want counted ok", r)
+	}
+
+	// A passing report is reused, a failing one is not.
+	if c.Report(ctx) != r || atomic.LoadInt32(&runs) != 1 {
+		t.Errorf("passing report not reused, %d runs", runs)
+	}
+	c = NewChecker(time.Hour, time.Second, counted)
+	atomic.StoreInt32(&failing, 1)
+	if r := c.Report(ctx); r.OK() || r.Components[0].Error != "broken" {
+		t.Errorf("report %+v, want counted failing", r)
+	}
+	atomic.StoreInt32(&failing, 0)
+	if r := c.Report(ctx); !r.OK() || atomic.LoadInt32(&runs) != 3 {
+		t.Errorf("report after a failure %+v, %d runs, want a new passing one", r, runs)
+	}
+
+	// Checks time out on their own, and one failure fails the report.
+	c = NewChecker(time.Hour, 10*time.Millisecond, counted, slow)
+	r = c.Report(ctx)
+	if r.OK() || r.Components[0].Status != StatusOK || r.Components[1].Status != StatusFail {
+		t.Errorf("report %+v, want counted ok and slow failing", r)
+	}
+
+	// A report cut short by the caller is not kept.
+	c = NewChecker(time.Hour, time.Second, counted)
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	c.Report(canceled)
+	if c.last != nil {
+		t.Error("report of a canceled caller kept")
+	}
+}
+
+// runner builds programs that import a v4 package, and fails the others.
+type runner struct{}
+
+func (runner) Run(ctx context.Context, req *sandbox.Request) (*sandbox.Result, error) {
+	switch {
+	case strings.Contains(req.Body, "upper/db/v4"):
+		return &sandbox.Result{}, nil
+	case strings.Contains(req.Body, "os.Exit(1)"):
+		return &sandbox.Result{Status: 1}, nil
+	}
+	return &sandbox.Result{Errors: "prog.go:3:8: package not found\n"}, nil
+}
+
+func TestBuildChecks(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"v3/main.go":      `import "upper.io/db.v3"`,
+		"v4/main.go":      `import "github.com/upper/db/v4"`,
+		"v4/helper.go":    "package main\n",
+		"v4/main_test.go": "package main\n",
+		"exit/main.go":    "os.Exit(1)",
+		"empty/README":    "",
+		"README":          "",
+	} {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checks, err := BuildChecks(runner{}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"build/exit": "program exited with status 1",
+		"build/v3":   "prog.go:3:8: package not found",
+		"build/v4":   "",
+	}
+	if len(checks) != len(want) {
+		t.Errorf("%d checks, want %d", len(checks), len(want))
+	}
+	for _, check := range checks {
+		wantErr, ok := want[check.Name]
+		if !ok {
+			t.Errorf("unexpected check %s", check.Name)
+			continue
+		}
+		err := check.Run(context.Background())
+		if (err == nil && wantErr != "") || (err != nil && err.Error() != wantErr) {
+			t.Errorf("%s: %v, want %q", check.Name, err, wantErr)
+		}
+	}
+}
+
+// listen serves connections with a function on the loopback, and returns
+// its address.
+func listen(t *testing.T, serve func(net.Conn)) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				serve(conn)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestDialChecks(t *testing.T) {
+	answer := func(b byte) func(net.Conn) {
+		return func(conn net.Conn) {
+			var req [8]byte
+			if _, err := io.ReadFull(conn, req[:]); err == nil {
+				conn.Write([]byte{b})
+			}
+		}
+	}
+	postgres := listen(t, answer('N'))
+	other := listen(t, answer('H'))
+	empty := listen(t, func(net.Conn) {})
+
+	allow, err := egress.ParseAllowlist(postgres + "," + other + "," + empty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"db/" + postgres: "",
+		"db/" + other:    "unexpected answer",
+		"db/" + empty:    "no answer",
+	}
+	checks := DialChecks(allow)
+	if len(checks) != len(want) {
+		t.Errorf("%d checks, want %d", len(checks), len(want))
+	}
+	for _, check := range checks {
+		wantErr, ok := want[check.Name]
+		if !ok {
+			t.Errorf("unexpected check %s", check.Name)
+			continue
+		}
+		err := check.Run(context.Background())
+		if (err == nil && wantErr != "") || (err != nil && (wantErr == "" || !strings.Contains(err.Error(), wantErr))) {
+			t.Errorf("%s: %v, want %q", check.Name, err, wantErr)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Package plans fetches the query plans of a run from the SQL proxies.
+//
+// In explain mode, programs connect to the demo databases with an
+// application_name that identifies the run, which tells the proxies in front of
+// the databases to explain the SELECT statements of the run. The plans are
+// kept by the proxies until the compile service fetches them.
+package plans
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// appPrefix must match proxy.ExplainAppPrefix in the sqlproxy module.
+const appPrefix = "sqlproxy-explain:"
+
+// wait is how long the proxies wait for the sessions of a run to end.
+const wait = 2 * time.Second
+
+// Client fetches plans from a list of proxies.
+type Client struct {
+	endpoints []string
+	http      *http.Client
+}
+
+// NewClient creates a client for a comma separated list of base URLs of
+// plan endpoints, such as http://upper-sqlproxy-postgresql:9100.
+func NewClient(endpoints string) (*Client, error) {
+	c := &Client{http: &http.Client{Timeout: wait + 5*time.Second}}
+	for _, e := range strings.Split(endpoints, ",") {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		if _, err := url.Parse(e); err != nil {
+			return nil, fmt.Errorf("invalid plan endpoint %q: %w", e, err)
+		}
+		c.endpoints = append(c.endpoints, strings.TrimSuffix(e, "/"))
+	}
+	return c, nil
+}
+
+// Env returns the environment that makes the PostgreSQL drivers of a program
+// connect with the application_name of a run.
+func (c *Client) Env(runID string) []string {
+	return []string{"PGAPPNAME=" + appPrefix + runID}
+}
+
+// Plans returns the plans of a run from all the proxies, in the order they
+// were made. Proxies that cannot be reached are logged and skipped.
+func (c *Client) Plans(ctx context.Context, runID string) ([]json.RawMessage, error) {
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		plans []plan
+	)
+	for _, endpoint := range c.endpoints {
+		wg.Add(1)
+		go func(endpoint string) {
+			defer wg.Done()
+			p, err := c.fetch(ctx, endpoint, runID)
+			if err != nil {
+				log.Printf("plans: %s: %v", endpoint, err)
+				return
+			}
+			mu.Lock()
+			plans = append(plans, p...)
+			mu.Unlock()
+		}(endpoint)
+	}
+	wg.Wait()
+
+	sort.SliceStable(plans, func(i, j int) bool {
+		return plans[i].Time.Before(plans[j].Time)
+	})
+	res := make([]json.RawMessage, len(plans))
+	for i := range plans {
+		res[i] = plans[i].raw
+	}
+	return res, nil
+}
+
+// plan is a plan as sent by a proxy. Only the time is decoded, the rest is
+// passed on as it is.
+type plan struct {
+	Time time.Time
+	raw  json.RawMessage
+}
+
+func (c *Client) fetch(ctx context.Context, endpoint, runID string) ([]plan, error) {
+	u := fmt.Sprintf("%s/plans/%s?wait=%s", endpoint, url.PathEscape(runID), wait)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var raw []json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return nil, err
+	}
+	plans := make([]plan, len(raw))
+	for i := range raw {
+		if err := json.Unmarshal(raw[i], &plans[i]); err != nil {
+			return nil, err
+		}
+		plans[i].raw = raw[i]
+	}
+	return plans, nil
+}
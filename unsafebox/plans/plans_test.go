@@ -0,0 +1,89 @@
+package plans
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// proxy serves the plans of run 42 like a SQL proxy.
+func proxy(t *testing.T, plans string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/plans/42" || r.URL.Query().Get("wait") != wait.String() {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, plans)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestNewClient(t *testing.T) {
+	tests := []struct {
+		endpoints string
+		want      []string
+		ok        bool
+	}{
+		{"", nil, true},
+		{"http://a:9100", []string{"http://a:9100"}, true},
+		{" http://a:9100/ , ,http://b:9100", []string{"http://a:9100", "http://b:9100"}, true},
+		{"http://a:9100,:x", nil, false},
+	}
+	for _, tt := range tests {
+		c, err := NewClient(tt.endpoints)
+		if (err == nil) != tt.ok {
+			t.Errorf("NewClient(%q): %v", tt.endpoints, err)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(c.endpoints, tt.want) {
+			t.Errorf("NewClient(%q): endpoints %q, want %q", tt.endpoints, c.endpoints, tt.want)
+		}
+	}
+}
+
+func TestPlans(t *testing.T) {
+	w := log.Writer()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(w) })
+
+	a := proxy(t, `[{"Time":"2021-03-04T05:06:07.3Z","Query":"a2"},{"Time":"2021-03-04T05:06:07.1Z","Query":"a1"}]`)
+	b := proxy(t, `[{"Time":"2021-03-04T05:06:07.2Z","Query":"b1","Plan":{"Node Type":"Seq Scan"}}]`)
+	broken := proxy(t, `[{"Time":`)
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	c, err := NewClient(a.URL + "," + b.URL + "," + broken.URL + "," + down.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plans, err := c.Plans(context.Background(), "42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range plans {
+		got = append(got, string(p))
+	}
+	// In the order they were made, as the proxies sent them.
+	want := []string{
+		`{"Time":"2021-03-04T05:06:07.1Z","Query":"a1"}`,
+		`{"Time":"2021-03-04T05:06:07.2Z","Query":"b1","Plan":{"Node Type":"Seq Scan"}}`,
+		`{"Time":"2021-03-04T05:06:07.3Z","Query":"a2"}`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Plans = %q, want %q", got, want)
+	}
+
+	if plans, err := c.Plans(context.Background(), "43"); err != nil || len(plans) != 0 {
+		t.Errorf("Plans of a run with none = %q, %v", plans, err)
+	}
+	if env := c.Env("42"); !reflect.DeepEqual(env, []string{"PGAPPNAME=sqlproxy-explain:42"}) {
+		t.Errorf("Env = %q", env)
+	}
+}
@@ -0,0 +1,319 @@
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/upper/upper.io/unsafebox/sqltrace"
+	"github.com/upper/upper.io/unsafebox/txtar"
+)
+
+const (
+	defaultBuildTimeout = 30 * time.Second
+	defaultRunTimeout   = 10 * time.Second
+	defaultMaxOutput    = 1 << 20
+)
+
+const programBin = "prog"
+
+// Chroot runs programs inside the chroot prepared by entrypoint.sh. Both the
+// build and the program itself run as an unprivileged user.
+type Chroot struct {
+	// Root is the path of the chroot directory.
+	Root string
+
+	// UID and GID are the credentials used to build and run programs.
+	UID uint32
+	GID uint32
+
+	// BuildTimeout is the maximum amount of time a build may take.
+	BuildTimeout time.Duration
+
+	// RunTimeout is the maximum amount of time a program may run.
+	RunTimeout time.Duration
+
+	// MaxOutput is the maximum number of bytes a program may write.
+	MaxOutput int
+
+	// Tracker, if not nil, is told about every program that is started.
+	Tracker Tracker
+
+	// Plans, if not nil, collects the query plans of programs run in explain
+	// mode.
+	Plans PlanSource
+
+	// Toolchains holds the Go toolchains programs may ask for. If nil, only
+	// the one at /usr/local/go is used.
+	Toolchains *Toolchains
+
+	// Pool, if not nil, provides the environments programs run in, instead
+	// of Root, UID and GID.
+	Pool *Pool
+}
+
+// Run builds the given program and runs it.
+func (c *Chroot) Run(ctx context.Context, req *Request) (*Result, error) {
+	tc := Toolchain{GOROOT: defaultGOROOT}
+	if c.Toolchains != nil {
+		var err error
+		if tc, err = c.Toolchains.Lookup(req.GoVersion); err != nil {
+			return &Result{Errors: err.Error() + "\n"}, nil
+		}
+	}
+
+	env := &Env{Root: c.Root, UID: c.UID, GID: c.GID}
+	if c.Pool != nil {
+		var err error
+		if env, err = c.Pool.Get(ctx); err != nil {
+			return nil, err
+		}
+		defer c.Pool.Put(env)
+	}
+
+	dir, err := os.MkdirTemp(filepath.Join(env.Root, "tmp"), "run-")
+	if err != nil {
+		return nil, fmt.Errorf("could not create work directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files, err := txtar.Program([]byte(req.Body))
+	if err != nil {
+		return &Result{Errors: err.Error() + "\n"}, nil
+	}
+	module, err := writeFiles(dir, files)
+	if err != nil {
+		// Conflicting names, like a and a/b.go, end up here.
+		return &Result{Errors: strings.ReplaceAll(err.Error(), dir+"/", "") + "\n"}, nil
+	}
+	// The go command may have to update go.sum, and write the binary.
+	err = filepath.Walk(dir, func(name string, _ os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Chown(name, int(env.UID), int(env.GID))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// workdir is the path of dir as seen from inside the chroot.
+	workdir := "/" + strings.TrimPrefix(dir, filepath.Clean(env.Root)+"/")
+
+	var buildOut bytes.Buffer
+	build := c.command(tc, env, workdir, path.Join(tc.GOROOT, "bin/go"), "build", "-o", programBin, ".")
+	// Without a go.mod the program is built in GOPATH mode, and the go command
+	// names the main package after its directory.
+	mainPkg := "_" + workdir
+	if module != "" {
+		// In module mode, requirements are resolved from the module cache
+		// only, and go.sum is completed from it.
+		build.Env = append(build.Env, "GOFLAGS=-mod=mod", "GOPROXY=off", "GOSUMDB=off")
+		mainPkg = module
+	}
+	build.Stdout = &buildOut
+	build.Stderr = &buildOut
+
+	if err := c.exec(ctx, build, durationOr(c.BuildTimeout, defaultBuildTimeout), nil); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return &Result{
+				Errors:    cleanBuildOutput(buildOut.String(), workdir, mainPkg),
+				Status:    exitErr.ExitCode(),
+				GoVersion: tc.Version,
+			}, nil
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			return &Result{
+				Errors:    "timeout building program",
+				GoVersion: tc.Version,
+				Limits:    []string{LimitBuildTimeout},
+			}, nil
+		}
+		return nil, err
+	}
+
+	out := newRecorder(intOr(c.MaxOutput, defaultMaxOutput), req.Stream, req.StreamSQL)
+	defer out.close()
+
+	run := c.command(tc, env, workdir, "./"+programBin)
+	var runID string
+	if req.Explain && c.Plans != nil {
+		runID = newRunID()
+		run.Env = append(run.Env, c.Plans.Env(runID)...)
+	}
+	run.Stdout = out.stream("stdout")
+	run.Stderr = out.stream("stderr")
+
+	var filters []*sqltrace.Filter
+	if req.TraceSQL {
+		// upper/db logs to stdout by default, but programs may set a logger
+		// that writes to stderr.
+		stdout := sqltrace.NewFilter(run.Stdout, out.query)
+		stderr := sqltrace.NewFilter(run.Stderr, out.query)
+		run.Stdout, run.Stderr = stdout, stderr
+		filters = append(filters, stdout, stderr)
+	}
+
+	res := &Result{GoVersion: tc.Version}
+	var blocked sync.Mutex
+	report := func(dest, msg string) {
+		blocked.Lock()
+		res.Blocked = append(res.Blocked, dest)
+		blocked.Unlock()
+		out.stream("stderr").Write([]byte(msg))
+	}
+
+	err = c.exec(ctx, run, durationOr(c.RunTimeout, defaultRunTimeout), report)
+	for _, f := range filters {
+		f.Close()
+	}
+	if err != nil {
+		var exitErr *exec.ExitError
+		switch {
+		case errors.As(err, &exitErr):
+			res.Status = exitErr.ExitCode()
+		case errors.Is(err, context.DeadlineExceeded):
+			out.stream("stderr").Write([]byte("\ntimeout running program\n"))
+			res.Status = -1
+			res.Limits = append(res.Limits, LimitRunTimeout)
+		default:
+			return nil, err
+		}
+	}
+	res.Events = out.Events()
+	res.SQL = out.Queries()
+	if out.Truncated() {
+		res.Limits = append(res.Limits, LimitOutput)
+	}
+
+	if runID != "" {
+		res.Plans, err = c.Plans.Plans(ctx, runID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+func (c *Chroot) command(tc Toolchain, env *Env, dir string, name string, args ...string) *exec.Cmd {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	cmd.Env = []string{
+		"PATH=" + path.Join(tc.GOROOT, "bin") + ":/bin",
+		"HOME=/tmp",
+		"GOROOT=" + tc.GOROOT,
+		"GOPATH=/go",
+		"GOCACHE=/tmp/.gocache",
+		"GO111MODULE=auto",
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Chroot:     env.Root,
+		Credential: &syscall.Credential{Uid: env.UID, Gid: env.GID},
+		Setpgid:    true,
+	}
+	return cmd
+}
+
+// exec runs cmd and waits for it to finish. The whole process group is killed
+// when ctx is done or the timeout expires. If report is not nil, the process
+// group is tracked while it runs.
+func (c *Chroot) exec(ctx context.Context, cmd *exec.Cmd, timeout time.Duration, report func(dest, msg string)) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	if c.Tracker != nil && report != nil {
+		untrack := c.Tracker.Track(cmd.Process.Pid, report)
+		defer untrack()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		return ctx.Err()
+	}
+}
+
+// writeFiles writes the files of a program to dir. If the program has a
+// go.mod, it returns the module path declared in it.
+func writeFiles(dir string, files []txtar.File) (module string, err error) {
+	for _, f := range files {
+		if f.Name == programBin {
+			return "", fmt.Errorf("file name %q is reserved", f.Name)
+		}
+		name := filepath.Join(dir, filepath.FromSlash(f.Name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(name, f.Data, 0644); err != nil {
+			return "", err
+		}
+		if f.Name == "go.mod" {
+			module = txtar.ModulePath(f.Data)
+			if module == "" {
+				return "", errors.New("go.mod: missing module declaration")
+			}
+		}
+	}
+	return module, nil
+}
+
+// cleanBuildOutput removes the noise the go command adds to compiler errors.
+// mainPkg is the name the go command gives to the main package.
+func cleanBuildOutput(out string, workdir string, mainPkg string) string {
+	out = strings.TrimPrefix(out, "# "+mainPkg+"\n")
+	out = strings.ReplaceAll(out, "_"+workdir+"/", "")
+	out = strings.ReplaceAll(out, workdir+"/", "")
+	lines := strings.SplitAfter(out, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimPrefix(line, "./")
+	}
+	return strings.Join(lines, "")
+}
+
+// newRunID returns a random ID for a run.
+func newRunID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+func durationOr(d, def time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return def
+}
+
+func intOr(n, def int) int {
+	if n > 0 {
+		return n
+	}
+	return def
+}
@@ -0,0 +1,195 @@
+package sandbox
+
+import (
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/upper/upper.io/unsafebox/sqltrace"
+)
+
+const truncatedMessage = "\n[output truncated]\n"
+
+// recorder collects the output of a program as a list of events. Consecutive
+// writes to the same stream are merged into a single event, but each write is
+// passed to onEvent as it happens. Statements traced from the output are
+// collected too, and count towards the same limit.
+//
+// onEvent and onQuery write to clients, so they are called by a goroutine of
+// their own rather than by the writers, in the order of the writes.
+type recorder struct {
+	mu        sync.Mutex
+	start     time.Time
+	events    []Event
+	queries   []sqltrace.Query
+	size      int
+	limit     int
+	truncated bool
+	onEvent   func(Event)
+	onQuery   func(sqltrace.Query)
+
+	// calls are the calls to onEvent and onQuery that notify has yet to
+	// make. They are bounded by the limit on the output.
+	calls  []func()
+	wake   *sync.Cond
+	closed bool
+	done   chan struct{}
+}
+
+func newRecorder(limit int, onEvent func(Event), onQuery func(sqltrace.Query)) *recorder {
+	r := &recorder{
+		start:   time.Now(),
+		limit:   limit,
+		onEvent: onEvent,
+		onQuery: onQuery,
+		done:    make(chan struct{}),
+	}
+	r.wake = sync.NewCond(&r.mu)
+	go r.notify()
+	return r
+}
+
+// notify makes the calls to onEvent and onQuery, without holding r.mu, until
+// the recorder is closed.
+func (r *recorder) notify() {
+	defer close(r.done)
+
+	r.mu.Lock()
+	for {
+		for len(r.calls) == 0 && !r.closed {
+			r.wake.Wait()
+		}
+		calls := r.calls
+		r.calls = nil
+		if len(calls) == 0 {
+			r.mu.Unlock()
+			return
+		}
+		r.mu.Unlock()
+		for _, call := range calls {
+			call()
+		}
+		r.mu.Lock()
+	}
+}
+
+// later queues a call for notify. It must be called with r.mu held.
+func (r *recorder) later(call func()) {
+	if r.closed {
+		return
+	}
+	r.calls = append(r.calls, call)
+	r.wake.Signal()
+}
+
+// close waits for the calls to onEvent and onQuery queued so far. Output
+// written after that is still recorded, but not passed to them.
+func (r *recorder) close() {
+	r.mu.Lock()
+	r.closed = true
+	r.wake.Signal()
+	r.mu.Unlock()
+
+	<-r.done
+}
+
+func (r *recorder) stream(kind string) *stream {
+	return &stream{r: r, kind: kind}
+}
+
+func (r *recorder) write(kind string, p []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.truncated {
+		return
+	}
+
+	msg := string(p)
+	if r.limit > 0 && r.size+len(msg) > r.limit {
+		// Cut at the start of a rune, not in the middle of one.
+		n := r.limit - r.size
+		for n > 0 && !utf8.RuneStart(msg[n]) {
+			n--
+		}
+		msg = msg[:n] + truncatedMessage
+		r.truncated = true
+	}
+	r.size += len(msg)
+	r.appendEvent(kind, msg)
+}
+
+// appendEvent must be called with r.mu held.
+func (r *recorder) appendEvent(kind string, msg string) {
+	ev := Event{
+		Message: msg,
+		Kind:    kind,
+		Delay:   time.Since(r.start),
+	}
+	if onEvent := r.onEvent; onEvent != nil {
+		r.later(func() { onEvent(ev) })
+	}
+
+	if n := len(r.events); n > 0 && r.events[n-1].Kind == kind {
+		r.events[n-1].Message += msg
+		return
+	}
+	r.events = append(r.events, ev)
+}
+
+// query records a statement executed by the program.
+func (r *recorder) query(q sqltrace.Query) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.truncated {
+		return
+	}
+
+	size := len(q.Statement) + len(q.Arguments) + len(q.Error)
+	if r.limit > 0 && r.size+size > r.limit {
+		r.appendEvent("stderr", truncatedMessage)
+		r.truncated = true
+		return
+	}
+	r.size += size
+
+	q.Delay = time.Since(r.start)
+	if onQuery := r.onQuery; onQuery != nil {
+		r.later(func() { onQuery(q) })
+	}
+	r.queries = append(r.queries, q)
+}
+
+func (r *recorder) Queries() []sqltrace.Query {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.queries
+}
+
+func (r *recorder) Events() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.events
+}
+
+// Truncated reports whether the output limit was reached.
+func (r *recorder) Truncated() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.truncated
+}
+
+// stream is an io.Writer that sends everything it gets to its recorder.
+type stream struct {
+	r    *recorder
+	kind string
+}
+
+func (s *stream) Write(p []byte) (int, error) {
+	s.r.write(s.kind, p)
+	return len(p), nil
+}
@@ -0,0 +1,85 @@
+package sandbox
+
+import (
+	"reflect"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/upper/upper.io/unsafebox/sqltrace"
+)
+
+func TestRecorderTruncate(t *testing.T) {
+	tests := []struct {
+		limit int
+		write []string
+		want  string
+	}{
+		{13, []string{"héllo", " wörld"}, "héllo wörld"},
+		// ö is two bytes: the limit falls in the middle of it.
+		{9, []string{"héllo", " wörld"}, "héllo w" + truncatedMessage},
+		{10, []string{"héllo", " wörld"}, "héllo wö" + truncatedMessage},
+		{1, []string{"日本"}, truncatedMessage},
+		{3, []string{"日本"}, "日" + truncatedMessage},
+	}
+	for _, tt := range tests {
+		r := newRecorder(tt.limit, nil, nil)
+		for _, s := range tt.write {
+			r.stream("stdout").Write([]byte(s))
+		}
+		truncated := r.Truncated()
+		if truncated {
+			// Nothing is recorded past the limit.
+			r.stream("stdout").Write([]byte("!"))
+		}
+		r.close()
+
+		events := r.Events()
+		if len(events) != 1 || events[0].Message != tt.want {
+			t.Errorf("limit %d: events %+v, want %q", tt.limit, events, tt.want)
+			continue
+		}
+		if !utf8.ValidString(events[0].Message) {
+			t.Errorf("limit %d: %q is not valid UTF-8", tt.limit, events[0].Message)
+		}
+		if truncated != (tt.want != "héllo wörld") {
+			t.Errorf("limit %d: Truncated() = %v", tt.limit, truncated)
+		}
+	}
+}
+
+func TestRecorderNotify(t *testing.T) {
+	var r *recorder
+	var got []string
+	r = newRecorder(1000,
+		func(ev Event) {
+			// The recorder is not locked while clients are written to.
+			_ = r.Events()
+			got = append(got, ev.Kind+" "+ev.Message)
+		},
+		func(q sqltrace.Query) {
+			_ = r.Queries()
+			got = append(got, "sql "+q.Statement)
+		},
+	)
+	r.stream("stdout").Write([]byte("a"))
+	r.stream("stdout").Write([]byte("b"))
+	r.query(sqltrace.Query{Statement: "SELECT 1"})
+	r.stream("stderr").Write([]byte("c"))
+	r.close()
+
+	want := []string{"stdout a", "stdout b", "sql SELECT 1", "stderr c"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("notified %q, want %q", got, want)
+	}
+
+	// Output written after close is recorded, but nobody is notified.
+	r.stream("stderr").Write([]byte("d"))
+	r.close()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("notified %q after close", got)
+	}
+	events := r.Events()
+	if len(events) != 2 || events[0].Message != "ab" || events[1].Message != "cd" {
+		t.Errorf("events %+v, want ab on stdout and cd on stderr", events)
+	}
+}
@@ -0,0 +1,428 @@
+package sandbox
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Env is a run environment: a chroot with its own /tmp, whose programs run as
+// a user of their own.
+type Env struct {
+	// Root is the path of the chroot directory.
+	Root string
+
+	// UID and GID are the credentials programs run with.
+	UID uint32
+	GID uint32
+}
+
+// PoolConfig configures a Pool.
+type PoolConfig struct {
+	// Dir is the directory the environments are created in.
+	Dir string
+
+	// Size is the number of environments.
+	Size int
+
+	// UID is the user of the first environment. Each environment has the
+	// next one, so that recycling an environment can kill every process of
+	// its user without touching the others.
+	UID uint32
+	GID uint32
+
+	// Mounts are the directories bind mounted read-only at the same path in
+	// every environment, like /usr/local/go and /go.
+	Mounts []string
+
+	// Etc is a directory whose files are copied to /etc in every
+	// environment.
+	Etc string
+
+	// Cache is the Go build cache shared by the environments, filled by
+	// trusted builds before the pool is created. It is the read-only lower
+	// layer of an overlay mounted at /tmp/.gocache in every environment,
+	// whose writable upper layer is private to the environment and emptied
+	// when it is recycled.
+	Cache string
+
+	// TmpfsSize is the size of the tmpfs mounted at /tmp, like 256m.
+	TmpfsSize string
+}
+
+// PoolStats are the metrics of a pool.
+type PoolStats struct {
+	Size  int
+	Ready int
+	// Hits counts the runs that found an environment ready, and Misses the
+	// ones that had to wait for one to be recycled.
+	Hits   uint64
+	Misses uint64
+	// Recycles counts the environments recycled, and Failures the ones that
+	// could not be and were prepared again from scratch.
+	Recycles    uint64
+	Failures    uint64
+	RecycleTime time.Duration // average
+	LastRecycle time.Duration
+}
+
+// Pool keeps environments prepared ahead of the runs that need them. After a
+// run, its environment is recycled: the processes of its user are killed and
+// its /tmp is replaced with an empty tmpfs.
+type Pool struct {
+	conf  PoolConfig
+	ready chan *Env
+
+	mu    sync.Mutex
+	stats PoolStats
+	total time.Duration // of all recycles
+}
+
+// NewPool prepares the environments of a pool.
+func NewPool(conf PoolConfig) (*Pool, error) {
+	if conf.Size < 1 {
+		return nil, errors.New("pool size must be at least 1")
+	}
+	if err := prepareCache(conf.Cache, conf.GID); err != nil {
+		return nil, err
+	}
+	p := &Pool{
+		conf:  conf,
+		ready: make(chan *Env, conf.Size),
+		stats: PoolStats{Size: conf.Size},
+	}
+	for i := 0; i < conf.Size; i++ {
+		env := &Env{
+			Root: filepath.Join(conf.Dir, fmt.Sprintf("env%d", i)),
+			UID:  conf.UID + uint32(i),
+			GID:  conf.GID,
+		}
+		if err := p.prepare(env); err != nil {
+			p.Close()
+			return nil, fmt.Errorf("prepare %s: %w", env.Root, err)
+		}
+		p.ready <- env
+	}
+	return p, nil
+}
+
+// Get returns a ready environment, waiting for one to be recycled if there is
+// none. The environment must be returned with Put.
+func (p *Pool) Get(ctx context.Context) (*Env, error) {
+	select {
+	case env := <-p.ready:
+		p.count(func(s *PoolStats) { s.Hits++ })
+		return env, nil
+	default:
+	}
+
+	p.count(func(s *PoolStats) { s.Misses++ })
+	select {
+	case env := <-p.ready:
+		return env, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Put recycles an environment and makes it ready again. It does not wait for
+// the environment to be recycled.
+func (p *Pool) Put(env *Env) {
+	go func() {
+		start := time.Now()
+		err := p.recycle(env)
+		elapsed := time.Since(start)
+		if err != nil {
+			log.Printf("pool: recycle %s: %v", env.Root, err)
+			p.count(func(s *PoolStats) { s.Failures++ })
+			p.replace(env)
+			return
+		}
+		p.mu.Lock()
+		p.stats.Recycles++
+		p.stats.LastRecycle = elapsed
+		p.total += elapsed
+		p.stats.RecycleTime = p.total / time.Duration(p.stats.Recycles)
+		p.mu.Unlock()
+		p.ready <- env
+	}()
+}
+
+// maxReplaceDelay is the longest wait between two attempts to prepare an
+// environment that could not be recycled.
+const maxReplaceDelay = time.Minute
+
+// replace prepares an environment that could not be recycled again from
+// scratch, and makes it ready. It retries until it can, so that the pool
+// keeps its size.
+func (p *Pool) replace(env *Env) {
+	for delay := time.Second; ; {
+		err := killUser(env.UID)
+		if err == nil {
+			p.release(env)
+			err = p.prepare(env)
+		}
+		if err == nil {
+			log.Printf("pool: %s prepared again", env.Root)
+			p.ready <- env
+			return
+		}
+		log.Printf("pool: prepare %s again: %v", env.Root, err)
+		time.Sleep(delay)
+		if delay *= 2; delay > maxReplaceDelay {
+			delay = maxReplaceDelay
+		}
+	}
+}
+
+// Stats returns the metrics of the pool.
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := p.stats
+	s.Ready = len(p.ready)
+	return s
+}
+
+// Close unmounts the environments that are ready. Environments in use are
+// left as they are.
+func (p *Pool) Close() {
+	for {
+		select {
+		case env := <-p.ready:
+			_ = killUser(env.UID)
+			p.release(env)
+		default:
+			return
+		}
+	}
+}
+
+func (p *Pool) count(fn func(s *PoolStats)) {
+	p.mu.Lock()
+	fn(&p.stats)
+	p.mu.Unlock()
+}
+
+// prepare creates the chroot of an environment, like entrypoint.sh does for
+// the one at -root.
+func (p *Pool) prepare(env *Env) error {
+	for _, dir := range []string{"bin", "etc", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(env.Root, dir), 0755); err != nil {
+			return err
+		}
+	}
+
+	if p.conf.Etc != "" {
+		entries, err := os.ReadDir(p.conf.Etc)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if !e.Type().IsRegular() {
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(p.conf.Etc, e.Name()))
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(filepath.Join(env.Root, "etc", e.Name()), data, 0644); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, dir := range p.conf.Mounts {
+		if _, err := os.Stat(dir); err != nil {
+			return err
+		}
+		target := filepath.Join(env.Root, dir)
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return err
+		}
+		if err := bindMount(dir, target, true); err != nil {
+			return fmt.Errorf("mount %s: %w", dir, err)
+		}
+	}
+
+	return p.mountTmp(env)
+}
+
+// release unmounts what prepare mounted in an environment.
+func (p *Pool) release(env *Env) {
+	_ = unmountTmp(env)
+	for i := len(p.conf.Mounts) - 1; i >= 0; i-- {
+		_ = syscall.Unmount(filepath.Join(env.Root, p.conf.Mounts[i]), syscall.MNT_DETACH)
+	}
+}
+
+// recycle kills what is left of the last run in an environment and gives it
+// an empty /tmp.
+func (p *Pool) recycle(env *Env) error {
+	if err := killUser(env.UID); err != nil {
+		return err
+	}
+	if err := unmountTmp(env); err != nil {
+		return err
+	}
+	return p.mountTmp(env)
+}
+
+func (p *Pool) mountTmp(env *Env) error {
+	tmp := filepath.Join(env.Root, "tmp")
+	opts := "mode=1777"
+	if p.conf.TmpfsSize != "" {
+		opts += ",size=" + p.conf.TmpfsSize
+	}
+	if err := syscall.Mount("tmpfs", tmp, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, opts); err != nil {
+		return fmt.Errorf("mount tmpfs: %w", err)
+	}
+	if p.conf.Cache == "" {
+		return nil
+	}
+	// The layers of the overlay are in a directory of /tmp only root can
+	// enter, so programs only see the cache through the overlay.
+	layers := filepath.Join(tmp, ".gocache-layers")
+	upper, work := filepath.Join(layers, "upper"), filepath.Join(layers, "work")
+	cache := filepath.Join(tmp, ".gocache")
+	for _, dir := range []string{layers, upper, work, cache} {
+		if err := os.Mkdir(dir, 0700); err != nil {
+			return err
+		}
+	}
+	// The root of the overlay takes its owner and mode from the upper layer.
+	if err := os.Chown(upper, 0, int(env.GID)); err != nil {
+		return err
+	}
+	if err := os.Chmod(upper, 0775); err != nil {
+		return err
+	}
+	opts = fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", p.conf.Cache, upper, work)
+	if err := syscall.Mount("overlay", cache, "overlay", syscall.MS_NOSUID|syscall.MS_NODEV, opts); err != nil {
+		return fmt.Errorf("mount cache: %w", err)
+	}
+	return nil
+}
+
+func unmountTmp(env *Env) error {
+	tmp := filepath.Join(env.Root, "tmp")
+	_ = syscall.Unmount(filepath.Join(tmp, ".gocache"), syscall.MNT_DETACH)
+	if err := syscall.Unmount(tmp, syscall.MNT_DETACH); err != nil && err != syscall.EINVAL {
+		return fmt.Errorf("unmount tmpfs: %w", err)
+	}
+	return nil
+}
+
+func bindMount(source, target string, readOnly bool) error {
+	if err := syscall.Mount(source, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return err
+	}
+	if !readOnly {
+		return nil
+	}
+	// The read-only flag is ignored by the first mount of a bind.
+	return syscall.Mount("", target, "", syscall.MS_REMOUNT|syscall.MS_BIND|syscall.MS_RDONLY, "")
+}
+
+// prepareCache gives the directories of the shared build cache to root and
+// the group of the environments, so that programs can add entries to the
+// cache of their overlay, but not to the shared one, which they never see.
+// The go command creates the 256 directories of the cache when it first opens
+// it, so they are created here if the warm-up did not.
+func prepareCache(dir string, gid uint32) error {
+	if dir == "" {
+		return nil
+	}
+	dirs := []string{dir}
+	for i := 0; i < 256; i++ {
+		dirs = append(dirs, filepath.Join(dir, fmt.Sprintf("%02x", i)))
+	}
+	for _, d := range dirs {
+		if err := os.MkdirAll(d, 0775); err != nil {
+			return err
+		}
+		if err := os.Chown(d, 0, int(gid)); err != nil {
+			return err
+		}
+		if err := os.Chmod(d, 0775); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// killUser kills every process of the user uid, including the ones that left
+// the process group of the program.
+func killUser(uid uint32) error {
+	for attempt := 0; ; attempt++ {
+		pids, err := userProcesses(uid)
+		if err != nil {
+			return err
+		}
+		if len(pids) == 0 {
+			return nil
+		}
+		if attempt == 50 {
+			return fmt.Errorf("%d processes of user %d survived SIGKILL", len(pids), uid)
+		}
+		for _, pid := range pids {
+			_ = syscall.Kill(pid, syscall.SIGKILL)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// userProcesses returns the processes whose real user is uid.
+func userProcesses(uid uint32) ([]int, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	want := strconv.FormatUint(uint64(uid), 10)
+	var pids []int
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		if uid, zombie := processStatus(pid); uid == want && !zombie {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+// processStatus returns the real user of a process, and whether it is a
+// zombie, which is dead already.
+func processStatus(pid int) (uid string, zombie bool) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "State:":
+			zombie = fields[1] == "Z"
+		case "Uid:":
+			return fields[1], zombie
+		}
+	}
+	return "", false
+}
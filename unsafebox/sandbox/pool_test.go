@@ -0,0 +1,146 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// newTestPool creates a pool of two environments in a temporary directory,
+// with a shared build cache holding one entry. It skips the test where
+// mounting is not permitted.
+func newTestPool(t *testing.T) *Pool {
+	t.Helper()
+	if os.Geteuid() != 0 {
+		t.Skip("the pool needs root to mount")
+	}
+	dir := t.TempDir()
+	cache := filepath.Join(dir, "cache")
+	if err := os.MkdirAll(filepath.Join(cache, "00"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(cache, "00", "shared-d"), []byte("trusted"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewPool(PoolConfig{
+		Dir:       filepath.Join(dir, "pool"),
+		Size:      2,
+		UID:       64000,
+		GID:       64000,
+		Cache:     cache,
+		TmpfsSize: "16m",
+	})
+	if errors.Is(err, syscall.EPERM) {
+		t.Skipf("mount: %v", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		// Close leaves the environments being recycled mounted.
+		deadline := time.Now().Add(10 * time.Second)
+		for p.Stats().Ready < p.Stats().Size && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		p.Close()
+	})
+	return p
+}
+
+func getEnv(t *testing.T, p *Pool) *Env {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	env, err := p.Get(ctx)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	return env
+}
+
+func TestPoolCache(t *testing.T) {
+	p := newTestPool(t)
+	env := getEnv(t, p)
+	cache := filepath.Join(env.Root, "tmp", ".gocache")
+
+	// The environment sees the shared entries, and writes its own to a
+	// layer of its own.
+	if data, err := os.ReadFile(filepath.Join(cache, "00", "shared-d")); err != nil || string(data) != "trusted" {
+		t.Fatalf("shared entry: %q, %v", data, err)
+	}
+	if err := os.WriteFile(filepath.Join(cache, "00", "shared-d"), []byte("poisoned"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(cache, "00", "private-d"), []byte("private"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(p.conf.Cache, "00", "shared-d")); string(data) != "trusted" {
+		t.Errorf("shared entry changed to %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(p.conf.Cache, "00", "private-d")); !os.IsNotExist(err) {
+		t.Errorf("private entry in the shared cache: %v", err)
+	}
+
+	fi, err := os.Stat(cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := fi.Sys().(*syscall.Stat_t); fi.Mode().Perm() != 0775 || st.Uid != 0 || st.Gid != env.GID {
+		t.Errorf("cache: mode %v, owner %d:%d, want 0775 0:%d", fi.Mode().Perm(), st.Uid, st.Gid, env.GID)
+	}
+
+	// The other environment does not see what the first one wrote.
+	other := getEnv(t, p)
+	if data, _ := os.ReadFile(filepath.Join(other.Root, "tmp", ".gocache", "00", "shared-d")); string(data) != "trusted" {
+		t.Errorf("shared entry in another environment: %q", data)
+	}
+	p.Put(other)
+
+	// Nor does the first one once it is recycled.
+	p.Put(env)
+	for i := 0; i < 2; i++ {
+		env := getEnv(t, p)
+		if data, _ := os.ReadFile(filepath.Join(env.Root, "tmp", ".gocache", "00", "shared-d")); string(data) != "trusted" {
+			t.Errorf("shared entry after recycling: %q", data)
+		}
+		defer p.Put(env)
+	}
+}
+
+func TestPoolReplace(t *testing.T) {
+	p := newTestPool(t)
+	env := getEnv(t, p)
+
+	// Without its shared cache, the environment cannot be recycled.
+	moved := p.conf.Cache + ".moved"
+	if err := os.Rename(p.conf.Cache, moved); err != nil {
+		t.Fatal(err)
+	}
+	p.Put(env)
+	deadline := time.Now().Add(5 * time.Second)
+	for p.Stats().Failures == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("recycling did not fail")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := os.Rename(moved, p.conf.Cache); err != nil {
+		t.Fatal(err)
+	}
+
+	// It is prepared again, and the pool keeps its size.
+	envs := []*Env{getEnv(t, p), getEnv(t, p)}
+	if st := p.Stats(); st.Size != 2 || st.Failures != 1 {
+		t.Errorf("stats: %+v", st)
+	}
+	for _, env := range envs {
+		if data, err := os.ReadFile(filepath.Join(env.Root, "tmp", ".gocache", "00", "shared-d")); err != nil || string(data) != "trusted" {
+			t.Errorf("%s: shared entry %q, %v", env.Root, data, err)
+		}
+		p.Put(env)
+	}
+}
@@ -0,0 +1,98 @@
+// Package sandbox builds and runs untrusted Go programs.
+package sandbox
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/upper/upper.io/unsafebox/sqltrace"
+)
+
+// Event is a chunk of program output, in the format expected by the
+// playground client.
+type Event struct {
+	Message string
+	Kind    string // "stdout" or "stderr"
+	Delay   time.Duration
+}
+
+// Request is a program submitted for execution.
+type Request struct {
+	// Body is the source of the program, either a single file or a txtar
+	// archive with several files and an optional go.mod.
+	Body string
+
+	// Stream, if not nil, is called with each chunk of output as soon as the
+	// program writes it.
+	Stream func(Event)
+
+	// TraceSQL moves the statements upper/db logs at the debug level out of
+	// the output and into Result.SQL.
+	TraceSQL bool
+
+	// StreamSQL, if not nil, is called with each traced statement as soon as
+	// it is logged.
+	StreamSQL func(sqltrace.Query)
+
+	// Explain asks for the plans of the SELECT statements the program runs
+	// against the demo databases.
+	Explain bool
+
+	// GoVersion selects the Go toolchain the program is built with, like 1.17
+	// or go1.17.13. If empty, the default toolchain is used.
+	GoVersion string
+}
+
+// Result is the outcome of running a program.
+type Result struct {
+	// Errors holds the compiler output when the program could not be built.
+	Errors string
+	// Events holds the output of the program, in the order it was written.
+	Events []Event
+	// Status is the exit status of the program.
+	Status int
+	// SQL holds the statements executed by the program, if they were traced.
+	SQL []sqltrace.Query
+	// Plans holds the query plans of the program in explain mode, in the
+	// normalized format of the SQL proxies.
+	Plans []json.RawMessage
+	// GoVersion is the version of the toolchain the program was built with.
+	GoVersion string
+	// Limits lists the limits the program hit, like LimitRunTimeout.
+	Limits []string
+	// Blocked lists the destinations the program tried to connect to and
+	// was not allowed to.
+	Blocked []string
+}
+
+// Limits a program may hit.
+const (
+	LimitBuildTimeout = "build-timeout"
+	LimitRunTimeout   = "run-timeout"
+	LimitOutput       = "output"
+)
+
+// Runner builds and runs programs.
+type Runner interface {
+	Run(ctx context.Context, req *Request) (*Result, error)
+}
+
+// PlanSource collects the query plans of the programs run in explain mode.
+type PlanSource interface {
+	// Env returns the environment a program needs so that its plans are
+	// collected under runID.
+	Env(runID string) []string
+	// Plans returns the plans collected under runID.
+	Plans(ctx context.Context, runID string) ([]json.RawMessage, error)
+}
+
+// Tracker is told about the programs a runner starts, so that what a program
+// does besides writing output, such as a blocked connection attempt, can be
+// reported back to the user.
+type Tracker interface {
+	// Track starts attributing the activity of the process group pgid to a
+	// run. Blocked attempts are passed to report, with the destination and a
+	// message for the user. The returned function stops tracking.
+	Track(pgid int, report func(dest, msg string)) (untrack func())
+}
@@ -0,0 +1,157 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultGOROOT is the Go installation of the base image.
+const defaultGOROOT = "/usr/local/go"
+
+// Toolchain is a Go installation programs can be built with.
+type Toolchain struct {
+	// Version is the full version of the toolchain, such as go1.17.13.
+	Version string
+	// GOROOT is the path of the installation inside the chroot.
+	GOROOT string
+}
+
+// Toolchains is the set of toolchains installed in a chroot.
+type Toolchains struct {
+	list []Toolchain // sorted from newest to oldest
+	def  Toolchain
+}
+
+// FindToolchains looks for the toolchains of a chroot: the one at
+// /usr/local/go, which is the default, and every installation in dir, a path
+// inside the chroot. Each installation is identified by its VERSION file.
+func FindToolchains(root, dir string) (*Toolchains, error) {
+	t := &Toolchains{}
+
+	def, err := readToolchain(root, defaultGOROOT)
+	if err != nil {
+		return nil, err
+	}
+	t.def = def
+	t.list = append(t.list, def)
+
+	if dir != "" {
+		entries, err := os.ReadDir(filepath.Join(root, dir))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			tc, err := readToolchain(root, filepath.Join(dir, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+			if tc.Version != def.Version {
+				t.list = append(t.list, tc)
+			}
+		}
+	}
+
+	sort.SliceStable(t.list, func(i, j int) bool {
+		return compareVersions(t.list[i].Version, t.list[j].Version) > 0
+	})
+	return t, nil
+}
+
+func readToolchain(root, goroot string) (Toolchain, error) {
+	data, err := os.ReadFile(filepath.Join(root, goroot, "VERSION"))
+	if err != nil {
+		return Toolchain{}, fmt.Errorf("toolchain %s: %w", goroot, err)
+	}
+	version := strings.TrimSpace(strings.SplitN(string(data), "\n", 2)[0])
+	if !strings.HasPrefix(version, "go") {
+		return Toolchain{}, fmt.Errorf("toolchain %s: unexpected version %q", goroot, version)
+	}
+	return Toolchain{Version: version, GOROOT: goroot}, nil
+}
+
+// Default returns the toolchain used when a request does not ask for one.
+func (t *Toolchains) Default() Toolchain {
+	return t.def
+}
+
+// Versions returns the versions of the toolchains, from newest to oldest.
+func (t *Toolchains) Versions() []string {
+	versions := make([]string, len(t.list))
+	for i, tc := range t.list {
+		versions[i] = tc.Version
+	}
+	return versions
+}
+
+// Lookup returns the toolchain for a version, with or without the go prefix.
+// A version without a patch number, like 1.17, picks the newest toolchain of
+// that release. The empty version picks the default toolchain.
+func (t *Toolchains) Lookup(version string) (Toolchain, error) {
+	if version == "" {
+		return t.def, nil
+	}
+	want := "go" + strings.TrimPrefix(version, "go")
+	for _, tc := range t.list {
+		if tc.Version == want || strings.HasPrefix(tc.Version, want+".") {
+			return tc, nil
+		}
+	}
+	return Toolchain{}, fmt.Errorf("unknown Go version %q, available versions are %s", version, strings.Join(t.Versions(), ", "))
+}
+
+// compareVersions compares two Go versions like go1.17.13 or go1.18rc1,
+// returning a negative number if a is older than b, zero if they are the
+// same and a positive number otherwise. Prereleases are older than the
+// releases they precede.
+func compareVersions(a, b string) int {
+	an, apre := splitVersion(a)
+	bn, bpre := splitVersion(b)
+	for i := 0; i < len(an) || i < len(bn); i++ {
+		var x, y int
+		if i < len(an) {
+			x = an[i]
+		}
+		if i < len(bn) {
+			y = bn[i]
+		}
+		if x != y {
+			return x - y
+		}
+	}
+	switch {
+	case apre == bpre:
+		return 0
+	case apre == "":
+		return 1
+	case bpre == "":
+		return -1
+	case apre < bpre:
+		return -1
+	}
+	return 1
+}
+
+// splitVersion splits a version into its numbers and its prerelease suffix.
+func splitVersion(v string) ([]int, string) {
+	v = strings.TrimPrefix(v, "go")
+	end := strings.IndexFunc(v, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	pre := ""
+	if end >= 0 {
+		v, pre = v[:end], v[end:]
+	}
+	var nums []int
+	for _, s := range strings.Split(v, ".") {
+		n, _ := strconv.Atoi(s)
+		nums = append(nums, n)
+	}
+	return nums, pre
+}
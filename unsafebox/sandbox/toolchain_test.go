@@ -0,0 +1,204 @@
+package sandbox
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newRoot creates a chroot with a Go installation for each of goroots, by
+// path inside the chroot, whose VERSION file has the text it maps to. An
+// installation without text has no VERSION file.
+func newRoot(t *testing.T, goroots map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for goroot, version := range goroots {
+		dir := filepath.Join(root, goroot)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if version == "" {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(dir, "VERSION"), []byte(version), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestFindToolchains(t *testing.T) {
+	root := newRoot(t, map[string]string{
+		"/usr/local/go":     "go1.17.13",
+		"/opt/go/go1.16.15": "go1.16.15\n",
+		"/opt/go/go1.18":    "go1.18\ntime 2022-03-15T02:26:35Z\n",
+		"/opt/go/go1.18rc1": "go1.18rc1",
+		"/opt/go/go1.17.13": "go1.17.13",
+		"/opt/go/go1.17.9":  "go1.17.9",
+	})
+	// Files next to the installations are not toolchains.
+	if err := os.WriteFile(filepath.Join(root, "opt/go/README"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tcs, err := FindToolchains(root, "/opt/go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The default is listed once, at /usr/local/go.
+	want := []string{"go1.18", "go1.18rc1", "go1.17.13", "go1.17.9", "go1.16.15"}
+	if got := tcs.Versions(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Versions = %q, want %q", got, want)
+	}
+	if def := tcs.Default(); def != (Toolchain{Version: "go1.17.13", GOROOT: "/usr/local/go"}) {
+		t.Errorf("Default = %+v", def)
+	}
+
+	// Without a directory of toolchains, or with one that is not there, only
+	// the default is found.
+	for _, dir := range []string{"", "/opt/none"} {
+		tcs, err := FindToolchains(root, dir)
+		if err != nil || !reflect.DeepEqual(tcs.Versions(), []string{"go1.17.13"}) {
+			t.Errorf("FindToolchains(%q) = %v, %v, want the default only", dir, tcs.Versions(), err)
+		}
+	}
+}
+
+func TestFindToolchainsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		goroots map[string]string
+		want    string
+	}{
+		{"no default", map[string]string{"/opt/go/go1.18": "go1.18"}, "toolchain /usr/local/go: "},
+		{"no VERSION", map[string]string{"/usr/local/go": "go1.17.13", "/opt/go/go1.18": ""}, "toolchain /opt/go/go1.18: "},
+		{"bad VERSION", map[string]string{"/usr/local/go": "devel +abc123"}, `toolchain /usr/local/go: unexpected version "devel +abc123"`},
+	}
+	for _, tt := range tests {
+		root := newRoot(t, tt.goroots)
+		if _, err := FindToolchains(root, "/opt/go"); err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("%s: FindToolchains: %v, want %s", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	root := newRoot(t, map[string]string{
+		"/usr/local/go":     "go1.17.13",
+		"/opt/go/go1.16.15": "go1.16.15",
+		"/opt/go/go1.17.9":  "go1.17.9",
+		"/opt/go/go1.18":    "go1.18",
+		"/opt/go/go1.18rc1": "go1.18rc1",
+	})
+	tcs, err := FindToolchains(root, "/opt/go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		version string
+		goroot  string
+	}{
+		{"", "/usr/local/go"},
+		{"go1.17.13", "/usr/local/go"},
+		{"1.17.9", "/opt/go/go1.17.9"},
+		// The newest toolchain of a release.
+		{"1.17", "/usr/local/go"},
+		{"go1.16", "/opt/go/go1.16.15"},
+		// Prereleases are picked by their full version only.
+		{"1.18", "/opt/go/go1.18"},
+		{"go1.18rc1", "/opt/go/go1.18rc1"},
+	}
+	for _, tt := range tests {
+		tc, err := tcs.Lookup(tt.version)
+		if err != nil || tc.GOROOT != tt.goroot {
+			t.Errorf("Lookup(%q) = %+v, %v, want %s", tt.version, tc, err, tt.goroot)
+		}
+	}
+
+	for _, version := range []string{"1.19", "go1.17.14", "1.1", "1.18rc", "latest"} {
+		_, err := tcs.Lookup(version)
+		want := `unknown Go version "` + version + `", available versions are go1.18, go1.18rc1, go1.17.13, go1.17.9, go1.16.15`
+		if err == nil || err.Error() != want {
+			t.Errorf("Lookup(%q): %v, want %s", version, err, want)
+		}
+	}
+}
+
+func TestRunToolchain(t *testing.T) {
+	root := newRoot(t, map[string]string{
+		"/usr/local/go":  "go1.17.13",
+		"/opt/go/go1.18": "go1.18",
+	})
+	tcs, err := FindToolchains(root, "/opt/go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Chroot{Root: root, Toolchains: tcs}
+
+	// A version that is not installed is an error of the program, reported
+	// before anything runs.
+	res, err := c.Run(context.Background(), &Request{Body: "package main\n", GoVersion: "1.19"})
+	want := "unknown Go version \"1.19\", available versions are go1.18, go1.17.13\n"
+	if err != nil || res.Errors != want {
+		t.Errorf("Run with go 1.19 = %+v, %v, want %q", res, err, want)
+	}
+
+	// The go command of the toolchain is run, with its GOROOT.
+	tc, err := tcs.Lookup("1.18")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := c.command(tc, &Env{Root: root}, "/tmp", "go", "build")
+	for _, v := range []string{"PATH=/opt/go/go1.18/bin:/bin", "GOROOT=/opt/go/go1.18"} {
+		if !contains(cmd.Env, v) {
+			t.Errorf("environment %q has no %s", cmd.Env, v)
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int // its sign
+	}{
+		{"go1.17.13", "go1.17.13", 0},
+		{"go1.17", "go1.17.0", 0},
+		{"go1.17.13", "go1.17.9", 1},
+		{"go1.18", "go1.17.13", 1},
+		{"go1.9", "go1.10", -1},
+		{"go1.18rc1", "go1.18", -1},
+		{"go1.18beta2", "go1.18rc1", -1},
+		{"go1.18rc1", "go1.17.13", 1},
+		{"go1.18rc2", "go1.18rc1", 1},
+	}
+	sign := func(n int) int {
+		switch {
+		case n < 0:
+			return -1
+		case n > 0:
+			return 1
+		}
+		return 0
+	}
+	for _, tt := range tests {
+		if got := sign(compareVersions(tt.a, tt.b)); got != tt.want {
+			t.Errorf("compareVersions(%s, %s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := sign(compareVersions(tt.b, tt.a)); got != -tt.want {
+			t.Errorf("compareVersions(%s, %s) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
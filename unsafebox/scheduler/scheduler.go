@@ -0,0 +1,309 @@
+// Package scheduler admits program runs into a fixed pool of workers.
+//
+// Pending runs are queued per client and clients are served round-robin, so a
+// client submitting programs in a loop cannot starve everybody else.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned for runs that were still queued when the scheduler
+// was closed.
+var ErrClosed = errors.New("scheduler: closed")
+
+// QueueFullError is returned when a run cannot be admitted because the global
+// queue or the client's queue is full.
+type QueueFullError struct {
+	// RetryAfter is an estimate of how long the client should wait before
+	// trying again.
+	RetryAfter time.Duration
+}
+
+func (e *QueueFullError) Error() string {
+	return "scheduler: queue is full"
+}
+
+// Config holds the limits of a Scheduler.
+type Config struct {
+	// Workers is the number of runs that may execute at the same time.
+	Workers int
+
+	// MaxQueue is the maximum number of runs waiting for a worker.
+	MaxQueue int
+
+	// MaxClientQueue is the maximum number of runs a single client may have
+	// waiting for a worker.
+	MaxClientQueue int
+}
+
+// Scheduler dispatches runs to a fixed pool of workers.
+type Scheduler struct {
+	cfg Config
+
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	// queues holds the pending tickets of each client, ring holds the clients
+	// with pending tickets in the order they will be served.
+	queues map[string][]*Ticket
+	ring   []string
+
+	queued  int
+	running int
+	closed  bool
+
+	// avg is a moving average of the time a run takes.
+	avg time.Duration
+
+	wg sync.WaitGroup
+}
+
+// New creates a scheduler and starts its workers.
+func New(cfg Config) *Scheduler {
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+	s := &Scheduler{
+		cfg:    cfg,
+		queues: make(map[string][]*Ticket),
+		avg:    time.Second,
+	}
+	s.cond = sync.NewCond(&s.mu)
+
+	s.wg.Add(cfg.Workers)
+	for i := 0; i < cfg.Workers; i++ {
+		go s.work()
+	}
+	return s
+}
+
+// Submit queues fn to be run on behalf of client. It returns a
+// *QueueFullError if the run cannot be admitted.
+func (s *Scheduler) Submit(client string, fn func()) (*Ticket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, ErrClosed
+	}
+	if s.cfg.MaxQueue > 0 && s.queued >= s.cfg.MaxQueue {
+		return nil, &QueueFullError{RetryAfter: s.estimate(s.queued)}
+	}
+	q := s.queues[client]
+	if s.cfg.MaxClientQueue > 0 && len(q) >= s.cfg.MaxClientQueue {
+		return nil, &QueueFullError{RetryAfter: s.estimate(s.queued)}
+	}
+
+	t := &Ticket{
+		s:      s,
+		client: client,
+		fn:     fn,
+		done:   make(chan struct{}),
+	}
+	if len(q) == 0 {
+		s.ring = append(s.ring, client)
+	}
+	s.queues[client] = append(q, t)
+	s.queued++
+
+	s.cond.Signal()
+	return t, nil
+}
+
+// Do submits fn and waits for it to finish. If ctx is done while fn is still
+// queued, fn is discarded and ctx.Err() is returned.
+func (s *Scheduler) Do(ctx context.Context, client string, fn func()) error {
+	t, err := s.Submit(client, fn)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-t.Done():
+		return t.Err()
+	case <-ctx.Done():
+		if t.Cancel() {
+			return ctx.Err()
+		}
+		<-t.Done()
+		return t.Err()
+	}
+}
+
+// Position returns the position in the queue of the next pending run of the
+// given client, or zero if the client has nothing queued.
+func (s *Scheduler) Position(client string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q := s.queues[client]
+	if len(q) == 0 {
+		return 0
+	}
+	return s.position(q[0])
+}
+
+// Stats returns the number of queued and running runs.
+func (s *Scheduler) Stats() (queued, running int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.queued, s.running
+}
+
+// Close stops the workers after the runs in progress finish. Queued runs are
+// discarded and finish with ErrClosed.
+func (s *Scheduler) Close() {
+	s.mu.Lock()
+	s.closed = true
+	for client, q := range s.queues {
+		for _, t := range q {
+			t.finish(ErrClosed)
+		}
+		delete(s.queues, client)
+	}
+	s.ring = nil
+	s.queued = 0
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+func (s *Scheduler) work() {
+	defer s.wg.Done()
+
+	for {
+		t := s.next()
+		if t == nil {
+			return
+		}
+
+		start := time.Now()
+		t.fn()
+		elapsed := time.Since(start)
+
+		s.mu.Lock()
+		s.running--
+		s.avg = (s.avg*7 + elapsed) / 8
+		t.finish(nil)
+		s.mu.Unlock()
+	}
+}
+
+// next blocks until there is a ticket to run and takes it off the queue. It
+// returns nil once the scheduler is closed.
+func (s *Scheduler) next() *Ticket {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.ring) == 0 {
+		if s.closed {
+			return nil
+		}
+		s.cond.Wait()
+	}
+
+	client := s.ring[0]
+	s.ring = s.ring[1:]
+
+	q := s.queues[client]
+	t := q[0]
+	if len(q) > 1 {
+		s.queues[client] = q[1:]
+		s.ring = append(s.ring, client)
+	} else {
+		delete(s.queues, client)
+	}
+
+	s.queued--
+	s.running++
+	t.started = true
+
+	return t
+}
+
+// position returns how many runs will be dispatched before t, plus one. Must
+// be called with s.mu held.
+func (s *Scheduler) position(t *Ticket) int {
+	q := s.queues[t.client]
+
+	k := -1
+	for i := range q {
+		if q[i] == t {
+			k = i
+			break
+		}
+	}
+	if k < 0 {
+		return 0
+	}
+
+	// Every round serves one run from each client in ring order, so before
+	// reaching t each client ahead of t.client in the ring gets k+1 turns and
+	// each client behind it gets k.
+	ahead := k
+	behind := false
+	for _, client := range s.ring {
+		if client == t.client {
+			behind = true
+			continue
+		}
+		turns := k + 1
+		if behind {
+			turns = k
+		}
+		if n := len(s.queues[client]); n < turns {
+			turns = n
+		}
+		ahead += turns
+	}
+	return ahead + 1
+}
+
+// estimate returns how long it will take to dispatch the given number of
+// queued runs. Must be called with s.mu held.
+func (s *Scheduler) estimate(queued int) time.Duration {
+	d := s.avg * time.Duration(queued+1) / time.Duration(s.cfg.Workers)
+	if d < time.Second {
+		d = time.Second
+	}
+	return d
+}
+
+// cancel removes t from the queue. It returns false if t is no longer queued.
+func (s *Scheduler) cancel(t *Ticket) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t.started || t.finished {
+		return false
+	}
+
+	q := s.queues[t.client]
+	for i := range q {
+		if q[i] != t {
+			continue
+		}
+		q = append(q[:i:i], q[i+1:]...)
+		break
+	}
+	if len(q) > 0 {
+		s.queues[t.client] = q
+	} else {
+		delete(s.queues, t.client)
+		for i := range s.ring {
+			if s.ring[i] == t.client {
+				s.ring = append(s.ring[:i:i], s.ring[i+1:]...)
+				break
+			}
+		}
+	}
+	s.queued--
+
+	t.finish(context.Canceled)
+	return true
+}
@@ -0,0 +1,229 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blocked returns a scheduler with a single worker that is busy until the
+// returned function is called.
+func blocked(t *testing.T, cfg Config) (*Scheduler, func()) {
+	t.Helper()
+	cfg.Workers = 1
+	s := New(cfg)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	if _, err := s.Submit("busy", func() {
+		close(started)
+		<-release
+	}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	var once sync.Once
+	unblock := func() { once.Do(func() { close(release) }) }
+	t.Cleanup(func() {
+		unblock()
+		s.Close()
+	})
+	return s, unblock
+}
+
+func TestFairness(t *testing.T) {
+	s, unblock := blocked(t, Config{})
+
+	var (
+		mu    sync.Mutex
+		order []string
+	)
+	run := func(name string) func() {
+		return func() {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+		}
+	}
+
+	// A submits a burst before B and C submit anything.
+	var tickets []*Ticket
+	for _, name := range []string{"a1", "a2", "a3", "a4", "b1", "b2", "c1"} {
+		tk, err := s.Submit(name[:1], run(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tickets = append(tickets, tk)
+	}
+
+	positions := []int{1, 4, 6, 7, 2, 5, 3}
+	for i, tk := range tickets {
+		if got := tk.Position(); got != positions[i] {
+			t.Errorf("ticket %d: position %d, want %d", i, got, positions[i])
+		}
+	}
+	if got := s.Position("b"); got != 2 {
+		t.Errorf("Position(b) = %d, want 2", got)
+	}
+	if got := s.Position("d"); got != 0 {
+		t.Errorf("Position(d) = %d, want 0", got)
+	}
+
+	unblock()
+	for _, tk := range tickets {
+		<-tk.Done()
+		if err := tk.Err(); err != nil {
+			t.Errorf("ticket: %v", err)
+		}
+	}
+
+	want := []string{"a1", "b1", "c1", "a2", "b2", "a3", "a4"}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(order) != len(want) {
+		t.Fatalf("order %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("order %v, want %v", order, want)
+		}
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		clients []string
+		full    int // index of the first submission that is turned away
+	}{
+		{"queue", Config{MaxQueue: 3}, []string{"a", "b", "c", "d"}, 3},
+		{"client queue", Config{MaxQueue: 10, MaxClientQueue: 2}, []string{"a", "b", "a", "a"}, 3},
+		{"other clients of a full client", Config{MaxQueue: 10, MaxClientQueue: 1}, []string{"a", "b", "c", "a"}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := blocked(t, tt.cfg)
+			for i, client := range tt.clients {
+				_, err := s.Submit(client, func() {})
+				var queueFull *QueueFullError
+				switch {
+				case i < tt.full && err != nil:
+					t.Fatalf("submission %d: %v", i, err)
+				case i == tt.full && !errors.As(err, &queueFull):
+					t.Fatalf("submission %d: error %v, want a QueueFullError", i, err)
+				case i == tt.full && queueFull.RetryAfter < time.Second:
+					t.Errorf("RetryAfter %v, want at least a second", queueFull.RetryAfter)
+				}
+			}
+			if queued, running := s.Stats(); queued != tt.full || running != 1 {
+				t.Errorf("Stats() = %d, %d, want %d, 1", queued, running, tt.full)
+			}
+		})
+	}
+}
+
+func TestCancel(t *testing.T) {
+	s, unblock := blocked(t, Config{})
+
+	ran := make(chan string, 3)
+	a, _ := s.Submit("a", func() { ran <- "a" })
+	b, _ := s.Submit("b", func() { ran <- "b" })
+	a2, _ := s.Submit("a", func() { ran <- "a2" })
+
+	if !a.Cancel() {
+		t.Fatal("Cancel of a queued ticket failed")
+	}
+	<-a.Done()
+	if err := a.Err(); !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled ticket: %v", err)
+	}
+	if a.Cancel() {
+		t.Error("second Cancel succeeded")
+	}
+	// a keeps its turn for its next run.
+	if got := a2.Position(); got != 1 {
+		t.Errorf("position after a cancel: %d, want 1", got)
+	}
+	if queued, _ := s.Stats(); queued != 2 {
+		t.Errorf("%d queued, want 2", queued)
+	}
+
+	unblock()
+	<-b.Done()
+	<-a2.Done()
+	if b.Cancel() {
+		t.Error("Cancel of a finished ticket succeeded")
+	}
+	close(ran)
+	var got []string
+	for name := range ran {
+		got = append(got, name)
+	}
+	if len(got) != 2 || got[0] != "a2" || got[1] != "b" {
+		t.Errorf("ran %v, want [a2 b]", got)
+	}
+}
+
+func TestDo(t *testing.T) {
+	s, unblock := blocked(t, Config{})
+
+	// A run abandoned while queued is not run.
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	ran := false
+	go func() {
+		errc <- s.Do(ctx, "a", func() { ran = true })
+	}()
+	for s.Position("a") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Errorf("Do with a canceled context: %v", err)
+	}
+
+	unblock()
+	if err := s.Do(context.Background(), "a", func() {}); err != nil {
+		t.Errorf("Do: %v", err)
+	}
+	if ran {
+		t.Error("abandoned run was run")
+	}
+}
+
+func TestClose(t *testing.T) {
+	s := New(Config{Workers: 1})
+	release := make(chan struct{})
+	started := make(chan struct{})
+	running, _ := s.Submit("a", func() {
+		close(started)
+		<-release
+	})
+	<-started
+	queued, _ := s.Submit("b", func() {})
+
+	closed := make(chan struct{})
+	go func() {
+		s.Close()
+		close(closed)
+	}()
+	<-queued.Done()
+	if err := queued.Err(); err != ErrClosed {
+		t.Errorf("queued run: %v, want ErrClosed", err)
+	}
+	select {
+	case <-closed:
+		t.Fatal("Close returned before the run in progress finished")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	<-closed
+	if err := running.Err(); err != nil {
+		t.Errorf("run in progress: %v", err)
+	}
+	if _, err := s.Submit("c", func() {}); err != ErrClosed {
+		t.Errorf("Submit after Close: %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,53 @@
+package scheduler
+
+// Ticket tracks a run submitted to a Scheduler.
+type Ticket struct {
+	s      *Scheduler
+	client string
+	fn     func()
+	done   chan struct{}
+
+	// The following fields are guarded by s.mu.
+	started  bool
+	finished bool
+	err      error
+}
+
+// Done returns a channel that is closed when the run finishes or is
+// discarded.
+func (t *Ticket) Done() <-chan struct{} {
+	return t.done
+}
+
+// Err returns the reason the run was discarded, if it was.
+func (t *Ticket) Err() error {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+
+	return t.err
+}
+
+// Position returns the position of the run in the queue, or zero if it is no
+// longer queued.
+func (t *Ticket) Position() int {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+
+	if t.started || t.finished {
+		return 0
+	}
+	return t.s.position(t)
+}
+
+// Cancel removes the run from the queue. It returns false if the run has
+// already started.
+func (t *Ticket) Cancel() bool {
+	return t.s.cancel(t)
+}
+
+// finish must be called with s.mu held.
+func (t *Ticket) finish(err error) {
+	t.finished = true
+	t.err = err
+	close(t.done)
+}
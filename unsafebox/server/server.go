@@ -0,0 +1,344 @@
+// Package server implements the HTTP interface of the compile service.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/upper/upper.io/unsafebox/analysis"
+	"github.com/upper/upper.io/unsafebox/audit"
+	"github.com/upper/upper.io/unsafebox/health"
+	"github.com/upper/upper.io/unsafebox/sandbox"
+	"github.com/upper/upper.io/unsafebox/scheduler"
+	"github.com/upper/upper.io/unsafebox/sqltrace"
+	"github.com/upper/upper.io/unsafebox/txtar"
+)
+
+// maxBodySize is the maximum size of a compile request.
+const maxBodySize = 64 << 10
+
+// busyMessage is the error of the requests turned away because the queue is
+// full.
+const busyMessage = "The sandbox is busy, please try again in a few seconds."
+
+// Config configures the optional parts of a server.
+type Config struct {
+	// Policy, if not nil, screens programs before they are queued.
+	Policy *analysis.Policy
+	// Health, if not nil, holds the checks /readyz reports.
+	Health *health.Checker
+	// Audit, if not nil, records every program submitted to /compile.
+	Audit *audit.Log
+	// Pool, if not nil, is the pool of run environments /pool reports on.
+	Pool *sandbox.Pool
+}
+
+// Server handles compile requests.
+type Server struct {
+	runner    sandbox.Runner
+	scheduler *scheduler.Scheduler
+	policy    *analysis.Policy
+	health    *health.Checker
+	audit     *audit.Log
+	pool      *sandbox.Pool
+	mux       *http.ServeMux
+}
+
+// New creates a server that runs programs with runner. Runs are admitted
+// through sched.
+func New(runner sandbox.Runner, sched *scheduler.Scheduler, conf Config) *Server {
+	s := &Server{
+		runner:    runner,
+		scheduler: sched,
+		policy:    conf.Policy,
+		health:    conf.Health,
+		audit:     conf.Audit,
+		pool:      conf.Pool,
+		mux:       http.NewServeMux(),
+	}
+	s.mux.HandleFunc("/compile", s.handleCompile)
+	s.mux.HandleFunc("/compile/stream", s.handleCompileStream)
+	s.mux.HandleFunc("/queue", s.handleQueue)
+	s.mux.HandleFunc("/fmt", s.handleFmt)
+	s.mux.HandleFunc("/vet", s.handleVet)
+	s.mux.HandleFunc("/healthz", s.handleHealthz)
+	s.mux.HandleFunc("/readyz", s.handleReadyz)
+	if s.pool != nil {
+		s.mux.HandleFunc("/pool", s.handlePool)
+	}
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+type compileResponse struct {
+	Errors    string
+	Events    []sandbox.Event
+	Status    int
+	SQL       []sqltrace.Query  `json:",omitempty"`
+	Plans     []json.RawMessage `json:",omitempty"`
+	GoVersion string            `json:",omitempty"`
+	// Policy holds what the screening of the program found.
+	Policy []analysis.Diagnostic `json:",omitempty"`
+	// Diagnostics holds the errors of a program that did not build, with
+	// their positions in the submitted source and hints to fix them.
+	Diagnostics []analysis.Diagnostic `json:",omitempty"`
+}
+
+func (s *Server) handleCompile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req := parseRequest(w, r)
+	if req == nil {
+		return
+	}
+	policy, ok := s.screen(req)
+	if !ok {
+		s.record(r, req, time.Now(), nil, audit.OutcomeRejected)
+		writeJSON(w, http.StatusOK, compileResponse{
+			Errors: formatDiagnostics(policy),
+			Policy: policy,
+		})
+		return
+	}
+
+	var (
+		res    *sandbox.Result
+		runErr error
+		start  = time.Now()
+	)
+	err := s.scheduler.Do(r.Context(), clientID(r), func() {
+		start = time.Now()
+		res, runErr = s.runner.Run(r.Context(), req)
+	})
+	if err == nil {
+		err = runErr
+	}
+	if err != nil {
+		if writeQueueFull(w, err) {
+			s.record(r, req, start, nil, audit.OutcomeBusy)
+			return
+		}
+		if r.Context().Err() != nil {
+			s.record(r, req, start, nil, audit.OutcomeCanceled)
+			return
+		}
+		s.record(r, req, start, nil, audit.OutcomeError)
+		log.Printf("compile: %v", err)
+		writeJSON(w, http.StatusInternalServerError, compileResponse{
+			Errors: "Could not run program.",
+		})
+		return
+	}
+
+	s.record(r, req, start, res, "")
+	errText, diags := buildErrors(req, res)
+	writeJSON(w, http.StatusOK, compileResponse{
+		Errors:      errText,
+		Events:      res.Events,
+		Status:      res.Status,
+		SQL:         res.SQL,
+		Plans:       res.Plans,
+		GoVersion:   res.GoVersion,
+		Policy:      policy,
+		Diagnostics: diags,
+	})
+}
+
+// screen checks a program against the policy. It returns what was found, and
+// whether the program may run.
+func (s *Server) screen(req *sandbox.Request) ([]analysis.Diagnostic, bool) {
+	if s.policy == nil {
+		return nil, true
+	}
+	files, err := txtar.Program([]byte(req.Body))
+	if err != nil {
+		// The runner reports broken archives.
+		return nil, true
+	}
+	diags := s.policy.Screen(files)
+	analysis.Annotate(diags, req.Body)
+	return diags, !analysis.Rejected(diags)
+}
+
+// buildErrors returns the errors of a program that did not build, followed
+// by hints for the ones that have them, and the same errors as diagnostics.
+func buildErrors(req *sandbox.Request, res *sandbox.Result) (string, []analysis.Diagnostic) {
+	if res.Errors == "" || res.Events != nil {
+		return res.Errors, nil
+	}
+	diags := analysis.CompileErrors(res.Errors)
+	analysis.Annotate(diags, req.Body)
+	return res.Errors + analysis.FormatHints(diags), diags
+}
+
+// record adds a run to the audit log. The outcome of runs that completed is
+// taken from res, otherwise it is given by outcome.
+func (s *Server) record(r *http.Request, req *sandbox.Request, start time.Time, res *sandbox.Result, outcome string) {
+	if s.audit == nil {
+		return
+	}
+	rec := s.audit.NewRecord(clientID(r), req.Body, start)
+	if res != nil {
+		rec.SetResult(res)
+	} else {
+		rec.Outcome = outcome
+	}
+	if err := s.audit.Append(rec); err != nil {
+		log.Printf("audit: %v", err)
+	}
+}
+
+// formatDiagnostics formats diagnostics like compiler errors.
+func formatDiagnostics(diags []analysis.Diagnostic) string {
+	var b strings.Builder
+	for _, d := range diags {
+		switch {
+		case d.Line > 0:
+			fmt.Fprintf(&b, "%s:%d:%d: %s\n", d.File, d.Line, d.Column, d.Message)
+		case d.File != "":
+			fmt.Fprintf(&b, "%s: %s\n", d.File, d.Message)
+		default:
+			fmt.Fprintf(&b, "%s\n", d.Message)
+		}
+	}
+	return b.String()
+}
+
+type queueResponse struct {
+	// Position is the position of the client's next queued run, zero if it
+	// has none.
+	Position int
+	Queued   int
+	Running  int
+}
+
+func (s *Server) handleQueue(w http.ResponseWriter, r *http.Request) {
+	queued, running := s.scheduler.Stats()
+	writeJSON(w, http.StatusOK, queueResponse{
+		Position: s.scheduler.Position(clientID(r)),
+		Queued:   queued,
+		Running:  running,
+	})
+}
+
+type healthResponse struct {
+	Status string
+}
+
+// handleHealthz replies as long as the service is up.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, healthResponse{Status: health.StatusOK})
+}
+
+// handleReadyz reports whether the service can build programs against every
+// version of upper/db and reach the demo databases, with 503 Service
+// Unavailable if it cannot.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if s.health == nil {
+		writeJSON(w, http.StatusOK, healthResponse{Status: health.StatusOK})
+		return
+	}
+	report := s.health.Report(r.Context())
+	status := http.StatusOK
+	if !report.OK() {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
+
+// handlePool reports the metrics of the pool of run environments.
+func (s *Server) handlePool(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.pool.Stats())
+}
+
+// parseRequest reads the program from the body form value. With trace=sql,
+// the statements logged by upper/db are returned apart from the output. With
+// mode=explain, the plans of the SELECT statements of the program are
+// returned too. The go form value selects the Go toolchain, like 1.17; the
+// version form value is the version of the playground protocol, which clients
+// send as 2, and is not read.
+//
+// If the form cannot be read, parseRequest replies with an error and returns
+// nil.
+func parseRequest(w http.ResponseWriter, r *http.Request) *sandbox.Request {
+	if !parseForm(w, r) {
+		return nil
+	}
+	return &sandbox.Request{
+		Body:      r.FormValue("body"),
+		TraceSQL:  r.FormValue("trace") == "sql",
+		Explain:   r.FormValue("mode") == "explain",
+		GoVersion: r.FormValue("go"),
+	}
+}
+
+// parseForm parses the form of r, whose body may be up to maxBodySize bytes.
+// If it cannot, it replies with 413 Request Entity Too Large for a body that
+// is too large, or 400 Bad Request, and returns false.
+func parseForm(w http.ResponseWriter, r *http.Request) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	err := r.ParseForm()
+	if err == nil {
+		return true
+	}
+	// The error of http.MaxBytesReader is not typed before Go 1.19.
+	if r.ContentLength > maxBodySize || strings.Contains(err.Error(), "request body too large") {
+		http.Error(w, fmt.Sprintf("request body larger than %d bytes", maxBodySize), http.StatusRequestEntityTooLarge)
+		return false
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+	return false
+}
+
+// writeQueueFull replies with 429 Too Many Requests if err is a
+// *scheduler.QueueFullError. It reports whether it did.
+func writeQueueFull(w http.ResponseWriter, err error) bool {
+	var queueFull *scheduler.QueueFullError
+	if !errors.As(err, &queueFull) {
+		return false
+	}
+	w.Header().Set("Retry-After", retryAfter(queueFull))
+	writeJSON(w, http.StatusTooManyRequests, compileResponse{
+		Errors: busyMessage,
+	})
+	return true
+}
+
+// clientID identifies the client that sent r. Requests are expected to come
+// through the front, which sets X-Real-IP.
+func clientID(r *http.Request) string {
+	if ip := r.Header.Get("X-Real-IP"); ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func retryAfter(err *scheduler.QueueFullError) string {
+	return strconv.Itoa(int(math.Ceil(err.RetryAfter.Seconds())))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("write response: %v", err)
+	}
+}
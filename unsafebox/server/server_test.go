@@ -0,0 +1,200 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/upper/upper.io/unsafebox/audit"
+	"github.com/upper/upper.io/unsafebox/sandbox"
+	"github.com/upper/upper.io/unsafebox/scheduler"
+)
+
+// fakeRunner records the requests it gets, and runs every program with the
+// same result.
+type fakeRunner struct {
+	mu   sync.Mutex
+	reqs []*sandbox.Request
+	run  func(ctx context.Context, req *sandbox.Request) (*sandbox.Result, error)
+}
+
+func (f *fakeRunner) Run(ctx context.Context, req *sandbox.Request) (*sandbox.Result, error) {
+	f.mu.Lock()
+	f.reqs = append(f.reqs, req)
+	f.mu.Unlock()
+	if f.run != nil {
+		return f.run(ctx, req)
+	}
+	return &sandbox.Result{Events: []sandbox.Event{{Kind: "stdout", Message: "hello\n"}}}, nil
+}
+
+func (f *fakeRunner) requests() []*sandbox.Request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*sandbox.Request(nil), f.reqs...)
+}
+
+func newServer(t *testing.T, runner sandbox.Runner, conf Config) *Server {
+	t.Helper()
+	sched := scheduler.New(scheduler.Config{Workers: 1, MaxQueue: 10, MaxClientQueue: 10})
+	t.Cleanup(sched.Close)
+	return New(runner, sched, conf)
+}
+
+func post(s http.Handler, path string, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+func TestBodyTooLarge(t *testing.T) {
+	runner := &fakeRunner{}
+	s := newServer(t, runner, Config{})
+	body := "package main\n\n// " + strings.Repeat("x", maxBodySize) + "\nfunc main() {}\n"
+
+	for _, path := range []string{"/compile", "/compile/stream", "/fmt", "/vet"} {
+		w := post(s, path, url.Values{"body": {body}})
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: status %d, want %d", path, w.Code, http.StatusRequestEntityTooLarge)
+		}
+	}
+	if reqs := runner.requests(); len(reqs) != 0 {
+		t.Errorf("ran %d programs, want none", len(reqs))
+	}
+
+	if w := post(s, "/compile", url.Values{"body": {"package main\n\nfunc main() {}\n"}}); w.Code != http.StatusOK {
+		t.Errorf("/compile: status %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestPlaygroundForm(t *testing.T) {
+	root := t.TempDir()
+	for dir, version := range map[string]string{
+		"usr/local/go":                "go1.17.13",
+		"usr/local/toolchains/go1.16": "go1.16.15",
+	} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, dir, "VERSION"), []byte(version+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	toolchains, err := sandbox.FindToolchains(root, "/usr/local/toolchains")
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner := &fakeRunner{
+		run: func(ctx context.Context, req *sandbox.Request) (*sandbox.Result, error) {
+			tc, err := toolchains.Lookup(req.GoVersion)
+			if err != nil {
+				return nil, err
+			}
+			return &sandbox.Result{GoVersion: tc.Version}, nil
+		},
+	}
+	s := newServer(t, runner, Config{})
+
+	tests := []struct {
+		name      string
+		form      string
+		goVersion string
+	}{
+		// As the playground and the tour send it.
+		{"playground", "version=2&body=package+main%0A%0Afunc+main()+%7B%7D%0A&withVet=true", "go1.17.13"},
+		{"toolchain", "version=2&go=1.16&body=package+main%0A%0Afunc+main()+%7B%7D%0A", "go1.16.15"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/compile", strings.NewReader(tt.form))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+			if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"GoVersion":"`+tt.goVersion+`"`) {
+				t.Errorf("status %d: %s, want %s", w.Code, w.Body, tt.goVersion)
+			}
+		})
+	}
+}
+
+func TestAuditCanceled(t *testing.T) {
+	started := make(chan struct{}, 2)
+	runner := &fakeRunner{
+		run: func(ctx context.Context, req *sandbox.Request) (*sandbox.Result, error) {
+			started <- struct{}{}
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+	dir := t.TempDir()
+	log, err := audit.Open(dir, audit.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	s := newServer(t, runner, Config{Audit: log})
+
+	// The client goes away while the program runs.
+	for _, path := range []string{"/compile", "/compile/stream"} {
+		ctx, cancel := context.WithCancel(context.Background())
+		r := httptest.NewRequest("POST", path, strings.NewReader("body=package+main"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r = r.WithContext(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			s.ServeHTTP(httptest.NewRecorder(), r)
+		}()
+		<-started
+		cancel()
+		<-done
+	}
+
+	var outcomes []string
+	err = audit.Read(dir, time.Time{}, func(rec *audit.Record) error {
+		outcomes = append(outcomes, rec.Outcome)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outcomes) != 2 || outcomes[0] != audit.OutcomeCanceled || outcomes[1] != audit.OutcomeCanceled {
+		t.Errorf("outcomes %q, want two %q", outcomes, audit.OutcomeCanceled)
+	}
+}
+
+func TestToolsAdmission(t *testing.T) {
+	s := newServer(t, &fakeRunner{}, Config{})
+	program := url.Values{"body": {"package main\n\nimport \"fmt\"\n\nfunc main() { fmt.Println(1) }\n"}}
+
+	if w := post(s, "/fmt", program); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"Body":"package main`) {
+		t.Errorf("/fmt: status %d: %s", w.Code, w.Body)
+	}
+	if w := post(s, "/vet", program); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"Diagnostics":[]`) {
+		t.Errorf("/vet: status %d: %s", w.Code, w.Body)
+	}
+
+	// The only worker is busy, and the queue is full.
+	release := make(chan struct{})
+	defer close(release)
+	for {
+		if _, err := s.scheduler.Submit("192.0.2.1", func() { <-release }); err != nil {
+			break
+		}
+	}
+	for _, path := range []string{"/fmt", "/vet"} {
+		w := post(s, path, program)
+		if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+			t.Errorf("%s with a full queue: status %d, Retry-After %q", path, w.Code, w.Header().Get("Retry-After"))
+		}
+	}
+}
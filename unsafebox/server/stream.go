@@ -0,0 +1,226 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/upper/upper.io/unsafebox/analysis"
+	"github.com/upper/upper.io/unsafebox/audit"
+	"github.com/upper/upper.io/unsafebox/sandbox"
+	"github.com/upper/upper.io/unsafebox/sqltrace"
+)
+
+// queuePollInterval is how often the position of a queued run is checked
+// while streaming.
+const queuePollInterval = time.Second
+
+// The following types are sent as the data of server-sent events.
+type (
+	queuedEvent struct {
+		Position int
+	}
+
+	compileStartedEvent struct {
+		Time time.Time
+	}
+
+	outputEvent struct {
+		Message string
+		Delay   time.Duration
+		Time    time.Time
+	}
+
+	sqlEvent struct {
+		sqltrace.Query
+		Time time.Time
+	}
+
+	exitEvent struct {
+		Errors    string
+		Status    int
+		Plans     []json.RawMessage     `json:",omitempty"`
+		GoVersion string                `json:",omitempty"`
+		Policy    []analysis.Diagnostic `json:",omitempty"`
+		// Diagnostics is like compileResponse.Diagnostics.
+		Diagnostics []analysis.Diagnostic `json:",omitempty"`
+		Duration    time.Duration
+		Time        time.Time
+	}
+)
+
+// handleCompileStream is like handleCompile, but it sends the progress of the
+// run as server-sent events instead of waiting for the program to exit:
+//
+//	queued           the run is waiting for a worker
+//	compile-started  a worker picked up the run
+//	stdout, stderr   a chunk of output written by the program
+//	sql              a statement executed by the program, with trace=sql
+//	exit             the run finished
+//	error            the run could not be completed
+func (s *Server) handleCompileStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	events, err := newEventStream(w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	req := parseRequest(w, r)
+	if req == nil {
+		return
+	}
+	policy, ok := s.screen(req)
+	if !ok {
+		s.record(r, req, time.Now(), nil, audit.OutcomeRejected)
+		events.send("exit", exitEvent{
+			Errors: formatDiagnostics(policy),
+			Policy: policy,
+			Time:   time.Now(),
+		})
+		return
+	}
+	req.Stream = func(ev sandbox.Event) {
+		events.send(ev.Kind, outputEvent{
+			Message: ev.Message,
+			Delay:   ev.Delay,
+			Time:    time.Now(),
+		})
+	}
+	req.StreamSQL = func(q sqltrace.Query) {
+		events.send("sql", sqlEvent{Query: q, Time: time.Now()})
+	}
+
+	var (
+		res    *sandbox.Result
+		runErr error
+		start  time.Time
+	)
+	ticket, err := s.scheduler.Submit(clientID(r), func() {
+		start = time.Now()
+		events.send("compile-started", compileStartedEvent{Time: start})
+		res, runErr = s.runner.Run(r.Context(), req)
+	})
+	if err != nil {
+		if writeQueueFull(w, err) {
+			s.record(r, req, time.Now(), nil, audit.OutcomeBusy)
+		} else {
+			s.record(r, req, time.Now(), nil, audit.OutcomeCanceled)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		}
+		return
+	}
+
+	events.open()
+
+	ticker := time.NewTicker(queuePollInterval)
+	defer ticker.Stop()
+
+	position := 0
+	for done := false; !done; {
+		if p := ticket.Position(); p > 0 && p != position {
+			position = p
+			events.send("queued", queuedEvent{Position: p})
+		}
+		select {
+		case <-ticket.Done():
+			done = true
+		case <-r.Context().Done():
+			// The run is recorded even though nobody gets its result:
+			// runs abandoned by their clients are worth auditing.
+			if ticket.Cancel() {
+				s.record(r, req, time.Now(), nil, audit.OutcomeCanceled)
+				return
+			}
+			<-ticket.Done()
+			s.record(r, req, start, nil, audit.OutcomeCanceled)
+			return
+		case <-ticker.C:
+		}
+	}
+
+	if err := ticket.Err(); err != nil {
+		// The scheduler was closed before the run started.
+		s.record(r, req, time.Now(), nil, audit.OutcomeCanceled)
+		events.send("error", compileResponse{Errors: "Could not run program."})
+		return
+	}
+	if runErr != nil {
+		s.record(r, req, start, nil, audit.OutcomeError)
+		log.Printf("compile: %v", runErr)
+		events.send("error", compileResponse{Errors: "Could not run program."})
+		return
+	}
+
+	s.record(r, req, start, res, "")
+	errText, diags := buildErrors(req, res)
+	events.send("exit", exitEvent{
+		Errors:      errText,
+		Status:      res.Status,
+		Plans:       res.Plans,
+		GoVersion:   res.GoVersion,
+		Policy:      policy,
+		Diagnostics: diags,
+		Duration:    time.Since(start),
+		Time:        time.Now(),
+	})
+}
+
+// eventStream writes server-sent events. It is safe for concurrent use.
+type eventStream struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+	opened  bool
+}
+
+func newEventStream(w http.ResponseWriter) (*eventStream, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, fmt.Errorf("streaming is not supported")
+	}
+	return &eventStream{w: w, flusher: flusher}, nil
+}
+
+// open sends the response headers, unless an event already did.
+func (e *eventStream) open() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.writeHeader()
+}
+
+func (e *eventStream) writeHeader() {
+	if e.opened {
+		return
+	}
+	h := e.w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	// Disable response buffering on the front.
+	h.Set("X-Accel-Buffering", "no")
+	e.w.WriteHeader(http.StatusOK)
+	e.opened = true
+}
+
+func (e *eventStream) send(event string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("stream: %v", err)
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.writeHeader()
+	fmt.Fprintf(e.w, "event: %s\ndata: %s\n\n", event, data)
+	e.flusher.Flush()
+}
@@ -0,0 +1,241 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/upper/upper.io/unsafebox/sandbox"
+	"github.com/upper/upper.io/unsafebox/sqltrace"
+)
+
+// event is a server-sent event.
+type event struct {
+	name string
+	data string
+}
+
+// openStream posts a program to /compile/stream of srv, and returns the
+// events it sends, as they come, and a function that hangs up. It hangs up
+// at the end of the test at the latest.
+func openStream(t *testing.T, srv *httptest.Server, form url.Values) (<-chan event, func()) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	r, err := http.NewRequestWithContext(ctx, "POST", srv.URL+"/compile/stream", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := res.Header.Get("Content-Type"); res.StatusCode != http.StatusOK || ct != "text/event-stream" {
+		t.Fatalf("status %d, Content-Type %q", res.StatusCode, ct)
+	}
+
+	events := make(chan event)
+	go func() {
+		defer close(events)
+		defer res.Body.Close()
+		br := bufio.NewReader(res.Body)
+		var ev event
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				ev.name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				ev.data = strings.TrimPrefix(line, "data: ")
+			case line == "":
+				select {
+				case events <- ev:
+				case <-ctx.Done():
+					return
+				}
+				ev = event{}
+			}
+		}
+	}()
+	return events, cancel
+}
+
+// next returns the next event, or fails if the stream ended or nothing came
+// for a while.
+func next(t *testing.T, events <-chan event) event {
+	t.Helper()
+	select {
+	case ev, ok := <-events:
+		if !ok {
+			t.Fatal("the stream ended")
+		}
+		return ev
+	case <-time.After(10 * time.Second):
+		t.Fatal("no event")
+	}
+	return event{}
+}
+
+// started skips the queued events that come before a worker picks up the run.
+func started(t *testing.T, events <-chan event) {
+	t.Helper()
+	for {
+		ev := next(t, events)
+		if ev.name == "compile-started" {
+			return
+		}
+		if ev.name != "queued" {
+			t.Fatalf("event %+v, want compile-started", ev)
+		}
+	}
+}
+
+// occupy keeps the only worker of s busy until the returned function is
+// called.
+func occupy(t *testing.T, s *Server) func() {
+	t.Helper()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	if _, err := s.scheduler.Submit("192.0.2.1", func() {
+		close(started)
+		<-release
+	}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	return func() { close(release) }
+}
+
+func TestStream(t *testing.T) {
+	runner := &fakeRunner{
+		run: func(ctx context.Context, req *sandbox.Request) (*sandbox.Result, error) {
+			req.Stream(sandbox.Event{Kind: "stdout", Message: "hello\n", Delay: time.Millisecond})
+			req.StreamSQL(sqltrace.Query{Statement: "SELECT 1"})
+			req.Stream(sandbox.Event{Kind: "stderr", Message: "bye\n", Delay: 2 * time.Millisecond})
+			return &sandbox.Result{Status: 1, GoVersion: "go1.17.13"}, nil
+		},
+	}
+	s := newServer(t, runner, Config{})
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+
+	release := occupy(t, s)
+	events, _ := openStream(t, srv, url.Values{
+		"body":  {"package main\n\nfunc main() {}\n"},
+		"trace": {"sql"},
+	})
+
+	// The run waits for the worker.
+	if ev := next(t, events); ev.name != "queued" || ev.data != `{"Position":1}` {
+		t.Fatalf("event %+v, want queued at 1", ev)
+	}
+	release()
+
+	var got []string
+	for ev := range events {
+		got = append(got, ev.name)
+		switch ev.name {
+		case "stdout":
+			if !strings.HasPrefix(ev.data, `{"Message":"hello\n","Delay":1000000,"Time":"`) {
+				t.Errorf("stdout: %s", ev.data)
+			}
+		case "sql":
+			if !strings.Contains(ev.data, `"Statement":"SELECT 1"`) {
+				t.Errorf("sql: %s", ev.data)
+			}
+		case "exit":
+			if !strings.HasPrefix(ev.data, `{"Errors":"","Status":1,"GoVersion":"go1.17.13","Duration":`) {
+				t.Errorf("exit: %s", ev.data)
+			}
+		}
+	}
+	if want := []string{"compile-started", "stdout", "sql", "stderr", "exit"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("events %q, want %q", got, want)
+	}
+}
+
+func TestStreamError(t *testing.T) {
+	runner := &fakeRunner{
+		run: func(ctx context.Context, req *sandbox.Request) (*sandbox.Result, error) {
+			return nil, errors.New("no environment")
+		},
+	}
+	srv := httptest.NewServer(newServer(t, runner, Config{}))
+	t.Cleanup(srv.Close)
+
+	w := log.Writer()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(w) })
+
+	events, _ := openStream(t, srv, url.Values{"body": {"package main\n\nfunc main() {}\n"}})
+
+	started(t, events)
+	// The error stays in the log: clients get a generic message.
+	if ev := next(t, events); ev.name != "error" || !strings.HasPrefix(ev.data, `{"Errors":"Could not run program.",`) {
+		t.Errorf("event %+v, want an error", ev)
+	}
+	if ev, ok := <-events; ok {
+		t.Errorf("event %+v after the error", ev)
+	}
+}
+
+func TestStreamHangUp(t *testing.T) {
+	canceled := make(chan error, 1)
+	runner := &fakeRunner{
+		run: func(ctx context.Context, req *sandbox.Request) (*sandbox.Result, error) {
+			<-ctx.Done()
+			canceled <- ctx.Err()
+			return nil, ctx.Err()
+		},
+	}
+	s := newServer(t, runner, Config{})
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	program := url.Values{"body": {"package main\n\nfunc main() {}\n"}}
+
+	// While the program runs, it is stopped.
+	events, hangUp := openStream(t, srv, program)
+	started(t, events)
+	hangUp()
+	select {
+	case err := <-canceled:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("run stopped with %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the run goes on after the client hung up")
+	}
+
+	// While the program is queued, it never runs.
+	release := occupy(t, s)
+	events, hangUp = openStream(t, srv, program)
+	if ev := next(t, events); ev.name != "queued" {
+		t.Fatalf("event %+v, want queued", ev)
+	}
+	hangUp()
+	deadline := time.Now().Add(10 * time.Second)
+	for queued, _ := s.scheduler.Stats(); queued > 0; queued, _ = s.scheduler.Stats() {
+		if time.Now().After(deadline) {
+			t.Fatal("the run is still queued after the client hung up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	release()
+	if n := len(runner.requests()); n != 1 {
+		t.Errorf("%d runs, want 1", n)
+	}
+}
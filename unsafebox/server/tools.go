@@ -0,0 +1,119 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/upper/upper.io/unsafebox/analysis"
+	"github.com/upper/upper.io/unsafebox/scheduler"
+	"github.com/upper/upper.io/unsafebox/txtar"
+)
+
+type fmtResponse struct {
+	Body  string
+	Error string
+}
+
+// handleFmt formats a program, like the /fmt endpoint of the Go playground.
+// With imports=true, missing imports are added and unused ones removed.
+// Programs with several files are formatted file by file and returned as a
+// txtar archive.
+func (s *Server) handleFmt(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !parseForm(w, r) {
+		return
+	}
+	body := r.FormValue("body")
+	fixImports := r.FormValue("imports") == "true"
+
+	var res fmtResponse
+	if !s.admit(w, r, func() { res = format(body, fixImports) }) {
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func format(body string, fixImports bool) fmtResponse {
+	if len(txtar.Parse([]byte(body)).Files) == 0 {
+		out, err := analysis.Format(txtar.ProgramFile, []byte(body), fixImports)
+		if err != nil {
+			return fmtResponse{Error: err.Error()}
+		}
+		return fmtResponse{Body: string(out)}
+	}
+
+	files, err := txtar.Program([]byte(body))
+	if err == nil {
+		files, err = analysis.FormatFiles(files, fixImports)
+	}
+	if err != nil {
+		return fmtResponse{Error: err.Error()}
+	}
+	return fmtResponse{Body: string(txtar.FormatProgram(files))}
+}
+
+type vetResponse struct {
+	Diagnostics []analysis.Diagnostic
+}
+
+// handleVet type checks a program and reports its problems with their
+// positions, without running it. What the policy would reject is reported
+// too.
+func (s *Server) handleVet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !parseForm(w, r) {
+		return
+	}
+	body := r.FormValue("body")
+
+	var diags []analysis.Diagnostic
+	files, err := txtar.Program([]byte(body))
+	if err != nil {
+		diags = []analysis.Diagnostic{{
+			Severity: analysis.SeverityError,
+			Category: "archive",
+			Message:  err.Error(),
+		}}
+	} else {
+		if !s.admit(w, r, func() { diags = analysis.Vet(files) }) {
+			return
+		}
+		if s.policy != nil {
+			diags = append(diags, s.policy.Screen(files)...)
+		}
+	}
+	if diags == nil {
+		diags = []analysis.Diagnostic{}
+	}
+	analysis.Annotate(diags, body)
+	writeJSON(w, http.StatusOK, vetResponse{Diagnostics: diags})
+}
+
+// admit calls fn on a worker of the scheduler, like the runs of /compile, so
+// that formatting and type checking count against the capacity of the
+// service. If fn could not be called, admit replies with an error, unless the
+// client went away, and returns false.
+func (s *Server) admit(w http.ResponseWriter, r *http.Request, fn func()) bool {
+	err := s.scheduler.Do(r.Context(), clientID(r), fn)
+	if err == nil {
+		return true
+	}
+	var queueFull *scheduler.QueueFullError
+	switch {
+	case errors.As(err, &queueFull):
+		w.Header().Set("Retry-After", retryAfter(queueFull))
+		http.Error(w, busyMessage, http.StatusTooManyRequests)
+	case r.Context().Err() != nil:
+	default:
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	}
+	return false
+}
@@ -0,0 +1,247 @@
+// Package sqltrace extracts the statements a program executes from the debug
+// log of upper/db.
+//
+// With db.LC().SetLevel(db.LogLevelDebug), upper/db logs every statement as a
+// block like this one:
+//
+//	2021/03/04 05:06:07 upper/db: log_level=DEBUG file=/go/src/app/main.go:42
+//		Session ID:     00001
+//		Query:          SELECT * FROM "books" WHERE ("id" = $1)
+//		Arguments:      []interface {}{1}
+//		Time taken:     0.00093s
+//		Context:        context.Background
+//
+// A Filter removes those blocks from the output of a program and turns them
+// into Query values.
+package sqltrace
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Query is a statement executed by a program.
+type Query struct {
+	SessionID     uint64
+	TransactionID uint64
+	Statement     string
+	// Arguments is the list of arguments as formatted by upper/db, for
+	// example []interface {}{1, "foo"}.
+	Arguments    string
+	RowsAffected *int64
+	LastInsertID *int64
+	Error        string
+	// Duration is the time the database took to execute the statement.
+	Duration time.Duration
+	// Delay is the time since the program started, set by the caller.
+	Delay time.Duration
+}
+
+const (
+	// datePattern is the prefix added by log.LstdFlags; 0 stands for a digit.
+	datePattern = "0000/00/00 00:00:00 "
+	marker      = "upper/db: log_level="
+)
+
+// Filter is an io.Writer that passes everything it gets to another writer,
+// except for the log blocks of upper/db, which are parsed and passed to a
+// function instead.
+//
+// Output is held back only while it could be part of a log block, so a line
+// written in pieces is still passed on as it is written.
+type Filter struct {
+	w    io.Writer
+	emit func(Query)
+
+	// line is the beginning of a line held back until it is known whether it
+	// starts a log block.
+	line []byte
+	// midLine is set when part of the current line was already passed on.
+	midLine bool
+
+	inBlock bool
+	block   []byte
+	query   Query
+	field   string
+}
+
+// NewFilter creates a filter that writes to w and passes the statements it
+// finds to emit.
+func NewFilter(w io.Writer, emit func(Query)) *Filter {
+	return &Filter{w: w, emit: emit}
+}
+
+// Write implements io.Writer. It always reports len(p) bytes written.
+func (f *Filter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		chunk, complete := p, false
+		if i := bytes.IndexByte(p, '\n'); i >= 0 {
+			chunk, complete = p[:i+1], true
+		}
+		p = p[len(chunk):]
+
+		if f.midLine {
+			f.write(chunk)
+			f.midLine = !complete
+			continue
+		}
+
+		f.line = append(f.line, chunk...)
+		if !complete {
+			if !f.inBlock && !couldBeHeader(f.line) {
+				f.write(f.line)
+				f.line = nil
+				f.midLine = true
+			}
+			continue
+		}
+
+		line := f.line
+		f.line = nil
+		f.handleLine(line)
+	}
+	return n, nil
+}
+
+// Close passes on whatever is still held back. A last line without a newline
+// is handled as a complete one, so the block of a program that exits while
+// logging is still parsed.
+func (f *Filter) Close() error {
+	if len(f.line) > 0 {
+		line := f.line
+		f.line = nil
+		f.handleLine(line)
+	}
+	if f.inBlock {
+		f.endBlock()
+	}
+	return nil
+}
+
+func (f *Filter) handleLine(line []byte) {
+	if f.inBlock {
+		text := strings.TrimRight(string(line), "\r\n")
+		switch {
+		case text == "":
+			// A blank line ends the block and is part of it.
+			f.block = append(f.block, line...)
+			f.endBlock()
+			return
+		case strings.HasPrefix(text, "\t"):
+			f.block = append(f.block, line...)
+			f.parseField(text[1:])
+			return
+		}
+		f.endBlock()
+	}
+
+	if isHeader(line) {
+		f.inBlock = true
+		f.block = append(f.block[:0], line...)
+		return
+	}
+	f.write(line)
+}
+
+// parseField parses a line of a log block. Lines that do not start with a
+// known field continue the previous one.
+func (f *Filter) parseField(text string) {
+	name, value := text, ""
+	if i := strings.IndexByte(text, ':'); i >= 0 {
+		name, value = text[:i], strings.TrimSpace(text[i+1:])
+	}
+
+	q := &f.query
+	switch name {
+	case "Session ID":
+		q.SessionID, _ = strconv.ParseUint(value, 10, 64)
+	case "Transaction ID":
+		q.TransactionID, _ = strconv.ParseUint(value, 10, 64)
+	case "Query":
+		q.Statement = value
+	case "Arguments":
+		q.Arguments = value
+	case "Rows affected":
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			q.RowsAffected = &n
+		}
+	case "Last insert ID":
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			q.LastInsertID = &n
+		}
+	case "Error":
+		q.Error = value
+	case "Time taken":
+		q.Duration, _ = time.ParseDuration(value)
+	case "Context":
+	default:
+		if f.field == "Error" {
+			q.Error += "\n" + strings.TrimSpace(text)
+		}
+		return
+	}
+	f.field = name
+}
+
+// endBlock emits the statement of the current block. Blocks without a
+// statement are other kinds of messages, and are passed on unchanged.
+func (f *Filter) endBlock() {
+	if f.query.Statement != "" {
+		f.emit(f.query)
+	} else {
+		f.write(f.block)
+	}
+	f.inBlock = false
+	f.block = f.block[:0]
+	f.query = Query{}
+	f.field = ""
+}
+
+func (f *Filter) write(p []byte) {
+	if len(p) > 0 {
+		_, _ = f.w.Write(p)
+	}
+}
+
+// isHeader reports whether line is the first line of a log block.
+func isHeader(line []byte) bool {
+	if len(line) >= len(datePattern) && matchesPrefix(line, datePattern) {
+		line = line[len(datePattern):]
+	}
+	return bytes.HasPrefix(line, []byte(marker))
+}
+
+// couldBeHeader reports whether b may be the beginning of a header.
+func couldBeHeader(b []byte) bool {
+	if matchesPrefix(b, marker) {
+		return true
+	}
+	if !matchesPrefix(b, datePattern) {
+		return false
+	}
+	if len(b) <= len(datePattern) {
+		return true
+	}
+	return matchesPrefix(b[len(datePattern):], marker)
+}
+
+// matchesPrefix reports whether b and pattern agree up to the length of the
+// shorter one. A 0 in pattern matches any digit.
+func matchesPrefix(b []byte, pattern string) bool {
+	for i := 0; i < len(b) && i < len(pattern); i++ {
+		if pattern[i] == '0' {
+			if b[i] < '0' || b[i] > '9' {
+				return false
+			}
+			continue
+		}
+		if b[i] != pattern[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,154 @@
+package sqltrace
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const block = "2021/03/04 05:06:07 upper/db: log_level=DEBUG file=/go/src/app/main.go:42\n" +
+	"\tSession ID:     00001\n" +
+	"\tQuery:          SELECT * FROM \"books\" WHERE (\"id\" = $1)\n" +
+	"\tArguments:      []interface {}{1}\n" +
+	"\tTime taken:     0.00093s\n" +
+	"\tContext:        context.Background\n" +
+	"\n"
+
+var selectBook = Query{
+	SessionID: 1,
+	Statement: `SELECT * FROM "books" WHERE ("id" = $1)`,
+	Arguments: "[]interface {}{1}",
+	Duration:  930 * time.Microsecond,
+}
+
+func int64p(n int64) *int64 { return &n }
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		out     string
+		queries []Query
+	}{
+		{"no log", "hello\nworld", "hello\nworld", nil},
+		{"block", "before\n" + block + "after\n", "before\nafter\n", []Query{selectBook}},
+		{"two blocks", block + block, "", []Query{selectBook, selectBook}},
+		{
+			// log.SetFlags(0) leaves the date out.
+			"no date",
+			"upper/db: log_level=DEBUG\n\tQuery: SELECT 1\n\n",
+			"",
+			[]Query{{Statement: "SELECT 1"}},
+		},
+		{
+			"results",
+			"upper/db: log_level=DEBUG\n" +
+				"\tSession ID:     00002\n" +
+				"\tTransaction ID: 00007\n" +
+				"\tQuery:          INSERT INTO \"books\" (\"title\") VALUES ($1) RETURNING \"id\"\n" +
+				"\tRows affected:  1\n" +
+				"\tLast insert ID: 14\n" +
+				"\tTime taken:     0.01s\n\n",
+			"",
+			[]Query{{
+				SessionID:     2,
+				TransactionID: 7,
+				Statement:     `INSERT INTO "books" ("title") VALUES ($1) RETURNING "id"`,
+				RowsAffected:  int64p(1),
+				LastInsertID:  int64p(14),
+				Duration:      10 * time.Millisecond,
+			}},
+		},
+		{
+			"multiline error",
+			"upper/db: log_level=ERROR\n" +
+				"\tQuery:          SELECT * FROM nope\n" +
+				"\tError:          pq: relation \"nope\" does not exist\n" +
+				"\t  at character 15\n" +
+				"\tTime taken:     0.001s\n\n",
+			"",
+			[]Query{{
+				Statement: "SELECT * FROM nope",
+				Error:     "pq: relation \"nope\" does not exist\nat character 15",
+				Duration:  time.Millisecond,
+			}},
+		},
+		{
+			// A block is also ended by a line that is not a field.
+			"no blank line",
+			"upper/db: log_level=DEBUG\n\tQuery: SELECT 1\nafter\n",
+			"after\n",
+			[]Query{{Statement: "SELECT 1"}},
+		},
+		{
+			// Messages of upper/db with no statement are output.
+			"no statement",
+			"upper/db: log_level=WARNING\n\tsession closed\n\nafter\n",
+			"upper/db: log_level=WARNING\n\tsession closed\n\nafter\n",
+			nil,
+		},
+		{
+			"block at the end",
+			"upper/db: log_level=DEBUG\n\tQuery: SELECT 1",
+			"",
+			[]Query{{Statement: "SELECT 1"}},
+		},
+		{
+			"looks like a date",
+			"2021/03/04 05:06:07 something else\n2021/03/04",
+			"2021/03/04 05:06:07 something else\n2021/03/04",
+			nil,
+		},
+		{
+			"marker in a line",
+			"see upper/db: log_level=DEBUG\n\tQuery: SELECT 1\n",
+			"see upper/db: log_level=DEBUG\n\tQuery: SELECT 1\n",
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		// The output is the same however it is written.
+		for _, size := range []int{len(tt.in), 1, 7} {
+			var out bytes.Buffer
+			var queries []Query
+			f := NewFilter(&out, func(q Query) { queries = append(queries, q) })
+			for in := tt.in; len(in) > 0; {
+				n := size
+				if n > len(in) {
+					n = len(in)
+				}
+				if m, err := f.Write([]byte(in[:n])); m != n || err != nil {
+					t.Fatalf("Write = %d, %v", m, err)
+				}
+				in = in[n:]
+			}
+			f.Close()
+			if out.String() != tt.out {
+				t.Errorf("%s, writes of %d bytes: output %q, want %q", tt.name, size, out.String(), tt.out)
+			}
+			if !reflect.DeepEqual(queries, tt.queries) {
+				t.Errorf("%s, writes of %d bytes: queries %+v, want %+v", tt.name, size, queries, tt.queries)
+			}
+		}
+	}
+}
+
+func TestPartialLines(t *testing.T) {
+	// A prompt with no newline is passed on at once, unless it could be the
+	// start of a log block.
+	var out bytes.Buffer
+	f := NewFilter(&out, func(Query) {})
+	for _, tt := range []struct{ write, out string }{
+		{"name? ", "name? "},
+		{"bob", "name? bob"},
+		{"\n2021/03", "name? bob\n"},
+		{"/04 hi", "name? bob\n2021/03/04 hi"},
+		{"\nupper/db", "name? bob\n2021/03/04 hi\n"},
+	} {
+		f.Write([]byte(tt.write))
+		if out.String() != tt.out {
+			t.Errorf("after %q: output %q, want %q", tt.write, out.String(), tt.out)
+		}
+	}
+}
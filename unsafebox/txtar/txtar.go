@@ -0,0 +1,210 @@
+// Package txtar reads and writes the txtar archives programs with several
+// files are submitted as, in the format used by the Go playground:
+//
+//	package main
+//
+//	func main() {
+//		hello()
+//	}
+//	-- hello.go --
+//	package main
+//
+//	import "fmt"
+//
+//	func hello() {
+//		fmt.Println("hello")
+//	}
+//	-- go.mod --
+//	module example
+//
+// Each file starts with a "-- name --" marker line. Text before the first
+// marker belongs to the file prog.go, so a program with a single file is also
+// an archive.
+package txtar
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ProgramFile is the name of the file that holds the text before the first
+// marker.
+const ProgramFile = "prog.go"
+
+// File is a file of an archive.
+type File struct {
+	Name string
+	Data []byte
+}
+
+// Archive is a parsed txtar archive.
+type Archive struct {
+	// Comment is the text before the first marker.
+	Comment []byte
+	Files   []File
+}
+
+var (
+	markerStart = []byte("-- ")
+	markerEnd   = []byte(" --")
+)
+
+// Parse parses an archive. Parsing never fails, text that is not in any file
+// ends up in Comment.
+func Parse(data []byte) *Archive {
+	a := &Archive{}
+	var name string
+	a.Comment, name, data = findMarker(data)
+	for name != "" {
+		f := File{Name: name}
+		f.Data, name, data = findMarker(data)
+		a.Files = append(a.Files, f)
+	}
+	return a
+}
+
+// findMarker returns the text before the next marker line, the name in that
+// marker and the text after it. If there are no more markers, name is empty.
+func findMarker(data []byte) (before []byte, name string, after []byte) {
+	var i int
+	for {
+		if name, after = isMarker(data[i:]); name != "" {
+			return data[:i], name, after
+		}
+		j := bytes.IndexByte(data[i:], '\n')
+		if j < 0 {
+			return data, "", nil
+		}
+		i += j + 1
+	}
+}
+
+// isMarker reports whether data starts with a marker line, returning the
+// name in it and the text after the line.
+func isMarker(data []byte) (name string, after []byte) {
+	if !bytes.HasPrefix(data, markerStart) {
+		return "", nil
+	}
+	line := data
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		line, after = data[:i], data[i+1:]
+	}
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	if !bytes.HasSuffix(line, markerEnd) || len(line) < len(markerStart)+len(markerEnd) {
+		return "", nil
+	}
+	name = strings.TrimSpace(string(line[len(markerStart) : len(line)-len(markerEnd)]))
+	return name, after
+}
+
+// Format returns the serialized form of an archive. Files that do not end
+// with a newline get one, so the next marker starts a line.
+func Format(a *Archive) []byte {
+	var buf bytes.Buffer
+	buf.Write(fixNewline(a.Comment))
+	for _, f := range a.Files {
+		fmt.Fprintf(&buf, "-- %s --\n", f.Name)
+		buf.Write(fixNewline(f.Data))
+	}
+	return buf.Bytes()
+}
+
+func fixNewline(data []byte) []byte {
+	if len(data) == 0 || data[len(data)-1] == '\n' {
+		return data
+	}
+	return append(data[:len(data):len(data)], '\n')
+}
+
+// Program returns the files of a program. The text before the first marker
+// is the file prog.go, unless it is blank. File names must be clean relative
+// slash-separated paths, and there must be at least one .go file.
+func Program(src []byte) ([]File, error) {
+	a := Parse(src)
+
+	var files []File
+	if len(bytes.TrimSpace(a.Comment)) > 0 {
+		files = append(files, File{Name: ProgramFile, Data: a.Comment})
+	}
+	files = append(files, a.Files...)
+
+	seen := make(map[string]bool)
+	hasGo := false
+	for _, f := range files {
+		if err := checkName(f.Name); err != nil {
+			return nil, err
+		}
+		if seen[f.Name] {
+			return nil, fmt.Errorf("duplicate file name %q", f.Name)
+		}
+		seen[f.Name] = true
+		if strings.HasSuffix(f.Name, ".go") {
+			hasGo = true
+		}
+	}
+	if !hasGo {
+		return nil, errors.New("no .go files in program")
+	}
+	return files, nil
+}
+
+// ModulePath returns the path in the module directive of a go.mod file, or ""
+// if it has none.
+func ModulePath(gomod []byte) string {
+	for _, line := range strings.Split(string(gomod), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], "\"`")
+		}
+	}
+	return ""
+}
+
+// StartLines returns, for each file of an archive, the line of src its
+// contents start at, so that positions in the files can be mapped back to
+// src. Lines start at 1. The text before the first marker is reported as
+// ProgramFile.
+func StartLines(src []byte) map[string]int {
+	lines := map[string]int{ProgramFile: 1}
+	for n := 1; len(src) > 0; n++ {
+		if name, _ := isMarker(src); name != "" {
+			lines[name] = n + 1
+		}
+		i := bytes.IndexByte(src, '\n')
+		if i < 0 {
+			break
+		}
+		src = src[i+1:]
+	}
+	return lines
+}
+
+// FormatProgram is the inverse of Program. If the first file is prog.go, it
+// is written without a marker.
+func FormatProgram(files []File) []byte {
+	a := &Archive{Files: files}
+	if len(files) > 0 && files[0].Name == ProgramFile {
+		a.Comment, a.Files = files[0].Data, files[1:]
+	}
+	return Format(a)
+}
+
+func checkName(name string) error {
+	switch {
+	case name == "":
+		return errors.New("empty file name")
+	case path.Clean(name) != name, path.IsAbs(name), name == "..", strings.HasPrefix(name, "../"):
+		return fmt.Errorf("invalid file name %q", name)
+	case strings.ContainsAny(name, "\\:*?\"<>|\x00"):
+		return fmt.Errorf("invalid character in file name %q", name)
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if strings.HasPrefix(elem, ".") {
+			return fmt.Errorf("invalid file name %q: elements must not start with a dot", name)
+		}
+	}
+	return nil
+}
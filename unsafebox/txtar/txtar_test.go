@@ -0,0 +1,166 @@
+package txtar
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProgramNames(t *testing.T) {
+	tests := []struct {
+		name string
+		err  string // empty if the name is valid
+	}{
+		{"hello.go", ""},
+		{"go.mod", ""},
+		{"internal/store/store.go", ""},
+		{"a-b_c.go", ""},
+
+		// Path traversal.
+		{"../hello.go", "invalid file name"},
+		{"..", "invalid file name"},
+		{"a/../../hello.go", "invalid file name"},
+		{"a/../hello.go", "invalid file name"},
+		{"/etc/passwd", "invalid file name"},
+		{"./hello.go", "invalid file name"},
+		{"a//b.go", "invalid file name"},
+		{"a/b/", "invalid file name"},
+		{`..\hello.go`, "invalid character"},
+		{`C:\hello.go`, "invalid character"},
+		{"hello\x00.go", "invalid character"},
+
+		// Hidden files.
+		{".hello.go", "must not start with a dot"},
+		{".git/config", "must not start with a dot"},
+		{"a/.b/c.go", "must not start with a dot"},
+	}
+	for _, tt := range tests {
+		src := "-- " + tt.name + " --\npackage main\n"
+		if !strings.HasSuffix(tt.name, ".go") {
+			src += "-- main.go --\npackage main\n"
+		}
+		_, err := Program([]byte(src))
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%q: %v", tt.name, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%q: error %v, want one with %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestProgram(t *testing.T) {
+	tests := []struct {
+		src   string
+		files []File
+		err   string
+	}{
+		{
+			src:   "package main\n",
+			files: []File{{ProgramFile, []byte("package main\n")}},
+		},
+		{
+			src: "package main\n-- hello.go --\npackage main\n-- go.mod --\nmodule example\n",
+			files: []File{
+				{ProgramFile, []byte("package main\n")},
+				{"hello.go", []byte("package main\n")},
+				{"go.mod", []byte("module example\n")},
+			},
+		},
+		{
+			// Blank text before the first marker is not a file.
+			src:   "\n  \n-- main.go --\npackage main\n",
+			files: []File{{"main.go", []byte("package main\n")}},
+		},
+		{
+			// Markers are trimmed, and may end in \r\n.
+			src:   "--  main.go  --\r\npackage main\n",
+			files: []File{{"main.go", []byte("package main\n")}},
+		},
+		{
+			// Lines that only look like markers are text.
+			src:   "package main\n// -- a.go --\n--a.go--\n-- --\n",
+			files: []File{{ProgramFile, []byte("package main\n// -- a.go --\n--a.go--\n-- --\n")}},
+		},
+		{src: "", err: "no .go files"},
+		{src: "-- go.mod --\nmodule example\n", err: "no .go files"},
+		{src: "-- a.go --\n-- a.go --\n", err: "duplicate file name"},
+		{src: "package main\n-- prog.go --\n", err: "duplicate file name"},
+	}
+	for _, tt := range tests {
+		files, err := Program([]byte(tt.src))
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%q: error %v, want one with %q", tt.src, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.src, err)
+			continue
+		}
+		if !reflect.DeepEqual(files, tt.files) {
+			t.Errorf("%q: files %q, want %q", tt.src, files, tt.files)
+		}
+	}
+}
+
+func TestFormatProgram(t *testing.T) {
+	tests := []struct {
+		files []File
+		want  string
+	}{
+		{[]File{{ProgramFile, []byte("package main\n")}}, "package main\n"},
+		{
+			[]File{{ProgramFile, []byte("package main")}, {"a.go", []byte("package main")}},
+			"package main\n-- a.go --\npackage main\n",
+		},
+		{
+			[]File{{"a.go", []byte("package main\n")}, {"go.mod", nil}},
+			"-- a.go --\npackage main\n-- go.mod --\n",
+		},
+	}
+	for _, tt := range tests {
+		got := string(FormatProgram(tt.files))
+		if got != tt.want {
+			t.Errorf("FormatProgram(%q) = %q, want %q", tt.files, got, tt.want)
+			continue
+		}
+		// What is formatted parses back to the same files, with newlines.
+		files, err := Program([]byte(got))
+		if err != nil {
+			t.Errorf("Program(%q): %v", got, err)
+			continue
+		}
+		if string(FormatProgram(files)) != got {
+			t.Errorf("%q does not round-trip", got)
+		}
+	}
+}
+
+func TestModulePath(t *testing.T) {
+	tests := []struct {
+		gomod string
+		want  string
+	}{
+		{"module example.com/prog\n\ngo 1.17\n", "example.com/prog"},
+		{"// A program.\nmodule \"example.com/prog\"\n", "example.com/prog"},
+		{"  module   prog // comment\n", "prog"},
+		{"go 1.17\n", ""},
+		{"module\n", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := ModulePath([]byte(tt.gomod)); got != tt.want {
+			t.Errorf("ModulePath(%q) = %q, want %q", tt.gomod, got, tt.want)
+		}
+	}
+}
+
+func TestStartLines(t *testing.T) {
+	src := "package main\n\nfunc main() {}\n-- a.go --\npackage main\n-- b/b.go --\n"
+	want := map[string]int{ProgramFile: 1, "a.go": 5, "b/b.go": 7}
+	if got := StartLines([]byte(src)); !reflect.DeepEqual(got, want) {
+		t.Errorf("StartLines = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,315 @@
+// Package check verifies that a vanity server answers for every import path
+// of a path map the way the go command expects: the meta tags of the path and
+// of the packages under it, and the repository and module they point to,
+// resolved end to end.
+package check
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+
+	"github.com/upper/upper.io/vanity/mirror"
+	"github.com/upper/upper.io/vanity/modproxy"
+	"github.com/upper/upper.io/vanity/paths"
+)
+
+// Config configures a check.
+type Config struct {
+	// Host is the host of the import paths, like upper.io.
+	Host string
+
+	// URL is where the server is, like http://127.0.0.1:9001. Requests for
+	// https://upper.io/... are sent there, with upper.io as their host.
+	URL string
+
+	// ModProxy is the URL the server announces its module proxy at, like
+	// https://upper.io/mod.
+	ModProxy string
+
+	// Mirrors are the local mirrors the repositories are resolved against,
+	// by repository URL.
+	Mirrors map[string]*mirror.Repo
+
+	// Client sends the requests. It must not follow redirects.
+	Client *http.Client
+}
+
+// Result is the outcome of the checks of an import path.
+type Result struct {
+	Path   string
+	Passed []string
+	Errors []string
+}
+
+// OK reports whether every check passed.
+func (r *Result) OK() bool {
+	return len(r.Errors) == 0
+}
+
+// Run checks the server for every package of m.
+func Run(ctx context.Context, conf Config, m *paths.Map) []*Result {
+	if conf.Client == nil {
+		conf.Client = &http.Client{
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		}
+	}
+	var results []*Result
+	for _, p := range m.Packages {
+		c := &checker{conf: conf, p: p, res: &Result{Path: p.Path}}
+		c.run(ctx)
+		results = append(results, c.res)
+	}
+	return results
+}
+
+type checker struct {
+	conf Config
+	p    *paths.Package
+	res  *Result
+}
+
+func (c *checker) passf(format string, args ...interface{}) {
+	c.res.Passed = append(c.res.Passed, fmt.Sprintf(format, args...))
+}
+
+func (c *checker) failf(format string, args ...interface{}) {
+	c.res.Errors = append(c.res.Errors, fmt.Sprintf(format, args...))
+}
+
+func (c *checker) run(ctx context.Context) {
+	root, ok := c.checkMeta(ctx, c.p.Path)
+	if !ok {
+		return
+	}
+	// The go command asks for the package it wants, then for the root its
+	// tags point to, and they must agree.
+	if sub, ok := c.checkMeta(ctx, c.p.Path+"/internal/check"); ok && sub != root {
+		c.failf("go-import of %s/internal/check is %v, but the one of %s is %v", c.p.Path, sub, c.p.Path, root)
+	}
+
+	c.checkRepo(ctx)
+	if c.wantMod() {
+		c.checkModule(ctx)
+	}
+	c.checkBrowser(ctx)
+}
+
+// wantMod reports whether the package is announced as a module of the proxy.
+func (c *checker) wantMod() bool {
+	return c.p.Mirror != "" && c.conf.ModProxy != ""
+}
+
+// checkMeta checks the meta tags of the page of importPath, and returns the
+// go-import tag of module mode.
+func (c *checker) checkMeta(ctx context.Context, importPath string) (metaImport, bool) {
+	status, body, err := c.get(ctx, "https://"+importPath+"?go-get=1")
+	if err != nil {
+		c.failf("%s?go-get=1: %v", importPath, err)
+		return metaImport{}, false
+	}
+	if status != http.StatusOK {
+		c.failf("%s?go-get=1: status %d, want %d", importPath, status, http.StatusOK)
+		return metaImport{}, false
+	}
+	tags, err := parseMeta(bytes.NewReader(body))
+	if err != nil {
+		c.failf("%s?go-get=1: %v", importPath, err)
+		return metaImport{}, false
+	}
+
+	ok := true
+	want := metaImport{Prefix: c.p.Path, VCS: c.p.VCS, RepoRoot: c.p.RepoRoot()}
+	if im, err := matchImport(tags.imports, importPath, false); err != nil {
+		c.failf("%s, GOPATH mode: %v", importPath, err)
+		ok = false
+	} else if im != want {
+		c.failf("%s, GOPATH mode: go-import is %q, want %q", importPath, im, want)
+		ok = false
+	}
+
+	if c.wantMod() {
+		want = metaImport{Prefix: c.p.Path, VCS: "mod", RepoRoot: c.conf.ModProxy}
+	}
+	im, err := matchImport(tags.imports, importPath, true)
+	if err != nil {
+		c.failf("%s, module mode: %v", importPath, err)
+		ok = false
+	} else if im != want {
+		c.failf("%s, module mode: go-import is %q, want %q", importPath, im, want)
+		ok = false
+	}
+
+	wantSource := c.p.Repo + "/tree/" + c.p.SourceBranch() + "{/dir}"
+	switch {
+	case len(tags.sources) != 1:
+		c.failf("%s: %d go-source tags, want 1", importPath, len(tags.sources))
+		ok = false
+	case len(tags.sources[0]) != 4 || tags.sources[0][0] != c.p.Path || tags.sources[0][2] != wantSource:
+		c.failf("%s: go-source is %q, want %s %s %s ...", importPath, tags.sources[0], c.p.Path, c.p.Repo, wantSource)
+		ok = false
+	}
+
+	if ok {
+		c.passf("%s: meta tags", importPath)
+	}
+	return im, ok
+}
+
+// checkRepo resolves the branch of the package. Repositories served from
+// upper.io are cloned through the server, and must check out the branch the
+// package is pinned to; the others are looked up in their local mirror.
+func (c *checker) checkRepo(ctx context.Context) {
+	branch := c.p.SourceBranch()
+	repo := c.conf.Mirrors[c.p.Repo]
+
+	var mirrorHash string
+	if repo != nil {
+		commit, err := repo.Resolve(ctx, "refs/heads/"+branch)
+		if err != nil {
+			c.failf("branch %s of the mirror of %s: %v", branch, c.p.Repo, err)
+			return
+		}
+		mirrorHash = commit.Hash
+	}
+
+	root := c.p.RepoRoot()
+	if !strings.HasPrefix(root, "https://"+c.conf.Host+"/") {
+		if repo == nil {
+			c.passf("%s is not mirrored, not resolved", root)
+			return
+		}
+		c.passf("%s: branch %s is %.12s in the mirror", root, branch, mirrorHash)
+		return
+	}
+
+	symref, head, err := c.lsRemote(ctx, c.url(root))
+	if err != nil {
+		c.failf("git ls-remote %s: %v", root, err)
+		return
+	}
+	if symref != "refs/heads/"+branch {
+		c.failf("git ls-remote %s: HEAD is %q, want refs/heads/%s", root, symref, branch)
+		return
+	}
+	if mirrorHash != "" && head != mirrorHash {
+		c.failf("git ls-remote %s: HEAD is %.12s, but branch %s is %.12s in the mirror", root, head, branch, mirrorHash)
+		return
+	}
+	c.passf("git ls-remote %s: HEAD is branch %s, %.12s", root, branch, head)
+}
+
+// lsRemote returns the branch HEAD of a remote points to and its commit.
+func (c *checker) lsRemote(ctx context.Context, url string) (symref, hash string, err error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", "-c", "http.extraHeader=Host: "+c.conf.Host, "ls-remote", "--symref", url, "HEAD")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 3 && fields[0] == "ref:" && fields[2] == "HEAD":
+			symref = fields[1]
+		case len(fields) == 2 && fields[1] == "HEAD":
+			hash = fields[0]
+		}
+	}
+	if hash == "" {
+		return "", "", fmt.Errorf("no HEAD")
+	}
+	return symref, hash, nil
+}
+
+// checkModule downloads the latest version of the package from the module
+// proxy, as the go command would.
+func (c *checker) checkModule(ctx context.Context) {
+	base := c.conf.ModProxy + "/" + c.p.Path
+
+	var info modproxy.Info
+	if err := c.getJSON(ctx, base+"/@latest", &info); err != nil {
+		c.failf("%s@latest: %v", c.p.Path, err)
+		return
+	}
+	if major, ok := modproxy.MajorOf(c.p.Path); ok && !strings.HasPrefix(info.Version, fmt.Sprintf("v%d.", major)) {
+		c.failf("%s@latest is %s, of another major version", c.p.Path, info.Version)
+		return
+	}
+
+	status, mod, err := c.get(ctx, base+"/@v/"+info.Version+".mod")
+	if err != nil || status != http.StatusOK {
+		c.failf("%s@%s: go.mod: status %d, %v", c.p.Path, info.Version, status, err)
+		return
+	}
+	if want := "module " + c.p.Path + "\n"; string(mod) != want {
+		c.failf("%s@%s: go.mod is %q, want %q", c.p.Path, info.Version, mod, want)
+		return
+	}
+
+	status, _, err = c.get(ctx, base+"/@v/"+info.Version+".zip")
+	if err != nil || status != http.StatusOK {
+		c.failf("%s@%s: zip: status %d, %v", c.p.Path, info.Version, status, err)
+		return
+	}
+	c.passf("%s@latest is %s", c.p.Path, info.Version)
+}
+
+// checkBrowser checks that people who visit the package are answered.
+func (c *checker) checkBrowser(ctx context.Context) {
+	if c.p.Docs == "" && c.p.Deprecated == "" {
+		return
+	}
+	status, _, err := c.get(ctx, "https://"+c.p.Path)
+	if err != nil {
+		c.failf("%s: %v", c.p.Path, err)
+		return
+	}
+	if status != http.StatusOK && status != http.StatusFound {
+		c.failf("%s: status %d for browsers, want a page or a redirect", c.p.Path, status)
+		return
+	}
+	c.passf("%s: status %d for browsers", c.p.Path, status)
+}
+
+// url returns where a URL of the host is on the server.
+func (c *checker) url(u string) string {
+	if strings.HasPrefix(u, "https://"+c.conf.Host) {
+		return strings.TrimSuffix(c.conf.URL, "/") + strings.TrimPrefix(u, "https://"+c.conf.Host)
+	}
+	return u
+}
+
+func (c *checker) get(ctx context.Context, u string) (int, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url(u), nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	if strings.HasPrefix(u, "https://"+c.conf.Host) {
+		req.Host = c.conf.Host
+	}
+	res, err := c.conf.Client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, 512<<20))
+	return res.StatusCode, body, err
+}
+
+func (c *checker) getJSON(ctx context.Context, u string, v interface{}) error {
+	status, body, err := c.get(ctx, u)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("status %d: %s", status, bytes.TrimSpace(body))
+	}
+	return json.Unmarshal(body, v)
+}
@@ -0,0 +1,176 @@
+package check
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/upper/upper.io/vanity/githttp"
+	"github.com/upper/upper.io/vanity/internal/gittest"
+	"github.com/upper/upper.io/vanity/mirror"
+	"github.com/upper/upper.io/vanity/modproxy"
+	"github.com/upper/upper.io/vanity/paths"
+	"github.com/upper/upper.io/vanity/server"
+)
+
+const packages = `{
+  "Packages": [
+    {"Path": "upper.io/db", "Repo": "https://github.com/upper/db", "Docs": "https://upper.io/v4/"},
+    {"Path": "upper.io/db.v3", "Repo": "https://github.com/upper/db", "Branch": "3", "Docs": "https://upper.io/db.v3/",
+     "Deprecated": "upper/db v3 is no longer maintained.", "Mirror": "db.git"},
+    {"Path": "upper.io/x", "Repo": "https://github.com/upper/x"}
+  ]
+}`
+
+// newMirror creates a repository with the branches master and 3, with the
+// tag v3.0.0, and mirrors it.
+func newMirror(t *testing.T) *mirror.Repo {
+	t.Helper()
+	src := gittest.New(t)
+	src.Commit(map[string]string{"db.go": "package db\n"})
+	src.Git("branch", "3")
+	src.Git("tag", "v3.0.0")
+	return src.Mirror()
+}
+
+func newMap(t *testing.T) *paths.Map {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "vanity.json")
+	if err := os.WriteFile(name, []byte(packages), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := paths.Load(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// newHandler serves m like the vanity command does, with upper.io/db.v3 read
+// from repo, and the module proxy at /mod unless modProxy is empty.
+func newHandler(m *paths.Map, repo *mirror.Repo, modProxy string) http.Handler {
+	vanity := server.New(m, server.Config{
+		Host:     "upper.io",
+		ModProxy: modProxy,
+		Mirrors:  map[string]*mirror.Repo{"db.git": repo},
+	})
+	git := githttp.New("upper.io", []*githttp.Repo{{Path: "upper.io/db.v3", Branch: "3", Mirror: repo}})
+
+	mux := http.NewServeMux()
+	if modProxy != "" {
+		mux.Handle("/mod/", http.StripPrefix("/mod", modproxy.New([]*modproxy.Module{
+			{Path: "upper.io/db.v3", Major: 3, Branch: "3", Repo: repo},
+		})))
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if githttp.Match(r) {
+			git.ServeHTTP(w, r)
+			return
+		}
+		vanity.ServeHTTP(w, r)
+	})
+	return mux
+}
+
+func TestRun(t *testing.T) {
+	repo := newMirror(t)
+	m := newMap(t)
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+		errors  map[string][]string // by import path
+		passed  map[string]string   // a prefix of a check that passed, by import path
+	}{
+		{
+			name:    "ok",
+			handler: newHandler(m, repo, "https://upper.io/mod"),
+			passed: map[string]string{
+				"upper.io/db":    "https://github.com/upper/db: branch master is ",
+				"upper.io/db.v3": "git ls-remote https://upper.io/db.v3: HEAD is branch 3, ",
+				"upper.io/x":     "https://github.com/upper/x is not mirrored",
+			},
+		},
+		{
+			// The check expects a module proxy the server does not announce.
+			name:    "no module proxy",
+			handler: newHandler(m, repo, ""),
+			errors: map[string][]string{
+				"upper.io/db.v3": {
+					`upper.io/db.v3, module mode: go-import is {"upper.io/db.v3" "git" "https://upper.io/db.v3"}, want {"upper.io/db.v3" "mod" "https://upper.io/mod"}`,
+				},
+			},
+		},
+		{
+			name: "module proxy down",
+			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasPrefix(r.URL.Path, "/mod/") {
+					http.Error(w, "down", http.StatusServiceUnavailable)
+					return
+				}
+				newHandler(m, repo, "https://upper.io/mod").ServeHTTP(w, r)
+			}),
+			errors: map[string][]string{
+				"upper.io/db.v3": {"upper.io/db.v3@latest: status 503: down"},
+			},
+		},
+		{
+			name: "wrong source",
+			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/x/internal/check" {
+					w.Write([]byte(`<html><head><meta name="go-import" content="upper.io/x git https://github.com/upper/y"></head></html>`))
+					return
+				}
+				newHandler(m, repo, "https://upper.io/mod").ServeHTTP(w, r)
+			}),
+			errors: map[string][]string{
+				"upper.io/x": {
+					`upper.io/x/internal/check, GOPATH mode: go-import is {"upper.io/x" "git" "https://github.com/upper/y"}, want {"upper.io/x" "git" "https://github.com/upper/x"}`,
+					`upper.io/x/internal/check, module mode: go-import is {"upper.io/x" "git" "https://github.com/upper/y"}, want {"upper.io/x" "git" "https://github.com/upper/x"}`,
+					"upper.io/x/internal/check: 0 go-source tags, want 1",
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+
+			results := Run(context.Background(), Config{
+				Host:     "upper.io",
+				URL:      srv.URL,
+				ModProxy: "https://upper.io/mod",
+				Mirrors:  map[string]*mirror.Repo{"https://github.com/upper/db": repo},
+			}, m)
+			if len(results) != len(m.Packages) {
+				t.Fatalf("%d results, want %d", len(results), len(m.Packages))
+			}
+			for _, res := range results {
+				want := tt.errors[res.Path]
+				if strings.Join(res.Errors, "\n") != strings.Join(want, "\n") {
+					t.Errorf("%s: errors\n%s\nwant\n%s", res.Path, strings.Join(res.Errors, "\n"), strings.Join(want, "\n"))
+				}
+				if res.OK() != (len(want) == 0) {
+					t.Errorf("%s: OK() = %v", res.Path, res.OK())
+				}
+				if len(want) == 0 && len(res.Passed) == 0 {
+					t.Errorf("%s: no checks passed", res.Path)
+				}
+				if prefix, ok := tt.passed[res.Path]; ok {
+					found := false
+					for _, msg := range res.Passed {
+						found = found || strings.HasPrefix(msg, prefix)
+					}
+					if !found {
+						t.Errorf("%s: passed %q, want one starting with %q", res.Path, res.Passed, prefix)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package check
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// metaImport is a go-import meta tag: <meta name="go-import"
+// content="prefix vcs repoRoot">.
+type metaImport struct {
+	Prefix, VCS, RepoRoot string
+}
+
+// metaTags are the meta tags of a page the go command reads.
+type metaTags struct {
+	imports []metaImport
+	sources [][]string // fields of the go-source tags
+}
+
+// parseMeta reads the meta tags of a page like the go command does: leniently,
+// and only up to the end of the head, where it stops looking.
+func parseMeta(r io.Reader) (*metaTags, error) {
+	d := xml.NewDecoder(r)
+	d.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		switch strings.ToLower(charset) {
+		case "utf-8", "ascii":
+			return input, nil
+		}
+		return nil, fmt.Errorf("can't decode XML document using charset %q", charset)
+	}
+	d.Strict = false
+
+	tags := &metaTags{}
+	for {
+		t, err := d.RawToken()
+		if err != nil {
+			if err == io.EOF || len(tags.imports) > 0 {
+				return tags, nil
+			}
+			return nil, err
+		}
+		if e, ok := t.(xml.StartElement); ok && strings.EqualFold(e.Name.Local, "body") {
+			return tags, nil
+		}
+		if e, ok := t.(xml.EndElement); ok && strings.EqualFold(e.Name.Local, "head") {
+			return tags, nil
+		}
+		e, ok := t.(xml.StartElement)
+		if !ok || !strings.EqualFold(e.Name.Local, "meta") {
+			continue
+		}
+		fields := strings.Fields(attr(e, "content"))
+		switch attr(e, "name") {
+		case "go-import":
+			if len(fields) == 3 {
+				tags.imports = append(tags.imports, metaImport{fields[0], fields[1], fields[2]})
+			}
+		case "go-source":
+			tags.sources = append(tags.sources, fields)
+		}
+	}
+}
+
+func attr(e xml.StartElement, name string) string {
+	for _, a := range e.Attr {
+		if strings.EqualFold(a.Name.Local, name) {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// matchImport returns the go-import tag the go command uses for importPath, in
+// module mode or GOPATH mode. In module mode, tags with the mod VCS come first;
+// in GOPATH mode, they are ignored. More than one match is an error, unless
+// the first one is for mod.
+func matchImport(imports []metaImport, importPath string, modules bool) (metaImport, error) {
+	var ordered []metaImport
+	for _, im := range imports {
+		if im.VCS == "mod" && modules {
+			ordered = append(ordered, im)
+		}
+	}
+	for _, im := range imports {
+		if im.VCS != "mod" {
+			ordered = append(ordered, im)
+		}
+	}
+
+	match := -1
+	for i, im := range ordered {
+		if importPath != im.Prefix && !strings.HasPrefix(importPath, im.Prefix+"/") {
+			continue
+		}
+		if match >= 0 {
+			if ordered[match].VCS == "mod" && im.VCS != "mod" {
+				break
+			}
+			return metaImport{}, fmt.Errorf("multiple meta tags match import path %q", importPath)
+		}
+		match = i
+	}
+	if match < 0 {
+		return metaImport{}, fmt.Errorf("no go-import meta tag matches import path %q", importPath)
+	}
+	return ordered[match], nil
+}
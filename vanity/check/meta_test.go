@@ -0,0 +1,105 @@
+package check
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMeta(t *testing.T) {
+	tests := []struct {
+		name    string
+		page    string
+		imports []metaImport
+		sources [][]string
+	}{
+		{
+			name: "page",
+			page: `<!DOCTYPE html>
+<html>
+  <head>
+    <meta http-equiv="Content-Type" content="text/html; charset=utf-8" />
+    <meta name="go-import" content="upper.io/db.v3 git https://upper.io/db.v3" />
+    <meta name="go-import" content="upper.io/db.v3 mod https://upper.io/mod" />
+    <meta name="go-source" content="upper.io/db.v3 https://github.com/upper/db https://github.com/upper/db/tree/3{/dir} https://github.com/upper/db/blob/3{/dir}/{file}#L{line}" />
+  </head>
+  <body>
+    <meta name="go-import" content="upper.io/x git https://github.com/upper/x" />
+  </body>
+</html>`,
+			imports: []metaImport{
+				{"upper.io/db.v3", "git", "https://upper.io/db.v3"},
+				{"upper.io/db.v3", "mod", "https://upper.io/mod"},
+			},
+			sources: [][]string{{
+				"upper.io/db.v3",
+				"https://github.com/upper/db",
+				"https://github.com/upper/db/tree/3{/dir}",
+				"https://github.com/upper/db/blob/3{/dir}/{file}#L{line}",
+			}},
+		},
+		{
+			// Like the go command, unclosed tags and odd case are fine, and
+			// go-import tags without three fields are skipped.
+			name: "lenient",
+			page: `<html><HEAD><META NAME="go-import" CONTENT="upper.io/db git https://github.com/upper/db"><meta name="go-import" content="upper.io/db git"></HEAD>`,
+			imports: []metaImport{
+				{"upper.io/db", "git", "https://github.com/upper/db"},
+			},
+		},
+		{
+			name: "no head",
+			page: `<html><body>nothing</body></html>`,
+		},
+	}
+	for _, tt := range tests {
+		tags, err := parseMeta(strings.NewReader(tt.page))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(tags.imports, tt.imports) || !reflect.DeepEqual(tags.sources, tt.sources) {
+			t.Errorf("%s: parseMeta = %q, %q, want %q, %q", tt.name, tags.imports, tags.sources, tt.imports, tt.sources)
+		}
+	}
+
+	if _, err := parseMeta(strings.NewReader(`<?xml version="1.0" encoding="latin1"?><html>`)); err == nil {
+		t.Error("parseMeta of a page in latin1 succeeded")
+	}
+}
+
+func TestMatchImport(t *testing.T) {
+	git := metaImport{"upper.io/db.v3", "git", "https://upper.io/db.v3"}
+	mod := metaImport{"upper.io/db.v3", "mod", "https://upper.io/mod"}
+	other := metaImport{"upper.io/db.v3", "git", "https://github.com/upper/db"}
+
+	tests := []struct {
+		imports    []metaImport
+		importPath string
+		modules    bool
+		want       metaImport
+		err        string
+	}{
+		{[]metaImport{git, mod}, "upper.io/db.v3", false, git, ""},
+		{[]metaImport{git, mod}, "upper.io/db.v3", true, mod, ""},
+		{[]metaImport{git, mod}, "upper.io/db.v3/postgresql", true, mod, ""},
+		{[]metaImport{git}, "upper.io/db.v3", true, git, ""},
+		{[]metaImport{mod}, "upper.io/db.v3", false, metaImport{}, "no go-import meta tag"},
+		{[]metaImport{git}, "upper.io/db.v31", false, metaImport{}, "no go-import meta tag"},
+		{[]metaImport{git, other}, "upper.io/db.v3", false, metaImport{}, "multiple meta tags"},
+		{[]metaImport{git, other, mod}, "upper.io/db.v3", true, mod, ""},
+		{[]metaImport{mod, mod}, "upper.io/db.v3", true, metaImport{}, "multiple meta tags"},
+	}
+	for _, tt := range tests {
+		got, err := matchImport(tt.imports, tt.importPath, tt.modules)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("matchImport(%q, %s, %v): %v, want an error with %q", tt.imports, tt.importPath, tt.modules, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("matchImport(%q, %s, %v) = %q, %v, want %q", tt.imports, tt.importPath, tt.modules, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http/httptest"
+	"os"
+	"time"
+
+	"github.com/upper/upper.io/vanity/check"
+	"github.com/upper/upper.io/vanity/mirror"
+	"github.com/upper/upper.io/vanity/paths"
+)
+
+// runCheck checks a running server, or one started in-process, against the
+// path map, and returns the exit code: 1 if any check failed.
+func runCheck(m *paths.Map, args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	var (
+		flagURL     = fs.String("url", "", "URL of a running server to check, like http://127.0.0.1:9001; one is started in-process if empty")
+		flagUpdate  = fs.Bool("update", false, "clone or fetch the mirrors before checking")
+		flagVerbose = fs.Bool("v", false, "list the checks that passed too")
+		flagTimeout = fs.Duration("timeout", 5*time.Minute, "timeout of the whole check")
+	)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: vanity [flags] check [check flags]\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *flagTimeout)
+	defer cancel()
+
+	handler, mirrors, err := newHandler(m)
+	if err != nil {
+		log.Fatal(err)
+	}
+	byURL := make(map[string]*mirror.Repo)
+	for _, repo := range mirrors {
+		if *flagUpdate {
+			if err := repo.Update(ctx); err != nil {
+				log.Fatalf("mirror %s: %v", repo.Dir, err)
+			}
+		}
+		byURL[repo.URL] = repo
+	}
+
+	url := *flagURL
+	if url == "" {
+		srv := httptest.NewServer(handler)
+		defer srv.Close()
+		url = srv.URL
+	}
+
+	results := check.Run(ctx, check.Config{
+		Host:     *flagHost,
+		URL:      url,
+		ModProxy: *flagModProxy,
+		Mirrors:  byURL,
+	}, m)
+
+	code := 0
+	for _, res := range results {
+		if res.OK() {
+			fmt.Printf("ok   %s\n", res.Path)
+		} else {
+			fmt.Printf("FAIL %s\n", res.Path)
+			code = 1
+		}
+		if *flagVerbose {
+			for _, msg := range res.Passed {
+				fmt.Printf("     ok: %s\n", msg)
+			}
+		}
+		for _, msg := range res.Errors {
+			fmt.Printf("     %s\n", msg)
+		}
+	}
+	if code != 0 {
+		fmt.Fprintln(os.Stderr, "vanity check: FAIL")
+	}
+	return code
+}
@@ -0,0 +1,188 @@
+// Command vanity serves the go-get requests for the import paths of upper.io,
+// like upper.io/db.v3, as listed in a config file. The packages with a mirror
+// are also served with the module proxy protocol under /mod/, and with the
+// smart HTTP protocol of git at their import paths.
+//
+// Usage:
+//
+//	vanity [flags] [serve]               serve the import paths
+//	vanity [flags] check [check flags]   check a server against the config file
+//
+// check asks a running server, or one started in-process, for every import
+// path like the go command would, resolves the repositories and modules they
+// point to against the local mirrors, and exits with 1 on any mismatch.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/upper/upper.io/vanity/githttp"
+	"github.com/upper/upper.io/vanity/mirror"
+	"github.com/upper/upper.io/vanity/modproxy"
+	"github.com/upper/upper.io/vanity/paths"
+	"github.com/upper/upper.io/vanity/server"
+)
+
+var (
+	flagAddr   = flag.String("addr", ":9001", "listen address")
+	flagHost   = flag.String("host", "upper.io", "host of the import paths")
+	flagConfig = flag.String("config", "/app/vanity.json", "JSON file with the import paths and the repositories they are served from")
+
+	flagModProxy     = flag.String("mod-proxy", "https://upper.io/mod", "URL the module proxy is served at, announced to the go command")
+	flagMirrorUpdate = flag.Duration("mirror-update", 15*time.Minute, "how often mirrors are fetched")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: vanity [flags] [serve]\n       vanity [flags] check [check flags]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	m, err := paths.Load(*flagConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch flag.Arg(0) {
+	case "", "serve":
+		serve(m)
+	case "check":
+		os.Exit(runCheck(m, flag.Args()[1:]))
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// newHandler returns the handler of every request of the server, and the
+// mirrors it reads from.
+func newHandler(m *paths.Map) (http.Handler, []*mirror.Repo, error) {
+	modules, gitRepos, mirrors, err := mirrored(m)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	byDir := make(map[string]*mirror.Repo)
+	for _, repo := range mirrors {
+		byDir[repo.Dir] = repo
+	}
+	vanity := server.New(m, server.Config{
+		Host:     *flagHost,
+		ModProxy: *flagModProxy,
+		Mirrors:  byDir,
+	})
+	git := githttp.New(*flagHost, gitRepos)
+
+	mux := http.NewServeMux()
+	mux.Handle("/mod/", http.StripPrefix("/mod", modproxy.New(modules)))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if githttp.Match(r) {
+			git.ServeHTTP(w, r)
+			return
+		}
+		vanity.ServeHTTP(w, r)
+	})
+	return mux, mirrors, nil
+}
+
+func serve(m *paths.Map) {
+	handler, mirrors, err := newHandler(m)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	srv := &http.Server{
+		Addr:    *flagAddr,
+		Handler: handler,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go updateMirrors(ctx, mirrors, *flagMirrorUpdate)
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("listening on %s (%d import paths)", *flagAddr, len(m.Packages))
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
+
+// mirrored returns the modules and the git repositories of the packages with
+// a mirror, and the mirrors they are read from.
+func mirrored(m *paths.Map) ([]*modproxy.Module, []*githttp.Repo, []*mirror.Repo, error) {
+	var (
+		modules  []*modproxy.Module
+		gitRepos []*githttp.Repo
+		mirrors  []*mirror.Repo
+	)
+	byDir := make(map[string]*mirror.Repo)
+	for _, p := range m.Packages {
+		if p.Mirror == "" {
+			continue
+		}
+		major, ok := modproxy.MajorOf(p.Path)
+		if !ok || p.Branch == "" {
+			return nil, nil, nil, fmt.Errorf("%s: only import paths that end in .vN and are pinned to a branch can have a mirror", p.Path)
+		}
+		repo := byDir[p.Mirror]
+		if repo == nil {
+			repo = &mirror.Repo{Dir: p.Mirror, URL: p.Repo}
+			byDir[p.Mirror] = repo
+			mirrors = append(mirrors, repo)
+		} else if repo.URL != p.Repo {
+			return nil, nil, nil, fmt.Errorf("%s: mirror %s is of %s, not %s", p.Path, p.Mirror, repo.URL, p.Repo)
+		}
+		modules = append(modules, &modproxy.Module{
+			Path:   p.Path,
+			Major:  major,
+			Branch: p.Branch,
+			Repo:   repo,
+		})
+		gitRepos = append(gitRepos, &githttp.Repo{
+			Path:   p.Path,
+			Branch: p.Branch,
+			Mirror: repo,
+		})
+	}
+	return modules, gitRepos, mirrors, nil
+}
+
+// updateMirrors fetches the mirrors every interval, cloning the ones that do
+// not exist yet, until ctx is done.
+func updateMirrors(ctx context.Context, repos []*mirror.Repo, interval time.Duration) {
+	if len(repos) == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, repo := range repos {
+			updateCtx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+			if err := repo.Update(updateCtx); err != nil {
+				log.Printf("mirror %s: %v", repo.Dir, err)
+			}
+			cancel()
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,229 @@
+// Package githttp serves the smart HTTP protocol of git for the import paths
+// of upper.io from local mirrors, so that git clone https://upper.io/db.v3, and
+// go get in GOPATH mode, work without asking GitHub.
+//
+// The mirrors are of whole repositories, whose default branches are not the
+// ones of the import paths: the HEAD advertised for upper.io/db.v3 is branch 3
+// of github.com/upper/db, so that a clone checks it out.
+//
+// Only version 0 of the protocol is served, as the HEAD of later versions is
+// listed by upload-pack itself. Clients fall back to it.
+package githttp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/upper/upper.io/vanity/mirror"
+)
+
+// Repo is a repository served by the handler.
+type Repo struct {
+	// Path is the import path the repository is cloned from, like
+	// upper.io/db.v3.
+	Path string
+
+	// Branch is the branch advertised as HEAD.
+	Branch string
+
+	// Mirror is the mirror the repository is read from.
+	Mirror *mirror.Repo
+}
+
+// maxRequestSize is the largest upload-pack request, a list of the commits
+// a client wants and has, that is read.
+const maxRequestSize = 10 << 20
+
+// Handler serves the smart HTTP protocol of git for a set of repositories.
+type Handler struct {
+	host  string
+	repos map[string]*Repo
+}
+
+// New creates a handler for repos, whose import paths are on host, like
+// upper.io.
+func New(host string, repos []*Repo) *Handler {
+	h := &Handler{host: host, repos: make(map[string]*Repo)}
+	for _, r := range repos {
+		h.repos[r.Path] = r
+	}
+	return h
+}
+
+// Match reports whether r is a request of the smart HTTP protocol.
+func Match(r *http.Request) bool {
+	return strings.HasSuffix(r.URL.Path, "/info/refs") || strings.HasSuffix(r.URL.Path, "/git-upload-pack")
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/info/refs"):
+		h.infoRefs(w, r)
+	case strings.HasSuffix(r.URL.Path, "/git-upload-pack"):
+		h.uploadPack(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// lookup returns the repository of a request, whose path is the one of the
+// repository followed by suffix, with or without .git.
+func (h *Handler) lookup(r *http.Request, suffix string) (*Repo, bool) {
+	p := strings.TrimSuffix(r.URL.Path, suffix)
+	p = strings.TrimSuffix(p, ".git")
+	repo, ok := h.repos[h.host+p]
+	return repo, ok
+}
+
+func (h *Handler) infoRefs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	repo, ok := h.lookup(r, "/info/refs")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if r.FormValue("service") != "git-upload-pack" {
+		// The dumb protocol, or git push.
+		http.Error(w, "only git-upload-pack is served, with the smart protocol", http.StatusForbidden)
+		return
+	}
+
+	adv, err := repo.Mirror.AdvertiseRefs(r.Context())
+	if err == nil {
+		adv, err = setHead(adv, repo.Branch)
+	}
+	if err != nil {
+		log.Printf("githttp: %s: %v", repo.Path, err)
+		http.Error(w, "repository not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+	w.Header().Set("Cache-Control", "no-cache")
+	var b bytes.Buffer
+	writePkt(&b, "# service=git-upload-pack\n")
+	b.WriteString(flushPkt)
+	b.Write(adv)
+	_, _ = w.Write(b.Bytes())
+}
+
+func (h *Handler) uploadPack(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	repo, ok := h.lookup(r, "/git-upload-pack")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/x-git-upload-pack-request" {
+		http.Error(w, "unexpected content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var body io.Reader = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer zr.Close()
+		body = zr
+	}
+	// upload-pack writes nothing until it read the whole request, so errors
+	// reading it can still be answered.
+	req, err := io.ReadAll(io.LimitReader(body, maxRequestSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-git-upload-pack-result")
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := repo.Mirror.UploadPack(r.Context(), bytes.NewReader(req), w); err != nil {
+		log.Printf("githttp: %s: %v", repo.Path, err)
+	}
+}
+
+const flushPkt = "0000"
+
+func writePkt(b *bytes.Buffer, payload string) {
+	fmt.Fprintf(b, "%04x%s", len(payload)+4, payload)
+}
+
+// setHead rewrites an advertisement of version 0 of the protocol, so that
+// HEAD is branch.
+func setHead(adv []byte, branch string) ([]byte, error) {
+	type ref struct{ hash, name string }
+	var (
+		refs []ref
+		caps []string
+	)
+	for len(adv) > 0 {
+		if len(adv) < 4 {
+			return nil, errors.New("truncated pkt-line")
+		}
+		var n int
+		if _, err := fmt.Sscanf(string(adv[:4]), "%04x", &n); err != nil {
+			return nil, fmt.Errorf("invalid pkt-line length %q", adv[:4])
+		}
+		if n == 0 {
+			adv = adv[4:]
+			continue
+		}
+		if n < 4 || n > len(adv) {
+			return nil, fmt.Errorf("invalid pkt-line length %d", n)
+		}
+		line := strings.TrimSuffix(string(adv[4:n]), "\n")
+		adv = adv[n:]
+
+		if i := strings.IndexByte(line, 0); i >= 0 {
+			caps = strings.Fields(line[i+1:])
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid ref %q", line)
+		}
+		refs = append(refs, ref{fields[0], fields[1]})
+	}
+
+	want := "refs/heads/" + branch
+	var head string
+	for _, r := range refs {
+		if r.name == want {
+			head = r.hash
+		}
+	}
+	if head == "" {
+		return nil, fmt.Errorf("no branch %s", branch)
+	}
+
+	newCaps := []string{"symref=HEAD:" + want}
+	for _, c := range caps {
+		if !strings.HasPrefix(c, "symref=HEAD:") {
+			newCaps = append(newCaps, c)
+		}
+	}
+
+	var b bytes.Buffer
+	writePkt(&b, head+" HEAD\x00"+strings.Join(newCaps, " ")+"\n")
+	for _, r := range refs {
+		if r.name != "HEAD" {
+			writePkt(&b, r.hash+" "+r.name+"\n")
+		}
+	}
+	b.WriteString(flushPkt)
+	return b.Bytes(), nil
+}
@@ -0,0 +1,141 @@
+package githttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/upper/upper.io/vanity/internal/gittest"
+)
+
+// newServer creates a repository with the branches master, 2 and 3, mirrors
+// it and serves the mirror as upper.io/db.v2 and upper.io/db.v3.
+func newServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	src := gittest.New(t)
+	src.Commit(map[string]string{"branch.txt": "master\n"})
+	for _, branch := range []string{"2", "3"} {
+		src.Git("checkout", "--quiet", "-b", branch, "master")
+		// A file says which branch it was committed to.
+		src.Commit(map[string]string{"branch.txt": branch + "\n"})
+		src.Git("tag", "v"+branch+".0.0")
+	}
+	src.Git("checkout", "--quiet", "master")
+	repo := src.Mirror()
+
+	h := New("upper.io", []*Repo{
+		{Path: "upper.io/db.v2", Branch: "2", Mirror: repo},
+		{Path: "upper.io/db.v3", Branch: "3", Mirror: repo},
+		{Path: "upper.io/db.v9", Branch: "9", Mirror: repo},
+	})
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestClone(t *testing.T) {
+	srv := newServer(t)
+
+	tests := []struct {
+		url    string
+		branch string
+	}{
+		{srv.URL + "/db.v3", "3"},
+		{srv.URL + "/db.v2", "2"},
+		{srv.URL + "/db.v3.git", "3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "clone")
+			gittest.Git(t, ".", "clone", "--quiet", tt.url, dir)
+
+			if got := gittest.Git(t, dir, "rev-parse", "--abbrev-ref", "HEAD"); got != tt.branch {
+				t.Errorf("checked out branch %q, want %q", got, tt.branch)
+			}
+			data, err := os.ReadFile(filepath.Join(dir, "branch.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.TrimSpace(string(data)); got != tt.branch {
+				t.Errorf("branch.txt is %q, want %q", got, tt.branch)
+			}
+			// Every branch and tag is there, like in a clone from GitHub.
+			if got := gittest.Git(t, dir, "tag"); got != "v2.0.0\nv3.0.0" {
+				t.Errorf("tags are %q", got)
+			}
+		})
+	}
+}
+
+func TestLsRemote(t *testing.T) {
+	srv := newServer(t)
+
+	out := gittest.Git(t, ".", "ls-remote", "--symref", srv.URL+"/db.v3", "HEAD")
+	if !strings.HasPrefix(out, "ref: refs/heads/3\tHEAD") {
+		t.Errorf("ls-remote HEAD is %q, want it to point to refs/heads/3", out)
+	}
+	head := strings.Fields(strings.Split(out, "\n")[1])[0]
+	branch := strings.Fields(gittest.Git(t, ".", "ls-remote", srv.URL+"/db.v3", "refs/heads/3"))[0]
+	if head != branch {
+		t.Errorf("HEAD is %s, want %s, the commit of branch 3", head, branch)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	srv := newServer(t)
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		status      int
+	}{
+		{"unknown path", "GET", "/db.v7/info/refs?service=git-upload-pack", "", http.StatusNotFound},
+		{"dumb protocol", "GET", "/db.v3/info/refs", "", http.StatusForbidden},
+		{"push", "GET", "/db.v3/info/refs?service=git-receive-pack", "", http.StatusForbidden},
+		{"missing branch", "GET", "/db.v9/info/refs?service=git-upload-pack", "", http.StatusServiceUnavailable},
+		{"upload-pack with GET", "GET", "/db.v3/git-upload-pack", "", http.StatusMethodNotAllowed},
+		{"wrong content type", "POST", "/db.v3/git-upload-pack", "text/plain", http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, srv.URL+tt.path, strings.NewReader(""))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != tt.status {
+				t.Errorf("status %d, want %d", res.StatusCode, tt.status)
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"/db.v3/info/refs?service=git-upload-pack", true},
+		{"/db.v3/git-upload-pack", true},
+		{"/db.v3?go-get=1", false},
+		{"/db.v3/info", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.url, nil)
+		if got := Match(r); got != tt.want {
+			t.Errorf("Match(%s) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/upper/upper.io/vanity
+
+go 1.17
@@ -0,0 +1,85 @@
+// Package gittest creates git repositories, and mirrors of them, for tests.
+package gittest
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/upper/upper.io/vanity/mirror"
+)
+
+// Repo is a repository in a temporary directory of a test.
+type Repo struct {
+	// Dir is the work tree of the repository.
+	Dir string
+
+	t *testing.T
+}
+
+// New creates a repository without commits, on the branch master, and with a
+// user to commit as. It skips the test if git is not installed, and keeps the
+// git configuration of the system and of the user out of the test.
+func New(t *testing.T) *Repo {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("HOME", t.TempDir())
+
+	r := &Repo{Dir: filepath.Join(t.TempDir(), "src"), t: t}
+	Git(t, ".", "init", "--quiet", r.Dir)
+	r.Git("config", "user.name", "upper")
+	r.Git("config", "user.email", "upper@example.com")
+	r.Git("checkout", "--quiet", "-b", "master")
+	return r
+}
+
+// Git runs git in the repository, and returns its output.
+func (r *Repo) Git(args ...string) string {
+	r.t.Helper()
+	return Git(r.t, r.Dir, args...)
+}
+
+// Commit writes files, by slash-separated name, and commits them.
+func (r *Repo) Commit(files map[string]string) {
+	r.t.Helper()
+	for name, data := range files {
+		name = filepath.Join(r.Dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			r.t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(data), 0644); err != nil {
+			r.t.Fatal(err)
+		}
+	}
+	r.Git("add", "-A")
+	r.Git("commit", "--quiet", "-m", "commit")
+}
+
+// Mirror mirrors the repository in a temporary directory.
+func (r *Repo) Mirror() *mirror.Repo {
+	r.t.Helper()
+	m := &mirror.Repo{Dir: filepath.Join(r.t.TempDir(), "mirror.git"), URL: r.Dir}
+	if err := m.Update(context.Background()); err != nil {
+		r.t.Fatal(err)
+	}
+	return m
+}
+
+// Git runs git in dir, and returns its output without the space around it.
+// It fails the test if git does.
+func Git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
@@ -0,0 +1,3 @@
+package mirror
+
+var CheckRev = checkRev
@@ -0,0 +1,214 @@
+// Package mirror reads the repositories of upper.io from local bare mirrors,
+// so that what is served does not depend on how branches are laid out
+// upstream.
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned for revisions that are not in a mirror.
+var ErrNotFound = errors.New("revision not found")
+
+// Repo is a bare mirror of a git repository.
+type Repo struct {
+	// Dir is the directory of the mirror, like /data/mirror/db.git.
+	Dir string
+
+	// URL is the repository the mirror is cloned from.
+	URL string
+}
+
+// Commit is a commit of a mirror.
+type Commit struct {
+	Hash string
+	Time time.Time
+}
+
+// Update clones the mirror if it does not exist, and fetches the branches and
+// tags of URL otherwise. Nothing is pruned: branches and tags deleted upstream
+// are kept, so that the versions they made are still served.
+func (r *Repo) Update(ctx context.Context) error {
+	if _, err := os.Stat(r.Dir); !os.IsNotExist(err) {
+		_, err := r.git(ctx, "fetch", "--quiet", "--tags", "origin")
+		return err
+	}
+
+	tmp := &Repo{Dir: r.Dir + ".tmp", URL: r.URL}
+	if err := os.RemoveAll(tmp.Dir); err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, "git", "clone", "--bare", "--quiet", r.URL, tmp.Dir)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git clone %s: %w: %s", r.URL, err, bytes.TrimSpace(out))
+	}
+	// A bare clone does not fetch branches again unless told to.
+	if _, err := tmp.git(ctx, "config", "remote.origin.fetch", "+refs/heads/*:refs/heads/*"); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Dir, r.Dir)
+}
+
+// Tags returns the names of the tags of the mirror.
+func (r *Repo) Tags(ctx context.Context) ([]string, error) {
+	out, err := r.git(ctx, "for-each-ref", "--format=%(refname:strip=2)", "refs/tags/")
+	if err != nil {
+		return nil, err
+	}
+	return lines(out), nil
+}
+
+// TagsMerged returns the tags that are ancestors of a commit, or the commit
+// itself, and match pattern, like v3.*.
+func (r *Repo) TagsMerged(ctx context.Context, hash, pattern string) ([]string, error) {
+	if err := checkRev(hash); err != nil {
+		return nil, err
+	}
+	out, err := r.git(ctx, "tag", "--list", "--merged", hash, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return lines(out), nil
+}
+
+// Resolve returns the commit a revision, like a tag, a branch or a prefix of
+// a hash, points to.
+func (r *Repo) Resolve(ctx context.Context, rev string) (*Commit, error) {
+	if err := checkRev(rev); err != nil {
+		return nil, err
+	}
+	out, err := r.git(ctx, "rev-parse", "--verify", "--quiet", rev+"^{commit}")
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			return nil, fmt.Errorf("%s: %w", rev, ErrNotFound)
+		}
+		return nil, err
+	}
+	hash := strings.TrimSpace(string(out))
+
+	out, err = r.git(ctx, "show", "--no-patch", "--format=%ct", hash)
+	if err != nil {
+		return nil, err
+	}
+	sec, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("commit time of %s: %w", hash, err)
+	}
+	return &Commit{Hash: hash, Time: time.Unix(sec, 0).UTC()}, nil
+}
+
+// Archive returns the files of a commit as a tar archive. Files marked
+// export-ignore in .gitattributes are left out, like go mod download does.
+func (r *Repo) Archive(ctx context.Context, hash string) ([]byte, error) {
+	if err := checkRev(hash); err != nil {
+		return nil, err
+	}
+	return r.git(ctx, "archive", "--format=tar", hash)
+}
+
+// PackageDirs returns the directories of the Go packages at a revision,
+// relative to the root of the repository, which is "." if it is a package.
+// Directories the go command ignores, like testdata and vendor, are left out.
+func (r *Repo) PackageDirs(ctx context.Context, rev string) (map[string]bool, error) {
+	if err := checkRev(rev); err != nil {
+		return nil, err
+	}
+	out, err := r.git(ctx, "ls-tree", "-r", "--name-only", rev)
+	if err != nil {
+		return nil, err
+	}
+	dirs := make(map[string]bool)
+	for _, name := range lines(out) {
+		if !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		dir := path.Dir(name)
+		if !ignoredDir(dir) {
+			dirs[dir] = true
+		}
+	}
+	return dirs, nil
+}
+
+func ignoredDir(dir string) bool {
+	for _, elem := range strings.Split(dir, "/") {
+		if elem == "testdata" || elem == "vendor" || strings.HasPrefix(elem, "_") || (strings.HasPrefix(elem, ".") && elem != ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// AdvertiseRefs returns the references of the mirror as git upload-pack
+// advertises them to the clients of the smart HTTP protocol, in pkt-lines.
+func (r *Repo) AdvertiseRefs(ctx context.Context) ([]byte, error) {
+	return r.git(ctx, "upload-pack", "--stateless-rpc", "--advertise-refs", ".")
+}
+
+// UploadPack runs git upload-pack for a request of the smart HTTP protocol,
+// read from in, and writes the response, a pack, to out.
+func (r *Repo) UploadPack(ctx context.Context, in io.Reader, out io.Writer) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", "upload-pack", "--stateless-rpc", ".")
+	cmd.Dir = r.Dir
+	cmd.Stdin = in
+	cmd.Stdout = out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git upload-pack: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return nil
+}
+
+func (r *Repo) git(ctx context.Context, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = r.Dir
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := bytes.TrimSpace(stderr.Bytes()); len(msg) > 0 {
+			return nil, fmt.Errorf("git %s: %w: %s", args[0], err, msg)
+		}
+		return nil, fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return out, nil
+}
+
+// checkRev rejects revisions git could take for an option, or that are not
+// names of refs or hashes.
+func checkRev(rev string) error {
+	if rev == "" || strings.HasPrefix(rev, "-") || strings.Contains(rev, "..") {
+		return fmt.Errorf("invalid revision %q", rev)
+	}
+	for _, c := range rev {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("-._+/", c):
+		default:
+			return fmt.Errorf("invalid revision %q", rev)
+		}
+	}
+	return nil
+}
+
+func lines(out []byte) []string {
+	var s []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			s = append(s, line)
+		}
+	}
+	return s
+}
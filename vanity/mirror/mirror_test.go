@@ -0,0 +1,177 @@
+package mirror_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/upper/upper.io/vanity/internal/gittest"
+	"github.com/upper/upper.io/vanity/mirror"
+)
+
+// newRepo creates a repository with the branches master and 3, the tags
+// v3.0.0 and v3.1.0 on 3 and v1.0.0 on master, and mirrors it.
+func newRepo(t *testing.T) (*mirror.Repo, *gittest.Repo) {
+	t.Helper()
+	src := gittest.New(t)
+	src.Commit(map[string]string{"db.go": "package db\n"})
+	src.Git("tag", "v1.0.0")
+	src.Git("checkout", "--quiet", "-b", "3")
+	src.Commit(map[string]string{
+		"db.go":                       "package db // v3\n",
+		"db_test.go":                  "package db\n",
+		"README.md":                   "db\n",
+		"postgresql/postgresql.go":    "package postgresql\n",
+		"internal/sqladapter/exql.go": "package sqladapter\n",
+		"tests/only_test.go":          "package tests\n",
+		"testdata/x/x.go":             "package x\n",
+		"vendor/y/y.go":               "package y\n",
+		"_examples/main.go":           "package main\n",
+		".github/z/z.go":              "package z\n",
+	})
+	src.Git("tag", "v3.0.0")
+	src.Commit(map[string]string{"db.go": "package db // v3.1.0\n"})
+	src.Git("tag", "v3.1.0")
+	src.Git("checkout", "--quiet", "master")
+	return src.Mirror(), src
+}
+
+func TestUpdate(t *testing.T) {
+	repo, src := newRepo(t)
+	ctx := context.Background()
+
+	if _, err := os.Stat(repo.Dir + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("the directory of the clone is left behind: %v", err)
+	}
+
+	// Upstream moves on, and deletes a branch and a tag.
+	src.Git("checkout", "--quiet", "3")
+	src.Commit(map[string]string{"db.go": "package db // v3.2.0\n"})
+	src.Git("tag", "v3.2.0")
+	before := src.Git("rev-parse", "v3.1.0")
+	src.Git("checkout", "--quiet", "master")
+	src.Git("branch", "--quiet", "-D", "3")
+	src.Git("tag", "-d", "v3.0.0")
+
+	if err := repo.Update(ctx); err != nil {
+		t.Fatal(err)
+	}
+	tags, err := repo.Tags(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(tags)
+	if want := []string{"v1.0.0", "v3.0.0", "v3.1.0", "v3.2.0"}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("Tags = %q, want %q", tags, want)
+	}
+	// The deleted branch is kept where it was.
+	c, err := repo.Resolve(ctx, "refs/heads/3")
+	if err != nil || c.Hash != before {
+		t.Errorf("Resolve(refs/heads/3) = %+v, %v, want %s", c, err, before)
+	}
+}
+
+func TestTagsMerged(t *testing.T) {
+	repo, _ := newRepo(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		rev     string
+		pattern string
+		tags    []string
+	}{
+		{"refs/heads/3", "v3.*", []string{"v3.0.0", "v3.1.0"}},
+		{"v3.0.0", "v3.*", []string{"v3.0.0"}},
+		{"refs/heads/3", "v*", []string{"v1.0.0", "v3.0.0", "v3.1.0"}},
+		{"refs/heads/master", "v3.*", nil},
+	}
+	for _, tt := range tests {
+		tags, err := repo.TagsMerged(ctx, tt.rev, tt.pattern)
+		sort.Strings(tags)
+		if err != nil || !reflect.DeepEqual(tags, tt.tags) {
+			t.Errorf("TagsMerged(%s, %s) = %q, %v, want %q", tt.rev, tt.pattern, tags, err, tt.tags)
+		}
+	}
+	if _, err := repo.TagsMerged(ctx, "--contains", "v*"); err == nil {
+		t.Error("TagsMerged with an option succeeded")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	repo, src := newRepo(t)
+	ctx := context.Background()
+
+	hash := src.Git("rev-parse", "v3.1.0")
+	for _, rev := range []string{"v3.1.0", "refs/heads/3", "3", hash, hash[:12]} {
+		c, err := repo.Resolve(ctx, rev)
+		if err != nil {
+			t.Errorf("Resolve(%s): %v", rev, err)
+			continue
+		}
+		if c.Hash != hash || c.Time.IsZero() || c.Time.Location().String() != "UTC" {
+			t.Errorf("Resolve(%s) = %+v, want %s in UTC", rev, c, hash)
+		}
+	}
+
+	for _, rev := range []string{"v9.0.0", "refs/heads/9", strings.Repeat("0", 40)} {
+		if _, err := repo.Resolve(ctx, rev); !errors.Is(err, mirror.ErrNotFound) {
+			t.Errorf("Resolve(%s): %v, want ErrNotFound", rev, err)
+		}
+	}
+	for _, rev := range []string{"", "--all", "v3.0.0..v3.1.0", "3^"} {
+		if _, err := repo.Resolve(ctx, rev); err == nil || errors.Is(err, mirror.ErrNotFound) {
+			t.Errorf("Resolve(%q): %v, want an invalid revision", rev, err)
+		}
+	}
+}
+
+func TestPackageDirs(t *testing.T) {
+	repo, _ := newRepo(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		rev  string
+		dirs map[string]bool
+	}{
+		{"refs/heads/3", map[string]bool{".": true, "postgresql": true, "internal/sqladapter": true}},
+		{"refs/heads/master", map[string]bool{".": true}},
+	}
+	for _, tt := range tests {
+		dirs, err := repo.PackageDirs(ctx, tt.rev)
+		if err != nil || !reflect.DeepEqual(dirs, tt.dirs) {
+			t.Errorf("PackageDirs(%s) = %v, %v, want %v", tt.rev, dirs, err, tt.dirs)
+		}
+	}
+	if _, err := repo.PackageDirs(ctx, "refs/heads/9"); err == nil {
+		t.Error("PackageDirs of a missing branch succeeded")
+	}
+}
+
+func TestCheckRev(t *testing.T) {
+	tests := []struct {
+		rev string
+		ok  bool
+	}{
+		{"v3.1.0", true},
+		{"v3.1.0-rc1+meta", true},
+		{"refs/heads/3", true},
+		{"0123abcdef", true},
+		{"", false},
+		{"-v", false},
+		{"--output=x", false},
+		{"a..b", false},
+		{"HEAD~1", false},
+		{"HEAD^{tree}", false},
+		{"a b", false},
+		{"a:b", false},
+	}
+	for _, tt := range tests {
+		if err := mirror.CheckRev(tt.rev); (err == nil) != tt.ok {
+			t.Errorf("checkRev(%q) = %v, want ok %v", tt.rev, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,385 @@
+// Package modproxy serves the module proxy protocol of the go command for the
+// legacy import paths of upper.io, like upper.io/db.v3, from local mirrors of
+// their repositories.
+//
+// These packages predate modules and have no go.mod, so their versions are
+// the tags of their major version, with +incompatible from v2 on, and their
+// go.mod files are synthesized. Requests look like:
+//
+//	GET /upper.io/db.v3/@v/list
+//	GET /upper.io/db.v3/@v/v3.8.0+incompatible.info
+//	GET /upper.io/db.v3/@v/v3.8.0+incompatible.mod
+//	GET /upper.io/db.v3/@v/v3.8.0+incompatible.zip
+//	GET /upper.io/db.v3/@latest
+package modproxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/upper/upper.io/vanity/mirror"
+)
+
+// Module is a module served by the proxy.
+type Module struct {
+	// Path is the module path, like upper.io/db.v3.
+	Path string
+
+	// Major is the major version of the module, 3 for upper.io/db.v3.
+	Major int
+
+	// Branch is the branch @latest points to when there are no tags of
+	// Major.
+	Branch string
+
+	// Repo is the mirror the module is read from.
+	Repo *mirror.Repo
+}
+
+// MajorOf returns the major version of a module path that ends in .vN, like
+// gopkg.in paths do.
+func MajorOf(path string) (int, bool) {
+	i := strings.LastIndex(path, ".v")
+	if i < 0 || strings.Contains(path[i:], "/") {
+		return 0, false
+	}
+	n, err := strconv.Atoi(path[i+2:])
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// Info is the response to .info and @latest requests.
+type Info struct {
+	Version string
+	Time    time.Time
+}
+
+// Proxy answers the requests of the module proxy protocol.
+type Proxy struct {
+	modules map[string]*Module
+}
+
+// New creates a proxy for modules.
+func New(modules []*Module) *Proxy {
+	p := &Proxy{modules: make(map[string]*Module)}
+	for _, m := range modules {
+		p.modules[m.Path] = m
+	}
+	return p
+}
+
+// errNotFound is answered with 404, which tells the go command to try the
+// next proxy, if any.
+var errNotFound = errors.New("not found")
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	modPath, query, ok := split(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	m, ok := p.modules[modPath]
+	if !ok {
+		http.Error(w, fmt.Sprintf("not found: unknown module %s", modPath), http.StatusNotFound)
+		return
+	}
+
+	ctx := r.Context()
+	var (
+		data        []byte
+		contentType = "text/plain; charset=utf-8"
+		immutable   bool
+		err         error
+	)
+	switch {
+	case query == "@latest":
+		data, err = m.latest(ctx)
+		contentType = "application/json"
+	case query == "@v/list":
+		data, err = m.list(ctx)
+	case strings.HasSuffix(query, ".info"):
+		data, err = m.info(ctx, strings.TrimSuffix(strings.TrimPrefix(query, "@v/"), ".info"))
+		contentType = "application/json"
+	case strings.HasSuffix(query, ".mod"):
+		data, err = m.mod(ctx, strings.TrimSuffix(strings.TrimPrefix(query, "@v/"), ".mod"))
+		immutable = true
+	case strings.HasSuffix(query, ".zip"):
+		data, err = m.zip(ctx, strings.TrimSuffix(strings.TrimPrefix(query, "@v/"), ".zip"))
+		contentType = "application/zip"
+		immutable = true
+	default:
+		err = errNotFound
+	}
+	if err != nil {
+		if errors.Is(err, errNotFound) || errors.Is(err, mirror.ErrNotFound) {
+			http.Error(w, fmt.Sprintf("not found: %s: %v", modPath, err), http.StatusNotFound)
+			return
+		}
+		log.Printf("modproxy: %s: %v", r.URL.Path, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	if immutable {
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=300")
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	if r.Method == http.MethodGet {
+		_, _ = w.Write(data)
+	}
+}
+
+// split splits the path of a request into the module path, unescaped, and
+// the query that follows it, like @v/list.
+func split(urlPath string) (modPath, query string, ok bool) {
+	urlPath = strings.TrimPrefix(urlPath, "/")
+	if i := strings.Index(urlPath, "/@v/"); i > 0 {
+		modPath, query = urlPath[:i], urlPath[i+1:]
+	} else if strings.HasSuffix(urlPath, "/@latest") {
+		modPath, query = strings.TrimSuffix(urlPath, "/@latest"), "@latest"
+	} else {
+		return "", "", false
+	}
+	modPath, ok = unescape(modPath)
+	return modPath, query, ok
+}
+
+// unescape undoes the escaping of upper case letters of the protocol, where
+// !x stands for X.
+func unescape(s string) (string, bool) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '!':
+			if i+1 == len(s) || s[i+1] < 'a' || s[i+1] > 'z' {
+				return "", false
+			}
+			i++
+			b.WriteByte(s[i] - 'a' + 'A')
+		case c >= 'A' && c <= 'Z':
+			return "", false
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), true
+}
+
+// incompatible returns the build suffix versions of the module have.
+func (m *Module) incompatible() string {
+	if m.Major >= 2 {
+		return "+incompatible"
+	}
+	return ""
+}
+
+// versions returns the tags of the module as versions, from the lowest to
+// the highest.
+func (m *Module) versions(ctx context.Context) ([]string, error) {
+	tags, err := m.Repo.Tags(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var versions []semver
+	for _, tag := range tags {
+		v, ok := parseSemver(tag)
+		if !ok || v.build != "" || v.major != strconv.Itoa(m.Major) {
+			continue
+		}
+		if _, _, pseudo := parsePseudo(tag); pseudo {
+			continue
+		}
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return compareSemver(versions[i], versions[j]) < 0
+	})
+	list := make([]string, len(versions))
+	for i, v := range versions {
+		list[i] = v.String() + m.incompatible()
+	}
+	return list, nil
+}
+
+func (v semver) String() string {
+	s := "v" + v.major + "." + v.minor + "." + v.patch
+	if v.pre != "" {
+		s += "-" + v.pre
+	}
+	return s
+}
+
+func (m *Module) list(ctx context.Context) ([]byte, error) {
+	versions, err := m.versions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	for _, v := range versions {
+		b.WriteString(v + "\n")
+	}
+	return []byte(b.String()), nil
+}
+
+// latest answers @latest with the highest release, or the highest
+// pre-release, or the head of Branch if there are no tags.
+func (m *Module) latest(ctx context.Context) ([]byte, error) {
+	versions, err := m.versions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(versions) - 1; i >= 0; i-- {
+		if v, _ := parseSemver(versions[i]); v.pre == "" {
+			return m.info(ctx, versions[i])
+		}
+	}
+	if len(versions) > 0 {
+		return m.info(ctx, versions[len(versions)-1])
+	}
+	branch := m.Branch
+	if branch == "" {
+		branch = "HEAD"
+	}
+	return m.info(ctx, branch)
+}
+
+func (m *Module) info(ctx context.Context, query string) ([]byte, error) {
+	version, c, err := m.resolve(ctx, query, true)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(Info{Version: version, Time: c.Time})
+}
+
+// mod returns the go.mod of a version, which is synthesized: the tags of
+// the module have none, or one of another module path.
+func (m *Module) mod(ctx context.Context, version string) ([]byte, error) {
+	if _, _, err := m.resolve(ctx, version, false); err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("module %s\n", m.Path)), nil
+}
+
+func (m *Module) zip(ctx context.Context, version string) ([]byte, error) {
+	_, c, err := m.resolve(ctx, version, false)
+	if err != nil {
+		return nil, err
+	}
+	tarball, err := m.Repo.Archive(ctx, c.Hash)
+	if err != nil {
+		return nil, err
+	}
+	return moduleZip(tarball, m.Path+"@"+version)
+}
+
+// resolve returns the commit of a version of the module, and the version in
+// canonical form. Unless query is true, only canonical versions are accepted;
+// otherwise, branches and commits are too, as for go get upper.io/db.v3@3.
+func (m *Module) resolve(ctx context.Context, version string, query bool) (string, *mirror.Commit, error) {
+	if t, rev, ok := parsePseudo(version); ok {
+		if err := m.checkVersion(version); err != nil {
+			return "", nil, err
+		}
+		c, err := m.Repo.Resolve(ctx, rev)
+		if err != nil {
+			return "", nil, err
+		}
+		if !c.Time.Equal(t) {
+			return "", nil, fmt.Errorf("%s: does not match the time of commit %s: %w", version, c.Hash[:12], errNotFound)
+		}
+		return version, c, nil
+	}
+
+	if v, ok := parseSemver(version); ok {
+		if err := m.checkVersion(version); err != nil {
+			return "", nil, err
+		}
+		c, err := m.Repo.Resolve(ctx, "refs/tags/"+v.String())
+		if err != nil {
+			return "", nil, err
+		}
+		return version, c, nil
+	}
+
+	if !query {
+		return "", nil, fmt.Errorf("%s: not a canonical version: %w", version, errNotFound)
+	}
+	c, err := m.Repo.Resolve(ctx, version)
+	if err != nil {
+		return "", nil, err
+	}
+	version, err = m.versionOf(ctx, c)
+	if err != nil {
+		return "", nil, err
+	}
+	return version, c, nil
+}
+
+// checkVersion checks that a version belongs to the module.
+func (m *Module) checkVersion(version string) error {
+	v, _ := parseSemver(version)
+	if v.major != strconv.Itoa(m.Major) {
+		return fmt.Errorf("%s: major version must be v%d: %w", version, m.Major, errNotFound)
+	}
+	build := ""
+	if v.build != "" {
+		build = "+" + v.build
+	}
+	if build != m.incompatible() {
+		return fmt.Errorf("%s: versions of %s must end in %q: %w", version, m.Path, m.incompatible(), errNotFound)
+	}
+	return nil
+}
+
+// versionOf returns the version of a commit: its highest tag of Major, or a
+// pseudo-version based on the highest tag before it.
+func (m *Module) versionOf(ctx context.Context, c *mirror.Commit) (string, error) {
+	tags, err := m.Repo.TagsMerged(ctx, c.Hash, fmt.Sprintf("v%d.*", m.Major))
+	if err != nil {
+		return "", err
+	}
+	var base semver
+	var found bool
+	for _, tag := range tags {
+		v, ok := parseSemver(tag)
+		if !ok || v.build != "" || v.major != strconv.Itoa(m.Major) {
+			continue
+		}
+		if _, _, pseudo := parsePseudo(tag); pseudo {
+			continue
+		}
+		if !found || compareSemver(v, base) > 0 {
+			base, found = v, true
+		}
+	}
+	if found {
+		tc, err := m.Repo.Resolve(ctx, "refs/tags/"+base.String())
+		if err != nil {
+			return "", err
+		}
+		if tc.Hash == c.Hash {
+			return base.String() + m.incompatible(), nil
+		}
+		return pseudoVersion(m.Major, base.String(), c.Time, c.Hash) + m.incompatible(), nil
+	}
+	return pseudoVersion(m.Major, "", c.Time, c.Hash) + m.incompatible(), nil
+}
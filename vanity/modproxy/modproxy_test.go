@@ -0,0 +1,229 @@
+package modproxy
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/upper/upper.io/vanity/internal/gittest"
+)
+
+// newServer creates a repository whose branch 3 has the tags v3.0.0,
+// v3.1.0-rc1 and v3.1.0 and a commit after them, mirrors it and serves it
+// as upper.io/db.v3. It returns the pseudo-version of the last commit.
+func newServer(t *testing.T) (*httptest.Server, string) {
+	t.Helper()
+	src := gittest.New(t)
+	src.Git("checkout", "--quiet", "-b", "3")
+
+	// The go.mod of the tag is not the one served.
+	src.Commit(map[string]string{
+		"go.mod":                       "module github.com/upper/db\n",
+		"db.go":                        "package db\n",
+		"internal/cache/cache.go":      "package cache\n",
+		"vendor/modules.txt":           "# github.com/x/y v1.0.0\n",
+		"vendor/github.com/x/y/y.go":   "package y\n",
+		"examples/go.mod":              "module examples\n",
+		"examples/main.go":             "package main\n",
+		"examples/nested/nested.go":    "package nested\n",
+		"internal/vendor/v/v.go":       "package v\n",
+		"internal/cache/vendor/x/x.go": "package x\n",
+	})
+	src.Git("tag", "v3.0.0")
+	src.Git("tag", "v2.0.0")
+	src.Commit(map[string]string{"db.go": "package db // rc1\n"})
+	src.Git("tag", "v3.1.0-rc1")
+	src.Commit(map[string]string{"db.go": "package db // v3.1.0\n"})
+	src.Git("tag", "v3.1.0")
+	src.Git("tag", "v3.1.0+meta")
+	src.Commit(map[string]string{"db.go": "package db // head\n"})
+
+	ct, err := strconv.ParseInt(src.Git("log", "-1", "--format=%ct"), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head := src.Git("rev-parse", "HEAD")
+	pseudo := "v3.1.1-0." + time.Unix(ct, 0).UTC().Format("20060102150405") + "-" + head[:12] + "+incompatible"
+
+	srv := httptest.NewServer(New([]*Module{
+		{Path: "upper.io/db.v3", Major: 3, Branch: "3", Repo: src.Mirror()},
+	}))
+	t.Cleanup(srv.Close)
+	return srv, pseudo
+}
+
+func get(t *testing.T, url string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestProxy(t *testing.T) {
+	srv, pseudo := newServer(t)
+	rev := pseudo[strings.LastIndex(pseudo, "-")+1 : len(pseudo)-len("+incompatible")]
+
+	tests := []struct {
+		path   string
+		status int
+		body   string // if not empty, the body, or the Version of an Info
+	}{
+		{"/upper.io/db.v3/@v/list", 200, "v3.0.0+incompatible\nv3.1.0-rc1+incompatible\nv3.1.0+incompatible\n"},
+		{"/upper.io/db.v3/@latest", 200, "v3.1.0+incompatible"},
+		{"/upper.io/db.v3/@v/v3.1.0-rc1+incompatible.info", 200, "v3.1.0-rc1+incompatible"},
+		{"/upper.io/db.v3/@v/v3.0.0+incompatible.mod", 200, "module upper.io/db.v3\n"},
+		{"/upper.io/db.v3/@v/" + pseudo + ".mod", 200, "module upper.io/db.v3\n"},
+
+		// Queries of branches and commits are answered with their version.
+		{"/upper.io/db.v3/@v/3.info", 200, pseudo},
+		{"/upper.io/db.v3/@v/" + rev + ".info", 200, pseudo},
+		{"/upper.io/db.v3/@v/v3.1.0.info", 404, ""},
+
+		// Versions of db.v3 are +incompatible, and of major 3.
+		{"/upper.io/db.v3/@v/v3.1.0.mod", 404, ""},
+		{"/upper.io/db.v3/@v/v2.0.0+incompatible.mod", 404, ""},
+		{"/upper.io/db.v3/@v/v3.1.0+meta.mod", 404, ""},
+		{"/upper.io/db.v3/@v/v3.9.0+incompatible.mod", 404, ""},
+		{"/upper.io/db.v3/@v/3.mod", 404, ""},
+		// A pseudo-version with the hash of a commit and another time.
+		{"/upper.io/db.v3/@v/" + strings.Replace(pseudo, "-0.20", "-0.19", 1) + ".mod", 404, ""},
+		{"/upper.io/db.v2/@v/list", 404, ""},
+		{"/upper.io/db.v3/@v/", 404, ""},
+	}
+	for _, tt := range tests {
+		status, body := get(t, srv.URL+tt.path)
+		if status != tt.status {
+			t.Errorf("%s: status %d, want %d\n%s", tt.path, status, tt.status, body)
+			continue
+		}
+		if tt.body == "" {
+			continue
+		}
+		if strings.HasSuffix(tt.path, ".info") || strings.HasSuffix(tt.path, "@latest") {
+			var info Info
+			if err := json.Unmarshal([]byte(body), &info); err != nil {
+				t.Errorf("%s: %v", tt.path, err)
+				continue
+			}
+			body = info.Version
+		}
+		if body != tt.body {
+			t.Errorf("%s: %q, want %q", tt.path, body, tt.body)
+		}
+	}
+}
+
+func TestProxyZip(t *testing.T) {
+	srv, pseudo := newServer(t)
+
+	for _, version := range []string{"v3.0.0+incompatible", pseudo} {
+		status, body := get(t, srv.URL+"/upper.io/db.v3/@v/"+version+".zip")
+		if status != http.StatusOK {
+			t.Fatalf("%s: status %d\n%s", version, status, body)
+		}
+		zr, err := zip.NewReader(strings.NewReader(body), int64(len(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, f := range zr.File {
+			names = append(names, f.Name)
+		}
+		prefix := "upper.io/db.v3@" + version + "/"
+		want := []string{
+			prefix + "db.go",
+			prefix + "internal/cache/cache.go",
+			prefix + "vendor/modules.txt",
+		}
+		if !reflect.DeepEqual(names, want) {
+			t.Errorf("%s: files %q, want %q", version, names, want)
+		}
+	}
+}
+
+func TestModuleZip(t *testing.T) {
+	tests := []struct {
+		files []string
+		want  []string
+		err   bool
+	}{
+		{
+			files: []string{"go.mod", "db.go", "LICENSE"},
+			want:  []string{"LICENSE", "db.go"},
+		},
+		{
+			// Files in vendor directories are kept, not the ones in their
+			// packages.
+			files: []string{"db.go", "vendor/modules.txt", "vendor/a/a.go", "lib/vendor/b/b.go", "lib/vendor/x.txt", "vendorx/c.go"},
+			want:  []string{"db.go", "lib/vendor/x.txt", "vendor/modules.txt", "vendorx/c.go"},
+		},
+		{
+			// Nested modules are left out, wherever their go.mod is.
+			files: []string{"db.go", "a/a.go", "a/b/go.mod", "a/b/b.go", "a/b/c/c.go", "ab/ab.go"},
+			want:  []string{"a/a.go", "ab/ab.go", "db.go"},
+		},
+		{files: []string{"go.mod", "vendor/a/a.go"}, err: true},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, name := range tt.files {
+			if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(name))}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tw.Write([]byte(name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "db.go"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := moduleZip(buf.Bytes(), "upper.io/db.v3@v3.0.0+incompatible")
+		if tt.err {
+			if err == nil {
+				t.Errorf("%q: no error", tt.files)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.files, err)
+			continue
+		}
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, f := range zr.File {
+			name := strings.TrimPrefix(f.Name, "upper.io/db.v3@v3.0.0+incompatible/")
+			if name == f.Name {
+				t.Errorf("%s is not under the prefix", f.Name)
+			}
+			names = append(names, name)
+		}
+		if !sort.StringsAreSorted(names) || !reflect.DeepEqual(names, tt.want) {
+			t.Errorf("%q: files %q, want %q", tt.files, names, tt.want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package modproxy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// semver is a parsed semantic version, like v3.8.0-rc1+incompatible.
+type semver struct {
+	major, minor, patch string
+	pre                 string // without the -
+	build               string // without the +
+}
+
+var semverRe = regexp.MustCompile(`^v(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)(?:-([0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*))?(?:\+([0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*))?$`)
+
+func parseSemver(v string) (semver, bool) {
+	m := semverRe.FindStringSubmatch(v)
+	if m == nil {
+		return semver{}, false
+	}
+	return semver{major: m[1], minor: m[2], patch: m[3], pre: m[4], build: m[5]}, true
+}
+
+// pseudoRe matches pseudo-versions, like v3.0.0-20190428100011-c0e5ec9ab75a
+// and v3.8.1-0.20200101000000-c0e5ec9ab75a, and captures their time and
+// commit.
+var pseudoRe = regexp.MustCompile(`^v[0-9]+\.[0-9]+\.[0-9]+-(?:[0-9A-Za-z-]+\.)*?(?:0\.)?([0-9]{14})-([0-9a-f]{12})(?:\+incompatible)?$`)
+
+const pseudoTime = "20060102150405"
+
+// parsePseudo returns the time and the commit prefix of a pseudo-version.
+func parsePseudo(v string) (t time.Time, rev string, ok bool) {
+	m := pseudoRe.FindStringSubmatch(v)
+	if m == nil {
+		return time.Time{}, "", false
+	}
+	t, err := time.Parse(pseudoTime, m[1])
+	if err != nil {
+		return time.Time{}, "", false
+	}
+	return t, m[2], true
+}
+
+// pseudoVersion returns the pseudo-version of a commit that comes after the
+// tag base, or that has no tag before it if base is empty.
+func pseudoVersion(major int, base string, t time.Time, hash string) string {
+	suffix := t.UTC().Format(pseudoTime) + "-" + hash[:12]
+	b, ok := parseSemver(base)
+	switch {
+	case !ok:
+		return fmt.Sprintf("v%d.0.0-%s", major, suffix)
+	case b.pre != "":
+		return fmt.Sprintf("v%s.%s.%s-%s.0.%s", b.major, b.minor, b.patch, b.pre, suffix)
+	default:
+		return fmt.Sprintf("v%s.%s.%s-0.%s", b.major, b.minor, incDecimal(b.patch), suffix)
+	}
+}
+
+// compareSemver compares two valid versions by precedence, ignoring their
+// build metadata.
+func compareSemver(a, b semver) int {
+	if c := compareNum(a.major, b.major); c != 0 {
+		return c
+	}
+	if c := compareNum(a.minor, b.minor); c != 0 {
+		return c
+	}
+	if c := compareNum(a.patch, b.patch); c != 0 {
+		return c
+	}
+	return comparePre(a.pre, b.pre)
+}
+
+// compareNum compares decimal numbers without leading zeros.
+func compareNum(a, b string) int {
+	switch {
+	case len(a) != len(b):
+		if len(a) < len(b) {
+			return -1
+		}
+		return 1
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// comparePre compares pre-release versions. A release, which has none, comes
+// after all of them.
+func comparePre(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return 1
+	case b == "":
+		return -1
+	}
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] == bs[i] {
+			continue
+		}
+		an, bn := isNum(as[i]), isNum(bs[i])
+		switch {
+		case an && bn:
+			return compareNum(as[i], bs[i])
+		case an:
+			return -1
+		case bn:
+			return 1
+		case as[i] < bs[i]:
+			return -1
+		default:
+			return 1
+		}
+	}
+	return compareNum(fmt.Sprint(len(as)), fmt.Sprint(len(bs)))
+}
+
+func isNum(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// incDecimal adds one to a decimal number.
+func incDecimal(s string) string {
+	b := []byte(s)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < '9' {
+			b[i]++
+			return string(b)
+		}
+		b[i] = '0'
+	}
+	return "1" + string(b)
+}
@@ -0,0 +1,201 @@
+package modproxy
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestParseSemver(t *testing.T) {
+	tests := []struct {
+		v  string
+		ok bool
+		s  semver
+	}{
+		{"v3.8.0", true, semver{major: "3", minor: "8", patch: "0"}},
+		{"v3.8.0+incompatible", true, semver{major: "3", minor: "8", patch: "0", build: "incompatible"}},
+		{"v4.0.0-rc1.2", true, semver{major: "4", minor: "0", patch: "0", pre: "rc1.2"}},
+		{"v3.0.0-20190428100011-c0e5ec9ab75a+incompatible", true, semver{major: "3", minor: "0", patch: "0", pre: "20190428100011-c0e5ec9ab75a", build: "incompatible"}},
+		{"v10.20.30", true, semver{major: "10", minor: "20", patch: "30"}},
+		{"3.8.0", false, semver{}},
+		{"v3.8", false, semver{}},
+		{"v03.8.0", false, semver{}},
+		{"v3.8.0-", false, semver{}},
+		{"v3.8.0-rc..1", false, semver{}},
+		{"v3.8.0+", false, semver{}},
+		{"master", false, semver{}},
+	}
+	for _, tt := range tests {
+		s, ok := parseSemver(tt.v)
+		if ok != tt.ok || s != tt.s {
+			t.Errorf("parseSemver(%q) = %+v, %v, want %+v, %v", tt.v, s, ok, tt.s, tt.ok)
+		}
+	}
+}
+
+func TestCompareSemver(t *testing.T) {
+	// In increasing order, as in semver.org.
+	ordered := []string{
+		"v1.0.0-0",
+		"v1.0.0-alpha",
+		"v1.0.0-alpha.1",
+		"v1.0.0-alpha.beta",
+		"v1.0.0-beta",
+		"v1.0.0-beta.2",
+		"v1.0.0-beta.11",
+		"v1.0.0-rc.1",
+		"v1.0.0",
+		"v1.0.1",
+		"v1.2.0",
+		"v1.10.0",
+		"v2.0.0",
+		"v10.0.0",
+	}
+	var versions []semver
+	for _, v := range ordered {
+		s, ok := parseSemver(v)
+		if !ok {
+			t.Fatalf("parseSemver(%q) failed", v)
+		}
+		versions = append(versions, s)
+	}
+	for i := range versions {
+		for j := range versions {
+			want := 0
+			switch {
+			case i < j:
+				want = -1
+			case i > j:
+				want = 1
+			}
+			if got := compareSemver(versions[i], versions[j]); got != want {
+				t.Errorf("compareSemver(%s, %s) = %d, want %d", ordered[i], ordered[j], got, want)
+			}
+		}
+	}
+
+	// Build metadata does not count.
+	a, _ := parseSemver("v3.8.0+incompatible")
+	b, _ := parseSemver("v3.8.0")
+	if compareSemver(a, b) != 0 {
+		t.Error("v3.8.0+incompatible and v3.8.0 differ")
+	}
+
+	shuffled := append([]semver(nil), versions...)
+	sort.Slice(shuffled, func(i, j int) bool { return shuffled[i].String() > shuffled[j].String() })
+	sort.Slice(shuffled, func(i, j int) bool { return compareSemver(shuffled[i], shuffled[j]) < 0 })
+	for i := range shuffled {
+		if shuffled[i].String() != ordered[i] {
+			t.Fatalf("sorted: %v", shuffled)
+		}
+	}
+}
+
+func TestPseudoVersion(t *testing.T) {
+	at := time.Date(2019, 4, 28, 10, 0, 11, 0, time.FixedZone("", 3600))
+	hash := "c0e5ec9ab75a4e3a7d52a8be77d6b4ef0ab3c7a1"
+
+	tests := []struct {
+		major int
+		base  string
+		want  string
+	}{
+		{3, "", "v3.0.0-20190428090011-c0e5ec9ab75a"},
+		{3, "v3.8.0", "v3.8.1-0.20190428090011-c0e5ec9ab75a"},
+		{3, "v3.8.9", "v3.8.10-0.20190428090011-c0e5ec9ab75a"},
+		{3, "v3.8.99", "v3.8.100-0.20190428090011-c0e5ec9ab75a"},
+		{4, "v4.0.0-rc1", "v4.0.0-rc1.0.20190428090011-c0e5ec9ab75a"},
+	}
+	for _, tt := range tests {
+		got := pseudoVersion(tt.major, tt.base, at, hash)
+		if got != tt.want {
+			t.Errorf("pseudoVersion(%d, %q) = %q, want %q", tt.major, tt.base, got, tt.want)
+			continue
+		}
+		// Pseudo-versions are valid versions, and can be parsed back, with
+		// or without +incompatible.
+		if _, ok := parseSemver(got); !ok {
+			t.Errorf("%s is not a valid version", got)
+		}
+		for _, v := range []string{got, got + "+incompatible"} {
+			pt, rev, ok := parsePseudo(v)
+			if !ok || !pt.Equal(at) || rev != hash[:12] {
+				t.Errorf("parsePseudo(%q) = %v, %q, %v", v, pt, rev, ok)
+			}
+		}
+	}
+
+	for _, v := range []string{"v3.8.0", "v3.8.0-rc1", "v3.0.0-2019042810001-c0e5ec9ab75a", "v3.0.0-20190428100011-c0e5ec9ab75"} {
+		if _, _, ok := parsePseudo(v); ok {
+			t.Errorf("%s is a pseudo-version", v)
+		}
+	}
+}
+
+func TestMajorOf(t *testing.T) {
+	tests := []struct {
+		path  string
+		major int
+		ok    bool
+	}{
+		{"upper.io/db.v3", 3, true},
+		{"upper.io/db.v1", 1, true},
+		{"upper.io/db.v10", 10, true},
+		{"upper.io/db", 0, false},
+		{"upper.io/db.v3/postgresql", 0, false},
+		{"upper.io/db.vx", 0, false},
+		{"upper.io/db/v4", 0, false},
+	}
+	for _, tt := range tests {
+		major, ok := MajorOf(tt.path)
+		if major != tt.major || ok != tt.ok {
+			t.Errorf("MajorOf(%q) = %d, %v, want %d, %v", tt.path, major, ok, tt.major, tt.ok)
+		}
+	}
+}
+
+func TestCheckVersion(t *testing.T) {
+	v1 := &Module{Path: "upper.io/db.v1", Major: 1}
+	v3 := &Module{Path: "upper.io/db.v3", Major: 3}
+
+	tests := []struct {
+		m       *Module
+		version string
+		ok      bool
+	}{
+		{v3, "v3.8.0+incompatible", true},
+		{v3, "v3.0.0-20190428100011-c0e5ec9ab75a+incompatible", true},
+		{v3, "v3.8.0", false},
+		{v3, "v3.8.0+other", false},
+		{v3, "v2.0.0+incompatible", false},
+		{v1, "v1.0.0", true},
+		{v1, "v1.0.0+incompatible", false},
+	}
+	for _, tt := range tests {
+		if err := tt.m.checkVersion(tt.version); (err == nil) != tt.ok {
+			t.Errorf("%s: checkVersion(%q) = %v, want ok %v", tt.m.Path, tt.version, err, tt.ok)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		path, modPath, query string
+		ok                   bool
+	}{
+		{"/upper.io/db.v3/@v/list", "upper.io/db.v3", "@v/list", true},
+		{"/upper.io/db.v3/@v/v3.8.0+incompatible.info", "upper.io/db.v3", "@v/v3.8.0+incompatible.info", true},
+		{"/upper.io/db.v3/@latest", "upper.io/db.v3", "@latest", true},
+		{"/github.com/!upper/db/@v/list", "github.com/Upper/db", "@v/list", true},
+		{"/github.com/Upper/db/@v/list", "", "", false},
+		{"/github.com/!/db/@v/list", "", "", false},
+		{"/upper.io/db.v3", "", "", false},
+		{"/@v/list", "", "", false},
+	}
+	for _, tt := range tests {
+		modPath, query, ok := split(tt.path)
+		if ok != tt.ok || ok && (modPath != tt.modPath || query != tt.query) {
+			t.Errorf("split(%q) = %q, %q, %v, want %q, %q, %v", tt.path, modPath, query, ok, tt.modPath, tt.query, tt.ok)
+		}
+	}
+}
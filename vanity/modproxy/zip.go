@@ -0,0 +1,112 @@
+package modproxy
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// moduleZip turns the tar archive of a commit into the zip of a module
+// version, whose files are under prefix, like upper.io/db.v3@v3.8.0+incompatible.
+//
+// The files are the ones go mod download would pack from the repository, so
+// that the zip matches the hashes in go.sum files and in the checksum
+// database: there are no files of vendored packages, or of directories with
+// their own go.mod, and no symlinks. The go.mod at the root is left out too,
+// as it is synthesized.
+func moduleZip(tarball []byte, prefix string) ([]byte, error) {
+	type file struct {
+		name string
+		data []byte
+	}
+	var files []file
+	nested := make(map[string]bool)
+
+	tr := tar.NewReader(bytes.NewReader(tarball))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := hdr.Name
+		if name == "go.mod" || isVendored(name) {
+			continue
+		}
+		if path.Base(name) == "go.mod" {
+			nested[path.Dir(name)] = true
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file{name, data})
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		if inNested(f.name, nested) {
+			continue
+		}
+		w, err := zw.Create(prefix + "/" + f.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(f.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, errors.New("no files")
+	}
+	if buf.Len() > maxZipSize {
+		return nil, fmt.Errorf("zip of %s is larger than %d bytes", prefix, maxZipSize)
+	}
+	return buf.Bytes(), nil
+}
+
+// maxZipSize is the largest module zip the go command accepts.
+const maxZipSize = 500 << 20
+
+// isVendored reports whether a file belongs to a vendored package. Files in
+// a vendor directory itself, like vendor/modules.txt, do not.
+func isVendored(name string) bool {
+	var i int
+	if strings.HasPrefix(name, "vendor/") {
+		i = len("vendor/")
+	} else if j := strings.Index(name, "/vendor/"); j >= 0 {
+		i = j + len("/vendor/")
+	} else {
+		return false
+	}
+	return strings.Contains(name[i:], "/")
+}
+
+// inNested reports whether a file is in a directory with a go.mod of its own,
+// which is another module.
+func inNested(name string, nested map[string]bool) bool {
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if nested[dir] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+// Package paths maps the vanity import paths of upper.io to the repositories
+// they are served from.
+//
+// The map is read from a JSON file like this one:
+//
+//	{
+//	  "Packages": [
+//	    {
+//	      "Path": "upper.io/db.v3",
+//	      "Repo": "https://github.com/upper/db",
+//	      "VCS": "git",
+//	      "Branch": "3",
+//	      "Docs": "https://upper.io/db.v3/",
+//	      "Deprecated": "upper/db v3 is no longer maintained.",
+//	      "Successor": "github.com/upper/db/v4",
+//	      "Migration": "https://upper.io/v4/getting-started/",
+//	      "Mirror": "/data/mirror/db.git"
+//	    },
+//	    {
+//	      "Path": "upper.io/db",
+//	      "Repo": "https://github.com/upper/db",
+//	      "VCS": "git",
+//	      "DefaultBranch": "master",
+//	      "Docs": "https://upper.io/v4/"
+//	    }
+//	  ]
+//	}
+package paths
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Package is an import path and everything under it.
+type Package struct {
+	// Path is the import path, like upper.io/db.v3.
+	Path string
+
+	// Repo is the URL of the repository the package lives in.
+	Repo string
+
+	// VCS is the version control system of Repo, git if empty.
+	VCS string
+
+	// Branch pins the package to a major-version branch of Repo, like 3 for
+	// upper.io/db.v3. The go command always clones the default branch, so
+	// pinned packages with a Mirror are cloned from their import path, which
+	// has Branch as its default branch. Those without one are cloned from
+	// Repo.
+	Branch string
+
+	// DefaultBranch is the default branch of Repo, the one source links of
+	// packages that are not pinned point to. It is master if empty.
+	DefaultBranch string
+
+	// Alias is the canonical import path of a package known by another
+	// name too, like github.com/upper/db/v4 for upper.io/db.v4.
+	Alias string
+
+	// Docs is the URL of the documentation of the package, where browsers
+	// that visit its import path are sent. For aliases, it is the page of
+	// the canonical import path on pkg.go.dev if empty.
+	Docs string
+
+	// Deprecated is shown to the people who visit a retired package, like
+	// upper.io/db.v3, in a banner that points them to Successor and to the
+	// Migration guide.
+	Deprecated string
+	Successor  string
+	Migration  string
+
+	// Mirror is the directory of a local mirror of Repo. If set, the
+	// package is a module served with the module proxy protocol from the
+	// mirror, which takes the versions of its major version from the tags,
+	// and git clones of RepoRoot are served from it too. Only pinned
+	// packages whose paths end in .vN, like upper.io/db.v3, can have one.
+	Mirror string
+}
+
+// RepoRoot returns the repository the go command is told to fetch the
+// package from. Only the import paths of pinned packages with a mirror are
+// git repositories themselves.
+func (p *Package) RepoRoot() string {
+	if p.Branch != "" && p.Mirror != "" {
+		return "https://" + p.Path
+	}
+	return p.Repo
+}
+
+// Canonical returns the import path of a package under p, like
+// upper.io/db.v4/postgresql, the go command knows it by: the one under Alias
+// for aliases.
+func (p *Package) Canonical(importPath string) string {
+	if p.Alias == "" {
+		return importPath
+	}
+	return p.Alias + strings.TrimPrefix(importPath, p.Path)
+}
+
+// SourceBranch returns the branch source links point to.
+func (p *Package) SourceBranch() string {
+	if p.Branch != "" {
+		return p.Branch
+	}
+	if p.DefaultBranch != "" {
+		return p.DefaultBranch
+	}
+	return "master"
+}
+
+// Map is a set of packages.
+type Map struct {
+	Packages []*Package
+}
+
+// Load reads a map from a JSON file.
+func Load(filename string) (*Map, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	m := &Map{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	if err := m.init(); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return m, nil
+}
+
+// init validates the packages and sorts them from the longest path to the
+// shortest, so that the first match is the most specific one.
+func (m *Map) init() error {
+	if len(m.Packages) == 0 {
+		return errors.New("no packages")
+	}
+	seen := make(map[string]bool)
+	for _, p := range m.Packages {
+		p.Path = strings.TrimSuffix(p.Path, "/")
+		if p.Path == "" || strings.Contains(p.Path, "://") {
+			return fmt.Errorf("invalid import path %q", p.Path)
+		}
+		if seen[p.Path] {
+			return fmt.Errorf("duplicate import path %q", p.Path)
+		}
+		seen[p.Path] = true
+		if p.VCS == "" {
+			p.VCS = "git"
+		}
+		if u, err := url.Parse(p.Repo); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("%s: invalid repository URL %q", p.Path, p.Repo)
+		}
+		p.Repo = strings.TrimSuffix(p.Repo, "/")
+		if p.Docs == "" && p.Alias != "" {
+			p.Docs = "https://pkg.go.dev/" + p.Alias
+		}
+	}
+	sort.SliceStable(m.Packages, func(i, j int) bool {
+		return len(m.Packages[i].Path) > len(m.Packages[j].Path)
+	})
+	return nil
+}
+
+// Lookup returns the package importPath belongs to, if any.
+func (m *Map) Lookup(importPath string) (*Package, bool) {
+	for _, p := range m.Packages {
+		if importPath == p.Path || strings.HasPrefix(importPath, p.Path+"/") {
+			return p, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,132 @@
+package paths
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRepoRoot(t *testing.T) {
+	tests := []struct {
+		p    Package
+		want string
+	}{
+		{Package{Path: "upper.io/db", Repo: "https://github.com/upper/db"}, "https://github.com/upper/db"},
+		{Package{Path: "upper.io/db.v4", Repo: "https://github.com/upper/db", Alias: "github.com/upper/db/v4"}, "https://github.com/upper/db"},
+		// Without a mirror, nothing serves git clones of the import path.
+		{Package{Path: "upper.io/db.v3", Repo: "https://github.com/upper/db", Branch: "3"}, "https://github.com/upper/db"},
+		{Package{Path: "upper.io/db.v3", Repo: "https://github.com/upper/db", Branch: "3", Mirror: "/data/mirror/db.git"}, "https://upper.io/db.v3"},
+	}
+	for _, tt := range tests {
+		if got := tt.p.RepoRoot(); got != tt.want {
+			t.Errorf("%+v: RepoRoot() = %q, want %q", tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	tests := []struct {
+		path, alias, importPath, want string
+	}{
+		{"upper.io/db.v3", "", "upper.io/db.v3/postgresql", "upper.io/db.v3/postgresql"},
+		{"upper.io/db.v4", "github.com/upper/db/v4", "upper.io/db.v4", "github.com/upper/db/v4"},
+		{"upper.io/db.v4", "github.com/upper/db/v4", "upper.io/db.v4/adapter/postgresql", "github.com/upper/db/v4/adapter/postgresql"},
+	}
+	for _, tt := range tests {
+		p := &Package{Path: tt.path, Alias: tt.alias}
+		if got := p.Canonical(tt.importPath); got != tt.want {
+			t.Errorf("Canonical(%q) of %s = %q, want %q", tt.importPath, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestSourceBranch(t *testing.T) {
+	tests := []struct {
+		p    Package
+		want string
+	}{
+		{Package{}, "master"},
+		{Package{DefaultBranch: "main"}, "main"},
+		{Package{Branch: "3", DefaultBranch: "master"}, "3"},
+	}
+	for _, tt := range tests {
+		if got := tt.p.SourceBranch(); got != tt.want {
+			t.Errorf("%+v: SourceBranch() = %q, want %q", tt.p, got, tt.want)
+		}
+	}
+}
+
+func load(t *testing.T, data string) (*Map, error) {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "vanity.json")
+	if err := os.WriteFile(name, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return Load(name)
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		data string
+		err  string
+	}{
+		{`{"Packages": []}`, "no packages"},
+		{`{"Packages": [{"Path": "", "Repo": "https://github.com/upper/db"}]}`, "invalid import path"},
+		{`{"Packages": [{"Path": "https://upper.io/db", "Repo": "https://github.com/upper/db"}]}`, "invalid import path"},
+		{`{"Packages": [{"Path": "upper.io/db", "Repo": "github.com/upper/db"}]}`, "invalid repository URL"},
+		{`{"Packages": [{"Path": "upper.io/db", "Repo": "https://github.com/upper/db"}, {"Path": "upper.io/db/", "Repo": "https://github.com/upper/db"}]}`, "duplicate import path"},
+		{`{"Packages": [`, "vanity.json"},
+	}
+	for _, tt := range tests {
+		_, err := load(t, tt.data)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got error %v, want %q", tt.data, err, tt.err)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	m, err := load(t, `{"Packages": [
+		{"Path": "upper.io/db", "Repo": "https://github.com/upper/db/"},
+		{"Path": "upper.io/db.v3", "Repo": "https://github.com/upper/db", "Branch": "3"},
+		{"Path": "upper.io/db/v4", "Repo": "https://github.com/upper/db", "Alias": "github.com/upper/db/v4"}
+	]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		importPath string
+		want       string
+	}{
+		{"upper.io/db", "upper.io/db"},
+		{"upper.io/db/postgresql", "upper.io/db"},
+		{"upper.io/db/v4", "upper.io/db/v4"},
+		{"upper.io/db/v4/adapter/postgresql", "upper.io/db/v4"},
+		{"upper.io/db.v3", "upper.io/db.v3"},
+		{"upper.io/db.v3/lib/sqlbuilder", "upper.io/db.v3"},
+		{"upper.io/db.v33", ""},
+		{"upper.io/dbx", ""},
+		{"upper.io", ""},
+	}
+	for _, tt := range tests {
+		p, ok := m.Lookup(tt.importPath)
+		var got string
+		if ok {
+			got = p.Path
+		}
+		if got != tt.want {
+			t.Errorf("Lookup(%q) = %q, want %q", tt.importPath, got, tt.want)
+		}
+	}
+
+	p, _ := m.Lookup("upper.io/db")
+	if p.VCS != "git" || p.Repo != "https://github.com/upper/db" {
+		t.Errorf("upper.io/db: VCS %q, Repo %q", p.VCS, p.Repo)
+	}
+	p, _ = m.Lookup("upper.io/db/v4")
+	if p.Docs != "https://pkg.go.dev/github.com/upper/db/v4" {
+		t.Errorf("upper.io/db/v4: Docs %q", p.Docs)
+	}
+}
@@ -0,0 +1,248 @@
+// Package server implements the vanity import server of upper.io, which tells
+// the go command where the packages of upper.io are, and sends the people who
+// visit them to their documentation.
+package server
+
+import (
+	"context"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/upper/upper.io/vanity/mirror"
+	"github.com/upper/upper.io/vanity/paths"
+)
+
+// Config configures a Server.
+type Config struct {
+	// Host is the host of the import paths, like upper.io.
+	Host string
+
+	// ModProxy is the URL the module proxy of the packages with a mirror is
+	// served at, like https://upper.io/mod. If set, their pages tell the go
+	// command to download them from it in module mode.
+	ModProxy string
+
+	// Mirrors are the mirrors of the packages, by directory. They tell the
+	// subpackages of a package apart from the pages of its documentation,
+	// which share its import path.
+	Mirrors map[string]*mirror.Repo
+}
+
+// Server answers go-get requests for the packages in a map, and the requests
+// of browsers for them.
+type Server struct {
+	conf  Config
+	paths *paths.Map
+
+	mu   sync.Mutex
+	dirs map[string]*packageDirs // by import path of a package
+}
+
+// packageDirs are the directories of the Go packages under a package.
+type packageDirs struct {
+	dirs    map[string]bool
+	expires time.Time
+}
+
+// dirsTTL is how long the directories of a package are kept before they are
+// read from its mirror again.
+const dirsTTL = 10 * time.Minute
+
+// New creates a server for the import paths listed in m.
+func New(m *paths.Map, conf Config) *Server {
+	return &Server{conf: conf, paths: m, dirs: make(map[string]*packageDirs)}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	importPath := s.conf.Host + strings.TrimSuffix(r.URL.Path, "/")
+	p, ok := s.paths.Lookup(importPath)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.FormValue("go-get") != "1" {
+		s.browse(w, r, p, importPath)
+		return
+	}
+
+	var modProxy string
+	if p.Mirror != "" {
+		modProxy = s.conf.ModProxy
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	err := metaPage.Execute(w, map[string]interface{}{
+		"ImportPath": importPath,
+		"Package":    p,
+		"RepoRoot":   p.RepoRoot(),
+		"Branch":     p.SourceBranch(),
+		"ModProxy":   modProxy,
+	})
+	if err != nil {
+		log.Printf("%s: %v", importPath, err)
+	}
+}
+
+// browse answers a browser that visits an import path. The root of a package
+// redirects to its documentation, unless the package is retired, which gets a
+// page of its own with the way out. Subpackages redirect to pkg.go.dev.
+//
+// Anything else under a package, like upper.io/db.v3/getting-started, is a
+// page of the legacy documentation, which shares the paths of the packages it
+// documents: the 404 tells the front to ask the documentation instead.
+func (s *Server) browse(w http.ResponseWriter, r *http.Request, p *paths.Package, importPath string) {
+	if importPath != p.Path {
+		if !s.isPackage(r.Context(), p, importPath) {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		http.Redirect(w, r, "https://pkg.go.dev/"+p.Canonical(importPath), http.StatusFound)
+		return
+	}
+
+	if p.Docs == "" || s.isSelf(p.Docs, r) {
+		// The documentation of the package lives at its import path, like
+		// upper.io/db.v3/.
+		if p.Deprecated == "" || strings.HasSuffix(r.URL.Path, "/") {
+			http.NotFound(w, r)
+			return
+		}
+	} else if p.Deprecated == "" {
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		http.Redirect(w, r, p.Docs, http.StatusFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	err := packagePage.Execute(w, map[string]interface{}{
+		"ImportPath": importPath,
+		"Package":    p,
+		"Reference":  "https://pkg.go.dev/" + p.Canonical(importPath),
+		"Source":     p.Repo + "/tree/" + p.SourceBranch(),
+	})
+	if err != nil {
+		log.Printf("%s: %v", importPath, err)
+	}
+}
+
+// isSelf reports whether a URL is the one of the request.
+func (s *Server) isSelf(rawURL string, r *http.Request) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	return (u.Host == "" || u.Host == s.conf.Host) && strings.TrimSuffix(u.Path, "/") == strings.TrimSuffix(r.URL.Path, "/")
+}
+
+// isPackage reports whether importPath, under p, is a Go package. Without a
+// mirror to tell, every path is.
+func (s *Server) isPackage(ctx context.Context, p *paths.Package, importPath string) bool {
+	repo := s.conf.Mirrors[p.Mirror]
+	if repo == nil {
+		return true
+	}
+
+	s.mu.Lock()
+	cached := s.dirs[p.Path]
+	s.mu.Unlock()
+
+	if cached == nil || time.Now().After(cached.expires) {
+		dirs, err := repo.PackageDirs(ctx, "refs/heads/"+p.SourceBranch())
+		if err != nil {
+			log.Printf("%s: %v", p.Path, err)
+			if cached == nil {
+				return false
+			}
+		} else {
+			cached = &packageDirs{dirs: dirs, expires: time.Now().Add(dirsTTL)}
+			s.mu.Lock()
+			s.dirs[p.Path] = cached
+			s.mu.Unlock()
+		}
+	}
+	return cached.dirs[strings.TrimPrefix(importPath, p.Path+"/")]
+}
+
+// metaPage is the page the go command reads the location of a package from.
+// go-source is the convention of godoc.org and pkg.go.dev for links to the
+// source of a package. In module mode, the go command prefers a go-import tag
+// with the mod VCS over the others, which GOPATH mode ignores.
+var metaPage = template.Must(template.New("meta").Parse(`<!DOCTYPE html>
+<html>
+  <head>
+    <meta http-equiv="Content-Type" content="text/html; charset=utf-8" />
+    <meta name="go-import" content="{{ .Package.Path }} {{ .Package.VCS }} {{ .RepoRoot }}" />
+    {{- with .ModProxy }}
+    <meta name="go-import" content="{{ $.Package.Path }} mod {{ . }}" />
+    {{- end }}
+    <meta name="go-source" content="{{ .Package.Path }} {{ .Package.Repo }} {{ .Package.Repo }}/tree/{{ .Branch }}{/dir} {{ .Package.Repo }}/blob/{{ .Branch }}{/dir}/{file}#L{line}" />
+    {{- with .Package.Docs }}
+    <meta http-equiv="refresh" content="0; url={{ . }}" />
+    {{- end }}
+  </head>
+  <body>
+    go get {{ .ImportPath }}
+    {{- with .Package.Deprecated }}
+    <p>{{ . }}</p>
+    {{- end }}
+    {{- with .Package.Alias }}
+    <p>This package is also known as {{ . }}, which is the import path to use with Go modules.</p>
+    {{- end }}
+  </body>
+</html>
+`))
+
+// packagePage is the page of a retired package, shown to browsers.
+var packagePage = template.Must(template.New("package").Parse(`<!DOCTYPE html>
+<html>
+  <head>
+    <meta http-equiv="Content-Type" content="text/html; charset=utf-8" />
+    <meta name="viewport" content="width=device-width, initial-scale=1" />
+    <title>{{ .ImportPath }}</title>
+    <style>
+      body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif; max-width: 42em; margin: 3em auto; padding: 0 1em; line-height: 1.5; color: #222; }
+      .banner { background: #fff4e5; border-left: 4px solid #f0a020; padding: 1em 1.25em; margin-bottom: 2em; }
+      .banner h2 { margin-top: 0; font-size: 1.1em; }
+      code { background: #f3f3f3; padding: 0.1em 0.3em; }
+      a { color: #1a6fb0; }
+    </style>
+  </head>
+  <body>
+    {{- with .Package.Deprecated }}
+    <div class="banner">
+      <h2>{{ $.Package.Path }} is retired</h2>
+      <p>{{ . }}</p>
+      {{- with $.Package.Successor }}
+      <p>New code should use <code>{{ . }}</code>.</p>
+      {{- end }}
+      {{- with $.Package.Migration }}
+      <p><a href="{{ . }}">Learn how to migrate</a>.</p>
+      {{- end }}
+    </div>
+    {{- end }}
+    <h1>{{ .ImportPath }}</h1>
+    <p><code>go get {{ .ImportPath }}</code></p>
+    <ul>
+      {{- with .Package.Docs }}
+      <li><a href="{{ . }}">Documentation</a></li>
+      {{- end }}
+      <li><a href="{{ .Reference }}">Package reference</a></li>
+      <li><a href="{{ .Source }}">Source</a></li>
+    </ul>
+  </body>
+</html>
+`))
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/upper/upper.io/vanity/internal/gittest"
+	"github.com/upper/upper.io/vanity/mirror"
+	"github.com/upper/upper.io/vanity/paths"
+)
+
+const packages = `{
+  "Packages": [
+    {"Path": "upper.io/db", "Repo": "https://github.com/upper/db", "Docs": "https://upper.io/v4/"},
+    {"Path": "upper.io/db.v3", "Repo": "https://github.com/upper/db", "Branch": "3", "Docs": "https://upper.io/db.v3/", "Mirror": "db.git"},
+    {"Path": "upper.io/db.v4", "Repo": "https://github.com/upper/db", "DefaultBranch": "main", "Alias": "github.com/upper/db/v4"}
+  ]
+}`
+
+func newMap(t *testing.T, data string) *paths.Map {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "vanity.json")
+	if err := os.WriteFile(name, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := paths.Load(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// get requests a path of srv, without following redirects.
+func get(t *testing.T, srv *httptest.Server, path string) (*http.Response, string) {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	res, err := client.Get(srv.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, string(body)
+}
+
+func TestGoGet(t *testing.T) {
+	srv := httptest.NewServer(New(newMap(t, packages), Config{Host: "upper.io", ModProxy: "https://upper.io/mod"}))
+	defer srv.Close()
+
+	tests := []struct {
+		path    string
+		want    []string
+		notWant []string
+	}{
+		{
+			path: "/db?go-get=1",
+			want: []string{
+				`<meta name="go-import" content="upper.io/db git https://github.com/upper/db" />`,
+				`<meta name="go-source" content="upper.io/db https://github.com/upper/db https://github.com/upper/db/tree/master{/dir} https://github.com/upper/db/blob/master{/dir}/{file}#L{line}" />`,
+				`<meta http-equiv="refresh" content="0; url=https://upper.io/v4/" />`,
+				"go get upper.io/db\n",
+			},
+			notWant: []string{" mod "},
+		},
+		{
+			// Subpackages name the package they are under.
+			path: "/db.v3/postgresql/?go-get=1",
+			want: []string{
+				`<meta name="go-import" content="upper.io/db.v3 git https://upper.io/db.v3" />`,
+				`<meta name="go-import" content="upper.io/db.v3 mod https://upper.io/mod" />`,
+				"https://github.com/upper/db/tree/3{/dir}",
+				"go get upper.io/db.v3/postgresql\n",
+			},
+		},
+		{
+			path: "/db.v4/adapter?go-get=1",
+			want: []string{
+				`<meta name="go-import" content="upper.io/db.v4 git https://github.com/upper/db" />`,
+				"https://github.com/upper/db/tree/main{/dir}",
+				`<meta http-equiv="refresh" content="0; url=https://pkg.go.dev/github.com/upper/db/v4" />`,
+				"This package is also known as github.com/upper/db/v4",
+			},
+			notWant: []string{" mod "},
+		},
+	}
+	for _, tt := range tests {
+		res, body := get(t, srv, tt.path)
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("%s: status %d", tt.path, res.StatusCode)
+			continue
+		}
+		if ct := res.Header.Get("Content-Type"); ct != "text/html; charset=utf-8" {
+			t.Errorf("%s: Content-Type %q", tt.path, ct)
+		}
+		for _, s := range tt.want {
+			if !strings.Contains(body, s) {
+				t.Errorf("%s: no %q in\n%s", tt.path, s, body)
+			}
+		}
+		for _, s := range tt.notWant {
+			if strings.Contains(body, s) {
+				t.Errorf("%s: %q in\n%s", tt.path, s, body)
+			}
+		}
+	}
+
+	for _, path := range []string{"/?go-get=1", "/dbx?go-get=1", "/other/db?go-get=1"} {
+		if res, _ := get(t, srv, path); res.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: status %d, want 404", path, res.StatusCode)
+		}
+	}
+
+	res, err := http.Post(srv.URL+"/db?go-get=1", "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status %d, want 405", res.StatusCode)
+	}
+}
+
+// newMirror creates a repository whose branch 3 has the packages db and
+// db/postgresql, and mirrors it.
+func newMirror(t *testing.T) *mirror.Repo {
+	t.Helper()
+	src := gittest.New(t)
+	src.Git("checkout", "--quiet", "-b", "3")
+	src.Commit(map[string]string{
+		"db.go":                    "package db\n",
+		"postgresql/postgresql.go": "package postgresql\n",
+		"internal/testdata/x.go":   "package x\n",
+	})
+	return src.Mirror()
+}
+
+func TestBrowse(t *testing.T) {
+	m := newMap(t, `{
+  "Packages": [
+    {"Path": "upper.io/db", "Repo": "https://github.com/upper/db", "Docs": "https://upper.io/v4/"},
+    {"Path": "upper.io/db.v1", "Repo": "https://github.com/upper/db", "Branch": "1"},
+    {"Path": "upper.io/db.v2", "Repo": "https://github.com/upper/db", "Branch": "2", "Docs": "https://upper.io/db.v2/",
+     "Deprecated": "upper/db v2 is no longer maintained.", "Successor": "github.com/upper/db/v4", "Migration": "https://upper.io/v4/getting-started/"},
+    {"Path": "upper.io/db.v3", "Repo": "https://github.com/upper/db", "Branch": "3", "Docs": "/db.v3/",
+     "Deprecated": "upper/db v3 is no longer maintained.", "Mirror": "db.git"},
+    {"Path": "upper.io/db.v4", "Repo": "https://github.com/upper/db", "Alias": "github.com/upper/db/v4"},
+    {"Path": "upper.io/db.v9", "Repo": "https://github.com/upper/db", "Branch": "9", "Mirror": "db.git"}
+  ]
+}`)
+	srv := httptest.NewServer(New(m, Config{
+		Host:    "upper.io",
+		Mirrors: map[string]*mirror.Repo{"db.git": newMirror(t)},
+	}))
+	defer srv.Close()
+
+	// The missing branch of db.v9 is logged.
+	w := log.Writer()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(w) })
+
+	tests := []struct {
+		path     string
+		status   int
+		location string
+		body     []string
+	}{
+		{"/db", http.StatusFound, "https://upper.io/v4/", nil},
+		{"/db/", http.StatusFound, "https://upper.io/v4/", nil},
+		{"/db.v4", http.StatusFound, "https://pkg.go.dev/github.com/upper/db/v4", nil},
+
+		// Without a mirror, every path under a package is a subpackage.
+		{"/db/sqlbuilder", http.StatusFound, "https://pkg.go.dev/upper.io/db/sqlbuilder", nil},
+		{"/db.v4/adapter/postgresql", http.StatusFound, "https://pkg.go.dev/github.com/upper/db/v4/adapter/postgresql", nil},
+
+		// With one, the rest are pages of the documentation.
+		{"/db.v3/postgresql", http.StatusFound, "https://pkg.go.dev/upper.io/db.v3/postgresql", nil},
+		{"/db.v3/getting-started", http.StatusNotFound, "", nil},
+		{"/db.v3/internal/testdata", http.StatusNotFound, "", nil},
+		{"/db.v9/postgresql", http.StatusNotFound, "", nil},
+
+		// Without documentation, a package has no page.
+		{"/db.v1", http.StatusNotFound, "", nil},
+
+		{"/db.v2", http.StatusOK, "", []string{
+			"<h2>upper.io/db.v2 is retired</h2>",
+			"<p>upper/db v2 is no longer maintained.</p>",
+			"New code should use <code>github.com/upper/db/v4</code>.",
+			`<a href="https://upper.io/v4/getting-started/">Learn how to migrate</a>`,
+			`<a href="https://upper.io/db.v2/">Documentation</a>`,
+			`<a href="https://pkg.go.dev/upper.io/db.v2">Package reference</a>`,
+			`<a href="https://github.com/upper/db/tree/2">Source</a>`,
+		}},
+
+		// The documentation of db.v3 is at its import path, with a slash:
+		// the page of the package is at the one without.
+		{"/db.v3", http.StatusOK, "", []string{"<h2>upper.io/db.v3 is retired</h2>", `<a href="/db.v3/">Documentation</a>`}},
+		{"/db.v3/", http.StatusNotFound, "", nil},
+	}
+	for _, tt := range tests {
+		res, body := get(t, srv, tt.path)
+		if res.StatusCode != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.path, res.StatusCode, tt.status)
+			continue
+		}
+		if got := res.Header.Get("Location"); got != tt.location {
+			t.Errorf("%s: Location %q, want %q", tt.path, got, tt.location)
+		}
+		for _, s := range tt.body {
+			if !strings.Contains(body, s) {
+				t.Errorf("%s: no %q in\n%s", tt.path, s, body)
+			}
+		}
+	}
+	// Neither a successor nor a migration guide for db.v3.
+	if _, body := get(t, srv, "/db.v3"); strings.Contains(body, "New code should use") || strings.Contains(body, "Learn how to migrate") {
+		t.Errorf("/db.v3: links it has none of in\n%s", body)
+	}
+}
@@ -0,0 +1,153 @@
+// Command front is the reverse proxy in front of the services of upper.io,
+// which routes the requests of upper.io, tour.upper.io and demo.upper.io to
+// the containers that answer them.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/upper/upper.io/worker/front"
+)
+
+var (
+	flagAddr               = flag.String("addr", ":80", "listen address")
+	flagAdmin              = flag.String("admin", "", "listen address of the admin endpoint, which must not be reachable from the outside; none if empty")
+	flagInternal           = flag.String("internal", "", "listen address of the internal site, for the services of upper-network, which must not be reachable from the outside; none if empty")
+	flagMaintenance        = flag.String("maintenance", "", "JSON file of the hosts in maintenance, read again when it changes")
+	flagPools              = flag.String("pools", "", "JSON file of the members of the pools, kept across restarts")
+	flagCheckInterval      = flag.Duration("check-interval", 10*time.Second, "how often the upstreams are checked")
+	flagCompileRate        = flag.Int("compile-rate", 120, "requests per minute each client may send to the compile service, from any origin or none, zero for no limit")
+	flagCompileCredentials = flag.Bool("compile-credentials", false, "let the origins of -compile-origin send cookies and authorization headers")
+)
+
+// upstreams are the URLs of upstreams set with -upstream, by name.
+type upstreams map[string]string
+
+func (u upstreams) String() string {
+	var s []string
+	for name, url := range u {
+		s = append(s, name+"="+url)
+	}
+	return strings.Join(s, ",")
+}
+
+func (u upstreams) Set(v string) error {
+	i := strings.Index(v, "=")
+	if i < 0 {
+		return fmt.Errorf("want name=url, like tour=http://127.0.0.1:4000")
+	}
+	u[v[:i]] = v[i+1:]
+	return nil
+}
+
+// origins are the origins set with -compile-origin.
+type origins []string
+
+func (o *origins) String() string {
+	return strings.Join(*o, ",")
+}
+
+func (o *origins) Set(v string) error {
+	*o = append(*o, v)
+	return nil
+}
+
+func main() {
+	overrides := upstreams{}
+	flag.Var(overrides, "upstream", "URL of an upstream, like tour=http://127.0.0.1:4000; may be repeated")
+	var compileOrigins origins
+	flag.Var(&compileOrigins, "compile-origin", "origin allowed to call the compile service besides the tour and the docs, like https://portal.example.com; may be repeated")
+	flag.Parse()
+
+	table := front.Default()
+	for name, url := range overrides {
+		if _, ok := table.Upstreams[name]; !ok {
+			log.Fatalf("unknown upstream %q", name)
+		}
+		table.Upstreams[name] = url
+	}
+
+	var compileRate front.Rate
+	if *flagCompileRate > 0 {
+		compileRate = front.Rate{Requests: *flagCompileRate, Per: time.Minute}
+	}
+	table.SetRate("compiler", compileRate)
+	for _, origin := range compileOrigins {
+		table.AllowOrigin("compiler", front.Origin{
+			Origin:      origin,
+			Credentials: *flagCompileCredentials,
+		})
+	}
+
+	handler, err := front.New(table)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *flagPools != "" {
+		if err := handler.LoadPools(*flagPools); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *flagMaintenance != "" {
+		if err := handler.WatchMaintenance(ctx, *flagMaintenance, 2*time.Second); err != nil {
+			log.Fatal(err)
+		}
+	}
+	go handler.CheckUpstreams(ctx, *flagCheckInterval)
+
+	if *flagAdmin != "" {
+		go listen(ctx, "admin endpoint", *flagAdmin, handler.Admin())
+	}
+	if *flagInternal != "" {
+		go listen(ctx, "internal site", *flagInternal, handler.Internal())
+	}
+
+	srv := &http.Server{
+		Addr:              *flagAddr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("listening on %s", *flagAddr)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
+
+// listen serves h on addr until ctx is done.
+func listen(ctx context.Context, what, addr string, h http.Handler) {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+	log.Printf("%s on %s", what, addr)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
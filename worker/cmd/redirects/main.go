@@ -0,0 +1,169 @@
+// Command redirects generates the map of the pages of the legacy docs that
+// moved to the docs of v4 from its reviewed table, after it checks that every
+// page the table points to is in the docs.
+//
+// Usage:
+//
+//	redirects [-docs dir] [-o file] [-check] table
+//
+// With -check, the map is not written, but compared with the one of file.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var (
+	flagDocs  = flag.String("docs", "../site/docs", "directory of the docs of v4")
+	flagOut   = flag.String("o", "front/legacy.go", "Go file the map is written to")
+	flagCheck = flag.Bool("check", false, "check the table and that the map is up to date, without writing it")
+)
+
+// docsPrefix is where the docs are served.
+const docsPrefix = "/v4/"
+
+// redirect is an entry of the table.
+type redirect struct {
+	line     int
+	from, to string
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("redirects: ")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: redirects [-docs dir] [-o file] [-check] table\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	table := flag.Arg(0)
+
+	f, err := os.Open(table)
+	if err != nil {
+		log.Fatal(err)
+	}
+	redirects, errs := parse(f)
+	f.Close()
+	errs = append(errs, check(redirects, *flagDocs)...)
+	if len(errs) > 0 {
+		for _, err := range errs {
+			log.Printf("%s:%v", table, err)
+		}
+		os.Exit(1)
+	}
+
+	src, err := generate(redirects, filepath.Base(table))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *flagCheck {
+		old, err := os.ReadFile(*flagOut)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !bytes.Equal(old, src) {
+			log.Fatalf("%s is out of date with %s: run make redirects", *flagOut, table)
+		}
+		return
+	}
+	if err := os.WriteFile(*flagOut, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// parse reads a table, with an entry per line: the path of a legacy page and
+// the one it moved to. Blank lines and lines that start with # are skipped.
+func parse(r io.Reader) ([]redirect, []error) {
+	var (
+		redirects []redirect
+		errs      []error
+		seen      = make(map[string]int)
+	)
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			errs = append(errs, fmt.Errorf("%d: want a path and the path it moved to", n))
+			continue
+		}
+		from, to := fields[0], fields[1]
+		switch {
+		case !strings.HasPrefix(from, "/db.v"):
+			errs = append(errs, fmt.Errorf("%d: %s is not a page of the legacy docs", n, from))
+		case strings.HasSuffix(from, "/") || strings.Count(from, "/") < 2:
+			errs = append(errs, fmt.Errorf("%d: %s must be a page under an import path, without a trailing slash", n, from))
+		case !strings.HasPrefix(to, docsPrefix):
+			errs = append(errs, fmt.Errorf("%d: %s is not a page of the docs", n, to))
+		case seen[from] != 0:
+			errs = append(errs, fmt.Errorf("%d: %s is already moved on line %d", n, from, seen[from]))
+		default:
+			seen[from] = n
+			redirects = append(redirects, redirect{line: n, from: from, to: to})
+		}
+	}
+	if err := s.Err(); err != nil {
+		errs = append(errs, fmt.Errorf(" %v", err))
+	}
+	return redirects, errs
+}
+
+// check verifies that every target is a page of the docs in dir: /v4/x is
+// served from x.md, or x/index.md.
+func check(redirects []redirect, dir string) []error {
+	if _, err := os.Stat(dir); err != nil {
+		return []error{fmt.Errorf(" docs: %v", err)}
+	}
+	var errs []error
+	for _, r := range redirects {
+		page := strings.Trim(strings.TrimPrefix(r.to, docsPrefix), "/")
+		if page == "" || strings.Contains(page, "..") {
+			errs = append(errs, fmt.Errorf("%d: %s is not a page of the docs", r.line, r.to))
+			continue
+		}
+		name := filepath.Join(dir, filepath.FromSlash(page))
+		if !exists(name+".md") && !exists(filepath.Join(name, "index.md")) {
+			errs = append(errs, fmt.Errorf("%d: %s: no %s.md or %s/index.md in %s", r.line, r.to, page, page, dir))
+		}
+	}
+	return errs
+}
+
+func exists(name string) bool {
+	fi, err := os.Stat(name)
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// generate returns the Go source of the map.
+func generate(redirects []redirect, table string) ([]byte, error) {
+	sorted := append([]redirect(nil), redirects...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].from < sorted[j].from })
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by cmd/redirects from %s; DO NOT EDIT.\n\n", table)
+	fmt.Fprintf(&b, "package front\n\n")
+	fmt.Fprintf(&b, "// legacyRedirects are the pages of the legacy docs that moved to the docs of\n// v4, by path.\n")
+	fmt.Fprintf(&b, "var legacyRedirects = map[string]string{\n")
+	for _, r := range sorted {
+		fmt.Fprintf(&b, "\t%q: %q,\n", r.from, r.to)
+	}
+	fmt.Fprintf(&b, "}\n")
+	return format.Source(b.Bytes())
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestTable checks the table of the front against the docs of the repository,
+// and that the map generated from it is up to date.
+func TestTable(t *testing.T) {
+	f, err := os.Open("../../front/legacy.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	redirects, errs := parse(f)
+	errs = append(errs, check(redirects, "../../../site/docs")...)
+	for _, err := range errs {
+		t.Errorf("legacy.txt:%v", err)
+	}
+
+	src, err := generate(redirects, "legacy.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := os.ReadFile("../../front/legacy.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(old, src) {
+		t.Errorf("front/legacy.go is out of date: run make redirects")
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		table string
+		want  int
+		err   string
+	}{
+		{"entries", "# comment\n\n/db.v3/mysql /v4/adapter/mysql\n  /db.v2/ql\t/v4/adapter/ql  \n", 2, ""},
+		{"one field", "/db.v3/mysql\n", 0, "1: want a path"},
+		{"three fields", "/db.v3/mysql /v4/adapter/mysql x\n", 0, "1: want a path"},
+		{"not legacy", "/v4/x /v4/adapter/mysql\n", 0, "is not a page of the legacy docs"},
+		{"import path", "/db.v3 /v4/getting-started\n", 0, "must be a page under an import path"},
+		{"trailing slash", "/db.v3/mysql/ /v4/adapter/mysql\n", 0, "must be a page under an import path"},
+		{"not docs", "/db.v3/mysql https://example.com\n", 0, "is not a page of the docs"},
+		{"duplicate", "/db.v3/mysql /v4/adapter/mysql\n/db.v3/mysql /v4/adapter/ql\n", 1, "2: /db.v3/mysql is already moved on line 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redirects, errs := parse(strings.NewReader(tt.table))
+			if len(redirects) != tt.want {
+				t.Errorf("%d entries, want %d", len(redirects), tt.want)
+			}
+			switch {
+			case tt.err == "" && len(errs) > 0:
+				t.Errorf("errors %v", errs)
+			case tt.err != "" && (len(errs) != 1 || !strings.Contains(errs[0].Error(), tt.err)):
+				t.Errorf("errors %v, want one with %q", errs, tt.err)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"getting-started/index.md", "getting-started/logger.md", "adapter/mysql/index.md"} {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte("---\ntitle: x\n---\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		to string
+		ok bool
+	}{
+		{"/v4/getting-started", true},
+		{"/v4/getting-started/", true},
+		{"/v4/getting-started/logger", true},
+		{"/v4/adapter/mysql", true},
+		{"/v4/adapter/ql", false},
+		{"/v4/adapter", false},
+		{"/v4/", false},
+		{"/v4/../docs", false},
+	}
+	for _, tt := range tests {
+		errs := check([]redirect{{line: 1, from: "/db.v3/x", to: tt.to}}, dir)
+		if (len(errs) == 0) != tt.ok {
+			t.Errorf("check(%s) = %v, want ok %v", tt.to, errs, tt.ok)
+		}
+	}
+
+	if errs := check(nil, filepath.Join(dir, "missing")); len(errs) != 1 {
+		t.Errorf("check of a missing directory = %v, want an error", errs)
+	}
+}
@@ -0,0 +1,221 @@
+package front
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Admin returns the handler of the admin endpoint, which must not be
+// reachable from the outside:
+//
+//	GET /status                       status of the upstreams and hosts in maintenance
+//	PUT /maintenance/<host>           puts a host in maintenance, with the message
+//	                                  and until (RFC 3339) form values
+//	DELETE /maintenance/<host>        ends the maintenance of a host
+//	GET /pools/[<pool>]               members of the pools
+//	PUT /pools/<pool>/<member>        registers the member at the url form value,
+//	                                  with no traffic
+//	POST /pools/<pool>/<member>/verify  runs the check of the pool against a member
+//	POST /pools/<pool>/shift          shifts the traffic to the member of the to form
+//	                                  value, step percent (100) every interval (30s)
+//	POST /pools/<pool>/rollback       restores the weights from before the last shift
+//	DELETE /pools/<pool>/<member>     removes a member with no traffic, once its
+//	                                  requests are over or after timeout (1m)
+func (s *Server) Admin() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.handleStatus)
+	mux.HandleFunc("/maintenance/", s.handleMaintenance)
+	mux.HandleFunc("/pools/", s.handlePools)
+	return mux
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	var upstreams []Status
+	for _, name := range s.poolNames() {
+		upstreams = append(upstreams, s.pools.byName[name].status())
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Upstreams   []Status
+		Maintenance map[string]Maintenance
+	}{upstreams, s.maintenance.all()})
+}
+
+func (s *Server) handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	host := strings.TrimPrefix(r.URL.Path, "/maintenance/")
+	if host == "" || strings.Contains(host, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut, http.MethodPost:
+		mt := Maintenance{Message: r.FormValue("message")}
+		if until := r.FormValue("until"); until != "" {
+			t, err := time.Parse(time.RFC3339, until)
+			if err != nil {
+				http.Error(w, "until: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			mt.Until = &t
+		}
+		if err := s.maintenance.set(host, &mt); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("%s is in maintenance", hostname(host))
+		writeJSON(w, http.StatusOK, mt)
+	case http.MethodDelete:
+		if err := s.maintenance.set(host, nil); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("%s is out of maintenance", hostname(host))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w, "PUT, POST, DELETE")
+	}
+}
+
+// poolState is a pool, as the admin endpoint reports it.
+type poolState struct {
+	Members []Member
+
+	// Shifting is whether traffic is being shifted step by step.
+	Shifting bool
+
+	// Rollback is whether the last shift can be rolled back.
+	Rollback bool
+}
+
+func (p *pool) state() poolState {
+	members := p.list()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return poolState{Members: members, Shifting: p.cancel != nil, Rollback: p.previous != nil}
+}
+
+func (s *Server) handlePools(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/pools/"), "/"), "/")
+	if parts[0] == "" {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, "GET")
+			return
+		}
+		pools := make(map[string]poolState)
+		for name, p := range s.pools.byName {
+			pools[name] = p.state()
+		}
+		writeJSON(w, http.StatusOK, pools)
+		return
+	}
+
+	p := s.pools.byName[parts[0]]
+	if p == nil || len(parts) > 3 {
+		http.NotFound(w, r)
+		return
+	}
+
+	var err error
+	switch {
+	case len(parts) == 1:
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, "GET")
+			return
+		}
+
+	case len(parts) == 2 && parts[1] == "shift":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, "POST")
+			return
+		}
+		step, every := 100, 30*time.Second
+		if v := r.FormValue("step"); v != "" {
+			if step, err = strconv.Atoi(v); err != nil || step <= 0 {
+				http.Error(w, "step: want a percentage", http.StatusBadRequest)
+				return
+			}
+		}
+		if v := r.FormValue("every"); v != "" {
+			if every, err = time.ParseDuration(v); err != nil || every <= 0 {
+				http.Error(w, "every: want a duration, like 30s", http.StatusBadRequest)
+				return
+			}
+		}
+		err = s.shift(p, r.FormValue("to"), step, every)
+
+	case len(parts) == 2 && parts[1] == "rollback":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, "POST")
+			return
+		}
+		err = s.rollback(context.Background(), p)
+
+	case len(parts) == 3 && parts[2] == "verify":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, "POST")
+			return
+		}
+		if err = s.verify(r.Context(), p, parts[1]); err != nil && !errors.Is(err, errUnknownMember) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+	case len(parts) == 2:
+		switch r.Method {
+		case http.MethodPut:
+			if err = s.register(p, parts[1], r.FormValue("url")); err != nil && !errors.Is(err, errConflict) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		case http.MethodDelete:
+			timeout := time.Minute
+			if v := r.FormValue("timeout"); v != "" {
+				if timeout, err = time.ParseDuration(v); err != nil {
+					http.Error(w, "timeout: want a duration, like 1m", http.StatusBadRequest)
+					return
+				}
+			}
+			var left int64
+			if left, err = s.remove(r.Context(), p, parts[1], timeout); err == nil {
+				writeJSON(w, http.StatusOK, struct{ Left int64 }{left})
+				return
+			}
+		default:
+			methodNotAllowed(w, "PUT, DELETE")
+			return
+		}
+
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case errors.Is(err, errUnknownMember):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		writeJSON(w, http.StatusOK, p.state())
+	}
+}
+
+func methodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	_ = enc.Encode(v)
+}
@@ -0,0 +1,290 @@
+package front
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CORS is the cross-origin policy of a route. Preflight requests are answered
+// by the front. Requests over the rate of their client, and those of origins
+// the policy does not allow, are rejected before they reach the upstream.
+//
+// The rate applies to every request, with or without an Origin header. The
+// origins are a check of browsers on top of it: requests without an Origin
+// header, and those of the host of the request itself, are not cross-origin,
+// and are only limited by their rate.
+type CORS struct {
+	// Origins are the origins allowed. The first one that matches the origin
+	// of a request applies.
+	Origins []Origin
+
+	// Methods are the methods allowed, like POST.
+	Methods []string
+
+	// Headers are the request headers allowed, like Content-Type.
+	Headers []string
+
+	// MaxAge is how long browsers cache preflight responses.
+	MaxAge time.Duration
+
+	// Expose are the headers of the response scripts can read.
+	Expose []string
+
+	// Rate limits the requests of each client, other than preflights, told
+	// apart by their IP address. The zero Rate is no limit.
+	Rate Rate
+}
+
+// Origin is an origin a CORS policy allows.
+type Origin struct {
+	// Origin is a scheme and a host, like https://tour.upper.io. The host may
+	// start with *. to allow its subdomains, and * alone allows any origin.
+	Origin string
+
+	// Credentials lets pages of the origin send cookies and authorization
+	// headers. Credentialed requests of other origins are rejected.
+	Credentials bool
+}
+
+// Rate is a number of requests per period. Requests are allowed in bursts of
+// up to Requests.
+type Rate struct {
+	Requests int
+	Per      time.Duration
+}
+
+func (c *CORS) validate() error {
+	if len(c.Origins) == 0 {
+		return fmt.Errorf("CORS: no origins")
+	}
+	if len(c.Methods) == 0 {
+		return fmt.Errorf("CORS: no methods")
+	}
+	if (c.Rate.Requests > 0) != (c.Rate.Per > 0) || c.Rate.Requests < 0 {
+		return fmt.Errorf("CORS: invalid rate %d per %v", c.Rate.Requests, c.Rate.Per)
+	}
+	for _, o := range c.Origins {
+		if o.Origin == "*" {
+			if o.Credentials {
+				return fmt.Errorf("CORS: credentials cannot be allowed to any origin")
+			}
+		} else if u, err := url.Parse(o.Origin); err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+			return fmt.Errorf("CORS: invalid origin %q", o.Origin)
+		}
+	}
+	return nil
+}
+
+// match returns the index of the origin of c that allows origin, or -1.
+func (c *CORS) match(origin string) int {
+	origin = strings.ToLower(origin)
+	for i, o := range c.Origins {
+		pattern := strings.ToLower(o.Origin)
+		switch {
+		case pattern == "*" || pattern == origin:
+			return i
+		case strings.Contains(pattern, "://*."):
+			j := strings.Index(pattern, "*")
+			scheme, domain := pattern[:j], pattern[j+1:]
+			if strings.HasPrefix(origin, scheme) && strings.HasSuffix(origin, domain) && len(origin) > len(pattern)-1 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// policy enforces a CORS policy, with the rate limiter of its clients.
+type policy struct {
+	*CORS
+	limiter *limiter // nil for no limit
+}
+
+func newPolicy(c *CORS) *policy {
+	p := &policy{CORS: c}
+	if c.Rate.Requests > 0 {
+		p.limiter = newLimiter(c.Rate)
+	}
+	return p
+}
+
+// serve applies the policy to a request. It reports whether the request is
+// to be passed to the upstream; if not, it has been answered.
+func (p *policy) serve(w http.ResponseWriter, r *http.Request) bool {
+	h := w.Header()
+	if origin := r.Header.Get("Origin"); origin != "" && origin != "http://"+r.Host && origin != "https://"+r.Host {
+		h.Add("Vary", "Origin")
+
+		i := p.match(origin)
+		if i < 0 {
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+			return false
+		}
+		o := p.Origins[i]
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			p.preflight(w, r, o)
+			return false
+		}
+
+		if !o.Credentials && (r.Header.Get("Cookie") != "" || r.Header.Get("Authorization") != "") {
+			http.Error(w, "credentials not allowed", http.StatusForbidden)
+			return false
+		}
+		p.allowOrigin(h, o, origin)
+		if len(p.Expose) > 0 {
+			h.Set("Access-Control-Expose-Headers", strings.Join(p.Expose, ", "))
+		}
+	}
+
+	if p.limiter != nil {
+		if ok, wait := p.limiter.allow(client(r), time.Now()); !ok {
+			h.Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return false
+		}
+	}
+	return true
+}
+
+// client returns the key of the client of a request in the rate limiters:
+// its IP address, or the /64 network of an IPv6 address, as a host is
+// usually given a whole one.
+func client(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return host
+	case ip.To4() == nil:
+		return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+	}
+	return ip.String()
+}
+
+// preflight answers a preflight request of an allowed origin.
+func (p *policy) preflight(w http.ResponseWriter, r *http.Request, o Origin) {
+	h := w.Header()
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+
+	if !contains(p.Methods, r.Header.Get("Access-Control-Request-Method")) {
+		http.Error(w, "method not allowed", http.StatusForbidden)
+		return
+	}
+	for _, field := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		if field = strings.TrimSpace(field); field != "" && !contains(p.Headers, field) {
+			http.Error(w, "header "+field+" not allowed", http.StatusForbidden)
+			return
+		}
+	}
+
+	p.allowOrigin(h, o, r.Header.Get("Origin"))
+	h.Set("Access-Control-Allow-Methods", strings.Join(p.Methods, ", "))
+	if len(p.Headers) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(p.Headers, ", "))
+	}
+	if p.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge/time.Second)))
+	}
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	h.Set("Content-Length", "0")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (p *policy) allowOrigin(h http.Header, o Origin, origin string) {
+	if o.Origin == "*" {
+		h.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	h.Set("Access-Control-Allow-Origin", origin)
+	if o.Credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// contains reports whether list has s, in any case.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// maxBuckets is the number of clients a limiter keeps track of before it
+// forgets those which are not limited anymore.
+const maxBuckets = 10000
+
+// limiter is a token bucket per client.
+type limiter struct {
+	rate Rate
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(rate Rate) *limiter {
+	return &limiter{rate: rate, buckets: make(map[string]*bucket)}
+}
+
+// allow takes a token of the bucket of key. If there is none, it returns how
+// long until there is.
+func (l *limiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.buckets[key]
+	if b == nil {
+		if len(l.buckets) >= maxBuckets {
+			l.sweep(now)
+		}
+		b = &bucket{tokens: float64(l.rate.Requests), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) * float64(l.interval()))
+}
+
+// interval is the time it takes for a token to come back.
+func (l *limiter) interval() time.Duration {
+	return l.rate.Per / time.Duration(l.rate.Requests)
+}
+
+func (l *limiter) refill(b *bucket, now time.Time) float64 {
+	tokens := b.tokens + float64(now.Sub(b.last))/float64(l.interval())
+	if max := float64(l.rate.Requests); tokens > max {
+		return max
+	}
+	return tokens
+}
+
+// sweep forgets the buckets that are full again.
+func (l *limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if l.refill(b, now) >= float64(l.rate.Requests) {
+			delete(l.buckets, key)
+		}
+	}
+}
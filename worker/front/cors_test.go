@@ -0,0 +1,346 @@
+package front
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORS(t *testing.T) {
+	upstream := httptest.NewServer(stub("compiler"))
+	defer upstream.Close()
+
+	policy := &CORS{
+		Origins: []Origin{
+			{Origin: "https://tour.upper.io"},
+			{Origin: "https://portal.example.com", Credentials: true},
+			{Origin: "https://*.upper.io"},
+		},
+		Methods: []string{"POST", "OPTIONS"},
+		Headers: []string{"Content-Type"},
+		MaxAge:  time.Hour,
+		Rate:    Rate{Requests: 3, Per: time.Hour},
+	}
+	s, err := New(&Table{
+		Upstreams: map[string]string{"compiler": upstream.URL},
+		Sites: []Site{{
+			Hosts: []string{"demo.upper.io"},
+			Routes: []Route{
+				{Match: Prefix, Path: "/compile", Upstream: "compiler", CORS: policy},
+				{Match: Prefix, Path: "/fmt", Upstream: "compiler", CORS: policy},
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The cases run in order: the ones of 198.51.100.1 use up its rate. The
+	// others come from an address of their own.
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		remote  string
+		headers map[string]string
+
+		status   int
+		upstream bool
+		want     map[string]string
+	}{
+		{
+			name: "preflight", method: "OPTIONS",
+			headers: map[string]string{
+				"Origin":                         "https://tour.upper.io",
+				"Access-Control-Request-Method":  "POST",
+				"Access-Control-Request-Headers": "content-type",
+			},
+			status: 204,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":      "https://tour.upper.io",
+				"Access-Control-Allow-Methods":     "POST, OPTIONS",
+				"Access-Control-Allow-Headers":     "Content-Type",
+				"Access-Control-Max-Age":           "3600",
+				"Access-Control-Allow-Credentials": "",
+			},
+		},
+		{
+			name: "preflight of a method not allowed", method: "OPTIONS",
+			headers: map[string]string{
+				"Origin":                        "https://tour.upper.io",
+				"Access-Control-Request-Method": "DELETE",
+			},
+			status: 403,
+			want:   map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name: "preflight of a header not allowed", method: "OPTIONS",
+			headers: map[string]string{
+				"Origin":                         "https://tour.upper.io",
+				"Access-Control-Request-Method":  "POST",
+				"Access-Control-Request-Headers": "Content-Type, X-Token",
+			},
+			status: 403,
+		},
+		{
+			name: "preflight of an origin not allowed", method: "OPTIONS",
+			headers: map[string]string{
+				"Origin":                        "https://example.com",
+				"Access-Control-Request-Method": "POST",
+			},
+			status: 403,
+		},
+		{
+			name:    "origin not allowed",
+			headers: map[string]string{"Origin": "https://example.com"},
+			status:  403,
+			want:    map[string]string{"Vary": "Origin", "Access-Control-Allow-Origin": ""},
+		},
+		{
+			name:    "null origin",
+			headers: map[string]string{"Origin": "null"},
+			status:  403,
+		},
+		{
+			name:    "lookalike of a subdomain",
+			headers: map[string]string{"Origin": "https://evilupper.io"},
+			status:  403,
+		},
+		{
+			name:    "subdomain",
+			headers: map[string]string{"Origin": "https://docs.upper.io"},
+			status:  200, upstream: true,
+			want: map[string]string{"Access-Control-Allow-Origin": "https://docs.upper.io"},
+		},
+		{
+			name:    "subdomain over http",
+			headers: map[string]string{"Origin": "http://docs.upper.io"},
+			status:  403,
+		},
+		{
+			name:    "cookies of an origin without credentials",
+			headers: map[string]string{"Origin": "https://docs.upper.io", "Cookie": "session=1"},
+			status:  403,
+		},
+		{
+			name:    "authorization of an origin without credentials",
+			headers: map[string]string{"Origin": "https://docs.upper.io", "Authorization": "Bearer x"},
+			status:  403,
+		},
+		{
+			name:    "cookies of an origin with credentials",
+			headers: map[string]string{"Origin": "https://portal.example.com", "Cookie": "session=1"},
+			status:  200, upstream: true,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":      "https://portal.example.com",
+				"Access-Control-Allow-Credentials": "true",
+			},
+		},
+		{
+			name:    "same origin",
+			headers: map[string]string{"Origin": "https://demo.upper.io", "Cookie": "session=1"},
+			status:  200, upstream: true,
+			want: map[string]string{"Access-Control-Allow-Origin": "", "Vary": ""},
+		},
+		{name: "no origin", status: 200, upstream: true},
+		{
+			name:   "first request of the rate, without origin",
+			remote: "198.51.100.1:1234",
+			status: 200, upstream: true,
+		},
+		{
+			name: "second request of the rate, of an origin, on another route", target: "/fmt",
+			remote:  "198.51.100.1:1234",
+			headers: map[string]string{"Origin": "https://tour.upper.io"},
+			status:  200, upstream: true,
+		},
+		{
+			name:    "third request of the rate, of the same origin",
+			remote:  "198.51.100.1:5678",
+			headers: map[string]string{"Origin": "https://demo.upper.io"},
+			status:  200, upstream: true,
+		},
+		{
+			name:   "over the rate without origin",
+			remote: "198.51.100.1:1234",
+			status: 429,
+			want:   map[string]string{"Retry-After": "1200"},
+		},
+		{
+			name:    "over the rate of an origin",
+			remote:  "198.51.100.1:1234",
+			headers: map[string]string{"Origin": "https://tour.upper.io"},
+			status:  429,
+			want: map[string]string{
+				"Retry-After":                 "1200",
+				"Access-Control-Allow-Origin": "https://tour.upper.io",
+			},
+		},
+		{
+			name: "preflight over the rate", method: "OPTIONS",
+			remote: "198.51.100.1:1234",
+			headers: map[string]string{
+				"Origin":                        "https://tour.upper.io",
+				"Access-Control-Request-Method": "POST",
+			},
+			status: 204,
+		},
+		{
+			name:    "origin not allowed over the rate",
+			remote:  "198.51.100.1:1234",
+			headers: map[string]string{"Origin": "https://example.com"},
+			status:  403,
+		},
+		{
+			name:    "other clients over the rate of one",
+			remote:  "198.51.100.2:1234",
+			headers: map[string]string{"Origin": "https://tour.upper.io"},
+			status:  200, upstream: true,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method, target := tt.method, tt.target
+			if method == "" {
+				method = "POST"
+			}
+			if target == "" {
+				target = "/compile"
+			}
+			r := httptest.NewRequest(method, "http://demo.upper.io"+target, nil)
+			r.RemoteAddr = tt.remote
+			if r.RemoteAddr == "" {
+				r.RemoteAddr = fmt.Sprintf("192.0.2.%d:1234", i+1)
+			}
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Errorf("status %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("X-Upstream") != ""; got != tt.upstream {
+				t.Errorf("passed to the upstream: %v, want %v", got, tt.upstream)
+			}
+			for k, v := range tt.want {
+				if got := w.Header().Get(k); got != v {
+					t.Errorf("%s: %q, want %q", k, got, v)
+				}
+			}
+		})
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter(Rate{Requests: 3, Per: 3 * time.Second})
+	now := time.Unix(0, 0)
+
+	steps := []struct {
+		after time.Duration
+		key   string
+		ok    bool
+		wait  time.Duration
+	}{
+		{0, "a", true, 0},
+		{0, "a", true, 0},
+		{0, "a", true, 0},
+		{0, "a", false, time.Second},
+		{0, "b", true, 0},
+		{500 * time.Millisecond, "a", false, 500 * time.Millisecond},
+		{500 * time.Millisecond, "a", true, 0},
+		{0, "a", false, time.Second},
+		{time.Hour, "a", true, 0},
+		{0, "a", true, 0},
+		{0, "a", true, 0},
+		{0, "a", false, time.Second},
+	}
+	for i, step := range steps {
+		now = now.Add(step.after)
+		ok, wait := l.allow(step.key, now)
+		if ok != step.ok || wait != step.wait {
+			t.Errorf("step %d: allow(%q) = %v, %v, want %v, %v", i, step.key, ok, wait, step.ok, step.wait)
+		}
+	}
+
+	l.sweep(now.Add(time.Hour))
+	if len(l.buckets) != 0 {
+		t.Errorf("%d buckets after a sweep, want 0", len(l.buckets))
+	}
+}
+
+func TestClient(t *testing.T) {
+	tests := []struct {
+		remote, want string
+	}{
+		{"192.0.2.1:1234", "192.0.2.1"},
+		{"192.0.2.1:5678", "192.0.2.1"},
+		{"[::ffff:192.0.2.1]:1234", "192.0.2.1"},
+		{"[2001:db8:1:2:3:4:5:6]:1234", "2001:db8:1:2::/64"},
+		{"[2001:db8:1:2::7]:5678", "2001:db8:1:2::/64"},
+		{"[2001:db8:1:3::7]:5678", "2001:db8:1:3::/64"},
+		{"@", "@"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "http://demo.upper.io/compile", nil)
+		r.RemoteAddr = tt.remote
+		if got := client(r); got != tt.want {
+			t.Errorf("client(%q) = %q, want %q", tt.remote, got, tt.want)
+		}
+	}
+}
+
+func TestCORSValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		cors CORS
+		ok   bool
+	}{
+		{"valid", CORS{Origins: []Origin{{Origin: "https://upper.io"}}, Methods: []string{"POST"}}, true},
+		{"subdomains", CORS{Origins: []Origin{{Origin: "https://*.upper.io", Credentials: true}}, Methods: []string{"POST"}}, true},
+		{"any origin", CORS{Origins: []Origin{{Origin: "*"}}, Methods: []string{"POST"}}, true},
+		{"no origins", CORS{Methods: []string{"POST"}}, false},
+		{"no methods", CORS{Origins: []Origin{{Origin: "https://upper.io"}}}, false},
+		{"credentials to any origin", CORS{Origins: []Origin{{Origin: "*", Credentials: true}}, Methods: []string{"POST"}}, false},
+		{"origin with a path", CORS{Origins: []Origin{{Origin: "https://upper.io/v4"}}, Methods: []string{"POST"}}, false},
+		{"origin without a scheme", CORS{Origins: []Origin{{Origin: "upper.io"}}, Methods: []string{"POST"}}, false},
+		{"rate", CORS{Origins: []Origin{{Origin: "https://upper.io"}}, Methods: []string{"POST"}, Rate: Rate{Requests: 1, Per: time.Second}}, true},
+		{"rate without a period", CORS{Origins: []Origin{{Origin: "https://upper.io"}}, Methods: []string{"POST"}, Rate: Rate{Requests: 1}}, false},
+		{"negative rate", CORS{Origins: []Origin{{Origin: "https://upper.io"}}, Methods: []string{"POST"}, Rate: Rate{Requests: -1, Per: time.Second}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cors.validate(); (err == nil) != tt.ok {
+				t.Errorf("validate() = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestAllowOrigin(t *testing.T) {
+	table := Default()
+	if !table.AllowOrigin("compiler", Origin{Origin: "https://portal.example.com"}) {
+		t.Fatal("no CORS policy for the compiler")
+	}
+	if table.AllowOrigin("tour", Origin{Origin: "https://portal.example.com"}) {
+		t.Error("CORS policy for the tour")
+	}
+	s, err := New(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/compile", "/fmt", "/vet"} {
+		r := httptest.NewRequest("OPTIONS", "http://demo.upper.io"+path, nil)
+		r.Header.Set("Origin", "https://portal.example.com")
+		r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != http.StatusNoContent {
+			t.Errorf("%s: status %d, want %d", path, w.Code, http.StatusNoContent)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+// Package front is the reverse proxy in front of the services of upper.io. It
+// routes the requests of each host to the upstream that answers them, with
+// the routes of a Table.
+package front
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server routes requests to upstreams.
+type Server struct {
+	table       *Table
+	pools       pools
+	policies    map[*CORS]*policy
+	maintenance maintenance
+
+	intervalMu sync.Mutex
+	interval   time.Duration // of the checks
+}
+
+// New creates a server for the routes of t.
+func New(t *Table) (*Server, error) {
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+	s := &Server{
+		table:    t,
+		pools:    pools{byName: make(map[string]*pool)},
+		policies: make(map[*CORS]*policy),
+		interval: 30 * time.Second,
+	}
+	for name, raw := range t.Upstreams {
+		p := &pool{name: name}
+		if c, ok := t.Checks[name]; ok {
+			p.check = &c
+		}
+		m, err := s.newMember(p, initialMember, raw)
+		if err != nil {
+			return nil, err
+		}
+		// Upstreams are up until their checks say otherwise.
+		m.weight, m.verified, m.status.Up = 100, true, true
+		p.members = []*member{m}
+		s.pools.byName[name] = p
+	}
+	for i := range t.Sites {
+		s.addPolicies(&t.Sites[i])
+	}
+	if t.Internal != nil {
+		s.addPolicies(t.Internal)
+	}
+	return s, nil
+}
+
+// addPolicies adds the CORS policies of the routes of site. Routes that share
+// a policy share the rate limits of its clients.
+func (s *Server) addPolicies(site *Site) {
+	for _, r := range site.Routes {
+		if r.CORS != nil && s.policies[r.CORS] == nil {
+			s.policies[r.CORS] = newPolicy(r.CORS)
+		}
+	}
+}
+
+// errFallback is returned by ModifyResponse to ask the fallback of a route.
+var errFallback = errors.New("fallback")
+
+// fallbackKey is the context key of the fallback of a request.
+type fallbackKey struct{}
+
+// fallback is where a request goes if its upstream answers 404: the pool of
+// the fallback, and the request as the front was to pass it, before the proxy
+// of the upstream added its headers.
+type fallback struct {
+	pool *pool
+	req  *http.Request
+	site *Site
+}
+
+func (s *Server) newProxy(name string, target *url.URL) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Director: func(r *http.Request) {
+			r.URL.Scheme = target.Scheme
+			r.URL.Host = target.Host
+			// The Host header of the request is kept, as the upstreams
+			// answer for more than one host.
+		},
+		// Flush right away, for the output streamed by the compile
+		// service.
+		FlushInterval: -1,
+		ModifyResponse: func(res *http.Response) error {
+			if res.StatusCode == http.StatusNotFound && res.Request.Context().Value(fallbackKey{}) != nil {
+				return errFallback
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			if errors.Is(err, errFallback) {
+				f := r.Context().Value(fallbackKey{}).(*fallback)
+				m := f.pool.pick()
+				if m == nil {
+					s.unavailable(w, f.req, http.StatusServiceUnavailable, f.site, nil)
+					return
+				}
+				m.serve(w, f.req)
+				return
+			}
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+			log.Printf("%s: %s %s%s: %v", name, r.Method, r.Host, r.URL.Path, err)
+			s.unavailable(w, r, http.StatusBadGateway, s.table.site(r.Host), nil)
+		},
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.serve(w, r, s.table.site(r.Host))
+}
+
+// Internal returns the handler of the internal site of the table, for the
+// listener of the services of upper-network. Without an internal site, it
+// answers 404.
+func (s *Server) Internal() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.table.Internal == nil {
+			http.NotFound(w, r)
+			return
+		}
+		s.serve(w, r, s.table.Internal)
+	})
+}
+
+// serve passes a request to the upstream of its route in site.
+func (s *Server) serve(w http.ResponseWriter, r *http.Request, site *Site) {
+	if to, ok := site.moved(r.URL.Path); ok && (r.Method == http.MethodGet || r.Method == http.MethodHead) && !isGoTool(r) {
+		http.Redirect(w, r, to, http.StatusMovedPermanently)
+		return
+	}
+
+	route := site.route(r.URL.Path)
+	if route == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if route.Redirect != "" {
+		http.Redirect(w, r, route.Redirect, http.StatusFound)
+		return
+	}
+
+	if route.CORS != nil && !s.policies[route.CORS].serve(w, r) {
+		return
+	}
+
+	if mt, ok := s.maintenance.get(r.Host); ok && !route.Essential && !isGoTool(r) {
+		s.unavailable(w, r, http.StatusServiceUnavailable, site, &mt)
+		return
+	}
+
+	hasFallback := route.Fallback != "" && (r.Method == http.MethodGet || r.Method == http.MethodHead)
+	m := s.pools.byName[route.Upstream].pick()
+	// While the upstream is down, browsers get the fallback; the go command
+	// and git get a 503 to retry, not pages they cannot read.
+	if m == nil && hasFallback && !isGoTool(r) {
+		m, hasFallback = s.pools.byName[route.Fallback].pick(), false
+	}
+	if m == nil {
+		s.unavailable(w, r, http.StatusServiceUnavailable, site, nil)
+		return
+	}
+
+	out := r.Clone(r.Context())
+	if route.Rewrite != "" {
+		out.URL.Path = route.Rewrite
+		out.URL.RawPath = ""
+	}
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		out.Header.Set("X-Real-IP", ip)
+	}
+	if hasFallback {
+		f := &fallback{pool: s.pools.byName[route.Fallback], req: out, site: site}
+		out = out.WithContext(context.WithValue(out.Context(), fallbackKey{}, f))
+	}
+	m.serve(w, out)
+}
+
+// isGoTool reports whether a request comes from the go command or git, which
+// are neither sent to moved pages, nor to the fallback of a route that is
+// down, nor kept out by a maintenance.
+func isGoTool(r *http.Request) bool {
+	return r.URL.Query().Get("go-get") == "1" || strings.Contains(r.URL.RequestURI(), "git-upload-pack")
+}
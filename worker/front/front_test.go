@@ -0,0 +1,384 @@
+package front
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// stub is an upstream that tells who answered a request, and what it was
+// asked, in the headers of its response.
+func stub(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("X-Upstream", name)
+		h.Set("X-Path", r.URL.RequestURI())
+		h.Set("X-Host", r.Host)
+		h.Set("X-Seen-Real-IP", r.Header.Get("X-Real-IP"))
+		h.Set("X-Seen-Forwarded-For", r.Header.Get("X-Forwarded-For"))
+		// vanity knows the import paths only.
+		if name == "vanity" && !strings.HasPrefix(r.URL.Path, "/db") && !strings.HasPrefix(r.URL.Path, "/mod/") {
+			http.NotFound(w, r)
+			return
+		}
+		if name == "vanity" && strings.HasPrefix(r.URL.Path, "/db.v3/migrate-from-v2") {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// newServer returns a server for the default table, whose upstreams are
+// stubs.
+func newServer(t *testing.T) *Server {
+	t.Helper()
+	table := Default()
+	for name := range table.Upstreams {
+		srv := httptest.NewServer(stub(name))
+		t.Cleanup(srv.Close)
+		table.Upstreams[name] = srv.URL
+	}
+	s, err := New(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestRoutes(t *testing.T) {
+	s := newServer(t)
+
+	tests := []struct {
+		name       string
+		method     string
+		host       string
+		target     string
+		reqHeaders map[string]string
+
+		status   int
+		upstream string // empty if the front answers
+		path     string // as the upstream got it
+		location string
+		headers  map[string]string
+	}{
+		// upper.io
+		{name: "root", host: "upper.io", target: "/", status: 302, location: "/v4/"},
+		{name: "v4 without slash", host: "upper.io", target: "/v4", status: 302, location: "/v4/"},
+		{name: "v4 index", host: "upper.io", target: "/v4/", status: 200, upstream: "docs", path: "/"},
+		{name: "v4 page", host: "upper.io", target: "/v4/getting-started/", status: 200, upstream: "docs", path: "/v4/getting-started/"},
+		{name: "css", host: "upper.io", target: "/css/main.css", status: 200, upstream: "docs", path: "/css/main.css"},
+		{name: "js", host: "upper.io", target: "/js/main.js", status: 200, upstream: "docs", path: "/js/main.js"},
+		{name: "img", host: "upper.io", target: "/img/logo.svg", status: 200, upstream: "docs", path: "/img/logo.svg"},
+		{name: "blog index", host: "upper.io", target: "/blog/", status: 200, upstream: "docs", path: "/blog/"},
+		{name: "blog post", host: "upper.io", target: "/blog/2020/11/04/upper-db-v4", status: 200, upstream: "docs", path: "/blog/2020/11/04/upper-db-v4"},
+		{name: "go-get", host: "upper.io", target: "/db.v3?go-get=1", status: 200, upstream: "vanity", path: "/db.v3?go-get=1"},
+		{name: "go-get subpackage", host: "upper.io", target: "/db.v3/postgresql?go-get=1", status: 200, upstream: "vanity", path: "/db.v3/postgresql?go-get=1"},
+		{name: "git info/refs", host: "upper.io", target: "/db.v3/info/refs?service=git-upload-pack", status: 200, upstream: "vanity", path: "/db.v3/info/refs?service=git-upload-pack"},
+		{name: "git upload-pack", method: "POST", host: "upper.io", target: "/db.v3/git-upload-pack", status: 200, upstream: "vanity", path: "/db.v3/git-upload-pack"},
+		{name: "module proxy", host: "upper.io", target: "/mod/upper.io/db.v3/@v/list", status: 200, upstream: "vanity", path: "/mod/upper.io/db.v3/@v/list"},
+		// Browsers get the docs redirects and retired-package pages of
+		// vanity for the import paths, before the legacy docs.
+		{name: "browser on import path", host: "upper.io", target: "/db.v3", status: 200, upstream: "vanity", path: "/db.v3"},
+		{name: "browser on subpackage", host: "upper.io", target: "/db/v4/adapter/postgresql", status: 200, upstream: "vanity", path: "/db/v4/adapter/postgresql"},
+		{name: "HEAD on import path", method: "HEAD", host: "upper.io", target: "/db.v4", status: 200, upstream: "vanity", path: "/db.v4"},
+		{name: "legacy docs page", host: "upper.io", target: "/db.v3/migrate-from-v2", status: 200, upstream: "legacy-docs", path: "/db.v3/migrate-from-v2"},
+		{name: "HEAD on a legacy docs page", method: "HEAD", host: "upper.io", target: "/db.v3/migrate-from-v2", status: 200, upstream: "legacy-docs", path: "/db.v3/migrate-from-v2"},
+		{
+			name: "forwarded headers of the fallback", host: "upper.io", target: "/db.v3/migrate-from-v2?x=1",
+			status: 200, upstream: "legacy-docs", path: "/db.v3/migrate-from-v2?x=1",
+			headers: map[string]string{
+				"X-Host":               "upper.io",
+				"X-Seen-Real-IP":       "192.0.2.1",
+				"X-Seen-Forwarded-For": "192.0.2.1",
+			},
+		},
+		{name: "legacy docs asset", host: "upper.io", target: "/favicon.ico", status: 200, upstream: "legacy-docs", path: "/favicon.ico"},
+		{name: "moved page", host: "upper.io", target: "/db.v3/getting-started", status: 301, location: "/v4/getting-started"},
+		{name: "moved page with a slash", host: "upper.io", target: "/db.v2/lib/sqlbuilder/", status: 301, location: "/v4/getting-started/sql-builder-api"},
+		{name: "moved adapter", host: "dev.upper.io", target: "/db.v1/postgresql", status: 301, location: "/v4/adapter/postgresql"},
+		{name: "go-get on a moved page", host: "upper.io", target: "/db.v3/postgresql?go-get=1", status: 200, upstream: "vanity", path: "/db.v3/postgresql?go-get=1"},
+		{name: "git on a moved page", host: "upper.io", target: "/db.v3/postgresql/info/refs?service=git-upload-pack", status: 200, upstream: "vanity", path: "/db.v3/postgresql/info/refs?service=git-upload-pack"},
+		{name: "moved on upper.io only", host: "tour.upper.io", target: "/db.v3/getting-started", status: 200, upstream: "tour", path: "/db.v3/getting-started"},
+		// Like with the error_page of nginx, the go command and git get the
+		// legacy docs for what vanity does not know.
+		{name: "unknown go-get", host: "upper.io", target: "/unknown?go-get=1", status: 200, upstream: "legacy-docs", path: "/unknown?go-get=1"},
+		{name: "unknown git", host: "upper.io", target: "/unknown/info/refs?service=git-upload-pack", status: 200, upstream: "legacy-docs", path: "/unknown/info/refs?service=git-upload-pack"},
+		{name: "no fallback for POST", method: "POST", host: "upper.io", target: "/unknown", status: 404, upstream: "vanity"},
+		{name: "no fallback for git POST", method: "POST", host: "upper.io", target: "/unknown/git-upload-pack", status: 404, upstream: "vanity"},
+		{name: "dev host", host: "dev.upper.io", target: "/", status: 302, location: "/v4/"},
+		{name: "host with port", host: "upper.io:80", target: "/v4/", status: 200, upstream: "docs", path: "/"},
+		{name: "unknown host", host: "203.0.113.1", target: "/v4/", status: 200, upstream: "docs", path: "/"},
+		{
+			name: "forwarded headers", host: "upper.io", target: "/v4/intro",
+			status: 200, upstream: "docs", path: "/v4/intro",
+			headers: map[string]string{
+				"X-Host":               "upper.io",
+				"X-Seen-Real-IP":       "192.0.2.1",
+				"X-Seen-Forwarded-For": "192.0.2.1",
+			},
+		},
+
+		// tour.upper.io
+		{name: "tour", host: "tour.upper.io", target: "/welcome/01", status: 200, upstream: "tour", path: "/welcome/01"},
+		{name: "tour root", host: "tour.dev.upper.io", target: "/", status: 200, upstream: "tour", path: "/"},
+
+		// demo.upper.io
+		{name: "demo root", host: "demo.upper.io", target: "/", status: 302, location: "https://tour.upper.io"},
+		{
+			name: "compile preflight", method: "OPTIONS", host: "demo.upper.io", target: "/compile",
+			reqHeaders: map[string]string{
+				"Origin":                        "https://tour.upper.io",
+				"Access-Control-Request-Method": "POST",
+			},
+			status: 204,
+			headers: map[string]string{
+				"Access-Control-Allow-Origin":  "https://tour.upper.io",
+				"Access-Control-Allow-Methods": "POST, OPTIONS",
+				"Access-Control-Max-Age":       "1728000",
+				"Content-Length":               "0",
+			},
+		},
+		{
+			name: "compile", method: "POST", host: "demo.upper.io", target: "/compile",
+			reqHeaders: map[string]string{"Origin": "https://upper.io"},
+			status:     200, upstream: "compiler", path: "/compile",
+			headers: map[string]string{
+				"Access-Control-Allow-Origin":   "https://upper.io",
+				"Access-Control-Expose-Headers": "Content-Length, Content-Range",
+				"Vary":                          "Origin",
+			},
+		},
+		{
+			name: "compile from another origin", method: "POST", host: "demo.upper.io", target: "/compile",
+			reqHeaders: map[string]string{"Origin": "https://example.com"},
+			status:     403,
+		},
+		{name: "compile stream", method: "POST", host: "demo.upper.io", target: "/compile/stream", status: 200, upstream: "compiler", path: "/compile/stream"},
+		{name: "fmt", method: "POST", host: "demo.upper.io", target: "/fmt", status: 200, upstream: "compiler", path: "/fmt"},
+		{name: "vet", method: "POST", host: "demo.dev.upper.io", target: "/vet", status: 200, upstream: "compiler", path: "/vet"},
+		{name: "no CORS without origin", host: "demo.upper.io", target: "/compile", status: 200, upstream: "compiler", path: "/compile", headers: map[string]string{"Access-Control-Allow-Origin": ""}},
+		{
+			name: "share preflight", method: "OPTIONS", host: "demo.upper.io", target: "/share",
+			reqHeaders: map[string]string{
+				"Origin":                        "https://example.com",
+				"Access-Control-Request-Method": "POST",
+			},
+			status: 204,
+			headers: map[string]string{
+				"Access-Control-Allow-Origin":   "*",
+				"Access-Control-Expose-Headers": "",
+			},
+		},
+		{
+			name: "share", method: "POST", host: "demo.upper.io", target: "/share",
+			reqHeaders: map[string]string{"Origin": "https://example.com"},
+			status:     200, upstream: "share", path: "/share",
+			headers: map[string]string{"Access-Control-Allow-Origin": "*"},
+		},
+		{name: "shared snippet", host: "demo.upper.io", target: "/p/abc123", status: 200, upstream: "share", path: "/p/abc123"},
+		{name: "share is exact", host: "demo.upper.io", target: "/shared", status: 200, upstream: "playground", path: "/shared"},
+		{name: "playground", host: "demo.upper.io", target: "/static/app.js", status: 200, upstream: "playground", path: "/static/app.js"},
+
+		// The internal site is not served to the outside, whatever the host.
+		{name: "internal host", method: "POST", host: "upper-front", target: "/compile?output=json", status: 404, upstream: "vanity", path: "/compile?output=json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = "GET"
+			}
+			r := httptest.NewRequest(method, "http://"+tt.host+tt.target, nil)
+			r.Host = tt.host
+			r.RemoteAddr = "192.0.2.1:1234"
+			for k, v := range tt.reqHeaders {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Errorf("status %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("X-Upstream"); got != tt.upstream {
+				t.Errorf("answered by %q, want %q", got, tt.upstream)
+			}
+			if got := w.Header().Get("X-Path"); tt.path != "" && got != tt.path {
+				t.Errorf("upstream asked for %q, want %q", got, tt.path)
+			}
+			if got := w.Header().Get("Location"); got != tt.location {
+				t.Errorf("Location %q, want %q", got, tt.location)
+			}
+			for k, v := range tt.headers {
+				if got := w.Header().Get(k); got != v {
+					t.Errorf("%s: %q, want %q", k, got, v)
+				}
+			}
+		})
+	}
+}
+
+func TestFallback(t *testing.T) {
+	var mu sync.Mutex
+	var asked []string
+	table := Default()
+	for name := range table.Upstreams {
+		name := name
+		h := stub(name)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			asked = append(asked, name)
+			mu.Unlock()
+			h.ServeHTTP(w, r)
+		}))
+		t.Cleanup(srv.Close)
+		table.Upstreams[name] = srv.URL
+	}
+	s, err := New(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method, target string
+		asked          []string
+	}{
+		// Browsers ask vanity first, and get the legacy docs only for the
+		// pages it does not know.
+		{"GET", "/db.v3", []string{"vanity"}},
+		{"GET", "/db.v3/migrate-from-v2", []string{"vanity", "legacy-docs"}},
+		{"HEAD", "/favicon.ico", []string{"vanity", "legacy-docs"}},
+		{"GET", "/db.v3?go-get=1", []string{"vanity"}},
+		{"GET", "/unknown?go-get=1", []string{"vanity", "legacy-docs"}},
+		{"GET", "/unknown/info/refs?service=git-upload-pack", []string{"vanity", "legacy-docs"}},
+		{"POST", "/unknown/git-upload-pack", []string{"vanity"}},
+	}
+	for _, tt := range tests {
+		asked = nil
+		r := httptest.NewRequest(tt.method, "http://upper.io"+tt.target, nil)
+		s.ServeHTTP(httptest.NewRecorder(), r)
+		mu.Lock()
+		if !reflect.DeepEqual(asked, tt.asked) {
+			t.Errorf("%s %s asked %q, want %q", tt.method, tt.target, asked, tt.asked)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestInternal(t *testing.T) {
+	s := newServer(t)
+
+	tests := []struct {
+		method, host, target string
+
+		status   int
+		upstream string
+	}{
+		{"POST", "upper-front:8082", "/compile?output=json", 200, "compiler"},
+		{"POST", "upper-front:8082", "/fmt", 200, "compiler"},
+		{"POST", "upper-front:8082", "/vet", 200, "compiler"},
+		{"POST", "demo.upper.io", "/compile", 200, "compiler"},
+		{"GET", "upper.io", "/db.v3", 404, ""},
+		{"GET", "upper-front:8082", "/", 404, ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "http://"+tt.host+tt.target, nil)
+		// Internal requests come from a single address, and have no rate.
+		r.RemoteAddr = "172.18.0.5:1234"
+		for i := 0; i < 200; i++ {
+			w := httptest.NewRecorder()
+			s.Internal().ServeHTTP(w, r)
+			if w.Code != tt.status || w.Header().Get("X-Upstream") != tt.upstream {
+				t.Errorf("%s %s%s, request %d: status %d from %q, want %d from %q", tt.method, tt.host, tt.target, i, w.Code, w.Header().Get("X-Upstream"), tt.status, tt.upstream)
+				break
+			}
+			if tt.upstream == "" {
+				break
+			}
+		}
+	}
+}
+
+func TestUpstreamDown(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	table := Default()
+	for name := range table.Upstreams {
+		table.Upstreams[name] = down.URL
+	}
+	s, err := New(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", "http://tour.upper.io/welcome/01", nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("status %d, want %d", w.Code, http.StatusBadGateway)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	upstreams := map[string]string{"a": "http://a:80"}
+	tests := []struct {
+		name  string
+		table Table
+		err   string
+	}{
+		{"no sites", Table{Upstreams: upstreams}, "no sites"},
+		{
+			"bad upstream URL",
+			Table{Upstreams: map[string]string{"a": "a:80"}, Sites: []Site{{Hosts: []string{"x"}}}},
+			"invalid URL",
+		},
+		{
+			"unknown upstream",
+			Table{Upstreams: upstreams, Sites: []Site{{Hosts: []string{"x"}, Routes: []Route{{Path: "/", Upstream: "b"}}}}},
+			"unknown upstream",
+		},
+		{
+			"unknown fallback",
+			Table{Upstreams: upstreams, Sites: []Site{{Hosts: []string{"x"}, Routes: []Route{{Path: "/", Upstream: "a", Fallback: "b"}}}}},
+			"unknown upstream",
+		},
+		{
+			"redirect and upstream",
+			Table{Upstreams: upstreams, Sites: []Site{{Hosts: []string{"x"}, Routes: []Route{{Path: "/", Upstream: "a", Redirect: "/y"}}}}},
+			"either a redirect or an upstream",
+		},
+		{
+			"relative path",
+			Table{Upstreams: upstreams, Sites: []Site{{Hosts: []string{"x"}, Routes: []Route{{Path: "y", Upstream: "a"}}}}},
+			"must start with /",
+		},
+		{
+			"duplicate host",
+			Table{Upstreams: upstreams, Sites: []Site{{Hosts: []string{"x"}}, {Hosts: []string{"x"}}}},
+			"more than one site",
+		},
+		{
+			"unknown upstream of the internal site",
+			Table{Upstreams: upstreams, Sites: []Site{{Hosts: []string{"x"}}}, Internal: &Site{Routes: []Route{{Path: "/", Upstream: "b"}}}},
+			"internal site",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(&tt.table)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("error %v, want one with %q", err, tt.err)
+			}
+		})
+	}
+
+	if _, err := New(Default()); err != nil {
+		t.Errorf("default table: %v", err)
+	}
+}
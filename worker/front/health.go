@@ -0,0 +1,155 @@
+package front
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Check is how the health of an upstream is checked: a GET of Path, which
+// must be answered within checkTimeout with Status, or a status under 500.
+type Check struct {
+	// Path is the path asked, like /readyz.
+	Path string
+
+	// Host is the Host header of the request, for upstreams that answer for
+	// some hosts only.
+	Host string
+
+	// Status is the status of the response, if not any under 500.
+	Status int
+}
+
+const (
+	// checkTimeout is how long a check may take.
+	checkTimeout = 5 * time.Second
+
+	// maxFails is how many checks in a row must fail for an upstream to be
+	// down. A check that passes brings it back at once.
+	maxFails = 2
+)
+
+// Status is the health of an upstream, or of a member of its pool.
+type Status struct {
+	Name string
+	Up   bool
+
+	// Since is when the upstream went up or down.
+	Since time.Time
+
+	// CheckedAt is when the last check ran, and Latency how long it took.
+	CheckedAt time.Time
+	Latency   time.Duration
+
+	// Error is why the last check failed.
+	Error string `json:",omitempty"`
+
+	fails int
+}
+
+// report records the result of a check.
+func (st *Status) report(name string, at time.Time, latency time.Duration, err error) {
+	st.CheckedAt = at
+	st.Latency = latency
+	if err == nil {
+		st.Error = ""
+		st.fails = 0
+		if !st.Up {
+			st.Up, st.Since = true, at
+			log.Printf("%s is up", name)
+		}
+		return
+	}
+	st.Error = err.Error()
+	st.fails++
+	if st.Up && st.fails >= maxFails {
+		st.Up, st.Since = false, at
+		log.Printf("%s is down: %v", name, err)
+	}
+}
+
+// retryAfter is how long clients are told to wait before they try again.
+func (s *Server) retryAfter() time.Duration {
+	s.intervalMu.Lock()
+	defer s.intervalMu.Unlock()
+	return s.interval
+}
+
+// CheckUpstreams checks every member of the pools of the upstreams that have
+// a check every interval, until ctx is done. Requests for an upstream with no
+// member up are answered by the front with a maintenance page, or passed to
+// the fallback of their route.
+func (s *Server) CheckUpstreams(ctx context.Context, interval time.Duration) {
+	s.intervalMu.Lock()
+	s.interval = interval
+	s.intervalMu.Unlock()
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		var wg sync.WaitGroup
+		for _, p := range s.pools.byName {
+			if p.check == nil {
+				continue
+			}
+			p.mu.Lock()
+			members := append([]*member(nil), p.members...)
+			p.mu.Unlock()
+			for _, m := range members {
+				wg.Add(1)
+				go func(p *pool, m *member) {
+					defer wg.Done()
+					start := time.Now()
+					err := s.check(ctx, m.url, *p.check)
+					if ctx.Err() != nil {
+						return
+					}
+					p.mu.Lock()
+					m.status.report(p.name+"/"+m.name, start, time.Since(start), err)
+					p.mu.Unlock()
+				}(p, m)
+			}
+		}
+		wg.Wait()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// checkClient sends the requests of the checks.
+var checkClient = &http.Client{
+	Timeout: checkTimeout,
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// check runs a check against the upstream at url.
+func (s *Server) check(ctx context.Context, url string, check Check) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+check.Path, nil)
+	if err != nil {
+		return err
+	}
+	if check.Host != "" {
+		req.Host = check.Host
+	}
+	res, err := checkClient.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if check.Status != 0 && res.StatusCode != check.Status {
+		return fmt.Errorf("GET %s: status %d, want %d", check.Path, res.StatusCode, check.Status)
+	}
+	if res.StatusCode >= 500 {
+		return fmt.Errorf("GET %s: status %d", check.Path, res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package front
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// switchable is an upstream that fails with 503 while it is down.
+type switchable struct {
+	http.Handler
+	down int32
+}
+
+func (u *switchable) set(down bool) {
+	v := int32(0)
+	if down {
+		v = 1
+	}
+	atomic.StoreInt32(&u.down, v)
+}
+
+func (u *switchable) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&u.down) == 1 {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+		return
+	}
+	u.Handler.ServeHTTP(w, r)
+}
+
+// newCheckedServer returns a server for the default table, whose upstreams
+// are stubs that can be switched down, and which checks them every few
+// milliseconds.
+func newCheckedServer(t *testing.T) (*Server, map[string]*switchable) {
+	t.Helper()
+	table := Default()
+	upstreams := make(map[string]*switchable)
+	for name := range table.Upstreams {
+		u := &switchable{Handler: stub(name)}
+		srv := httptest.NewServer(u)
+		t.Cleanup(srv.Close)
+		table.Upstreams[name] = srv.URL
+		upstreams[name] = u
+	}
+	s, err := New(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go s.CheckUpstreams(ctx, 5*time.Millisecond)
+	return s, upstreams
+}
+
+// waitUp waits for the front to see an upstream up or down.
+func waitUp(t *testing.T, s *Server, name string, up bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.pools.byName[name].up() != up {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s is not up=%v after 5s", name, up)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func get(s *Server, method, target string, header map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+func TestUpstreamHealth(t *testing.T) {
+	s, upstreams := newCheckedServer(t)
+	browser := map[string]string{"Accept": "text/html,application/xhtml+xml"}
+
+	if w := get(s, "GET", "http://tour.upper.io/welcome/01", browser); w.Code != http.StatusOK {
+		t.Fatalf("tour: status %d, want %d", w.Code, http.StatusOK)
+	}
+
+	upstreams["tour"].set(true)
+	waitUp(t, s, "tour", false)
+
+	w := get(s, "GET", "http://tour.upper.io/welcome/01", browser)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("tour down: status %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if w.Header().Get("X-Upstream") != "" {
+		t.Errorf("tour down: answered by %s", w.Header().Get("X-Upstream"))
+	}
+	if got := w.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("tour down: Retry-After %q, want 1", got)
+	}
+	body := w.Body.String()
+	for _, want := range []string{"tour.upper.io is temporarily unavailable", `<span class="down">down</span>`, "reloads in 1 second"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("tour down: page without %q:\n%s", want, body)
+		}
+	}
+
+	w = get(s, "POST", "http://tour.upper.io/welcome/01", nil)
+	if w.Code != http.StatusServiceUnavailable || !strings.HasPrefix(w.Body.String(), "tour.upper.io is temporarily unavailable; try again in") {
+		t.Errorf("tour down, not a browser: status %d, %q", w.Code, w.Body.String())
+	}
+
+	// The other sites go on.
+	if w := get(s, "POST", "http://demo.upper.io/compile", nil); w.Code != http.StatusOK {
+		t.Errorf("compile while tour is down: status %d, want %d", w.Code, http.StatusOK)
+	}
+
+	upstreams["tour"].set(false)
+	waitUp(t, s, "tour", true)
+	if w := get(s, "GET", "http://tour.upper.io/welcome/01", browser); w.Code != http.StatusOK {
+		t.Errorf("tour back up: status %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestUpstreamHealthFallback(t *testing.T) {
+	s, upstreams := newCheckedServer(t)
+
+	upstreams["vanity"].set(true)
+	waitUp(t, s, "vanity", false)
+
+	tests := []struct {
+		method, target string
+		status         int
+		upstream       string
+	}{
+		// Browsers get the legacy docs while vanity is down.
+		{"GET", "http://upper.io/db.v3", 200, "legacy-docs"},
+		{"HEAD", "http://upper.io/db.v3/migrate-from-v2", 200, "legacy-docs"},
+		// The go command and git do not.
+		{"GET", "http://upper.io/db.v3?go-get=1", 503, ""},
+		{"GET", "http://upper.io/db.v3/info/refs?service=git-upload-pack", 503, ""},
+		{"GET", "http://upper.io/mod/upper.io/db.v3/@v/list", 503, ""},
+		{"POST", "http://upper.io/db.v3/git-upload-pack", 503, ""},
+		// Nor the routes of vanity without a fallback.
+		{"GET", "http://upper.io/v4/", 200, "docs"},
+	}
+	for _, tt := range tests {
+		w := get(s, tt.method, tt.target, nil)
+		if w.Code != tt.status || w.Header().Get("X-Upstream") != tt.upstream {
+			t.Errorf("%s %s: status %d from %q, want %d from %q", tt.method, tt.target, w.Code, w.Header().Get("X-Upstream"), tt.status, tt.upstream)
+		}
+	}
+
+	upstreams["legacy-docs"].set(true)
+	waitUp(t, s, "legacy-docs", false)
+	if w := get(s, "GET", "http://upper.io/db.v3", nil); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("vanity and legacy docs down: status %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestStatusReport(t *testing.T) {
+	st := Status{Name: "tour", Up: true}
+	now := time.Unix(0, 0)
+	fail := errors.New("connection refused")
+
+	steps := []struct {
+		err error
+		up  bool
+	}{
+		{nil, true},
+		{fail, true}, // a failure is not enough
+		{nil, true},
+		{fail, true},
+		{fail, false},
+		{fail, false},
+		{nil, true}, // a pass is
+	}
+	for i, step := range steps {
+		now = now.Add(time.Second)
+		st.report("tour", now, time.Millisecond, step.err)
+		if st.Up != step.up {
+			t.Errorf("step %d: up %v, want %v", i, st.Up, step.up)
+		}
+		if (st.Error != "") != (step.err != nil) {
+			t.Errorf("step %d: error %q", i, st.Error)
+		}
+	}
+	if !st.Since.Equal(now) {
+		t.Errorf("up since %v, want %v", st.Since, now)
+	}
+}
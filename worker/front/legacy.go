@@ -0,0 +1,29 @@
+// Code generated by cmd/redirects from legacy.txt; DO NOT EDIT.
+
+package front
+
+// legacyRedirects are the pages of the legacy docs that moved to the docs of
+// v4, by path.
+var legacyRedirects = map[string]string{
+	"/db.v1/mongo":           "/v4/adapter/mongo",
+	"/db.v1/mysql":           "/v4/adapter/mysql",
+	"/db.v1/postgresql":      "/v4/adapter/postgresql",
+	"/db.v1/ql":              "/v4/adapter/ql",
+	"/db.v1/sqlite":          "/v4/adapter/sqlite",
+	"/db.v2/getting-started": "/v4/getting-started",
+	"/db.v2/lib/sqlbuilder":  "/v4/getting-started/sql-builder-api",
+	"/db.v2/mongo":           "/v4/adapter/mongo",
+	"/db.v2/mysql":           "/v4/adapter/mysql",
+	"/db.v2/postgresql":      "/v4/adapter/postgresql",
+	"/db.v2/ql":              "/v4/adapter/ql",
+	"/db.v2/sqlite":          "/v4/adapter/sqlite",
+	"/db.v3/adapters":        "/v4/getting-started/connect-to-a-database",
+	"/db.v3/getting-started": "/v4/getting-started",
+	"/db.v3/lib/sqlbuilder":  "/v4/getting-started/sql-builder-api",
+	"/db.v3/mongo":           "/v4/adapter/mongo",
+	"/db.v3/mssql":           "/v4/adapter/mssql",
+	"/db.v3/mysql":           "/v4/adapter/mysql",
+	"/db.v3/postgresql":      "/v4/adapter/postgresql",
+	"/db.v3/ql":              "/v4/adapter/ql",
+	"/db.v3/sqlite":          "/v4/adapter/sqlite",
+}
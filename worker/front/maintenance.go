@@ -0,0 +1,189 @@
+package front
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Maintenance is the maintenance of a host: its requests are answered by the
+// front with the maintenance page.
+type Maintenance struct {
+	// Message is shown on the page.
+	Message string `json:",omitempty"`
+
+	// Until is when the maintenance is expected to end, if known. Clients are
+	// told to try again then.
+	Until *time.Time `json:",omitempty"`
+}
+
+// maintenance keeps the hosts in maintenance, in a file if there is one.
+type maintenance struct {
+	mu      sync.Mutex
+	hosts   map[string]Maintenance
+	file    string
+	modTime time.Time
+	size    int64
+}
+
+// get returns the maintenance of a host, which may have a port.
+func (m *maintenance) get(host string) (Maintenance, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mt, ok := m.hosts[hostname(host)]
+	return mt, ok
+}
+
+// all returns the hosts in maintenance.
+func (m *maintenance) all() map[string]Maintenance {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hosts := make(map[string]Maintenance, len(m.hosts))
+	for host, mt := range m.hosts {
+		hosts[host] = mt
+	}
+	return hosts
+}
+
+// set puts a host in maintenance, or takes it out if mt is nil, and saves
+// the hosts to the file.
+func (m *maintenance) set(host string, mt *Maintenance) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hosts := make(map[string]Maintenance, len(m.hosts)+1)
+	for h, v := range m.hosts {
+		hosts[h] = v
+	}
+	if mt != nil {
+		hosts[hostname(host)] = *mt
+	} else {
+		delete(hosts, hostname(host))
+	}
+	if m.file != "" {
+		if err := m.save(hosts); err != nil {
+			return err
+		}
+	}
+	m.hosts = hosts
+	return nil
+}
+
+// save writes hosts to the file.
+func (m *maintenance) save(hosts map[string]Maintenance) error {
+	data, err := json.MarshalIndent(hosts, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := writeFile(m.file, append(data, '\n')); err != nil {
+		return err
+	}
+	if fi, err := os.Stat(m.file); err == nil {
+		m.modTime, m.size = fi.ModTime(), fi.Size()
+	}
+	return nil
+}
+
+// load reads the file if it changed since it was last read. A missing file is
+// no host in maintenance.
+func (m *maintenance) load() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fi, err := os.Stat(m.file)
+	if errors.Is(err, fs.ErrNotExist) {
+		if len(m.hosts) > 0 {
+			log.Printf("%s removed: no host in maintenance", m.file)
+		}
+		m.hosts, m.modTime, m.size = nil, time.Time{}, 0
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.ModTime().Equal(m.modTime) && fi.Size() == m.size {
+		return nil
+	}
+	data, err := os.ReadFile(m.file)
+	if err != nil {
+		return err
+	}
+	var hosts map[string]Maintenance
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, &hosts); err != nil {
+			return fmt.Errorf("%s: %v", m.file, err)
+		}
+	}
+	m.hosts = make(map[string]Maintenance, len(hosts))
+	for host, mt := range hosts {
+		m.hosts[hostname(host)] = mt
+	}
+	m.modTime, m.size = fi.ModTime(), fi.Size()
+	log.Printf("%s: %d hosts in maintenance", m.file, len(m.hosts))
+	return nil
+}
+
+// WatchMaintenance keeps the hosts in maintenance in file, a JSON object of
+// Maintenance by host, which is read again when it changes, until ctx is done.
+// Hosts put in maintenance through the admin endpoint are saved to it.
+func (s *Server) WatchMaintenance(ctx context.Context, file string, interval time.Duration) error {
+	s.maintenance.mu.Lock()
+	s.maintenance.file = file
+	s.maintenance.mu.Unlock()
+	if err := s.maintenance.load(); err != nil {
+		return err
+	}
+
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			// A file that cannot be read keeps the hosts it had.
+			if err := s.maintenance.load(); err != nil {
+				log.Printf("maintenance: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// hostname returns a host without its port, in lower case.
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+// writeFile writes a file at once: readers get either the old data or the
+// new one.
+func writeFile(name string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
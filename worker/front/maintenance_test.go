@@ -0,0 +1,165 @@
+package front
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func admin(t *testing.T, s *Server, method, path string, form url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, "http://127.0.0.1:8081"+path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	s.Admin().ServeHTTP(w, r)
+	return w
+}
+
+func TestMaintenance(t *testing.T) {
+	s := newServer(t)
+	browser := map[string]string{"Accept": "text/html"}
+
+	until := time.Now().Add(10 * time.Minute).UTC().Truncate(time.Second)
+	w := admin(t, s, "PUT", "/maintenance/Upper.io", url.Values{
+		"message": {"Moving to a new server."},
+		"until":   {until.Format(time.RFC3339)},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT: status %d: %s", w.Code, w.Body)
+	}
+
+	tests := []struct {
+		name, method, target string
+		status               int
+		upstream             string
+	}{
+		{"page", "GET", "http://upper.io/v4/", 503, ""},
+		{"dev host", "GET", "http://dev.upper.io/v4/", 200, "docs"},
+		{"redirect", "GET", "http://upper.io/", 302, ""},
+		{"go-get", "GET", "http://upper.io/db.v3?go-get=1", 200, "vanity"},
+		{"git", "GET", "http://upper.io/db.v3/info/refs?service=git-upload-pack", 200, "vanity"},
+		{"module proxy", "GET", "http://upper.io/mod/upper.io/db.v3/@v/list", 200, "vanity"},
+		{"other host", "GET", "http://tour.upper.io/welcome/01", 200, "tour"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := get(s, tt.method, tt.target, browser)
+			if w.Code != tt.status || w.Header().Get("X-Upstream") != tt.upstream {
+				t.Errorf("status %d from %q, want %d from %q", w.Code, w.Header().Get("X-Upstream"), tt.status, tt.upstream)
+			}
+		})
+	}
+
+	w = get(s, "GET", "http://upper.io/v4/", browser)
+	body := w.Body.String()
+	for _, want := range []string{"upper.io is down for maintenance", "Moving to a new server.", until.Format("Jan 2, 15:04 MST"), "reloads in 10 minutes"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page without %q:\n%s", want, body)
+		}
+	}
+	if got := w.Header().Get("Retry-After"); got != "600" && got != "599" {
+		t.Errorf("Retry-After %q, want 600", got)
+	}
+
+	w = admin(t, s, "GET", "/status", nil)
+	var status struct {
+		Upstreams   []Status
+		Maintenance map[string]Maintenance
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Upstreams) != 7 || status.Maintenance["upper.io"].Message != "Moving to a new server." {
+		t.Errorf("status: %s", w.Body)
+	}
+
+	if w := admin(t, s, "DELETE", "/maintenance/upper.io", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE: status %d: %s", w.Code, w.Body)
+	}
+	if w := get(s, "GET", "http://upper.io/v4/", browser); w.Code != http.StatusOK {
+		t.Errorf("after DELETE: status %d, want %d", w.Code, http.StatusOK)
+	}
+
+	for _, tt := range []struct {
+		method, path string
+		form         url.Values
+		status       int
+	}{
+		{"GET", "/maintenance/upper.io", nil, http.StatusMethodNotAllowed},
+		{"PUT", "/maintenance/", nil, http.StatusNotFound},
+		{"PUT", "/maintenance/upper.io", url.Values{"until": {"tomorrow"}}, http.StatusBadRequest},
+	} {
+		if w := admin(t, s, tt.method, tt.path, tt.form); w.Code != tt.status {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.path, w.Code, tt.status)
+		}
+	}
+}
+
+func TestMaintenanceFile(t *testing.T) {
+	s := newServer(t)
+	file := filepath.Join(t.TempDir(), "maintenance.json")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.WatchMaintenance(ctx, file, 5*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor := func(host string, want bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if _, ok := s.maintenance.get(host); ok == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s in maintenance is not %v after 5s", host, want)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	if err := os.WriteFile(file, []byte(`{"tour.upper.io": {"Message": "Upgrading."}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("tour.upper.io", true)
+	if w := get(s, "GET", "http://tour.upper.io/", nil); w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "Upgrading.") {
+		t.Errorf("tour in maintenance: status %d, %q", w.Code, w.Body)
+	}
+
+	// The admin endpoint saves to the file.
+	if w := admin(t, s, "PUT", "/maintenance/demo.upper.io", nil); w.Code != http.StatusOK {
+		t.Fatalf("PUT: status %d: %s", w.Code, w.Body)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hosts map[string]Maintenance
+	if err := json.Unmarshal(data, &hosts); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := hosts["demo.upper.io"]; !ok || len(hosts) != 2 {
+		t.Errorf("file after PUT: %s", data)
+	}
+
+	// A broken file keeps the hosts it had.
+	if err := os.WriteFile(file, []byte(`{"tour.upper.io": `), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	waitFor("tour.upper.io", true)
+
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("tour.upper.io", false)
+	waitFor("demo.upper.io", false)
+}
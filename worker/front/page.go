@@ -0,0 +1,139 @@
+package front
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pageData is what the maintenance page shows.
+type pageData struct {
+	Host        string
+	Maintenance *Maintenance
+	Upstreams   []Status
+	Retry       time.Duration
+}
+
+// RetrySeconds is how many seconds clients are told to wait, at least one.
+func (d *pageData) RetrySeconds() int {
+	if secs := int(math.Ceil(d.Retry.Seconds())); secs > 1 {
+		return secs
+	}
+	return 1
+}
+
+// RetryText is the retry hint of the page, like "2 minutes".
+func (d *pageData) RetryText() string {
+	switch secs := d.RetrySeconds(); {
+	case secs < 60:
+		return plural(secs, "second")
+	case secs < 2*3600:
+		return plural((secs+59)/60, "minute")
+	default:
+		return plural((secs+3599)/3600, "hour")
+	}
+}
+
+func plural(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+// unavailable answers a request for a site the front cannot serve, because
+// its host is in maintenance or because an upstream is down or failed. The
+// status is 503, or 502 for a failed upstream; browsers get the maintenance
+// page, other clients a line of text, and both a Retry-After.
+func (s *Server) unavailable(w http.ResponseWriter, r *http.Request, status int, site *Site, mt *Maintenance) {
+	d := &pageData{
+		Host:        hostname(r.Host),
+		Maintenance: mt,
+		Retry:       s.retryAfter(),
+	}
+	for _, name := range site.upstreams() {
+		d.Upstreams = append(d.Upstreams, s.pools.byName[name].status())
+	}
+	if mt != nil && mt.Until != nil {
+		if left := time.Until(*mt.Until); left > 0 {
+			d.Retry = left
+		}
+	}
+
+	h := w.Header()
+	h.Set("Retry-After", strconv.Itoa(d.RetrySeconds()))
+	h.Set("Cache-Control", "no-store")
+
+	if !strings.Contains(r.Header.Get("Accept"), "text/html") || isGoTool(r) {
+		msg := d.Host + " is temporarily unavailable"
+		if mt != nil {
+			msg = d.Host + " is down for maintenance"
+			if mt.Message != "" {
+				msg += ": " + mt.Message
+			}
+		}
+		http.Error(w, msg+"; try again in "+d.RetryText(), status)
+		return
+	}
+
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		_ = pageTmpl.Execute(w, d)
+	}
+}
+
+// upstreams returns the names of the upstreams the routes of s use.
+func (s *Site) upstreams() []string {
+	names := []string{}
+	for _, r := range s.Routes {
+		for _, name := range []string{r.Upstream, r.Fallback} {
+			if name != "" && !contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+var pageTmpl = template.Must(template.New("maintenance").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta http-equiv="refresh" content="{{.RetrySeconds}}">
+<title>{{if .Maintenance}}Down for maintenance{{else}}Temporarily unavailable{{end}} · {{.Host}}</title>
+<style>
+body { margin: 0; font: 16px/1.5 -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; color: #2b2b2b; background: #f6f8fa; }
+main { max-width: 36rem; margin: 10vh auto; padding: 2rem; background: #fff; border-top: 4px solid #23a7d0; box-shadow: 0 1px 3px rgba(0,0,0,.1); }
+.brand { font-weight: 700; font-size: 1.25rem; color: #23a7d0; text-decoration: none; }
+h1 { font-size: 1.5rem; margin: 1.5rem 0 .5rem; }
+table { width: 100%; border-collapse: collapse; margin: 1.5rem 0; font-size: .9rem; }
+td { padding: .4rem 0; border-bottom: 1px solid #eee; }
+.up { color: #1a7f37; } .down { color: #cf222e; }
+.hint { color: #666; font-size: .9rem; }
+</style>
+</head>
+<body>
+<main>
+<a class="brand" href="https://upper.io/v4/">upper.io</a>
+{{if .Maintenance}}
+<h1>{{.Host}} is down for maintenance</h1>
+<p>{{if .Maintenance.Message}}{{.Maintenance.Message}}{{else}}We are working on it, and will be back shortly.{{end}}</p>
+{{with .Maintenance.Until}}<p>It should be over by {{.UTC.Format "Jan 2, 15:04 MST"}}.</p>{{end}}
+{{else}}
+<h1>{{.Host}} is temporarily unavailable</h1>
+<p>Some of the services behind this page are not answering. They are checked all the time, and traffic will be back as soon as they are.</p>
+{{end}}
+<table>
+{{range .Upstreams}}<tr><td>{{.Name}}</td><td>{{if .Up}}<span class="up">up</span>{{else}}<span class="down">down</span> since {{.Since.UTC.Format "15:04 MST"}}{{end}}</td></tr>
+{{end}}</table>
+<p class="hint">This page reloads in {{.RetryText}}. The docs are at <a href="https://upper.io/v4/">upper.io/v4</a>, and the source at <a href="https://github.com/upper/db">github.com/upper/db</a>.</p>
+</main>
+</body>
+</html>
+`))
@@ -0,0 +1,530 @@
+package front
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// initialMember is the name of the member of a pool the URL of the table is.
+const initialMember = "default"
+
+// Errors of the operations on pools, which the admin endpoint answers with
+// 409 Conflict.
+var (
+	errUnknownMember = errors.New("unknown member")
+	errConflict      = errors.New("conflict")
+)
+
+// Member is the state of a member of a pool, as the admin endpoint reports it.
+type Member struct {
+	Name string
+	URL  string
+
+	// Weight is the share of the requests of the pool the member gets, out of
+	// the sum of the weights of the pool.
+	Weight int
+
+	// Verified is whether the member passed the check of its pool since it was
+	// registered. Traffic can only be shifted to verified members.
+	Verified bool
+
+	// Inflight is the number of requests the member is answering.
+	Inflight int64 `json:",omitempty"`
+
+	Status Status
+}
+
+// member is an instance of an upstream, like a container.
+type member struct {
+	name     string
+	url      string
+	proxy    *httputil.ReverseProxy
+	inflight int64 // atomic
+
+	// Guarded by the mutex of the pool.
+	transport *http.Transport
+	weight    int
+	verified  bool
+	status    Status
+}
+
+// serve passes a request to the member.
+func (m *member) serve(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt64(&m.inflight, 1)
+	defer atomic.AddInt64(&m.inflight, -1)
+	m.proxy.ServeHTTP(w, r)
+}
+
+// pool is an upstream: the members that answer its requests, which get
+// traffic by weight. A new version of an upstream is registered as a member
+// with no weight, verified, and traffic is shifted to it, at once or step by
+// step; the previous weights are kept for a rollback.
+type pool struct {
+	name  string
+	check *Check
+
+	mu       sync.Mutex
+	members  []*member
+	previous map[string]int // weights before the last shift, by member
+	cancel   context.CancelFunc
+}
+
+// pick returns a member that is up to answer a request, by weight, or nil if
+// there is none.
+func (p *pool) pick() *member {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	total := 0
+	for _, m := range p.members {
+		if m.weight > 0 && m.status.Up {
+			total += m.weight
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+	n := rand.Intn(total)
+	for _, m := range p.members {
+		if m.weight > 0 && m.status.Up {
+			if n < m.weight {
+				return m
+			}
+			n -= m.weight
+		}
+	}
+	return nil
+}
+
+// up reports whether a member that gets traffic is up.
+func (p *pool) up() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, m := range p.members {
+		if m.weight > 0 && m.status.Up {
+			return true
+		}
+	}
+	return false
+}
+
+// status returns the status of the pool: the one of its first member up that
+// gets traffic, or of the last one that went down.
+func (p *pool) status() Status {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var down *Status
+	for _, m := range p.members {
+		if m.weight == 0 {
+			continue
+		}
+		if m.status.Up {
+			st := m.status
+			st.Name = p.name
+			return st
+		}
+		if down == nil || m.status.Since.After(down.Since) {
+			down = &m.status
+		}
+	}
+	st := Status{Name: p.name}
+	if down != nil {
+		st = *down
+		st.Name = p.name
+	}
+	return st
+}
+
+// list returns the state of the members.
+func (p *pool) list() []Member {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	list := make([]Member, len(p.members))
+	for i, m := range p.members {
+		list[i] = Member{
+			Name:     m.name,
+			URL:      m.url,
+			Weight:   m.weight,
+			Verified: m.verified,
+			Inflight: atomic.LoadInt64(&m.inflight),
+			Status:   m.status,
+		}
+	}
+	return list
+}
+
+func (p *pool) member(name string) *member {
+	for _, m := range p.members {
+		if m.name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// pools are the pools of a server, by upstream name, and the file their
+// members are saved to.
+type pools struct {
+	byName map[string]*pool
+
+	mu   sync.Mutex // serializes saves
+	file string
+}
+
+// newMember returns a member of a pool, with its own transport, so that its
+// idle connections can be closed once it is removed.
+func (s *Server) newMember(p *pool, name, rawurl string) (*member, error) {
+	target, err := url.Parse(rawurl)
+	if err != nil || target.Scheme == "" || target.Host == "" {
+		return nil, fmt.Errorf("invalid URL %q", rawurl)
+	}
+	m := &member{
+		name:      name,
+		url:       rawurl,
+		transport: http.DefaultTransport.(*http.Transport).Clone(),
+		status:    Status{Name: name, Since: time.Now()},
+	}
+	m.proxy = s.newProxy(p.name+"/"+name, target)
+	m.proxy.Transport = m.transport
+	return m, nil
+}
+
+// register adds a member to a pool, or replaces the URL of one with no
+// weight. New members get no traffic and are not verified.
+func (s *Server) register(p *pool, name, rawurl string) error {
+	m, err := s.newMember(p, name, rawurl)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	if old := p.member(name); old != nil {
+		if old.weight > 0 {
+			p.mu.Unlock()
+			return fmt.Errorf("%w: %s/%s gets traffic", errConflict, p.name, name)
+		}
+		for i := range p.members {
+			if p.members[i] == old {
+				p.members[i] = m
+			}
+		}
+		old.transport.CloseIdleConnections()
+	} else {
+		p.members = append(p.members, m)
+	}
+	p.mu.Unlock()
+	log.Printf("%s/%s registered at %s", p.name, name, rawurl)
+	return s.savePools()
+}
+
+// verify runs the check of a pool against a member, and marks it verified
+// if it passes.
+func (s *Server) verify(ctx context.Context, p *pool, name string) error {
+	p.mu.Lock()
+	m := p.member(name)
+	p.mu.Unlock()
+	if m == nil {
+		return fmt.Errorf("%w %s/%s", errUnknownMember, p.name, name)
+	}
+
+	check := Check{Path: "/"}
+	if p.check != nil {
+		check = *p.check
+	}
+	start := time.Now()
+	err := s.check(ctx, m.url, check)
+
+	p.mu.Lock()
+	m.status.report(p.name+"/"+name, start, time.Since(start), err)
+	if err == nil && !m.verified {
+		m.verified = true
+		log.Printf("%s/%s verified", p.name, name)
+	}
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.savePools()
+}
+
+// shift moves the traffic of a pool to a member: at once if step is 100 or
+// more, or step percent at a time, every interval. A shift in progress is
+// stopped. If the member goes down before the shift is over, the pool is
+// rolled back.
+func (s *Server) shift(p *pool, name string, step int, every time.Duration) error {
+	p.mu.Lock()
+	m := p.member(name)
+	switch {
+	case m == nil:
+		p.mu.Unlock()
+		return fmt.Errorf("%w %s/%s", errUnknownMember, p.name, name)
+	case !m.verified:
+		p.mu.Unlock()
+		return fmt.Errorf("%w: %s/%s is not verified", errConflict, p.name, name)
+	case !m.status.Up:
+		p.mu.Unlock()
+		return fmt.Errorf("%w: %s/%s is down", errConflict, p.name, name)
+	}
+	if p.cancel != nil {
+		p.cancel()
+		p.cancel = nil
+	} else {
+		p.previous = make(map[string]int)
+		for _, m := range p.members {
+			p.previous[m.name] = m.weight
+		}
+	}
+	if step >= 100 || step <= 0 {
+		p.setShare(m, 100)
+		p.mu.Unlock()
+		log.Printf("%s: all traffic to %s", p.name, name)
+		return s.savePools()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	share := p.share(m)
+	p.mu.Unlock()
+
+	go func() {
+		t := time.NewTicker(every)
+		defer t.Stop()
+		for share < 100 {
+			p.mu.Lock()
+			if ctx.Err() != nil {
+				p.mu.Unlock()
+				return
+			}
+			if !m.status.Up {
+				p.mu.Unlock()
+				log.Printf("%s: %s went down during the shift", p.name, name)
+				if err := s.rollback(ctx, p); err != nil && !errors.Is(err, context.Canceled) {
+					log.Printf("%s: rollback: %v", p.name, err)
+				}
+				return
+			}
+			share += step
+			if share > 100 {
+				share = 100
+			}
+			p.setShare(m, share)
+			if share == 100 {
+				p.cancel = nil
+			}
+			p.mu.Unlock()
+			log.Printf("%s: %d%% of the traffic to %s", p.name, share, name)
+			if err := s.savePools(); err != nil {
+				log.Printf("%s: %v", p.name, err)
+			}
+			if share == 100 {
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// share returns the percentage of the traffic a member gets.
+func (p *pool) share(m *member) int {
+	total := 0
+	for _, m := range p.members {
+		total += m.weight
+	}
+	if total == 0 {
+		return 0
+	}
+	return m.weight * 100 / total
+}
+
+// setShare gives a member share percent of the traffic, and the others the
+// rest, in proportion to the weights they had before the shift.
+func (p *pool) setShare(to *member, share int) {
+	rest, others := 100-share, 0
+	for _, m := range p.members {
+		if m != to {
+			others += p.previous[m.name]
+		}
+	}
+	left := rest
+	for _, m := range p.members {
+		switch {
+		case m == to:
+			m.weight = share
+		case others == 0:
+			m.weight = 0
+		default:
+			m.weight = rest * p.previous[m.name] / others
+			left -= m.weight
+		}
+	}
+	// Rounding leftovers go to the member shifted to.
+	if others > 0 {
+		to.weight += left
+	}
+}
+
+// rollback stops a shift in progress, and restores the weights of the pool
+// from before the last one. It does nothing once ctx is done: a shift rolls
+// itself back with its own context, which is canceled when another shift
+// takes its place.
+func (s *Server) rollback(ctx context.Context, p *pool) error {
+	p.mu.Lock()
+	if err := ctx.Err(); err != nil {
+		p.mu.Unlock()
+		return err
+	}
+	if p.cancel != nil {
+		p.cancel()
+		p.cancel = nil
+	}
+	if p.previous == nil {
+		p.mu.Unlock()
+		return fmt.Errorf("%w: no shift of %s to roll back", errConflict, p.name)
+	}
+	for _, m := range p.members {
+		m.weight = p.previous[m.name]
+	}
+	p.previous = nil
+	p.mu.Unlock()
+	log.Printf("%s: rolled back", p.name)
+	return s.savePools()
+}
+
+// remove takes a member with no traffic out of a pool, once the requests it
+// is answering are over or timeout has passed. It returns how many were left.
+func (s *Server) remove(ctx context.Context, p *pool, name string, timeout time.Duration) (int64, error) {
+	p.mu.Lock()
+	m := p.member(name)
+	switch {
+	case m == nil:
+		p.mu.Unlock()
+		return 0, fmt.Errorf("%w %s/%s", errUnknownMember, p.name, name)
+	case m.weight > 0:
+		p.mu.Unlock()
+		return 0, fmt.Errorf("%w: %s/%s gets traffic", errConflict, p.name, name)
+	}
+	if p.previous[name] > 0 {
+		// The last shift cannot be rolled back without the member.
+		p.previous = nil
+	}
+	p.mu.Unlock()
+
+	deadline := time.Now().Add(timeout)
+	for atomic.LoadInt64(&m.inflight) > 0 && time.Now().Before(deadline) && ctx.Err() == nil {
+		time.Sleep(50 * time.Millisecond)
+	}
+	left := atomic.LoadInt64(&m.inflight)
+
+	p.mu.Lock()
+	for i := range p.members {
+		if p.members[i] == m {
+			p.members = append(p.members[:i], p.members[i+1:]...)
+			break
+		}
+	}
+	p.mu.Unlock()
+	m.transport.CloseIdleConnections()
+	log.Printf("%s/%s removed, with %d requests left", p.name, name, left)
+	return left, s.savePools()
+}
+
+// savedMember is a member in the file of the pools.
+type savedMember struct {
+	Name     string
+	URL      string
+	Weight   int
+	Verified bool
+}
+
+// LoadPools keeps the members of the pools in file, so that the front starts
+// with the members it had. Pools of the file that are not in the table are
+// ignored, and a missing file is the members of the table.
+func (s *Server) LoadPools(file string) error {
+	s.pools.mu.Lock()
+	s.pools.file = file
+	s.pools.mu.Unlock()
+
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var saved map[string][]savedMember
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+	for name, members := range saved {
+		p := s.pools.byName[name]
+		if p == nil || len(members) == 0 {
+			continue
+		}
+		var list []*member
+		for _, sm := range members {
+			m, err := s.newMember(p, sm.Name, sm.URL)
+			if err != nil {
+				return fmt.Errorf("%s: %s/%s: %v", file, name, sm.Name, err)
+			}
+			m.weight, m.verified = sm.Weight, sm.Verified
+			// Members that get traffic are up until their checks say
+			// otherwise, like the ones of the table.
+			m.status.Up = m.weight > 0
+			list = append(list, m)
+		}
+		p.mu.Lock()
+		p.members = list
+		p.mu.Unlock()
+	}
+	return nil
+}
+
+// savePools writes the members of the pools to the file, if there is one.
+func (s *Server) savePools() error {
+	s.pools.mu.Lock()
+	defer s.pools.mu.Unlock()
+	if s.pools.file == "" {
+		return nil
+	}
+
+	saved := make(map[string][]savedMember)
+	for name, p := range s.pools.byName {
+		for _, m := range p.list() {
+			saved[name] = append(saved[name], savedMember{m.Name, m.URL, m.Weight, m.Verified})
+		}
+	}
+	data, err := json.MarshalIndent(saved, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFile(s.pools.file, append(data, '\n'))
+}
+
+// poolNames returns the names of the pools, sorted.
+func (s *Server) poolNames() []string {
+	var names []string
+	for name := range s.pools.byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
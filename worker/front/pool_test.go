@@ -0,0 +1,283 @@
+package front
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// upstreamOf returns who answered a request for the tour.
+func upstreamOf(t *testing.T, s *Server) string {
+	t.Helper()
+	w := get(s, "GET", "http://tour.upper.io/welcome/01", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("tour: status %d", w.Code)
+	}
+	return w.Header().Get("X-Upstream")
+}
+
+// poolOf returns the state of a pool, as the admin endpoint reports it.
+func poolOf(t *testing.T, s *Server, name string) poolState {
+	t.Helper()
+	w := admin(t, s, "GET", "/pools/"+name, nil)
+	var st poolState
+	if err := json.Unmarshal(w.Body.Bytes(), &st); err != nil {
+		t.Fatalf("GET /pools/%s: %v: %s", name, err, w.Body)
+	}
+	return st
+}
+
+func weights(st poolState) map[string]int {
+	w := make(map[string]int)
+	for _, m := range st.Members {
+		w[m.Name] = m.Weight
+	}
+	return w
+}
+
+// waitPool waits for a pool to reach a state.
+func waitPool(t *testing.T, s *Server, name string, ok func(poolState) bool) poolState {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		st := poolOf(t, s, name)
+		if ok(st) {
+			return st
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pool %s: %+v after 5s", name, st)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestBlueGreen(t *testing.T) {
+	s := newServer(t)
+	file := filepath.Join(t.TempDir(), "pools.json")
+	if err := s.LoadPools(file); err != nil {
+		t.Fatal(err)
+	}
+	green := httptest.NewServer(stub("tour-green"))
+	defer green.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no", http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+
+	steps := []struct {
+		method, path string
+		form         url.Values
+		status       int
+	}{
+		{"PUT", "/pools/tour/green", url.Values{"url": {green.URL}}, 200},
+		{"PUT", "/pools/tour/broken", url.Values{"url": {broken.URL}}, 200},
+		{"PUT", "/pools/tour/bad", url.Values{"url": {"upper-tour-green:4000"}}, 400},
+		{"PUT", "/pools/nope/green", url.Values{"url": {green.URL}}, 404},
+		{"POST", "/pools/tour/shift", url.Values{"to": {"green"}}, 409}, // not verified
+		{"POST", "/pools/tour/broken/verify", nil, 503},
+		{"POST", "/pools/tour/nope/verify", nil, 404},
+		{"POST", "/pools/tour/green/verify", nil, 200},
+		{"POST", "/pools/tour/shift", url.Values{"to": {"broken"}}, 409},
+		{"POST", "/pools/tour/shift", url.Values{"to": {"green"}, "step": {"x"}}, 400},
+		{"POST", "/pools/tour/rollback", nil, 409}, // nothing to roll back
+		{"DELETE", "/pools/tour/broken", nil, 200},
+		{"DELETE", "/pools/tour/default", nil, 409}, // gets traffic
+	}
+	for _, step := range steps {
+		if w := admin(t, s, step.method, step.path, step.form); w.Code != step.status {
+			t.Fatalf("%s %s: status %d, want %d: %s", step.method, step.path, w.Code, step.status, w.Body)
+		}
+	}
+	if got := upstreamOf(t, s); got != "tour" {
+		t.Fatalf("before the shift: answered by %q, want tour", got)
+	}
+
+	// All at once, and back.
+	if w := admin(t, s, "POST", "/pools/tour/shift", url.Values{"to": {"green"}}); w.Code != 200 {
+		t.Fatalf("shift: status %d: %s", w.Code, w.Body)
+	}
+	for i := 0; i < 10; i++ {
+		if got := upstreamOf(t, s); got != "tour-green" {
+			t.Fatalf("after the shift: answered by %q, want tour-green", got)
+		}
+	}
+	if w := admin(t, s, "POST", "/pools/tour/rollback", nil); w.Code != 200 {
+		t.Fatalf("rollback: status %d: %s", w.Code, w.Body)
+	}
+	if got := upstreamOf(t, s); got != "tour" {
+		t.Fatalf("after the rollback: answered by %q, want tour", got)
+	}
+
+	// Step by step.
+	if w := admin(t, s, "POST", "/pools/tour/shift", url.Values{"to": {"green"}, "step": {"50"}, "every": {"20ms"}}); w.Code != 200 {
+		t.Fatalf("shift: status %d: %s", w.Code, w.Body)
+	}
+	st := waitPool(t, s, "tour", func(st poolState) bool { return !st.Shifting })
+	if w := weights(st); w["green"] != 100 || w["default"] != 0 || !st.Rollback {
+		t.Fatalf("after a shift step by step: %+v", st)
+	}
+
+	// The front restarts with the members it had.
+	s2 := newServer(t)
+	if err := s2.LoadPools(file); err != nil {
+		t.Fatal(err)
+	}
+	if got := upstreamOf(t, s2); got != "tour-green" {
+		t.Errorf("after a restart: answered by %q, want tour-green", got)
+	}
+
+	// The blue member goes once its traffic is gone, and the rollback with
+	// it.
+	if w := admin(t, s, "DELETE", "/pools/tour/default", nil); w.Code != 200 {
+		t.Fatalf("DELETE: status %d: %s", w.Code, w.Body)
+	}
+	if st := poolOf(t, s, "tour"); len(st.Members) != 1 || st.Rollback {
+		t.Errorf("after DELETE: %+v", st)
+	}
+}
+
+func TestShiftRollsBackWhenDown(t *testing.T) {
+	s := newServer(t)
+	green := &switchable{Handler: stub("tour-green")}
+	srv := httptest.NewServer(green)
+	defer srv.Close()
+
+	admin(t, s, "PUT", "/pools/tour/green", url.Values{"url": {srv.URL}})
+	if w := admin(t, s, "POST", "/pools/tour/green/verify", nil); w.Code != 200 {
+		t.Fatalf("verify: status %d: %s", w.Code, w.Body)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.CheckUpstreams(ctx, 5*time.Millisecond)
+
+	if w := admin(t, s, "POST", "/pools/tour/shift", url.Values{"to": {"green"}, "step": {"10"}, "every": {"50ms"}}); w.Code != 200 {
+		t.Fatalf("shift: status %d: %s", w.Code, w.Body)
+	}
+	green.set(true)
+
+	st := waitPool(t, s, "tour", func(st poolState) bool { return !st.Shifting })
+	if w := weights(st); w["default"] != 100 || w["green"] != 0 || st.Rollback {
+		t.Errorf("after green went down: %+v", st)
+	}
+	if got := upstreamOf(t, s); got != "tour" {
+		t.Errorf("after green went down: answered by %q, want tour", got)
+	}
+}
+
+func TestRemoveDrains(t *testing.T) {
+	release := make(chan struct{})
+	var started int32
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/slow") {
+			atomic.StoreInt32(&started, 1)
+			<-release
+		}
+		w.Header().Set("X-Upstream", "blue")
+	}))
+	defer slow.Close()
+	green := httptest.NewServer(stub("tour-green"))
+	defer green.Close()
+
+	table := Default()
+	table.Upstreams["tour"] = slow.URL
+	s, err := New(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan int)
+	go func() {
+		done <- get(s, "GET", "http://tour.upper.io/slow", nil).Code
+	}()
+	for atomic.LoadInt32(&started) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	admin(t, s, "PUT", "/pools/tour/green", url.Values{"url": {green.URL}})
+	admin(t, s, "POST", "/pools/tour/green/verify", nil)
+	if w := admin(t, s, "POST", "/pools/tour/shift", url.Values{"to": {"green"}}); w.Code != 200 {
+		t.Fatalf("shift: status %d: %s", w.Code, w.Body)
+	}
+
+	removed := make(chan *httptest.ResponseRecorder)
+	go func() {
+		removed <- admin(t, s, "DELETE", "/pools/tour/default", url.Values{"timeout": {"5s"}})
+	}()
+	select {
+	case w := <-removed:
+		t.Fatalf("removed with a request in flight: %d %s", w.Code, w.Body)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if st := poolOf(t, s, "tour"); st.Members[0].Inflight != 1 {
+		t.Errorf("draining: %+v", st.Members[0])
+	}
+	// New requests go to green meanwhile.
+	if got := upstreamOf(t, s); got != "tour-green" {
+		t.Errorf("while draining: answered by %q, want tour-green", got)
+	}
+
+	close(release)
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("request in flight: status %d, want %d", code, http.StatusOK)
+	}
+	w := <-removed
+	if w.Code != 200 || !strings.Contains(w.Body.String(), `"Left": 0`) {
+		t.Errorf("DELETE: status %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestSetShare(t *testing.T) {
+	a, b, c := &member{name: "a"}, &member{name: "b"}, &member{name: "c"}
+	p := &pool{members: []*member{a, b, c}, previous: map[string]int{"a": 75, "b": 25}}
+
+	tests := []struct {
+		share   int
+		a, b, c int
+	}{
+		{0, 75, 25, 0},
+		{10, 67, 22, 11}, // the rounding goes to c
+		{50, 37, 12, 51},
+		{100, 0, 0, 100},
+	}
+	for _, tt := range tests {
+		p.setShare(c, tt.share)
+		if a.weight != tt.a || b.weight != tt.b || c.weight != tt.c {
+			t.Errorf("setShare(c, %d): %d, %d, %d, want %d, %d, %d", tt.share, a.weight, b.weight, c.weight, tt.a, tt.b, tt.c)
+		}
+		if sum := a.weight + b.weight + c.weight; sum != 100 {
+			t.Errorf("setShare(c, %d): weights add up to %d", tt.share, sum)
+		}
+	}
+}
+
+func TestStaleRollback(t *testing.T) {
+	a, b := &member{name: "a", weight: 100}, &member{name: "b"}
+	p := &pool{name: "tour", members: []*member{a, b}, previous: map[string]int{"a": 100}}
+	s := &Server{}
+
+	// The shift the rollback was of has been stopped by another one, which
+	// is not rolled back.
+	ctx, cancel := context.WithCancel(context.Background())
+	p.setShare(b, 50)
+	cancel()
+	if err := s.rollback(ctx, p); err == nil {
+		t.Error("rollback of a stopped shift succeeded")
+	}
+	if a.weight != 50 || b.weight != 50 || p.previous == nil {
+		t.Errorf("after the rollback of a stopped shift: %d, %d, previous %v", a.weight, b.weight, p.previous)
+	}
+
+	if err := s.rollback(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+	if a.weight != 100 || b.weight != 0 || p.previous != nil {
+		t.Errorf("after the rollback: %d, %d, previous %v", a.weight, b.weight, p.previous)
+	}
+}
@@ -0,0 +1,220 @@
+package front
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Match is how a route matches the path of a request.
+type Match int
+
+const (
+	// Exact routes match their Path only.
+	Exact Match = iota
+	// Prefix routes match the paths that start with their Path.
+	Prefix
+)
+
+// Route says what to do with the requests for some paths of a site: redirect
+// them, or pass them to an upstream.
+type Route struct {
+	Match Match
+	Path  string
+
+	// Redirect is the URL requests are redirected to with a 302.
+	Redirect string
+
+	// Upstream is the name of the upstream requests are passed to.
+	Upstream string
+
+	// Rewrite is the path the upstream is asked for instead of the one of
+	// the request, if set.
+	Rewrite string
+
+	// Fallback is the name of the upstream asked when Upstream answers a
+	// GET or HEAD with 404, whoever sends it, like the error_page of nginx.
+	// Other methods get the 404: their body was read by Upstream. While
+	// Upstream is down, browsers are sent to Fallback too.
+	Fallback string
+
+	// CORS lets pages of other origins call the upstream.
+	CORS *CORS
+
+	// Essential routes are served while their host is in maintenance, like
+	// the module proxy.
+	Essential bool
+}
+
+// Site is a set of routes shared by some hosts.
+type Site struct {
+	Hosts  []string
+	Routes []Route
+
+	// Moved are the paths of pages that moved for good, and where to. Browsers
+	// are redirected with a 301, with or without a trailing slash; the go
+	// command and git are routed as usual.
+	Moved map[string]string
+}
+
+// Table is the configuration of the front.
+type Table struct {
+	// Upstreams are the URLs of the upstreams, by name.
+	Upstreams map[string]string
+
+	// Checks are how the upstreams are checked, by name. Upstreams without
+	// one are taken to be up.
+	Checks map[string]Check
+
+	// Sites are the sites served. The first one answers the requests for
+	// hosts no site has.
+	Sites []Site
+
+	// Internal is the site of the other services of upper-network, like the
+	// playground, whatever host they ask for. It is served on a listener of
+	// its own, with the handler of Server.Internal, and never to the
+	// outside.
+	Internal *Site
+}
+
+// validate checks that the routes of the table can be served.
+func (t *Table) validate() error {
+	if len(t.Sites) == 0 {
+		return fmt.Errorf("no sites")
+	}
+	for name, raw := range t.Upstreams {
+		if u, err := url.Parse(raw); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("upstream %s: invalid URL %q", name, raw)
+		}
+	}
+	for name, c := range t.Checks {
+		if _, ok := t.Upstreams[name]; !ok {
+			return fmt.Errorf("check of unknown upstream %q", name)
+		}
+		if !strings.HasPrefix(c.Path, "/") {
+			return fmt.Errorf("upstream %s: check path %q must start with /", name, c.Path)
+		}
+	}
+	hosts := make(map[string]bool)
+	for _, site := range t.Sites {
+		for _, host := range site.Hosts {
+			if hosts[host] {
+				return fmt.Errorf("host %s is in more than one site", host)
+			}
+			hosts[host] = true
+		}
+		if err := t.validateSite(&site); err != nil {
+			return err
+		}
+	}
+	if t.Internal != nil {
+		if err := t.validateSite(t.Internal); err != nil {
+			return fmt.Errorf("internal site: %w", err)
+		}
+	}
+	return nil
+}
+
+func (t *Table) validateSite(site *Site) error {
+	for from, to := range site.Moved {
+		if !strings.HasPrefix(from, "/") || strings.HasSuffix(from, "/") {
+			return fmt.Errorf("%v: moved page %q: path must start with / and not end with it", site.Hosts, from)
+		}
+		if to == "" {
+			return fmt.Errorf("%v: moved page %q: no target", site.Hosts, from)
+		}
+	}
+	for _, r := range site.Routes {
+		if !strings.HasPrefix(r.Path, "/") {
+			return fmt.Errorf("%v: route %q: path must start with /", site.Hosts, r.Path)
+		}
+		if (r.Redirect == "") == (r.Upstream == "") {
+			return fmt.Errorf("%v: route %q: needs either a redirect or an upstream", site.Hosts, r.Path)
+		}
+		if r.CORS != nil {
+			if err := r.CORS.validate(); err != nil {
+				return fmt.Errorf("%v: route %q: %v", site.Hosts, r.Path, err)
+			}
+		}
+		for _, name := range []string{r.Upstream, r.Fallback} {
+			if _, ok := t.Upstreams[name]; name != "" && !ok {
+				return fmt.Errorf("%v: route %q: unknown upstream %q", site.Hosts, r.Path, name)
+			}
+		}
+	}
+	return nil
+}
+
+// site returns the site of a host, which may have a port.
+func (t *Table) site(host string) *Site {
+	host = hostname(host)
+	for i := range t.Sites {
+		for _, h := range t.Sites[i].Hosts {
+			if h == host {
+				return &t.Sites[i]
+			}
+		}
+	}
+	return &t.Sites[0]
+}
+
+// moved returns where the page of a path moved to, if it did.
+func (s *Site) moved(path string) (string, bool) {
+	if len(path) > 1 {
+		path = strings.TrimSuffix(path, "/")
+	}
+	to, ok := s.Moved[path]
+	return to, ok
+}
+
+// route returns the route of a path, like nginx picks a location: an exact
+// match first, and then the longest prefix.
+func (s *Site) route(path string) *Route {
+	var best *Route
+	for i := range s.Routes {
+		r := &s.Routes[i]
+		switch r.Match {
+		case Exact:
+			if path == r.Path {
+				return r
+			}
+		case Prefix:
+			if strings.HasPrefix(path, r.Path) && (best == nil || len(r.Path) > len(best.Path)) {
+				best = r
+			}
+		}
+	}
+	return best
+}
+
+// AllowOrigin adds o to the CORS policies of the routes to an upstream, like
+// the compile service, and reports whether there was any.
+func (t *Table) AllowOrigin(upstream string, o Origin) bool {
+	seen := make(map[*CORS]bool)
+	for _, site := range t.Sites {
+		for _, r := range site.Routes {
+			if r.Upstream != upstream || r.CORS == nil || seen[r.CORS] {
+				continue
+			}
+			seen[r.CORS] = true
+			r.CORS.Origins = append(r.CORS.Origins, o)
+		}
+	}
+	return len(seen) > 0
+}
+
+// SetRate sets the rate of the clients of the routes to an upstream with a
+// CORS policy, and reports whether there was any.
+func (t *Table) SetRate(upstream string, rate Rate) bool {
+	seen := make(map[*CORS]bool)
+	for _, site := range t.Sites {
+		for _, r := range site.Routes {
+			if r.Upstream != upstream || r.CORS == nil || seen[r.CORS] {
+				continue
+			}
+			seen[r.CORS] = true
+			r.CORS.Rate = rate
+		}
+	}
+	return len(seen) > 0
+}
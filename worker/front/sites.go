@@ -0,0 +1,111 @@
+package front
+
+//go:generate go run ../cmd/redirects -docs ../../site/docs -o legacy.go legacy.txt
+
+import (
+	"net/http"
+	"time"
+)
+
+// Default returns the table of upper.io: the docs and the import paths at
+// upper.io, the tour at tour.upper.io and the playground at demo.upper.io,
+// each with its dev. host too.
+func Default() *Table {
+	// The tour and the docs run snippets on the compile service of the
+	// playground, which is shared by all their readers; a client that goes
+	// over its rate does not take the sandboxes of the others, whichever
+	// page it calls from, if any.
+	compileCORS := &CORS{
+		Origins: []Origin{
+			{Origin: "https://tour.upper.io"},
+			{Origin: "https://upper.io"},
+			{Origin: "https://tour.dev.upper.io"},
+			{Origin: "https://dev.upper.io"},
+		},
+		Methods: []string{"POST", "OPTIONS"},
+		Headers: []string{"Content-Type"},
+		MaxAge:  20 * 24 * time.Hour,
+		Expose:  []string{"Content-Length", "Content-Range"},
+		Rate:    Rate{Requests: 120, Per: time.Minute},
+	}
+	shareCORS := &CORS{
+		Origins: []Origin{{Origin: "*"}},
+		Methods: []string{"POST", "OPTIONS"},
+		Headers: []string{"Content-Type"},
+		MaxAge:  20 * 24 * time.Hour,
+	}
+
+	return &Table{
+		Upstreams: map[string]string{
+			"vanity":      "http://upper-vanity:9001",
+			"legacy-docs": "http://upper-legacy-docs:9000",
+			"docs":        "http://upper-docusaurus:80",
+			"tour":        "http://upper-tour:4000",
+			"playground":  "http://upper-playground-webapp:3000",
+			"compiler":    "http://upper-unsafebox:8080",
+			"share":       "http://upper-share:8090",
+		},
+		Checks: map[string]Check{
+			"vanity":      {Path: "/db.v4?go-get=1", Host: "upper.io"},
+			"legacy-docs": {Path: "/db.v3/getting-started", Host: "upper.io"},
+			"docs":        {Path: "/"},
+			"tour":        {Path: "/welcome/01", Status: http.StatusOK},
+			"playground":  {Path: "/"},
+			"compiler":    {Path: "/readyz", Status: http.StatusOK},
+			"share":       {Path: "/share"},
+		},
+		Sites: []Site{
+			{
+				Hosts: []string{"upper.io", "dev.upper.io"},
+				Routes: []Route{
+					{Match: Prefix, Path: "/css", Upstream: "docs"},
+					{Match: Prefix, Path: "/js", Upstream: "docs"},
+					{Match: Prefix, Path: "/img", Upstream: "docs"},
+					{Match: Prefix, Path: "/blog", Upstream: "docs"},
+					{Match: Exact, Path: "/", Redirect: "/v4/"},
+					{Match: Exact, Path: "/v4", Redirect: "/v4/"},
+					// The docs are built for /, and served at /v4/ behind
+					// the front.
+					{Match: Exact, Path: "/v4/", Upstream: "docs", Rewrite: "/"},
+					{Match: Prefix, Path: "/v4/", Upstream: "docs"},
+					{Match: Prefix, Path: "/mod/", Upstream: "vanity", Essential: true},
+					// vanity answers the go command, git and browsers for
+					// the import paths, with its docs redirects and
+					// retired-package pages for browsers, and 404 for the
+					// pages of the legacy docs, which share their paths.
+					{Match: Prefix, Path: "/", Upstream: "vanity", Fallback: "legacy-docs"},
+				},
+				// Old links to the legacy docs go to their pages in the docs
+				// of v4.
+				Moved: legacyRedirects,
+			},
+			{
+				Hosts: []string{"tour.upper.io", "tour.dev.upper.io"},
+				Routes: []Route{
+					{Match: Prefix, Path: "/", Upstream: "tour"},
+				},
+			},
+			{
+				Hosts: []string{"demo.upper.io", "demo.dev.upper.io"},
+				Routes: []Route{
+					{Match: Exact, Path: "/", Redirect: "https://tour.upper.io"},
+					{Match: Prefix, Path: "/compile", Upstream: "compiler", CORS: compileCORS},
+					{Match: Prefix, Path: "/fmt", Upstream: "compiler", CORS: compileCORS},
+					{Match: Prefix, Path: "/vet", Upstream: "compiler", CORS: compileCORS},
+					{Match: Exact, Path: "/share", Upstream: "share", CORS: shareCORS},
+					{Match: Prefix, Path: "/p/", Upstream: "share"},
+					{Match: Prefix, Path: "/", Upstream: "playground"},
+				},
+			},
+		},
+		// The playground reaches the compile service through the front, so
+		// that it follows the shifts of the compiler pool.
+		Internal: &Site{
+			Routes: []Route{
+				{Match: Prefix, Path: "/compile", Upstream: "compiler"},
+				{Match: Prefix, Path: "/fmt", Upstream: "compiler"},
+				{Match: Prefix, Path: "/vet", Upstream: "compiler"},
+			},
+		},
+	}
+}
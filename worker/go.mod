@@ -0,0 +1,3 @@
+module github.com/upper/upper.io/worker
+
+go 1.17